@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/nmea2000"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+const backendHelp = `backends:
+  can0, socketcan:can0       SocketCAN interface, including vcan
+  usbcan:/dev/ttyUSB0        USB-CAN analyzer
+  slcan:/dev/ttyACM0         SLCAN/LAWICEL adapter, i.e. CANable or CANUSB
+  actisense:/dev/ttyUSB0     Actisense NGT-1/NGX-1
+  socketcand:host:port/can0  bus on a socketcand server
+  cannelloni:host:port       cannelloni over UDP; cannelloni::port waits for the peer
+  ydraw:host:port            Yacht Devices gateway speaking RAW over TCP
+  n2kascii:host:port         Actisense W2K-1 speaking N2K ASCII over TCP
+  virtual                    in-process loopback bus, for trying things out`
+
+// Default serial speeds for the adapters, as they ship
+const (
+	defaultUSBCANBaudRate    = 2000000
+	defaultSLCANBaudRate     = 115200
+	defaultActisenseBaudRate = 115200
+)
+
+// backendOptions are the settings shared by every subcommand that opens a bus
+type backendOptions struct {
+	bitRate         int
+	baudRate        int
+	trace           bool
+	traceRate       float64
+	settingsTimeout time.Duration
+	// confirmTransmit isn't a flag; it's set by commands that confirm their writes.
+	confirmTransmit bool
+}
+
+func (o *backendOptions) register(fs *flag.FlagSet) {
+	fs.IntVar(&o.bitRate, "bitrate", 250000, "CAN bitrate, for backends that set it")
+	fs.IntVar(&o.baudRate, "baud", 0, "serial baud rate (default the adapter's usual rate)")
+	fs.BoolVar(&o.trace, "trace", false, "log every frame the driver receives and sends (every backend but virtual)")
+	fs.Float64Var(&o.traceRate, "trace-rate", 0, "trace at most this many frames per ID per second")
+	fs.DurationVar(&o.settingsTimeout, "settings-timeout", 0,
+		"wait this long for the adapter to confirm its settings, 0 to not wait (usbcan)")
+}
+
+func (o backendOptions) baud(def int) int {
+	if o.baudRate > 0 {
+		return o.baudRate
+	}
+	return def
+}
+
+// backendSpec is a parsed BACKEND argument
+type backendSpec struct {
+	kind    string
+	address string
+}
+
+// parseBackend splits a BACKEND argument into its kind and address.  A bare name is a SocketCAN interface.
+func parseBackend(s string) (backendSpec, error) {
+	kind, address, ok := strings.Cut(s, ":")
+	if !ok {
+		if s == "virtual" {
+			return backendSpec{kind: "virtual"}, nil
+		}
+		if s == "" || strings.ContainsAny(s, "/\\") {
+			return backendSpec{}, usageErrorf("backend %q needs a kind, i.e. usbcan:%s", s, s)
+		}
+		return backendSpec{kind: "socketcan", address: s}, nil
+	}
+
+	switch kind {
+	case "socketcan", "usbcan", "slcan", "actisense", "socketcand", "cannelloni", "ydraw", "n2kascii":
+		if address == "" {
+			return backendSpec{}, usageErrorf("backend %q needs an address", s)
+		}
+	case "virtual":
+	default:
+		return backendSpec{}, usageErrorf("unknown backend kind %q", kind)
+	}
+	return backendSpec{kind: kind, address: address}, nil
+}
+
+// String returns a short name for the bus, used as the interface name in logs.
+func (b backendSpec) String() string {
+	switch b.kind {
+	case "socketcan":
+		return b.address
+	case "socketcand":
+		if _, bus, ok := strings.Cut(b.address, "/"); ok {
+			return bus
+		}
+	}
+	return b.kind
+}
+
+// open builds the channel for the backend, delivering received frames to handler.
+func (b backendSpec) open(log *logrus.Logger, options backendOptions, handler can.HandlerFunc) (canbus.Interface, error) {
+	switch b.kind {
+	case "socketcan":
+		return canbus.NewSocketCANChannel(log, canbus.SocketCANChannelOptions{
+			InterfaceName:   b.address,
+			BitRate:         options.bitRate,
+			MessageHandler:  handler,
+			ConfirmTransmit: options.confirmTransmit,
+		}), nil
+	case "usbcan":
+		return canbus.NewUSBCANChannel(log, canbus.USBCANChannelOptions{
+			SerialPortName:          b.address,
+			SerialBaudRate:          options.baud(defaultUSBCANBaudRate),
+			BitRate:                 options.bitRate,
+			FrameHandler:            handler,
+			SettingsResponseTimeout: options.settingsTimeout,
+		}), nil
+	case "slcan":
+		return canbus.NewSLCANChannel(log, canbus.SLCANChannelOptions{
+			SerialPortName: b.address,
+			SerialBaudRate: options.baud(defaultSLCANBaudRate),
+			BitRate:        options.bitRate,
+			FrameHandler:   handler,
+		}), nil
+	case "actisense":
+		return canbus.NewActisenseChannel(log, canbus.ActisenseChannelOptions{
+			SerialPortName: b.address,
+			SerialBaudRate: options.baud(defaultActisenseBaudRate),
+			ReceiveAll:     true,
+			FrameHandler:   handler,
+			FastPacketPGN:  nmea2000.NewRegistry().IsFastPacket,
+		}), nil
+	case "socketcand":
+		address, bus, ok := strings.Cut(b.address, "/")
+		if !ok || bus == "" {
+			return nil, usageErrorf("socketcand backend needs host:port/bus, not %q", b.address)
+		}
+		return canbus.NewSocketcandChannel(log, canbus.SocketcandChannelOptions{
+			Address:      address,
+			BusName:      bus,
+			FrameHandler: handler,
+		}), nil
+	case "cannelloni":
+		options := canbus.CannelloniChannelOptions{Transport: canbus.CannelloniUDP, FrameHandler: handler}
+		if strings.HasPrefix(b.address, ":") {
+			options.Listen = true
+			options.LocalAddress = b.address
+		} else {
+			options.RemoteAddress = b.address
+		}
+		return canbus.NewCannelloniChannel(log, options), nil
+	case "ydraw", "n2kascii":
+		format := canbus.GatewayYDRaw
+		if b.kind == "n2kascii" {
+			format = canbus.GatewayN2KASCII
+		}
+		return canbus.NewGatewayChannel(log, canbus.GatewayChannelOptions{
+			Format:        format,
+			Transport:     canbus.GatewayTCP,
+			RemoteAddress: b.address,
+			FrameHandler:  handler,
+			FastPacketPGN: nmea2000.NewRegistry().IsFastPacket,
+		}), nil
+	case "virtual":
+		return newVirtualChannel(handler), nil
+	default:
+		return nil, fmt.Errorf("unknown backend kind %q", b.kind)
+	}
+}
+
+// runningBus is a started channel with Run going in the background
+type runningBus struct {
+	channel canbus.Interface
+	done    chan error
+}
+
+// startBus opens the backend and starts it, running it until ctx is done or close is called.
+func startBus(ctx context.Context, log *logrus.Logger, spec backendSpec, options backendOptions,
+	handler can.HandlerFunc) (*runningBus, error) {
+	channel, err := spec.open(log, options, handler)
+	if err != nil {
+		return nil, err
+	}
+	if options.trace {
+		tracer, ok := channel.(canbus.Tracer)
+		if !ok {
+			_ = channel.Close()
+			return nil, fmt.Errorf("%s backend can't trace frames", spec.kind)
+		}
+		tracer.SetTrace(&canbus.TraceOptions{RateLimit: options.traceRate, PGNName: nmea2000.NewRegistry().Name})
+	}
+
+	if err := channel.Start(ctx); err != nil {
+		_ = channel.Close()
+		return nil, fmt.Errorf("start %s: %w", spec, err)
+	}
+
+	b := &runningBus{channel: channel, done: make(chan error, 1)}
+	go func() {
+		b.done <- channel.Run(ctx)
+	}()
+	return b, nil
+}
+
+// wait blocks until ctx is done or the bus stops by itself, returning the error it stopped with.
+func (b *runningBus) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-b.done:
+		b.done <- err
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			return errors.New("bus closed")
+		}
+		return err
+	}
+}
+
+// close closes the channel and waits for Run to finish.
+func (b *runningBus) close() error {
+	err := b.channel.Close()
+	<-b.done
+	return err
+}
+
+// virtualChannel is a loopback bus: every frame written to it is received straight back
+type virtualChannel struct {
+	handler can.HandlerFunc
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+func newVirtualChannel(handler can.HandlerFunc) *virtualChannel {
+	return &virtualChannel{handler: handler, done: make(chan struct{})}
+}
+
+// Start does nothing, since there's nothing to open.
+func (c *virtualChannel) Start(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errors.New("virtual channel is closed")
+	}
+	return nil
+}
+
+// Run waits until the channel is closed or ctx is done.
+func (c *virtualChannel) Run(ctx context.Context) error {
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// Close stops Run.
+func (c *virtualChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	return nil
+}
+
+// WriteFrame hands the frame to the channel's own handler.
+func (c *virtualChannel) WriteFrame(frame can.Frame) error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return errors.New("canbus channel is closed")
+	}
+	if c.handler != nil {
+		c.handler(frame)
+	}
+	return nil
+}
+
+var _ canbus.Interface = (*virtualChannel)(nil)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBackend(t *testing.T) {
+	for s, want := range map[string]backendSpec{
+		"can0":                       {kind: "socketcan", address: "can0"},
+		"socketcan:vcan0":            {kind: "socketcan", address: "vcan0"},
+		"usbcan:/dev/ttyUSB0":        {kind: "usbcan", address: "/dev/ttyUSB0"},
+		"socketcand:boat:29536/can1": {kind: "socketcand", address: "boat:29536/can1"},
+		"cannelloni::20000":          {kind: "cannelloni", address: ":20000"},
+		"virtual":                    {kind: "virtual"},
+	} {
+		got, err := parseBackend(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, got, s)
+	}
+
+	for _, bad := range []string{"", "/dev/ttyUSB0", "usbcan:", "kvaser:0"} {
+		_, err := parseBackend(bad)
+		require.Error(t, err, bad)
+	}
+
+	spec, _ := parseBackend("socketcand:boat:29536/can1")
+	require.Equal(t, "can1", spec.String())
+	spec, _ = parseBackend("slcan:/dev/ttyACM0")
+	require.Equal(t, "slcan", spec.String())
+}
+
+func TestBackendOpen(t *testing.T) {
+	for s, want := range map[string]canbus.Interface{
+		"can0":                 &canbus.SocketCANChannel{},
+		"usbcan:/dev/ttyUSB0":  &canbus.USBCANChannel{},
+		"slcan:/dev/ttyACM0":   &canbus.SLCANChannel{},
+		"actisense:/dev/ttyS0": &canbus.ActisenseChannel{},
+		"socketcand:boat:1/c0": &canbus.SocketcandChannel{},
+		"cannelloni:boat:1":    &canbus.CannelloniChannel{},
+		"ydraw:boat:1":         &canbus.GatewayChannel{},
+		"virtual":              &virtualChannel{},
+	} {
+		spec, err := parseBackend(s)
+		require.NoError(t, err)
+		channel, err := spec.open(logrus.New(), backendOptions{bitRate: 250000}, nil)
+		require.NoError(t, err, s)
+		require.IsType(t, want, channel, s)
+	}
+
+	spec, _ := parseBackend("socketcand:boat:1")
+	_, err := spec.open(logrus.New(), backendOptions{}, nil)
+	require.ErrorContains(t, err, "host:port/bus")
+}
+
+func TestVirtualChannel(t *testing.T) {
+	var received []can.Frame
+	ctx := context.Background()
+	bus, err := startBus(ctx, logrus.New(), backendSpec{kind: "virtual"}, backendOptions{}, func(frame can.Frame) {
+		received = append(received, frame)
+	})
+	require.NoError(t, err)
+
+	frame := can.Frame{ID: 0x123, Length: 1, Data: [8]byte{0x42}}
+	require.NoError(t, bus.channel.WriteFrame(frame))
+	require.Equal(t, []can.Frame{frame}, received)
+
+	require.NoError(t, bus.close())
+	require.Error(t, bus.channel.WriteFrame(frame))
+	require.Error(t, bus.channel.Start(ctx))
+}
+
+func TestStartBusTrace(t *testing.T) {
+	_, err := startBus(context.Background(), logrus.New(), backendSpec{kind: "virtual"}, backendOptions{trace: true}, nil)
+	require.ErrorContains(t, err, "virtual backend can't trace frames")
+
+	bus, err := startBus(context.Background(), logrus.New(), backendSpec{kind: "cannelloni", address: ":0"}, backendOptions{trace: true}, nil)
+	require.NoError(t, err)
+	require.NoError(t, bus.close())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sync/atomic"
+
+	"github.com/brutella/can"
+)
+
+// bridgeDirection forwards the frames received on one bus to another
+type bridgeDirection struct {
+	name   string
+	to     atomic.Pointer[runningBus]
+	filter *frameFilter
+
+	forwarded atomic.Uint64
+	failed    atomic.Uint64
+}
+
+func (d *bridgeDirection) handle(c *cli) can.HandlerFunc {
+	return func(frame can.Frame) {
+		to := d.to.Load()
+		if to == nil || !d.filter.match(frame) {
+			return
+		}
+		if err := to.channel.WriteFrame(withEFF(frame)); err != nil {
+			d.failed.Add(1)
+			c.log.WithError(err).WithField("direction", d.name).Debug("Forwarding frame failed")
+			return
+		}
+		d.forwarded.Add(1)
+	}
+}
+
+func runBridge(ctx context.Context, c *cli, fs *flag.FlagSet, args []string) error {
+	var backend backendOptions
+	backend.register(fs)
+	var filter frameFilter
+	filter.register(fs)
+	oneWay := fs.Bool("oneway", false, "only forward from the first backend to the second")
+
+	rest, err := parseArgs(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	specA, err := parseBackend(rest[0])
+	if err != nil {
+		return err
+	}
+	specB, err := parseBackend(rest[1])
+	if err != nil {
+		return err
+	}
+
+	aToB := &bridgeDirection{name: specA.String() + "->" + specB.String(), filter: &filter}
+	bToA := &bridgeDirection{name: specB.String() + "->" + specA.String(), filter: &filter}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	a, err := startBus(ctx, c.log, specA, backend, aToB.handle(c))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = a.close() }()
+
+	var bHandler can.HandlerFunc
+	if !*oneWay {
+		bHandler = bToA.handle(c)
+	}
+	b, err := startBus(ctx, c.log, specB, backend, bHandler)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = b.close() }()
+
+	aToB.to.Store(b)
+	bToA.to.Store(a)
+
+	// Stop as soon as either side goes away
+	errs := make(chan error, 2)
+	go func() { errs <- a.wait(ctx) }()
+	go func() { errs <- b.wait(ctx) }()
+	err = <-errs
+	cancel()
+
+	c.log.WithField("forwarded", aToB.forwarded.Load()).WithField("failed", aToB.failed.Load()).
+		Info("Bridged " + aToB.name)
+	if !*oneWay {
+		c.log.WithField("forwarded", bToA.forwarded.Load()).WithField("failed", bToA.failed.Load()).
+			Info("Bridged " + bToA.name)
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus/canbustest"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeDirection(t *testing.T) {
+	target := canbustest.NewBus(nil)
+	d := &bridgeDirection{name: "usbcan->can0", filter: parseFilter(t, "-filter", "09F80000:1FFF0000")}
+	handle := d.handle(&cli{log: logrus.New()})
+
+	// Frames that arrive before the other side is up are dropped
+	handle(can.Frame{ID: 0x09f80102})
+	d.to.Store(&runningBus{channel: target})
+
+	handle(can.Frame{ID: 0x09f80102, Length: 1, Data: [8]byte{0x42}})
+	handle(can.Frame{ID: 0x123})
+	require.Equal(t, []can.Frame{{ID: 0x09f80102 | can.MaskEff, Length: 1, Data: [8]byte{0x42}}}, target.Frames())
+	require.Equal(t, uint64(1), d.forwarded.Load())
+
+	require.NoError(t, target.Close())
+	handle(can.Frame{ID: 0x09f80102})
+	require.Equal(t, uint64(1), d.failed.Load())
+}
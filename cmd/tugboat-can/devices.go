@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+)
+
+// knownAdapters maps the USB VID:PID of serial CAN adapters to the backend that talks to them.  The CH340 in the
+// USB-CAN analyzers is a generic USB serial chip, so that one is only a good guess.
+var knownAdapters = map[string]string{
+	"1A86:7523": "usbcan",
+	"16D0:117E": "slcan",
+	"0403:D9AA": "actisense",
+	"0403:D9AB": "actisense",
+}
+
+// serialPortDetails is what we know about a serial port.  Only the name is known where the platform's USB
+// details need cgo, i.e. macOS builds without it.
+type serialPortDetails struct {
+	Name         string
+	IsUSB        bool
+	VID          string
+	PID          string
+	Product      string
+	SerialNumber string
+}
+
+// deviceLister finds the CAN hardware on the system, so tests can fake it
+type deviceLister struct {
+	interfaces  func() ([]canbus.CANInterfaceInfo, error)
+	serialPorts func() ([]serialPortDetails, error)
+}
+
+func runListDevices(_ context.Context, c *cli, flags *flag.FlagSet, args []string) error {
+	if _, err := parseArgs(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	return listDevices(c, deviceLister{
+		interfaces: func() ([]canbus.CANInterfaceInfo, error) {
+			return canbus.DiscoverCANInterfaces(canbus.DiscoveryOptions{})
+		},
+		serialPorts: listSerialPorts,
+	})
+}
+
+// listDevices prints the SocketCAN interfaces and then the serial ports, with the backend to use for each
+func listDevices(c *cli, lister deviceLister) error {
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+
+	ifaces, err := lister.interfaces()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("list CAN interfaces: %w", err)
+	}
+	fmt.Fprintf(tw, "BACKEND\tSTATE\tBITRATE\tDRIVER\tDEVICE\t\n")
+	for _, iface := range ifaces {
+		bitRate := "-"
+		if iface.BitRate > 0 {
+			bitRate = fmt.Sprint(iface.BitRate)
+		}
+		state := iface.OperState
+		if iface.Type == "can" {
+			state += "/" + iface.State.String()
+		}
+		fmt.Fprintf(tw, "socketcan:%s\t%s\t%s\t%s\t%s\t\n", iface.Name, state, bitRate, dash(iface.Driver), dash(iface.Device))
+	}
+
+	ports, err := lister.serialPorts()
+	if err != nil {
+		c.log.WithError(err).Warn("Listing serial ports failed")
+	}
+	for _, port := range ports {
+		// Ports we don't recognize are listed bare, to be prefixed with whichever backend fits
+		backend := port.Name
+		device := "-"
+		if port.IsUSB {
+			id := strings.ToUpper(port.VID + ":" + port.PID)
+			device = strings.TrimSpace(strings.Join([]string{id, port.Product, port.SerialNumber}, " "))
+			if kind, ok := knownAdapters[id]; ok {
+				backend = kind + ":" + port.Name
+			}
+		}
+		fmt.Fprintf(tw, "%s\t-\t-\t-\t%s\t\n", backend, device)
+	}
+
+	return tw.Flush()
+}
+
+// dash is a helper that stands in for an empty table cell
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,22 @@
+//go:build !darwin || cgo
+
+package main
+
+import "go.bug.st/serial/enumerator"
+
+// listSerialPorts lists the serial ports with their USB details.
+func listSerialPorts() ([]serialPortDetails, error) {
+	ports, err := enumerator.GetDetailedPortsList()
+	details := make([]serialPortDetails, 0, len(ports))
+	for _, p := range ports {
+		details = append(details, serialPortDetails{
+			Name:         p.Name,
+			IsUSB:        p.IsUSB,
+			VID:          p.VID,
+			PID:          p.PID,
+			Product:      p.Product,
+			SerialNumber: p.SerialNumber,
+		})
+	}
+	return details, err
+}
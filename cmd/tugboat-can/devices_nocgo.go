@@ -0,0 +1,15 @@
+//go:build darwin && !cgo
+
+package main
+
+import "go.bug.st/serial"
+
+// listSerialPorts lists the serial ports by name only, since the enumerator needs cgo on macOS.
+func listSerialPorts() ([]serialPortDetails, error) {
+	names, err := serial.GetPortsList()
+	details := make([]serialPortDetails, 0, len(names))
+	for _, name := range names {
+		details = append(details, serialPortDetails{Name: name})
+	}
+	return details, err
+}
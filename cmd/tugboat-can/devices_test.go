@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListDevices(t *testing.T) {
+	var out bytes.Buffer
+	c := &cli{log: logrus.New(), stdout: &out}
+
+	err := listDevices(c, deviceLister{
+		interfaces: func() ([]canbus.CANInterfaceInfo, error) {
+			return []canbus.CANInterfaceInfo{
+				{Name: "can0", Type: "can", Driver: "mcp251x", Device: "spi0.0", BitRate: 250000, OperState: "up"},
+				{Name: "vcan0", Type: "vcan", OperState: "unknown"},
+			}, nil
+		},
+		serialPorts: func() ([]serialPortDetails, error) {
+			return []serialPortDetails{
+				{Name: "/dev/ttyUSB0", IsUSB: true, VID: "1a86", PID: "7523", Product: "USB Serial"},
+				{Name: "/dev/ttyACM0", IsUSB: true, VID: "0483", PID: "5740"},
+				{Name: "/dev/ttyS0"},
+			}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 6)
+	require.Equal(t, []string{"socketcan:can0", "up/ERROR-ACTIVE", "250000", "mcp251x", "spi0.0"}, strings.Fields(lines[1]))
+	require.Equal(t, []string{"socketcan:vcan0", "unknown", "-", "-", "-"}, strings.Fields(lines[2]))
+	require.Equal(t, []string{"usbcan:/dev/ttyUSB0", "-", "-", "-", "1A86:7523", "USB", "Serial"}, strings.Fields(lines[3]))
+	require.Equal(t, []string{"/dev/ttyACM0", "-", "-", "-", "0483:5740"}, strings.Fields(lines[4]))
+	require.Equal(t, []string{"/dev/ttyS0", "-", "-", "-", "-"}, strings.Fields(lines[5]))
+}
+
+func TestListDevicesWithoutSysfs(t *testing.T) {
+	var out bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	c := &cli{log: log, stdout: &out}
+
+	err := listDevices(c, deviceLister{
+		interfaces:  func() ([]canbus.CANInterfaceInfo, error) { return nil, fs.ErrNotExist },
+		serialPorts: func() ([]serialPortDetails, error) { return nil, errors.New("not implemented") },
+	})
+	require.NoError(t, err)
+	require.Equal(t, "BACKEND  STATE  BITRATE  DRIVER  DEVICE", strings.TrimSpace(out.String()))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/brutella/can"
+)
+
+// frameFormat is an output format for dumped frames
+type frameFormat string
+
+const (
+	// formatCandump looks like can-utils candump: "can0  18EEFF00   [2]  01 02".
+	formatCandump frameFormat = "candump"
+	// formatLog is the candump -l log format that record writes and replay reads.
+	formatLog frameFormat = "log"
+	// formatYDRaw is Yacht Devices RAW, as written by their gateways' logs.
+	formatYDRaw frameFormat = "ydraw"
+	// formatJSON is one JSON object per line, with the NMEA 2000 header fields split out.
+	formatJSON frameFormat = "json"
+)
+
+func (f *frameFormat) String() string {
+	return string(*f)
+}
+
+func (f *frameFormat) Set(s string) error {
+	switch frameFormat(s) {
+	case formatCandump, formatLog, formatYDRaw, formatJSON:
+		*f = frameFormat(s)
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", s)
+	}
+}
+
+// jsonFrame is a frame in the json format
+type jsonFrame struct {
+	Time      time.Time `json:"time"`
+	Interface string    `json:"interface"`
+	ID        string    `json:"id"`
+	Extended  bool      `json:"extended"`
+	RTR       bool      `json:"rtr,omitempty"`
+	Length    uint8     `json:"length"`
+	Data      string    `json:"data"`
+	N2K       *jsonN2K  `json:"n2k,omitempty"`
+}
+
+type jsonN2K struct {
+	PGN         uint32 `json:"pgn"`
+	Priority    uint8  `json:"priority"`
+	Source      uint8  `json:"source"`
+	Destination uint8  `json:"destination"`
+}
+
+// formatFrame formats a received frame as a single line, without the newline
+func formatFrame(format frameFormat, timestamps bool, ts time.Time, iface string, frame can.Frame) string {
+	switch format {
+	case formatLog:
+		return canbus.CandumpRecord{Time: ts, Interface: iface, Frame: frame}.String()
+	case formatYDRaw:
+		y, m, d := ts.Date()
+		tod := ts.Sub(time.Date(y, m, d, 0, 0, 0, 0, ts.Location()))
+		return canbus.YDRawRecord{Time: tod, Direction: 'R', Frame: frame}.String()
+	case formatJSON:
+		n := min(int(frame.Length), can.MaxFrameDataLength)
+		j := jsonFrame{
+			Time:      ts,
+			Interface: iface,
+			Extended:  canbus.IsExtendedID(frame.ID),
+			RTR:       frame.ID&can.MaskRtr != 0,
+			Length:    frame.Length,
+			Data:      hex.EncodeToString(frame.Data[:n]),
+		}
+		if j.Extended {
+			j.ID = fmt.Sprintf("%08X", frame.ID&can.MaskIDEff)
+			h := canbus.ParseN2KHeader(frame.ID)
+			j.N2K = &jsonN2K{PGN: h.PGN, Priority: h.Priority, Source: h.Source, Destination: h.Destination}
+		} else {
+			j.ID = fmt.Sprintf("%03X", frame.ID&can.MaskIDSff)
+		}
+		b, err := json.Marshal(j)
+		if err != nil {
+			return fmt.Sprintf(`{"error":%q}`, err.Error())
+		}
+		return string(b)
+	case formatCandump:
+		fallthrough
+	default:
+		var sb strings.Builder
+		if timestamps {
+			fmt.Fprintf(&sb, "(%d.%06d)  ", ts.Unix(), ts.Nanosecond()/1000)
+		}
+		if canbus.IsExtendedID(frame.ID) {
+			fmt.Fprintf(&sb, "%s  %08X   [%d]", iface, frame.ID&can.MaskIDEff, frame.Length)
+		} else {
+			fmt.Fprintf(&sb, "%s  %03X   [%d]", iface, frame.ID&can.MaskIDSff, frame.Length)
+		}
+		if frame.ID&can.MaskRtr != 0 {
+			sb.WriteString("  remote request")
+			return sb.String()
+		}
+		for i, b := range frame.Data[:min(int(frame.Length), can.MaxFrameDataLength)] {
+			if i == 0 {
+				sb.WriteString(" ")
+			}
+			fmt.Fprintf(&sb, " %02X", b)
+		}
+		return sb.String()
+	}
+}
+
+// frameWriter writes frames that pass a filter to an output, one per line, until it's seen enough of them
+type frameWriter struct {
+	w          io.Writer
+	format     frameFormat
+	timestamps bool
+	iface      string
+	filter     *frameFilter
+	limit      int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	count   int
+	err     error
+	done    chan struct{}
+	doneOne sync.Once
+}
+
+func newFrameWriter(w io.Writer, format frameFormat, iface string, filter *frameFilter, limit int) *frameWriter {
+	return &frameWriter{
+		w:      w,
+		format: format,
+		iface:  iface,
+		filter: filter,
+		limit:  limit,
+		now:    time.Now,
+		done:   make(chan struct{}),
+	}
+}
+
+// handle is the frame handler for the bus being dumped.
+func (fw *frameWriter) handle(frame can.Frame) {
+	ts := fw.now()
+	if !fw.filter.match(frame) {
+		return
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if fw.err != nil || (fw.limit > 0 && fw.count >= fw.limit) {
+		return
+	}
+	if _, err := io.WriteString(fw.w, formatFrame(fw.format, fw.timestamps, ts, fw.iface, frame)+"\n"); err != nil {
+		fw.err = err
+		fw.finish()
+		return
+	}
+	fw.count++
+	if fw.limit > 0 && fw.count >= fw.limit {
+		fw.finish()
+	}
+}
+
+// finish closes done, once
+func (fw *frameWriter) finish() {
+	fw.doneOne.Do(func() {
+		close(fw.done)
+	})
+}
+
+// result returns how many frames were written and the write error that stopped it, if any.
+func (fw *frameWriter) result() (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return fw.count, fw.err
+}
+
+func runDump(ctx context.Context, c *cli, fs *flag.FlagSet, args []string) error {
+	var backend backendOptions
+	backend.register(fs)
+	var filter frameFilter
+	filter.register(fs)
+	format := formatCandump
+	fs.Var(&format, "format", "output `format`: candump, log, ydraw or json")
+	timestamps := fs.Bool("t", false, "prefix candump lines with the time received")
+	limit := fs.Int("n", 0, "exit after this many frames")
+
+	rest, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	spec, err := parseBackend(rest[0])
+	if err != nil {
+		return err
+	}
+
+	fw := newFrameWriter(c.stdout, format, spec.String(), &filter, *limit)
+	fw.timestamps = *timestamps
+	return dumpFrames(ctx, c, spec, backend, fw)
+}
+
+// dumpFrames runs a bus into a frameWriter until ctx is done, the bus fails or the writer has had enough
+func dumpFrames(ctx context.Context, c *cli, spec backendSpec, backend backendOptions, fw *frameWriter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	bus, err := startBus(ctx, c.log, spec, backend, fw.handle)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		select {
+		case <-fw.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	runErr := bus.wait(ctx)
+	if err := bus.close(); err != nil {
+		c.log.WithError(err).Debug("Closing bus failed")
+	}
+	if runErr != nil {
+		return runErr
+	}
+	_, err = fw.result()
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatFrame(t *testing.T) {
+	ts := time.Unix(1783170309, 123456000)
+	heading := can.Frame{ID: 0x09f11201 | can.MaskEff, Length: 3, Data: [8]byte{0xff, 0x10, 0x20}}
+	standard := can.Frame{ID: 0x123, Length: 1, Data: [8]byte{0x42}}
+	remote := can.Frame{ID: 0x123 | can.MaskRtr}
+
+	require.Equal(t, "can0  09F11201   [3]  FF 10 20", formatFrame(formatCandump, false, ts, "can0", heading))
+	require.Equal(t, "can0  123   [1]  42", formatFrame(formatCandump, false, ts, "can0", standard))
+	require.Equal(t, "can0  123   [0]  remote request", formatFrame(formatCandump, false, ts, "can0", remote))
+	require.Equal(t, "(1783170309.123456)  can0  123   [1]  42", formatFrame(formatCandump, true, ts, "can0", standard))
+
+	require.Equal(t, "(1783170309.123456) can0 09F11201#FF1020", formatFrame(formatLog, false, ts, "can0", heading))
+	require.Equal(t, ts.Format("15:04:05.000")+" R 09F11201 FF 10 20", formatFrame(formatYDRaw, false, ts, "can0", heading))
+
+	var j jsonFrame
+	require.NoError(t, json.Unmarshal([]byte(formatFrame(formatJSON, false, ts, "can0", heading)), &j))
+	require.Equal(t, "09F11201", j.ID)
+	require.True(t, j.Extended)
+	require.Equal(t, "ff1020", j.Data)
+	require.Equal(t, &jsonN2K{PGN: 127250, Priority: 2, Source: 1, Destination: 0xff}, j.N2K)
+
+	var js jsonFrame
+	require.NoError(t, json.Unmarshal([]byte(formatFrame(formatJSON, false, ts, "can0", standard)), &js))
+	require.Equal(t, "123", js.ID)
+	require.Nil(t, js.N2K)
+}
+
+func TestFrameFormatFlag(t *testing.T) {
+	var f frameFormat
+	require.NoError(t, f.Set("json"))
+	require.Equal(t, formatJSON, f)
+	require.Error(t, f.Set("csv"))
+}
+
+func TestFrameWriter(t *testing.T) {
+	var out bytes.Buffer
+	fw := newFrameWriter(&out, formatCandump, "vcan0", parseFilter(t, "-filter", "100:700"), 2)
+
+	for i := range 5 {
+		fw.handle(can.Frame{ID: uint32(0x100 + i*0x80)})
+	}
+
+	select {
+	case <-fw.done:
+	default:
+		t.Fatal("writer isn't done after reaching its limit")
+	}
+	count, err := fw.result()
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, []string{"vcan0  100   [0]", "vcan0  180   [0]"}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/brutella/can"
+)
+
+// idFilter is a candump-style can_id:can_mask pair.  Inverted filters (can_id~can_mask) match the frames the
+// plain one wouldn't.
+type idFilter struct {
+	id, mask uint32
+	invert   bool
+}
+
+func (f idFilter) match(id uint32) bool {
+	return (id&f.mask == f.id&f.mask) != f.invert
+}
+
+// frameFilter picks which frames a subcommand handles, by CAN ID and, for NMEA 2000 traffic, by PGN and source
+// address.  Each kind of filter matches if any of its values do, and a frame has to pass every kind given.
+type frameFilter struct {
+	ids     []idFilter
+	pgns    map[uint32]bool
+	sources map[uint8]bool
+}
+
+func (f *frameFilter) register(fs *flag.FlagSet) {
+	fs.Func("filter", "only frames matching `id[:mask]` or not matching id~mask, in hex; repeatable, comma separated",
+		f.addIDs)
+	fs.Func("pgn", "only NMEA 2000 frames with one of these `PGNs`; repeatable, comma separated", f.addPGNs)
+	fs.Func("src", "only NMEA 2000 frames from one of these source `addresses`; repeatable, comma separated",
+		f.addSources)
+}
+
+func (f *frameFilter) addIDs(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		sep := strings.IndexAny(part, ":~")
+		idStr, maskStr := part, ""
+		if sep >= 0 {
+			idStr, maskStr = part[:sep], part[sep+1:]
+		}
+
+		id, err := strconv.ParseUint(idStr, 16, 32)
+		if err != nil || id > can.MaskIDEff {
+			return fmt.Errorf("invalid CAN ID %q", idStr)
+		}
+		mask := uint64(can.MaskIDEff)
+		if maskStr != "" {
+			mask, err = strconv.ParseUint(maskStr, 16, 32)
+			if err != nil {
+				return fmt.Errorf("invalid CAN ID mask %q", maskStr)
+			}
+		}
+
+		f.ids = append(f.ids, idFilter{
+			id:     uint32(id),
+			mask:   uint32(mask) & can.MaskIDEff,
+			invert: sep >= 0 && part[sep] == '~',
+		})
+	}
+	return nil
+}
+
+func (f *frameFilter) addPGNs(s string) error {
+	if f.pgns == nil {
+		f.pgns = map[uint32]bool{}
+	}
+	for _, part := range strings.Split(s, ",") {
+		pgn, err := strconv.ParseUint(part, 0, 32)
+		if err != nil || pgn > 0x3ffff {
+			return fmt.Errorf("invalid PGN %q", part)
+		}
+		f.pgns[uint32(pgn)] = true
+	}
+	return nil
+}
+
+func (f *frameFilter) addSources(s string) error {
+	if f.sources == nil {
+		f.sources = map[uint8]bool{}
+	}
+	for _, part := range strings.Split(s, ",") {
+		src, err := strconv.ParseUint(part, 0, 8)
+		if err != nil {
+			return fmt.Errorf("invalid source address %q", part)
+		}
+		f.sources[uint8(src)] = true
+	}
+	return nil
+}
+
+// match reports whether the frame passes the filter.  The EFF flag is ignored, since not every backend sets it.
+func (f *frameFilter) match(frame can.Frame) bool {
+	id := frame.ID & can.MaskIDEff
+
+	if len(f.ids) > 0 {
+		matched := false
+		for _, idf := range f.ids {
+			if idf.match(id) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.pgns) == 0 && len(f.sources) == 0 {
+		return true
+	}
+	if !canbus.IsExtendedID(frame.ID) {
+		return false
+	}
+	h := canbus.ParseN2KHeader(id)
+	if len(f.pgns) > 0 && !f.pgns[h.PGN] {
+		return false
+	}
+	return len(f.sources) == 0 || f.sources[h.Source]
+}
+
+// withEFF sets the EFF flag on extended IDs, which SocketCAN needs but the serial backends leave off
+func withEFF(frame can.Frame) can.Frame {
+	if canbus.IsExtendedID(frame.ID) {
+		frame.ID |= can.MaskEff
+	}
+	return frame
+}
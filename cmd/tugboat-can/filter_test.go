@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func parseFilter(t *testing.T, args ...string) *frameFilter {
+	t.Helper()
+
+	var f frameFilter
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.register(fs)
+	require.NoError(t, fs.Parse(args))
+	return &f
+}
+
+func TestFrameFilter(t *testing.T) {
+	heading := can.Frame{ID: 0x09f11201 | can.MaskEff} // PGN 127250 from 1
+	position := can.Frame{ID: 0x09f80102}              // PGN 129025 from 2, as a serial backend delivers it
+	standard := can.Frame{ID: 0x123}
+
+	all := parseFilter(t)
+	require.True(t, all.match(heading))
+	require.True(t, all.match(standard))
+
+	ids := parseFilter(t, "-filter", "123,09F80000:1FFF0000")
+	require.False(t, ids.match(heading))
+	require.True(t, ids.match(position))
+	require.True(t, ids.match(standard))
+
+	inverted := parseFilter(t, "-filter", "123~7FF")
+	require.True(t, inverted.match(heading))
+	require.False(t, inverted.match(standard))
+
+	pgns := parseFilter(t, "-pgn", "127250", "-pgn", "0x1F801")
+	require.True(t, pgns.match(heading))
+	require.True(t, pgns.match(position))
+	require.False(t, pgns.match(standard))
+
+	both := parseFilter(t, "-pgn", "127250,129025", "-src", "2")
+	require.False(t, both.match(heading))
+	require.True(t, both.match(position))
+
+	for _, bad := range [][]string{
+		{"-filter", "2000000000"},
+		{"-filter", "123:xyz"},
+		{"-pgn", "400000"},
+		{"-src", "256"},
+	} {
+		var f frameFilter
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		f.register(fs)
+		require.Error(t, fs.Parse(bad), bad)
+	}
+}
+
+func TestWithEFF(t *testing.T) {
+	require.Equal(t, uint32(0x09f80102|can.MaskEff), withEFF(can.Frame{ID: 0x09f80102}).ID)
+	require.Equal(t, uint32(0x123), withEFF(can.Frame{ID: 0x123}).ID)
+}
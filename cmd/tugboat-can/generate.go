@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/cangen"
+	"github.com/brutella/can"
+)
+
+// parseSchedule parses the @PERIOD[*BURST] end of a stream flag into a stream
+func parseSchedule(s string, stream *cangen.Stream) error {
+	periodStr, burstStr, hasBurst := strings.Cut(s, "*")
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return fmt.Errorf("invalid period %q", periodStr)
+	}
+	stream.Period = period
+	if hasBurst {
+		burst, err := strconv.Atoi(burstStr)
+		if err != nil || burst < 1 {
+			return fmt.Errorf("invalid burst %q", burstStr)
+		}
+		stream.Burst = burst
+	}
+	return nil
+}
+
+// parseStream parses a -stream flag, FRAME@PERIOD[*BURST] with the frame in cansend syntax
+func parseStream(s string) (cangen.Stream, error) {
+	frameStr, schedule, ok := strings.Cut(s, "@")
+	if !ok {
+		return cangen.Stream{}, fmt.Errorf("stream %q has no @PERIOD", s)
+	}
+	frame, err := canbus.ParseCandumpFrame(frameStr)
+	if err != nil {
+		return cangen.Stream{}, err
+	}
+	if frame.ID&can.MaskRtr != 0 {
+		return cangen.Stream{}, fmt.Errorf("can't generate remote requests: %q", frameStr)
+	}
+
+	stream := cangen.Stream{ID: frame.ID, Data: append([]byte{}, frame.Data[:frame.Length]...)}
+	return stream, parseSchedule(schedule, &stream)
+}
+
+// parseRandomStream parses a -random flag, LO-HI@PERIOD[*BURST] with the IDs in hex
+func parseRandomStream(s string) (cangen.Stream, error) {
+	ids, schedule, ok := strings.Cut(s, "@")
+	if !ok {
+		return cangen.Stream{}, fmt.Errorf("stream %q has no @PERIOD", s)
+	}
+	loStr, hiStr, ok := strings.Cut(ids, "-")
+	if !ok {
+		return cangen.Stream{}, fmt.Errorf("invalid ID range %q", ids)
+	}
+	lo, err := strconv.ParseUint(loStr, 16, 32)
+	if err != nil || lo > can.MaskIDEff {
+		return cangen.Stream{}, fmt.Errorf("invalid CAN ID %q", loStr)
+	}
+	hi, err := strconv.ParseUint(hiStr, 16, 32)
+	if err != nil || hi > can.MaskIDEff || hi < lo {
+		return cangen.Stream{}, fmt.Errorf("invalid CAN ID %q", hiStr)
+	}
+
+	stream := cangen.Stream{
+		RandomID:   true,
+		IDMin:      uint32(lo),
+		IDMax:      uint32(hi),
+		RandomData: true,
+		Length:     can.MaxFrameDataLength,
+	}
+	return stream, parseSchedule(schedule, &stream)
+}
+
+func runGenerate(ctx context.Context, c *cli, fs *flag.FlagSet, args []string) error {
+	var backend backendOptions
+	backend.register(fs)
+	var streams []cangen.Stream
+	fs.Func("stream", "send `FRAME@PERIOD[*BURST]`, i.e. 123#0102@100ms*5; repeatable", func(s string) error {
+		stream, err := parseStream(s)
+		streams = append(streams, stream)
+		return err
+	})
+	fs.Func("random", "send random IDs and data, `LO-HI@PERIOD[*BURST]` with the IDs in hex; repeatable", func(s string) error {
+		stream, err := parseRandomStream(s)
+		streams = append(streams, stream)
+		return err
+	})
+	devices := fs.Int("n2k", 0, "send the traffic of this many NMEA 2000 `devices`")
+	load := fs.Float64("load", 0, "add filler frames to bring the bus up to this `percent` load")
+	jitter := fs.Duration("jitter", 0, "delay each message by a random amount up to this")
+	duration := fs.Duration("duration", 0, "stop after this long")
+	limit := fs.Uint64("n", 0, "stop after this many frames")
+	seed := fs.Uint64("seed", 0, "random seed, to repeat a run (default random)")
+	keepGoing := fs.Bool("keep-going", false, "count failed writes and carry on")
+
+	rest, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	spec, err := parseBackend(rest[0])
+	if err != nil {
+		return err
+	}
+	if *devices > 0 {
+		mix, err := cangen.N2KMix(*devices)
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		streams = append(streams, mix...)
+	}
+	if *load < 0 || *load > 100 {
+		return usageErrorf("load must be between 0 and 100")
+	}
+	if len(streams) == 0 && *load == 0 {
+		return usageErrorf("nothing to generate; give -stream, -random, -n2k or -load")
+	}
+	for i := range streams {
+		streams[i].Jitter = *jitter
+	}
+
+	gen, err := cangen.NewGenerator(c.log, cangen.GeneratorOptions{
+		Streams:           streams,
+		BitRate:           backend.bitRate,
+		BusLoad:           *load / 100,
+		Seed:              *seed,
+		Duration:          *duration,
+		MaxFrames:         *limit,
+		IgnoreWriteErrors: *keepGoing,
+	})
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	bus, err := startBus(ctx, c.log, spec, backend, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := bus.close(); err != nil {
+			c.log.WithError(err).Debug("Closing bus failed")
+		}
+	}()
+
+	err = gen.Run(ctx, bus.channel)
+	stats := gen.Stats()
+	rate := 0.0
+	if stats.Elapsed > 0 {
+		rate = float64(stats.Frames) / stats.Elapsed.Seconds()
+	}
+	fmt.Fprintf(c.stdout, "%d frames in %v, %.1f frames/s, %.1f%% load, %d write errors, max lag %v\n",
+		stats.Frames, stats.Elapsed.Round(time.Millisecond), rate, 100*stats.Load(backend.bitRate), stats.WriteErrors,
+		stats.MaxLag.Round(time.Microsecond))
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStream(t *testing.T) {
+	s, err := parseStream("18EEFF00#0102@100ms*5")
+	require.NoError(t, err)
+	require.Equal(t, uint32(0x18eeff00)|can.MaskEff, s.ID)
+	require.Equal(t, []byte{1, 2}, s.Data)
+	require.Equal(t, 100*time.Millisecond, s.Period)
+	require.Equal(t, 5, s.Burst)
+
+	s, err = parseStream("123#@1s")
+	require.NoError(t, err)
+	require.Equal(t, uint32(0x123), s.ID)
+	require.Empty(t, s.Data)
+	require.Equal(t, 0, s.Burst)
+
+	for _, bad := range []string{"123#01", "123#01@", "123#01@-1s", "123#01@1s*0", "123#R@1s", "XYZ#01@1s"} {
+		_, err := parseStream(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestParseRandomStream(t *testing.T) {
+	s, err := parseRandomStream("100-1FF@5ms*2")
+	require.NoError(t, err)
+	require.True(t, s.RandomID)
+	require.Equal(t, uint32(0x100), s.IDMin)
+	require.Equal(t, uint32(0x1ff), s.IDMax)
+	require.Equal(t, 8, s.Length)
+	require.Equal(t, 5*time.Millisecond, s.Period)
+	require.Equal(t, 2, s.Burst)
+
+	for _, bad := range []string{"100@5ms", "200-100@5ms", "100-20000000@5ms", "100-1FF"} {
+		_, err := parseRandomStream(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestRunGenerateVirtual(t *testing.T) {
+	code, stdout, stderr := runCLI(t, "generate", "-n", "20", "-stream", "123#01@1ms*2", "-random", "100-1FF@1ms", "virtual")
+	require.Equal(t, 0, code, stderr)
+	require.Contains(t, stdout, "20 frames")
+
+	code, stdout, stderr = runCLI(t, "generate", "-n2k", "3", "-load", "10", "-duration", "50ms", "virtual")
+	require.Equal(t, 0, code, stderr)
+	require.Contains(t, stdout, "0 write errors")
+
+	code, _, stderr = runCLI(t, "generate", "virtual")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "nothing to generate")
+
+	code, _, stderr = runCLI(t, "generate", "-load", "150", "virtual")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "load must be between")
+}
@@ -0,0 +1,165 @@
+// Command tugboat-can is a CAN bus multitool for the boat computer.  It dumps, sends, records, replays, counts,
+// generates and bridges frames on any backend tugboat supports, and lists the CAN hardware that's plugged in.
+//
+//	tugboat-can dump -pgn 127250 can0
+//	tugboat-can send usbcan:/dev/ttyUSB0 09F80100#0102030405060708
+//	tugboat-can record can0 passage.log
+//	tugboat-can generate -n2k 20 -load 40 can0
+//	tugboat-can bridge can0 cannelloni:192.168.1.20:20000
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cli is what every subcommand gets to work with
+type cli struct {
+	log    *logrus.Logger
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command is a single tugboat-can subcommand
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, c *cli, fs *flag.FlagSet, args []string) error
+}
+
+// usageError is returned by a subcommand when it was called wrong, so run prints its usage
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...any) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// flagError wraps a flag parsing error, which the flag package has already reported along with the usage
+type flagError struct {
+	error
+}
+
+func commands() []command {
+	return []command{
+		{"dump", "BACKEND", "print frames as they arrive", runDump},
+		{"send", "BACKEND FRAME...", "send frames given in cansend syntax, i.e. 123#DEADBEEF", runSend},
+		{"record", "BACKEND FILE", "write frames to a candump log file (- for stdout)", runRecord},
+		{"replay", "BACKEND FILE", "send the frames in a candump log file with their original timing", runReplay},
+		{"stats", "BACKEND", "show frame rates, bus load and the busiest IDs", runStats},
+		{"generate", "BACKEND", "send synthetic traffic: fixed or random IDs, bursts, a bus load or an NMEA 2000 mix", runGenerate},
+		{"bridge", "BACKEND BACKEND", "forward frames between two backends", runBridge},
+		{"list-devices", "", "list CAN interfaces and serial adapters", runListDevices},
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run runs the subcommand named by args[0], returning the exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return 2
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		printUsage(stdout)
+		return 0
+	}
+
+	var cmd *command
+	for _, c := range commands() {
+		if c.name == args[0] {
+			cmd = &c
+			break
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "tugboat-can: unknown command %q\n\n", args[0])
+		printUsage(stderr)
+		return 2
+	}
+
+	log := logrus.New()
+	log.SetOutput(stderr)
+	log.SetLevel(logrus.WarnLevel)
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: tugboat-can %s [flags] %s\n\n%s.\n", cmd.name, cmd.args, cmd.summary)
+		if strings.Contains(cmd.args, "BACKEND") {
+			fmt.Fprintf(stderr, "\n%s\n", backendHelp)
+		}
+		fmt.Fprintf(stderr, "\nflags:\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolFunc("v", "log what the backends are doing", func(string) error {
+		log.SetLevel(logrus.DebugLevel)
+		return nil
+	})
+
+	err := cmd.run(ctx, &cli{log: log, stdin: stdin, stdout: stdout, stderr: stderr}, fs, args[1:])
+	var usageErr usageError
+	var flagErr flagError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &flagErr):
+		return 2
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(stderr, "tugboat-can %s: %v\n\n", cmd.name, err)
+		fs.Usage()
+		return 2
+	default:
+		fmt.Fprintf(stderr, "tugboat-can %s: %v\n", cmd.name, err)
+		return 1
+	}
+}
+
+// parseArgs is a helper to parse a subcommand's flags and check it got the expected number of arguments
+func parseArgs(fs *flag.FlagSet, args []string, minArgs, maxArgs int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, flagError{err}
+	}
+
+	rest := fs.Args()
+	if len(rest) < minArgs {
+		return nil, usageErrorf("not enough arguments")
+	}
+	if maxArgs >= 0 && len(rest) > maxArgs {
+		return nil, usageErrorf("too many arguments")
+	}
+	return rest, nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: tugboat-can COMMAND [flags] ARGS...\n\ncommands:\n")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-13s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\n%s\n\nRun \"tugboat-can COMMAND -h\" for a command's flags.\n", backendHelp)
+}
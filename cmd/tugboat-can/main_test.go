@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunUsage(t *testing.T) {
+	code, _, stderr := runCLI(t)
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "list-devices")
+
+	code, stdout, _ := runCLI(t, "help")
+	require.Equal(t, 0, code)
+	require.Contains(t, stdout, "usbcan:/dev/ttyUSB0")
+
+	code, _, stderr = runCLI(t, "frobnicate")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, `unknown command "frobnicate"`)
+
+	code, _, stderr = runCLI(t, "dump", "-h")
+	require.Equal(t, 0, code)
+	require.Contains(t, stderr, "-format")
+
+	code, _, stderr = runCLI(t, "dump", "-nope", "can0")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "flag provided but not defined")
+
+	code, _, stderr = runCLI(t, "send", "virtual")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "not enough arguments")
+
+	code, _, stderr = runCLI(t, "send", "virtual", "123#XYZ")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "invalid CAN data")
+
+	code, _, stderr = runCLI(t, "dump", "/dev/ttyUSB0")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "needs a kind")
+}
+
+func TestRunSendVirtual(t *testing.T) {
+	code, _, stderr := runCLI(t, "send", "-count", "3", "virtual", "123#01", "18EEFF00#0203")
+	require.Equal(t, 0, code, stderr)
+
+	// Only SocketCAN can confirm transmits
+	code, _, stderr = runCLI(t, "send", "-confirm", "virtual", "123#01")
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr, "can't confirm transmits")
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+)
+
+// recordFlushInterval is how often a recording is flushed to disk, so a crash or pulled plug loses little
+const recordFlushInterval = time.Second
+
+// lockedWriter serializes writes and flushes to a buffered writer
+type lockedWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
+
+func (l *lockedWriter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Flush()
+}
+
+func runRecord(ctx context.Context, c *cli, fs *flag.FlagSet, args []string) error {
+	var backend backendOptions
+	backend.register(fs)
+	var filter frameFilter
+	filter.register(fs)
+	limit := fs.Int("n", 0, "stop after this many frames")
+	duration := fs.Duration("duration", 0, "stop after this long")
+	appendFile := fs.Bool("append", false, "append to the file instead of replacing it")
+
+	rest, err := parseArgs(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	spec, err := parseBackend(rest[0])
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = c.stdout
+	if rest[1] != "-" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if *appendFile {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(filepath.Clean(rest[1]), flags, 0o644) // #nosec G302 -- logs are meant to be shared.
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				c.log.WithError(err).Warn("Closing recording failed")
+			}
+		}()
+		out = f
+	}
+
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	w := &lockedWriter{w: bufio.NewWriter(out)}
+	flushDone := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(recordFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.Flush(); err != nil {
+					c.log.WithError(err).Warn("Flushing recording failed")
+				}
+			case <-flushDone:
+				return
+			}
+		}
+	}()
+
+	fw := newFrameWriter(w, formatLog, spec.String(), &filter, *limit)
+	err = dumpFrames(ctx, c, spec, backend, fw)
+	close(flushDone)
+	return errors.Join(err, w.Flush())
+}
+
+// replayOptions control how a log is played back
+type replayOptions struct {
+	// speed scales the original timing; 2 plays twice as fast, and 0 sends as fast as the backend takes them.
+	speed float64
+	// loop starts over at the end of the log until ctx is done.
+	loop bool
+	// iface only replays the frames recorded on this interface, if set.
+	iface  string
+	filter *frameFilter
+}
+
+// replayLog sends the frames in a candump log with their original spacing, returning how many were sent.  Blank
+// lines and # comments are skipped.
+func replayLog(ctx context.Context, r io.Reader, bus canbus.Interface, options replayOptions) (int, error) {
+	var start time.Time
+	var first time.Time
+	sent := 0
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rec, err := canbus.ParseCandump(text)
+		if err != nil {
+			return sent, fmt.Errorf("line %d: %w", line, err)
+		}
+		if options.iface != "" && rec.Interface != options.iface {
+			continue
+		}
+		if options.filter != nil && !options.filter.match(rec.Frame) {
+			continue
+		}
+
+		if first.IsZero() {
+			first, start = rec.Time, time.Now()
+		} else if options.speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / options.speed))
+			if err := sleepUntil(ctx, due); err != nil {
+				return sent, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return sent, nil
+		}
+
+		if err := bus.WriteFrame(withEFF(rec.Frame)); err != nil {
+			return sent, fmt.Errorf("line %d: %w", line, err)
+		}
+		sent++
+	}
+	return sent, scanner.Err()
+}
+
+// sleepUntil is a helper that waits until a time, or returns ctx's error if it's done first
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func runReplay(ctx context.Context, c *cli, fs *flag.FlagSet, args []string) error {
+	var backend backendOptions
+	backend.register(fs)
+	var filter frameFilter
+	filter.register(fs)
+	options := replayOptions{filter: &filter}
+	fs.Float64Var(&options.speed, "speed", 1, "playback speed; 0 sends as fast as possible")
+	fs.BoolVar(&options.loop, "loop", false, "start over at the end of the log")
+	fs.StringVar(&options.iface, "interface", "", "only replay frames recorded on this `interface`")
+
+	rest, err := parseArgs(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	spec, err := parseBackend(rest[0])
+	if err != nil {
+		return err
+	}
+	if options.speed < 0 {
+		return usageErrorf("speed can't be negative")
+	}
+	if options.loop && rest[1] == "-" {
+		return usageErrorf("can't loop over stdin")
+	}
+
+	bus, err := startBus(ctx, c.log, spec, backend, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := bus.close(); err != nil {
+			c.log.WithError(err).Debug("Closing bus failed")
+		}
+	}()
+
+	total := 0
+	for {
+		r := c.stdin
+		var f *os.File
+		if rest[1] != "-" {
+			if f, err = os.Open(filepath.Clean(rest[1])); err != nil {
+				return err
+			}
+			r = f
+		}
+
+		sent, err := replayLog(ctx, r, bus.channel, options)
+		total += sent
+		if f != nil {
+			_ = f.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if !options.loop || ctx.Err() != nil {
+			break
+		}
+		if sent == 0 {
+			return errors.New("nothing to replay")
+		}
+	}
+
+	c.log.WithField("frames", total).Info("Replay finished")
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/canbus/canbustest"
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+const testLog = `# recorded on the test bench
+(1700000000.000000) can0 09F80102#0102
+(1700000000.050000) can1 123#DEADBEEF
+
+(1700000000.100000) can0 09F11201#FF1020
+`
+
+func TestReplayLog(t *testing.T) {
+	bus := canbustest.NewBus(nil)
+	start := time.Now()
+	sent, err := replayLog(context.Background(), strings.NewReader(testLog), bus, replayOptions{speed: 2})
+	require.NoError(t, err)
+	require.Equal(t, 3, sent)
+	require.Equal(t, []can.Frame{
+		{ID: 0x09f80102 | can.MaskEff, Length: 2, Data: [8]byte{0x01, 0x02}},
+		{ID: 0x123, Length: 4, Data: [8]byte{0xde, 0xad, 0xbe, 0xef}},
+		{ID: 0x09f11201 | can.MaskEff, Length: 3, Data: [8]byte{0xff, 0x10, 0x20}},
+	}, bus.Frames())
+
+	// At double speed the last frame goes out 50ms after the first
+	require.GreaterOrEqual(t, bus.Times()[2].Sub(start), 45*time.Millisecond)
+}
+
+func TestReplayLogSelects(t *testing.T) {
+	bus := canbustest.NewBus(nil)
+	sent, err := replayLog(context.Background(), strings.NewReader(testLog), bus, replayOptions{iface: "can0"})
+	require.NoError(t, err)
+	require.Equal(t, 2, sent)
+
+	bus = canbustest.NewBus(nil)
+	sent, err = replayLog(context.Background(), strings.NewReader(testLog), bus, replayOptions{filter: parseFilter(t, "-pgn", "127250")})
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+	require.Equal(t, uint32(0x09f11201|can.MaskEff), bus.Frames()[0].ID)
+
+	_, err = replayLog(context.Background(), strings.NewReader("(1700000000.000000) can0 09F80102#0102\nbogus\n"), bus, replayOptions{})
+	require.ErrorContains(t, err, "line 2")
+}
+
+func TestReplayLogStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	log := "(1700000000.000000) can0 123#01\n(1700000100.000000) can0 123#02\n"
+	sent, err := replayLog(ctx, strings.NewReader(log), canbustest.NewBus(nil), replayOptions{speed: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+}
+
+func TestRecordAndReplayRoundTrip(t *testing.T) {
+	// Record what a replay puts on a virtual bus, then check it reads back the same
+	var out bytes.Buffer
+	fw := newFrameWriter(&out, formatLog, "vcan0", &frameFilter{}, 0)
+	bus := newVirtualChannel(fw.handle)
+	_, err := replayLog(context.Background(), strings.NewReader(testLog), bus, replayOptions{})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "roundtrip.log")
+	require.NoError(t, os.WriteFile(path, out.Bytes(), 0o600))
+
+	code, _, stderr := runCLI(t, "replay", "-speed", "0", "virtual", path)
+	require.Equal(t, 0, code, stderr)
+
+	var frames []can.Frame
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		rec, err := canbus.ParseCandump(line)
+		require.NoError(t, err)
+		require.Equal(t, "vcan0", rec.Interface)
+		frames = append(frames, rec.Frame)
+	}
+	require.Len(t, frames, 3)
+	require.Equal(t, uint32(0x123), frames[1].ID)
+}
+
+func TestRecordToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.log")
+	code, _, stderr := runCLI(t, "record", "-duration", "10ms", "virtual", path)
+	require.Equal(t, 0, code, stderr)
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Empty(t, b)
+}
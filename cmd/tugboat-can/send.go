@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/brutella/can"
+)
+
+// confirmingWriter is implemented by channels that can tell when a frame has actually gone out on the bus, such
+// as SocketCANChannel
+type confirmingWriter interface {
+	WriteFrameConfirmed(ctx context.Context, frame can.Frame) (time.Time, error)
+}
+
+func runSend(ctx context.Context, c *cli, fs *flag.FlagSet, args []string) error {
+	var backend backendOptions
+	backend.register(fs)
+	count := fs.Int("count", 1, "send the frames this many times, 0 for forever")
+	interval := fs.Duration("interval", 0, "wait this long between frames")
+	confirm := fs.Bool("confirm", false, "wait until each frame has been acked on the bus (SocketCAN only)")
+
+	rest, err := parseArgs(fs, args, 2, -1)
+	if err != nil {
+		return err
+	}
+	spec, err := parseBackend(rest[0])
+	if err != nil {
+		return err
+	}
+
+	frames := make([]can.Frame, 0, len(rest)-1)
+	for _, s := range rest[1:] {
+		frame, err := canbus.ParseCandumpFrame(s)
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		frames = append(frames, frame)
+	}
+
+	backend.confirmTransmit = *confirm
+	bus, err := startBus(ctx, c.log, spec, backend, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := bus.close(); err != nil {
+			c.log.WithError(err).Debug("Closing bus failed")
+		}
+	}()
+
+	var confirmer confirmingWriter
+	if *confirm {
+		var ok bool
+		if confirmer, ok = bus.channel.(confirmingWriter); !ok {
+			return fmt.Errorf("%s backend can't confirm transmits", spec.kind)
+		}
+	}
+
+	sent := 0
+	for i := 0; *count == 0 || i < *count; i++ {
+		for _, frame := range frames {
+			if sent > 0 && *interval > 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(*interval):
+				}
+			} else if ctx.Err() != nil {
+				return nil
+			}
+
+			if confirmer == nil {
+				if err := bus.channel.WriteFrame(frame); err != nil {
+					return err
+				}
+			} else {
+				ts, err := confirmer.WriteFrameConfirmed(ctx, frame)
+				if err != nil {
+					if errors.Is(err, context.Canceled) {
+						return nil
+					}
+					return err
+				}
+				fmt.Fprintf(c.stdout, "(%d.%06d) %s sent\n", ts.Unix(), ts.Nanosecond()/1000, canbus.FormatCandumpFrame(frame))
+			}
+			sent++
+		}
+	}
+	return nil
+}
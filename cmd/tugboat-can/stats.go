@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/cangen"
+	"github.com/brutella/can"
+)
+
+// idStats counts the frames seen with one CAN ID
+type idStats struct {
+	id     uint32
+	frames uint64
+}
+
+// busStats counts the traffic on a bus
+type busStats struct {
+	bitRate int
+
+	mu          sync.Mutex
+	start       time.Time
+	frames      uint64
+	bits        uint64
+	windowStart time.Time
+	window      uint64
+	windowBits  uint64
+	ids         map[uint32]*idStats
+}
+
+func newBusStats(bitRate int, now time.Time) *busStats {
+	return &busStats{
+		bitRate:     bitRate,
+		start:       now,
+		windowStart: now,
+		ids:         map[uint32]*idStats{},
+	}
+}
+
+// add counts a frame.
+func (s *busStats) add(frame can.Frame) {
+	id := withEFF(frame).ID &^ can.MaskRtr
+	bits := cangen.FrameBits(frame)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.frames++
+	s.window++
+	s.bits += bits
+	s.windowBits += bits
+	st, ok := s.ids[id]
+	if !ok {
+		st = &idStats{id: id}
+		s.ids[id] = st
+	}
+	st.frames++
+}
+
+// report writes the totals, the rate and bus load since the last report, and the busiest IDs, then starts a new
+// reporting window.
+func (s *busStats) report(w io.Writer, now time.Time, top int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := now.Sub(s.start).Seconds()
+	window := now.Sub(s.windowStart).Seconds()
+	rate, load := 0.0, 0.0
+	if window > 0 {
+		rate = float64(s.window) / window
+		if s.bitRate > 0 {
+			load = 100 * float64(s.windowBits) / (float64(s.bitRate) * window)
+		}
+	}
+	s.windowStart, s.window, s.windowBits = now, 0, 0
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%s\t%d frames\t%d IDs\t%.1f frames/s\t%.1f%% load\t\n",
+		now.Format(time.TimeOnly), s.frames, len(s.ids), rate, load)
+
+	ids := make([]*idStats, 0, len(s.ids))
+	for _, st := range s.ids {
+		ids = append(ids, st)
+	}
+	slices.SortFunc(ids, func(a, b *idStats) int {
+		if c := cmp.Compare(b.frames, a.frames); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.id, b.id)
+	})
+	if top > 0 && len(ids) > top {
+		ids = ids[:top]
+	}
+
+	if len(ids) > 0 {
+		fmt.Fprintf(tw, "ID\tPGN\tSRC\tFRAMES\tFRAMES/s\t\n")
+	}
+	for _, st := range ids {
+		perSecond := 0.0
+		if elapsed > 0 {
+			perSecond = float64(st.frames) / elapsed
+		}
+		if st.id&can.MaskEff != 0 {
+			h := canbus.ParseN2KHeader(st.id)
+			fmt.Fprintf(tw, "%08X\t%d\t%d\t%d\t%.1f\t\n", st.id&can.MaskIDEff, h.PGN, h.Source, st.frames, perSecond)
+		} else {
+			fmt.Fprintf(tw, "%03X\t-\t-\t%d\t%.1f\t\n", st.id&can.MaskIDSff, st.frames, perSecond)
+		}
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
+
+func runStats(ctx context.Context, c *cli, fs *flag.FlagSet, args []string) error {
+	var backend backendOptions
+	backend.register(fs)
+	var filter frameFilter
+	filter.register(fs)
+	interval := fs.Duration("interval", time.Second, "how often to report")
+	top := fs.Int("top", 10, "how many of the busiest IDs to list, 0 for all")
+	duration := fs.Duration("duration", 0, "stop after this long")
+
+	rest, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	spec, err := parseBackend(rest[0])
+	if err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return usageErrorf("interval must be positive")
+	}
+
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	stats := newBusStats(backend.bitRate, time.Now())
+	bus, err := startBus(ctx, c.log, spec, backend, func(frame can.Frame) {
+		if filter.match(frame) {
+			stats.add(frame)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- bus.wait(ctx)
+	}()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := stats.report(c.stdout, time.Now(), *top); err != nil {
+				_ = bus.close()
+				return err
+			}
+		case err := <-runDone:
+			if closeErr := bus.close(); closeErr != nil {
+				c.log.WithError(closeErr).Debug("Closing bus failed")
+			}
+			if err != nil {
+				return err
+			}
+			return stats.report(c.stdout, time.Now(), *top)
+		}
+	}
+}
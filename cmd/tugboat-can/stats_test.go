@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusStatsReport(t *testing.T) {
+	start := time.Date(2026, 7, 4, 12, 0, 0, 0, time.UTC)
+	stats := newBusStats(250000, start)
+
+	for range 100 {
+		stats.add(can.Frame{ID: 0x09f80102, Length: 8})
+	}
+	for range 50 {
+		stats.add(can.Frame{ID: 0x09f11201 | can.MaskEff, Length: 8})
+	}
+	stats.add(can.Frame{ID: 0x123, Length: 8})
+
+	var out bytes.Buffer
+	require.NoError(t, stats.report(&out, start.Add(time.Second), 2))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 4)
+
+	// 100 + 50 extended frames at 131 bits plus one standard at 111 bits, out of 250000 bits
+	require.Equal(t, []string{"12:00:01", "151", "frames", "3", "IDs", "151.0", "frames/s", "7.9%", "load"}, strings.Fields(lines[0]))
+	require.Equal(t, []string{"ID", "PGN", "SRC", "FRAMES", "FRAMES/s"}, strings.Fields(lines[1]))
+	require.Equal(t, []string{"09F80102", "129025", "2", "100", "100.0"}, strings.Fields(lines[2]))
+	require.Equal(t, []string{"09F11201", "127250", "1", "50", "50.0"}, strings.Fields(lines[3]))
+
+	// The rate is per window, the totals aren't
+	out.Reset()
+	stats.add(can.Frame{ID: 0x123})
+	require.NoError(t, stats.report(&out, start.Add(3*time.Second), 0))
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 5)
+	require.Equal(t, "0.5", strings.Fields(lines[0])[5])
+	require.Equal(t, []string{"123", "-", "-", "2", "0.7"}, strings.Fields(lines[4]))
+}
@@ -0,0 +1,654 @@
+package canbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"go.bug.st/serial"
+)
+
+// Docs for the Actisense BST binary protocol (there are no public ones from Actisense, canboat is the reference):
+// * https://github.com/canboat/canboat/blob/master/actisense-serial/actisense-serial.c
+// * https://github.com/canboat/canboat/blob/master/common/parse.c
+
+const (
+	bstDLE = 0x10
+	bstSTX = 0x02
+	bstETX = 0x03
+)
+
+// ActisenseCommand is an enum for the BST message type byte
+type ActisenseCommand byte
+
+const (
+	// ActisenseN2KReceived is an NMEA 2000 message received from the bus by the gateway.
+	ActisenseN2KReceived ActisenseCommand = 0x93
+	// ActisenseN2KSend is an NMEA 2000 message for the gateway to transmit.
+	ActisenseN2KSend ActisenseCommand = 0x94
+	// ActisenseBEMResponse is the gateway's answer to a BEM command.
+	ActisenseBEMResponse ActisenseCommand = 0xa0
+	// ActisenseBEMCommand is a configuration command for the gateway.
+	ActisenseBEMCommand ActisenseCommand = 0xa1
+)
+
+// ActisenseBEM is an enum for the BEM command IDs we know how to send
+type ActisenseBEM byte
+
+const (
+	BEMOperatingMode ActisenseBEM = 0x11
+	BEMRxPGNEnable   ActisenseBEM = 0x46
+	BEMTxPGNEnable   ActisenseBEM = 0x47
+)
+
+// ActisenseOperatingMode is the operating mode set with BEMOperatingMode
+type ActisenseOperatingMode uint16
+
+const (
+	// ActisenseModeRxPGNList only delivers PGNs on the gateway's Rx enable list (the factory default).
+	ActisenseModeRxPGNList ActisenseOperatingMode = 0x0001
+	// ActisenseModeReceiveAll delivers every PGN seen on the bus regardless of the enable list.
+	ActisenseModeReceiveAll ActisenseOperatingMode = 0x0002
+)
+
+// defaultActisenseCommandTimeout is how long we wait for a BEM response if the options don't say.
+const defaultActisenseCommandTimeout = time.Second
+
+// ActisenseChannelOptions is a type that contains required options on an ActisenseChannel.
+type ActisenseChannelOptions struct {
+	SerialPortName string
+	// SerialBaudRate is 115200 for the NGT-1 and NGX-1 unless reconfigured.
+	SerialBaudRate int
+	// ReceiveAll puts the gateway in receive-all mode at startup, otherwise it only delivers RxPGNs (plus whatever
+	// was enabled previously and saved on the gateway).
+	ReceiveAll bool
+	// RxPGNs and TxPGNs are added to the gateway's enable lists at startup.
+	RxPGNs []uint32
+	TxPGNs []uint32
+	// FrameHandler receives each message as CAN frames, split into fast-packet series where it doesn't fit in one,
+	// so code written against raw CAN channels works unchanged.
+	FrameHandler can.HandlerFunc
+	// MessageHandler receives each message whole, as the gateway delivered it.
+	MessageHandler func(PGNMessage)
+	// CommandTimeout bounds how long we wait for the gateway to answer a BEM command.  Defaults to one second.
+	CommandTimeout time.Duration
+	// FastPacketPGN reports whether a PGN is sent as a fast-packet series; nmea2000.Registry's IsFastPacket fits.
+	// With it, WriteFrame reassembles fast-packet series into whole messages for the gateway, and FrameHandler
+	// gets even short fast-packet messages as a series.  Without it, every frame written is sent as a message of
+	// its own, so fast-packet PGNs have to go through WriteMessage.
+	FastPacketPGN func(pgn uint32) bool
+}
+
+type actisenseCommandWaiter struct {
+	bem ActisenseBEM
+	ch  chan []byte
+}
+
+// ActisenseChannel represents an NMEA 2000 network reached through an Actisense NGT-1/NGX-1 gateway speaking the
+// BST binary protocol over serial.  The gateway deals in whole PGNs rather than CAN frames, so WriteFrame
+// reassembles fast-packet series (given FastPacketPGN) and sends everything else as a single-frame message.
+type ActisenseChannel struct {
+	options ActisenseChannelOptions
+
+	startMu  sync.Mutex
+	mu       sync.Mutex
+	port     serial.Port
+	closed   bool
+	done     chan struct{}
+	openPort serialPortOpener
+
+	decoder bstDecoder
+	writeMu sync.Mutex
+	rxSeq   uint8
+	// txAssembler reassembles the fast-packet series written with WriteFrame, if FastPacketPGN is set.
+	txAssembler *FastPacketAssembler
+
+	waitersMu sync.Mutex
+	waiters   []*actisenseCommandWaiter
+
+	tracer frameTracer
+
+	log *logrus.Logger
+}
+
+// NewActisenseChannel returns a Channel object based on an Actisense gateway and the given options.
+// ChannelOptions are required settings.
+func NewActisenseChannel(log *logrus.Logger, options ActisenseChannelOptions) *ActisenseChannel {
+	if options.CommandTimeout == 0 {
+		options.CommandTimeout = defaultActisenseCommandTimeout
+	}
+
+	c := ActisenseChannel{
+		options:  options,
+		log:      log,
+		done:     make(chan struct{}),
+		openPort: serial.Open,
+	}
+	if options.FastPacketPGN != nil {
+		c.txAssembler = NewFastPacketAssembler(options.FastPacketPGN)
+	}
+
+	return &c
+}
+
+// Start synchronously opens the serial port and applies the operating mode and PGN enable lists.
+func (c *ActisenseChannel) Start(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errors.New("actisense channel is closed")
+	}
+	if c.port != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	done := c.done
+	c.mu.Unlock()
+
+	mode := &serial.Mode{
+		BaudRate: c.options.SerialBaudRate,
+	}
+	resultCh := make(chan serialOpenResult, 1)
+	go func() {
+		port, err := c.openPort(c.options.SerialPortName, mode)
+		if err == nil {
+			err = c.configure(port)
+			if err != nil {
+				_ = port.Close()
+				port = nil
+			}
+		}
+		resultCh <- serialOpenResult{port: port, err: err}
+	}()
+
+	var result serialOpenResult
+	select {
+	case result = <-resultCh:
+	case <-ctx.Done():
+		go closeAbandonedSerialPort(resultCh)
+		return ctx.Err()
+	case <-done:
+		go closeAbandonedSerialPort(resultCh)
+		return errors.New("actisense channel is closed")
+	}
+	if result.err != nil {
+		return result.err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		_ = result.port.Close()
+		return errors.New("actisense channel is closed")
+	}
+	c.port = result.port
+	c.mu.Unlock()
+
+	c.log.WithField("portName", c.options.SerialPortName).
+		Info("Opened Actisense")
+
+	return nil
+}
+
+// configure is a helper to send the startup BEM commands, reading responses directly off the port since Run isn't
+// consuming it yet.
+func (c *ActisenseChannel) configure(port serial.Port) error {
+	var cmds [][]byte
+	if c.options.ReceiveAll {
+		cmds = append(cmds, operatingModeCommand(ActisenseModeReceiveAll))
+	}
+	for _, pgn := range c.options.RxPGNs {
+		cmds = append(cmds, pgnEnableCommand(BEMRxPGNEnable, pgn, true))
+	}
+	for _, pgn := range c.options.TxPGNs {
+		cmds = append(cmds, pgnEnableCommand(BEMTxPGNEnable, pgn, true))
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	if err := port.SetReadTimeout(10 * time.Millisecond); err != nil {
+		return err
+	}
+	defer func() {
+		_ = port.SetReadTimeout(serial.NoTimeout)
+	}()
+
+	working := make([]byte, 256)
+	for _, cmd := range cmds {
+		if err := c.commandDirect(port, cmd, working); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commandDirect is a helper to send a BEM command and synchronously pump the port until it is answered
+func (c *ActisenseChannel) commandDirect(port serial.Port, cmd []byte, working []byte) error {
+	waiter := c.addCommandWaiter(ActisenseBEM(cmd[0]))
+	defer c.removeCommandWaiter(waiter)
+
+	if err := c.writePacket(port, ActisenseBEMCommand, cmd); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(c.options.CommandTimeout)
+	for {
+		select {
+		case <-waiter.ch:
+			return nil
+		default:
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("no response to BEM command %#x within %s", cmd[0], c.options.CommandTimeout)
+		}
+
+		readBytes, err := port.Read(working)
+		if err != nil {
+			return err
+		}
+		c.handleBytes(working[:readBytes])
+	}
+}
+
+// SendBEMCommand sends a raw BEM command (the BEM ID followed by its parameters) and returns the payload of the
+// gateway's response.  Run must be active to receive the response.
+func (c *ActisenseChannel) SendBEMCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	if len(cmd) == 0 {
+		return nil, errors.New("empty BEM command")
+	}
+	port, err := c.openedPort()
+	if err != nil {
+		return nil, err
+	}
+
+	waiter := c.addCommandWaiter(ActisenseBEM(cmd[0]))
+	defer c.removeCommandWaiter(waiter)
+
+	if err := c.writePacket(port, ActisenseBEMCommand, cmd); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(c.options.CommandTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-waiter.ch:
+		return resp, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no response to BEM command %#x within %s", cmd[0], c.options.CommandTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SetRxPGNEnabled adds or removes a PGN from the gateway's receive enable list.  Run must be active.
+func (c *ActisenseChannel) SetRxPGNEnabled(ctx context.Context, pgn uint32, enabled bool) error {
+	_, err := c.SendBEMCommand(ctx, pgnEnableCommand(BEMRxPGNEnable, pgn, enabled))
+	return err
+}
+
+// SetTxPGNEnabled adds or removes a PGN from the gateway's transmit enable list.  Run must be active.
+func (c *ActisenseChannel) SetTxPGNEnabled(ctx context.Context, pgn uint32, enabled bool) error {
+	_, err := c.SendBEMCommand(ctx, pgnEnableCommand(BEMTxPGNEnable, pgn, enabled))
+	return err
+}
+
+// Run starts listening after synchronously opening the gateway.
+func (c *ActisenseChannel) Run(ctx context.Context) error {
+	if err := c.Start(ctx); err != nil {
+		return err
+	}
+
+	port, err := c.openedPort()
+	if err != nil {
+		return err
+	}
+
+	c.log.WithField("portName", c.options.SerialPortName).
+		Info("Listening on Actisense")
+
+	working := make([]byte, 256)
+	for {
+		readBytes, err := port.Read(working)
+		if err != nil {
+			if c.isClosed() {
+				return nil
+			}
+			return err
+		}
+		c.handleBytes(working[:readBytes])
+	}
+}
+
+// handleBytes is a helper to feed received bytes through the BST decoder and act on each complete packet
+func (c *ActisenseChannel) handleBytes(buf []byte) {
+	for _, b := range buf {
+		packet, err := c.decoder.feed(b)
+		if err != nil {
+			c.log.Debugf("Bad BST packet: %v\n", err)
+			continue
+		}
+		if packet != nil {
+			c.handlePacket(packet)
+		}
+	}
+}
+
+// handlePacket is a helper to route a single validated BST packet (command, length, payload)
+func (c *ActisenseChannel) handlePacket(packet []byte) {
+	payload := packet[2:]
+
+	switch ActisenseCommand(packet[0]) {
+	case ActisenseN2KReceived:
+		msg, err := parseActisenseN2K(payload)
+		if err != nil {
+			c.log.Debugf("Bad Actisense N2K message: %v\n", err)
+			return
+		}
+		if c.options.MessageHandler != nil {
+			c.options.MessageHandler(msg)
+		}
+		frames := msg.Frames(c.rxSeq)
+		if len(msg.Data) > can.MaxFrameDataLength || c.options.FastPacketPGN != nil && c.options.FastPacketPGN(msg.PGN) {
+			frames = msg.FastPacketFrames(c.rxSeq)
+			c.rxSeq = (c.rxSeq + 1) & 0x7
+		}
+		for _, f := range frames {
+			c.tracer.trace(c.log, TraceReceive, f)
+			if c.options.FrameHandler != nil {
+				c.options.FrameHandler(f)
+			}
+		}
+	case ActisenseBEMResponse:
+		if len(payload) == 0 {
+			return
+		}
+		c.waitersMu.Lock()
+		defer c.waitersMu.Unlock()
+		for i, w := range c.waiters {
+			if w.bem == ActisenseBEM(payload[0]) {
+				w.ch <- slices.Clone(payload)
+				c.waiters = slices.Delete(c.waiters, i, i+1)
+				return
+			}
+		}
+	default:
+		c.log.Debugf("Unhandled BST packet: %+v\n", packet)
+	}
+}
+
+func (c *ActisenseChannel) addCommandWaiter(bem ActisenseBEM) *actisenseCommandWaiter {
+	w := &actisenseCommandWaiter{
+		bem: bem,
+		ch:  make(chan []byte, 1),
+	}
+
+	c.waitersMu.Lock()
+	c.waiters = append(c.waiters, w)
+	c.waitersMu.Unlock()
+
+	return w
+}
+
+func (c *ActisenseChannel) removeCommandWaiter(w *actisenseCommandWaiter) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+
+	if i := slices.Index(c.waiters, w); i != -1 {
+		c.waiters = slices.Delete(c.waiters, i, i+1)
+	}
+}
+
+// Close shuts down the channel
+func (c *ActisenseChannel) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	port := c.port
+	c.port = nil
+	c.mu.Unlock()
+	if port == nil {
+		return nil
+	}
+	return port.Close()
+}
+
+// WriteFrame will send a CAN frame to the bus as a single-frame PGN, or hold on to it until the rest of its
+// fast-packet series arrives and send the whole message.
+func (c *ActisenseChannel) WriteFrame(frame can.Frame) error {
+	if frame.Length > can.MaxFrameDataLength {
+		return fmt.Errorf("invalid frame length %d", frame.Length)
+	}
+
+	msg := PGNMessage{N2KHeader: ParseN2KHeader(frame.ID), Data: frame.Data[:frame.Length]}
+	if c.txAssembler != nil {
+		var ok bool
+		if msg, ok = c.txAssembler.Add(frame); !ok {
+			c.tracer.trace(c.log, TraceTransmit, frame)
+			return nil
+		}
+	}
+
+	if err := c.writeMessage(msg); err != nil {
+		return err
+	}
+	c.tracer.trace(c.log, TraceTransmit, frame)
+	return nil
+}
+
+// WriteMessage will send a complete NMEA 2000 message to the bus, letting the gateway split it into a fast-packet
+// series if needed.  The gateway fills in its own source address.
+func (c *ActisenseChannel) WriteMessage(msg PGNMessage) error {
+	if err := c.writeMessage(msg); err != nil {
+		return err
+	}
+	c.tracer.traceMessage(c.log, TraceTransmit, msg)
+	return nil
+}
+
+// SetTrace starts logging each frame received and transmitted, or stops if options is nil.  Messages are traced
+// as the frames they're carried in on the bus.
+func (c *ActisenseChannel) SetTrace(options *TraceOptions) {
+	c.tracer.set(options)
+}
+
+// writeMessage is a helper to send a message to the gateway without tracing it
+func (c *ActisenseChannel) writeMessage(msg PGNMessage) error {
+	port, err := c.openedPort()
+	if err != nil {
+		return err
+	}
+	if len(msg.Data) > 223 {
+		return fmt.Errorf("message too long for fast-packet: %d bytes", len(msg.Data))
+	}
+
+	payload := make([]byte, 0, 6+len(msg.Data))
+	payload = append(payload, msg.Priority, byte(msg.PGN), byte(msg.PGN>>8), byte(msg.PGN>>16), msg.Destination, byte(len(msg.Data)))
+	payload = append(payload, msg.Data...)
+
+	return c.writePacket(port, ActisenseN2KSend, payload)
+}
+
+func (c *ActisenseChannel) writePacket(port serial.Port, command ActisenseCommand, payload []byte) error {
+	buf := encodeBSTPacket(command, payload)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	o, err := port.Write(buf)
+	if o != len(buf) {
+		return fmt.Errorf("writePacket sent %d of %d bytes", o, len(buf))
+	}
+	return err
+}
+
+func (c *ActisenseChannel) openedPort() (serial.Port, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.port == nil {
+		return nil, errors.New("actisense channel is not open")
+	}
+	return c.port, nil
+}
+
+func (c *ActisenseChannel) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+var (
+	_ Interface = (*ActisenseChannel)(nil)
+	_ Tracer    = (*ActisenseChannel)(nil)
+)
+
+// parseActisenseN2K is a helper to decode the payload of an ActisenseN2KReceived packet
+func parseActisenseN2K(payload []byte) (PGNMessage, error) {
+	// priority, PGN (3), destination, source, timestamp (4), length
+	const headerLen = 11
+	if len(payload) < headerLen {
+		return PGNMessage{}, fmt.Errorf("message too short: %d bytes", len(payload))
+	}
+	dataLen := int(payload[10])
+	if len(payload) != headerLen+dataLen {
+		return PGNMessage{}, fmt.Errorf("data length %d doesn't match %d bytes", dataLen, len(payload)-headerLen)
+	}
+
+	return PGNMessage{
+		N2KHeader: N2KHeader{
+			Priority:    payload[0],
+			PGN:         uint32(payload[1]) | uint32(payload[2])<<8 | uint32(payload[3])<<16,
+			Destination: payload[4],
+			Source:      payload[5],
+		},
+		Data: slices.Clone(payload[headerLen:]),
+	}, nil
+}
+
+// operatingModeCommand is a helper to build a BEMOperatingMode command
+func operatingModeCommand(mode ActisenseOperatingMode) []byte {
+	return binary.LittleEndian.AppendUint16([]byte{byte(BEMOperatingMode)}, uint16(mode))
+}
+
+// pgnEnableCommand is a helper to build a BEMRxPGNEnable/BEMTxPGNEnable command
+func pgnEnableCommand(bem ActisenseBEM, pgn uint32, enabled bool) []byte {
+	cmd := binary.LittleEndian.AppendUint32([]byte{byte(bem)}, pgn)
+	if enabled {
+		return append(cmd, 1)
+	}
+	return append(cmd, 0)
+}
+
+// encodeBSTPacket is a helper to frame a BST packet: DLE STX, then the command, length, payload and checksum with
+// every DLE doubled, then DLE ETX.  The checksum makes the command, length, payload and checksum sum to zero.
+func encodeBSTPacket(command ActisenseCommand, payload []byte) []byte {
+	buf := make([]byte, 0, 2*(len(payload)+3)+4)
+	buf = append(buf, bstDLE, bstSTX)
+
+	sum := byte(0)
+	appendEscaped := func(b byte) {
+		sum += b
+		buf = append(buf, b)
+		if b == bstDLE {
+			buf = append(buf, bstDLE)
+		}
+	}
+	appendEscaped(byte(command))
+	appendEscaped(byte(len(payload)))
+	for _, b := range payload {
+		appendEscaped(b)
+	}
+	appendEscaped(-sum)
+
+	return append(buf, bstDLE, bstETX)
+}
+
+// bstDecoder is a byte-at-a-time BST packet decoder that resynchronizes on the next DLE STX after garbage
+type bstDecoder struct {
+	inPacket bool
+	escaped  bool
+	buf      []byte
+}
+
+// feed consumes one byte, returning the unescaped command, length and payload (without the checksum) once a
+// complete valid packet has been seen.
+func (d *bstDecoder) feed(b byte) ([]byte, error) {
+	if d.escaped {
+		d.escaped = false
+		switch b {
+		case bstDLE:
+			if d.inPacket {
+				d.buf = append(d.buf, bstDLE)
+			} else {
+				// Outside a packet there's nothing to escape, so the second DLE may be the start of DLE STX.
+				d.escaped = true
+			}
+			return nil, nil
+		case bstSTX:
+			wasInPacket := d.inPacket && len(d.buf) > 0
+			d.inPacket = true
+			d.buf = d.buf[:0]
+			if wasInPacket {
+				return nil, errors.New("packet restarted before it ended")
+			}
+			return nil, nil
+		case bstETX:
+			if !d.inPacket {
+				return nil, nil
+			}
+			d.inPacket = false
+			return d.finish()
+		default:
+			d.inPacket = false
+			return nil, fmt.Errorf("unexpected byte %#x after DLE", b)
+		}
+	}
+
+	if b == bstDLE {
+		d.escaped = true
+		return nil, nil
+	}
+	if d.inPacket {
+		d.buf = append(d.buf, b)
+	}
+	return nil, nil
+}
+
+func (d *bstDecoder) finish() ([]byte, error) {
+	buf := d.buf
+	if len(buf) < 3 {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(buf))
+	}
+	if int(buf[1]) != len(buf)-3 {
+		return nil, fmt.Errorf("length byte %d doesn't match %d payload bytes", buf[1], len(buf)-3)
+	}
+	sum := byte(0)
+	for _, b := range buf {
+		sum += b
+	}
+	if sum != 0 {
+		return nil, fmt.Errorf("bad checksum %#x", buf[len(buf)-1])
+	}
+
+	return slices.Clone(buf[:len(buf)-1]), nil
+}
@@ -0,0 +1,187 @@
+package canbus
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeActisenseGateway answers BEM commands and records N2K sends on the controlling side of a pty.
+type fakeActisenseGateway struct {
+	master *os.File
+
+	mu      sync.Mutex
+	packets [][]byte
+}
+
+func runFakeActisenseGateway(t *testing.T, master *os.File) *fakeActisenseGateway {
+	t.Helper()
+
+	g := &fakeActisenseGateway{master: master}
+	go func() {
+		var decoder bstDecoder
+		buf := make([]byte, 256)
+		for {
+			n, err := master.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, b := range buf[:n] {
+				packet, err := decoder.feed(b)
+				if err != nil || packet == nil {
+					continue
+				}
+				g.mu.Lock()
+				g.packets = append(g.packets, packet)
+				g.mu.Unlock()
+
+				if ActisenseCommand(packet[0]) == ActisenseBEMCommand {
+					// Echo the BEM ID back with a zero status.
+					resp := encodeBSTPacket(ActisenseBEMResponse, []byte{packet[2], 0})
+					if _, err := master.Write(resp); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return g
+}
+
+func (g *fakeActisenseGateway) Packets() [][]byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([][]byte{}, g.packets...)
+}
+
+func TestActisenseChannelLifecycle(t *testing.T) {
+	master, portName := openTestPTY(t)
+	gateway := runFakeActisenseGateway(t, master)
+
+	frames := make(chan can.Frame, 8)
+	messages := make(chan PGNMessage, 2)
+	channel := NewActisenseChannel(logrus.New(), ActisenseChannelOptions{
+		SerialPortName: portName,
+		SerialBaudRate: 115200,
+		ReceiveAll:     true,
+		RxPGNs:         []uint32{127250},
+		FrameHandler:   func(f can.Frame) { frames <- f },
+		MessageHandler: func(m PGNMessage) { messages <- m },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, channel.Start(ctx))
+	require.Equal(t, [][]byte{
+		{byte(ActisenseBEMCommand), 3, 0x11, 0x02, 0x00},
+		{byte(ActisenseBEMCommand), 6, 0x46, 0x12, 0xf1, 0x01, 0x00, 0x01},
+	}, gateway.Packets())
+
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(ctx) }()
+
+	// A 10-byte message with a DLE in it, which the gateway has to escape.
+	data := []byte{0x10, 1, 2, 3, 4, 5, 6, 7, 8, 0x10}
+	payload := []byte{3, 0xf8, 0xf1, 0x01, 0xff, 0x10, 0x01, 0x02, 0x03, 0x04, byte(len(data))}
+	_, err := master.Write(append([]byte{0x00, 0x10}, encodeBSTPacket(ActisenseN2KReceived, append(payload, data...))...))
+	require.NoError(t, err)
+
+	select {
+	case msg := <-messages:
+		require.Equal(t, PGNMessage{N2KHeader: N2KHeader{Priority: 3, PGN: 127480, Source: 0x10, Destination: 0xff}, Data: data}, msg)
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+	first := receiveFrame(t, frames)
+	require.Equal(t, N2KHeader{Priority: 3, PGN: 127480, Source: 0x10, Destination: 0xff}, ParseN2KHeader(first.ID))
+	require.Equal(t, [8]byte{0x00, 10, 0x10, 1, 2, 3, 4, 5}, first.Data)
+	require.Equal(t, [8]byte{0x01, 6, 7, 8, 0x10, 0xff, 0xff, 0xff}, receiveFrame(t, frames).Data)
+
+	require.NoError(t, channel.SetTxPGNEnabled(ctx, 130306, false))
+	require.NoError(t, channel.WriteFrame(can.Frame{
+		ID:     N2KHeader{Priority: 2, PGN: 130306, Source: 0x99, Destination: 0xff}.ID(),
+		Length: 8,
+		Data:   [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}))
+	require.Eventually(t, func() bool { return len(gateway.Packets()) == 4 }, time.Second, time.Millisecond)
+	require.Equal(t, []byte{byte(ActisenseBEMCommand), 6, 0x47, 0x02, 0xfd, 0x01, 0x00, 0x00}, gateway.Packets()[2])
+	require.Equal(t, []byte{byte(ActisenseN2KSend), 14, 2, 0x02, 0xfd, 0x01, 0xff, 8, 1, 2, 3, 4, 5, 6, 7, 8}, gateway.Packets()[3])
+
+	require.NoError(t, channel.Close())
+	require.NoError(t, <-runDone)
+}
+
+func TestBSTDecoderResynchronizes(t *testing.T) {
+	good := encodeBSTPacket(ActisenseBEMResponse, []byte{0x11, 0x10})
+	corrupt := encodeBSTPacket(ActisenseBEMResponse, []byte{0x11, 0x00})
+	corrupt[len(corrupt)-3]++
+
+	var decoder bstDecoder
+	var packets [][]byte
+	var errs int
+	for _, b := range append(append([]byte{0xff, bstDLE, 0x42}, corrupt...), good...) {
+		packet, err := decoder.feed(b)
+		if err != nil {
+			errs++
+		}
+		if packet != nil {
+			packets = append(packets, packet)
+		}
+	}
+
+	require.Equal(t, 2, errs)
+	require.Equal(t, [][]byte{{byte(ActisenseBEMResponse), 2, 0x11, 0x10}}, packets)
+}
+
+func TestActisenseChannelFastPacketRoundTrip(t *testing.T) {
+	master, portName := openTestPTY(t)
+	gateway := runFakeActisenseGateway(t, master)
+
+	frames := make(chan can.Frame, 8)
+	channel := NewActisenseChannel(logrus.New(), ActisenseChannelOptions{
+		SerialPortName: portName,
+		SerialBaudRate: 115200,
+		FrameHandler:   func(f can.Frame) { frames <- f },
+		FastPacketPGN:  func(pgn uint32) bool { return pgn == 129029 || pgn == 126464 },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, channel.Start(ctx))
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(ctx) }()
+
+	// A 20-byte GNSS position arrives as a three-frame series, and the same frames written back go out whole
+	data := make([]byte, 20)
+	for i := range data {
+		data[i] = byte(i + 1)
+	}
+	payload := append([]byte{3, 0x05, 0xf8, 0x01, 0xff, 0x22, 0x01, 0x02, 0x03, 0x04, byte(len(data))}, data...)
+	_, err := master.Write(encodeBSTPacket(ActisenseN2KReceived, payload))
+	require.NoError(t, err)
+	series := []can.Frame{receiveFrame(t, frames), receiveFrame(t, frames), receiveFrame(t, frames)}
+	for _, f := range series {
+		require.NoError(t, channel.WriteFrame(f))
+	}
+
+	// A short fast-packet PGN still comes as a series, and goes out whole too
+	list := PGNMessage{N2KHeader: N2KHeader{Priority: 6, PGN: 126464, Source: 0x22, Destination: 0xff}, Data: []byte{0, 0x00, 0xee, 0x00}}
+	listFrames := list.FastPacketFrames(5)
+	require.Len(t, listFrames, 1)
+	require.NoError(t, channel.WriteFrame(listFrames[0]))
+
+	require.Eventually(t, func() bool { return len(gateway.Packets()) == 2 }, time.Second, time.Millisecond)
+	require.Equal(t, append([]byte{byte(ActisenseN2KSend), 26, 3, 0x05, 0xf8, 0x01, 0xff, 20}, data...), gateway.Packets()[0])
+	require.Equal(t, []byte{byte(ActisenseN2KSend), 10, 6, 0x00, 0xee, 0x01, 0xff, 4, 0, 0x00, 0xee, 0x00}, gateway.Packets()[1])
+
+	require.NoError(t, channel.Close())
+	require.NoError(t, <-runDone)
+}
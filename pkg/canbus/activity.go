@@ -0,0 +1,241 @@
+package canbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/boatkit-io/tugboat/pkg/service"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+// flusher is implemented by channels that batch writes, such as CannelloniChannel
+type flusher interface {
+	Flush() error
+}
+
+// ChannelActivity runs a canbus Interface under a service.Runner.  Run starts the channel and closes Ready once
+// it's up, Shutdown waits for writes already in flight (and flushes batching channels), and Kill closes the
+// channel.  It is itself an Interface, so writes made through it are the ones Shutdown drains.
+//
+// Channels can't be restarted once they've run, so the channel is built by a factory, and each Run after the first
+// (as the Runner's restart policies do) closes the old channel and builds a new one.  Ready is latched: it stays
+// closed across restarts, and writes fail until the new channel is up.
+type ChannelActivity struct {
+	name       string
+	newChannel func() Interface
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	startMu  sync.Mutex
+	mu       sync.Mutex
+	channel  Interface
+	used     bool
+	draining bool
+	killed   bool
+	trace    *TraceOptions
+	writes   sync.WaitGroup
+
+	log *logrus.Logger
+}
+
+// Ensure that ChannelActivity implements the interfaces.
+var (
+	_ service.Activity = &ChannelActivity{}
+	_ service.Readier  = &ChannelActivity{}
+	_ service.Starter  = &ChannelActivity{}
+	_ Interface        = &ChannelActivity{}
+	_ Tracer           = &ChannelActivity{}
+)
+
+// NewChannelActivity wraps the channels built by newChannel as an Activity.  The first is built straight away.  An
+// empty name defaults to one based on the channel type, i.e. "canbus-socketcan" for a SocketCANChannel.
+func NewChannelActivity(log *logrus.Logger, name string, newChannel func() Interface) *ChannelActivity {
+	channel := newChannel()
+	if name == "" {
+		t := reflect.TypeOf(channel)
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		name = "canbus-" + strings.ToLower(strings.TrimSuffix(t.Name(), "Channel"))
+	}
+
+	return &ChannelActivity{
+		name:       name,
+		newChannel: newChannel,
+		channel:    channel,
+		ready:      make(chan struct{}),
+		log:        log,
+	}
+}
+
+// Name returns the name of the Activity.
+func (a *ChannelActivity) Name() string {
+	return a.name
+}
+
+// Ready is closed once the first channel has started.
+func (a *ChannelActivity) Ready() <-chan struct{} {
+	return a.ready
+}
+
+// Start synchronously starts the channel, closing Ready when it's done.  If the channel has already run, or failed
+// to start, it's closed and a new one is built first.
+func (a *ChannelActivity) Start(ctx context.Context) error {
+	a.startMu.Lock()
+	defer a.startMu.Unlock()
+
+	a.mu.Lock()
+	if a.killed {
+		a.mu.Unlock()
+		return errors.New("canbus channel is killed")
+	}
+	var old Interface
+	if a.used {
+		old = a.channel
+		a.channel = a.newChannel()
+		a.used = false
+		if tracer, ok := a.channel.(Tracer); ok && a.trace != nil {
+			tracer.SetTrace(a.trace)
+		}
+	}
+	channel := a.channel
+	a.mu.Unlock()
+
+	if old != nil {
+		if err := old.Close(); err != nil {
+			a.log.WithError(err).WithField("name", a.name).Debug("closing old canbus channel")
+		}
+	}
+
+	if err := channel.Start(ctx); err != nil {
+		a.markUsed(channel)
+		return err
+	}
+	a.readyOnce.Do(func() {
+		close(a.ready)
+	})
+	return nil
+}
+
+// Run starts the channel and runs it until it's closed.  Errors caused by Kill closing the channel underneath
+// it are treated as a clean exit.
+func (a *ChannelActivity) Run(ctx context.Context) error {
+	if err := a.Start(ctx); err != nil {
+		if a.isKilled() {
+			return nil
+		}
+		return err
+	}
+
+	channel := a.currentChannel()
+	a.markUsed(channel)
+	err := channel.Run(ctx)
+	if err != nil && a.isKilled() && isClosedError(err) {
+		a.log.WithError(err).WithField("name", a.name).Debug("canbus channel closed")
+		return nil
+	}
+	return err
+}
+
+// WriteFrame sends a frame through the channel, refusing new writes once Shutdown has started.
+func (a *ChannelActivity) WriteFrame(frame can.Frame) error {
+	a.mu.Lock()
+	if a.draining || a.killed {
+		a.mu.Unlock()
+		return errors.New("canbus channel is shutting down")
+	}
+	a.writes.Add(1)
+	channel := a.channel
+	a.mu.Unlock()
+	defer a.writes.Done()
+
+	return channel.WriteFrame(frame)
+}
+
+// Shutdown stops accepting writes and waits for the ones in flight to finish, then flushes channels that batch
+// writes.  The channel keeps receiving until Kill.
+func (a *ChannelActivity) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.draining = true
+	a.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		a.writes.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if f, ok := a.currentChannel().(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// SetTrace passes the trace options on to the channel, and to each one built on restart, if it's a Tracer.
+func (a *ChannelActivity) SetTrace(options *TraceOptions) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.trace = options
+	if tracer, ok := a.channel.(Tracer); ok {
+		tracer.SetTrace(options)
+	}
+}
+
+// Kill closes the channel, which ends Run.
+func (a *ChannelActivity) Kill() error {
+	a.mu.Lock()
+	a.killed = true
+	channel := a.channel
+	a.mu.Unlock()
+
+	return channel.Close()
+}
+
+// Close is the same as Kill, for use as an Interface.
+func (a *ChannelActivity) Close() error {
+	return a.Kill()
+}
+
+// currentChannel is a helper to get the channel being run
+func (a *ChannelActivity) currentChannel() Interface {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.channel
+}
+
+// markUsed is a helper to have the next Start replace channel, if it's still the current one
+func (a *ChannelActivity) markUsed(channel Interface) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == channel {
+		a.used = true
+	}
+}
+
+func (a *ChannelActivity) isKilled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.killed
+}
+
+// isClosedError reports whether an error just means the channel's connection or file was closed
+func isClosedError(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed) || isClosedCANBusError(err)
+}
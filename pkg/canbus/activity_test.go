@@ -0,0 +1,340 @@
+package canbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/service"
+	"github.com/boatkit-io/tugboat/pkg/usbcan/usbcantest"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+// scriptedChannel is a fake Interface whose Start, Run and WriteFrame behavior is controlled by the test
+type scriptedChannel struct {
+	startErr  error
+	runErr    error
+	closed    chan struct{}
+	entered   chan struct{}
+	writeGate chan struct{}
+	writes    atomic.Int32
+	flushes   atomic.Int32
+	trace     atomic.Pointer[TraceOptions]
+}
+
+func newScriptedChannel() *scriptedChannel {
+	return &scriptedChannel{closed: make(chan struct{}), entered: make(chan struct{}, 1)}
+}
+
+func (c *scriptedChannel) Start(context.Context) error { return c.startErr }
+
+func (c *scriptedChannel) Run(context.Context) error {
+	<-c.closed
+	return c.runErr
+}
+
+func (c *scriptedChannel) Close() error {
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	return nil
+}
+
+func (c *scriptedChannel) WriteFrame(can.Frame) error {
+	select {
+	case c.entered <- struct{}{}:
+	default:
+	}
+	if c.writeGate != nil {
+		<-c.writeGate
+	}
+	c.writes.Add(1)
+	return nil
+}
+
+func (c *scriptedChannel) SetTrace(options *TraceOptions) {
+	c.trace.Store(options)
+}
+
+func (c *scriptedChannel) Flush() error {
+	c.flushes.Add(1)
+	return nil
+}
+
+func TestChannelActivityName(t *testing.T) {
+	name := func(name string, channel Interface) string {
+		return NewChannelActivity(logrus.New(), name, func() Interface { return channel }).Name()
+	}
+	require.Equal(t, "canbus-cannelloni", name("", &CannelloniChannel{}))
+	require.Equal(t, "canbus-socketcan", name("", &SocketCANChannel{}))
+	require.Equal(t, "engine bus", name("engine bus", &SLCANChannel{}))
+}
+
+func TestChannelActivityUnderRunner(t *testing.T) {
+	log := logrus.New()
+
+	server, received := startCannelloni(t, CannelloniChannelOptions{
+		Transport:    CannelloniUDP,
+		Listen:       true,
+		LocalAddress: "127.0.0.1:0",
+	})
+
+	client := NewChannelActivity(log, "", func() Interface {
+		return NewCannelloniChannel(log, CannelloniChannelOptions{
+			Transport:     CannelloniUDP,
+			LocalAddress:  "127.0.0.1:0",
+			RemoteAddress: server.LocalAddr().String(),
+		})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sender := &funcActivity{name: "sender", run: func(ctx context.Context) error {
+		select {
+		case <-client.Ready():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := client.WriteFrame(can.Frame{ID: 0x123, Length: 1, Data: [8]byte{0x42}}); err != nil {
+			return err
+		}
+		select {
+		case f := <-received:
+			if f.Data[0] != 0x42 {
+				return fmt.Errorf("unexpected frame %v", f)
+			}
+		case <-time.After(time.Second):
+			return errors.New("frame never arrived")
+		}
+		cancel()
+		return nil
+	}}
+
+	runner := service.NewRunner(log, time.Second, time.Second)
+	runner.RegisterActivities(client, sender)
+	require.Equal(t, 0, runner.Run(ctx))
+
+	require.ErrorContains(t, client.WriteFrame(can.Frame{}), "shutting down")
+}
+
+// funcActivity is a minimal service.Activity around a run function
+type funcActivity struct {
+	name string
+	run  func(context.Context) error
+}
+
+func (a *funcActivity) Name() string                     { return a.name }
+func (a *funcActivity) Run(ctx context.Context) error    { return a.run(ctx) }
+func (a *funcActivity) Shutdown(_ context.Context) error { return nil }
+func (a *funcActivity) Kill() error                      { return nil }
+
+func TestChannelActivityForwardsTrace(t *testing.T) {
+	var channels []*scriptedChannel
+	a := NewChannelActivity(logrus.New(), "test", func() Interface {
+		ch := newScriptedChannel()
+		channels = append(channels, ch)
+		return ch
+	})
+
+	options := &TraceOptions{SampleEvery: 2}
+	a.SetTrace(options)
+	require.Same(t, options, channels[0].trace.Load())
+
+	// The channel built on restart keeps tracing
+	require.NoError(t, channels[0].Close())
+	require.NoError(t, a.Run(context.Background()))
+	require.NoError(t, a.Start(context.Background()))
+	require.Len(t, channels, 2)
+	require.Same(t, options, channels[1].trace.Load())
+
+	a.SetTrace(nil)
+	require.Nil(t, channels[1].trace.Load())
+}
+
+func TestChannelActivityDrainsWrites(t *testing.T) {
+	ch := newScriptedChannel()
+	ch.writeGate = make(chan struct{})
+	a := NewChannelActivity(logrus.New(), "test", func() Interface { return ch })
+
+	writeDone := make(chan error, 1)
+	go func() { writeDone <- a.WriteFrame(can.Frame{}) }()
+	<-ch.entered
+
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- a.Shutdown(context.Background()) }()
+
+	select {
+	case <-shutdownDone:
+		t.Fatal("shutdown returned before the write finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.ErrorContains(t, a.WriteFrame(can.Frame{}), "shutting down")
+
+	close(ch.writeGate)
+	require.NoError(t, <-writeDone)
+	require.NoError(t, <-shutdownDone)
+	require.Equal(t, int32(1), ch.writes.Load())
+	require.Equal(t, int32(1), ch.flushes.Load())
+}
+
+func TestChannelActivityShutdownTimeout(t *testing.T) {
+	ch := newScriptedChannel()
+	ch.writeGate = make(chan struct{})
+	defer close(ch.writeGate)
+	a := NewChannelActivity(logrus.New(), "test", func() Interface { return ch })
+
+	go func() { _ = a.WriteFrame(can.Frame{}) }()
+	<-ch.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, a.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func TestChannelActivityRunErrors(t *testing.T) {
+	// Start failures are real errors, and Ready never closes
+	ch := newScriptedChannel()
+	ch.startErr = errors.New("no such device")
+	a := NewChannelActivity(logrus.New(), "test", func() Interface { return ch })
+	require.ErrorContains(t, a.Run(context.Background()), "no such device")
+	select {
+	case <-a.Ready():
+		t.Fatal("ready after a failed start")
+	default:
+	}
+
+	// ...unless we were killed first
+	require.NoError(t, a.Kill())
+	require.NoError(t, a.Run(context.Background()))
+
+	// Closed-connection errors are errors too when we didn't close it, i.e. the device went away
+	ch = newScriptedChannel()
+	ch.runErr = fmt.Errorf("read: %w", net.ErrClosed)
+	a = NewChannelActivity(logrus.New(), "test", func() Interface { return ch })
+	require.NoError(t, ch.Close())
+	require.ErrorIs(t, a.Run(context.Background()), net.ErrClosed)
+	<-a.Ready()
+
+	// So is anything else while still running
+	ch = newScriptedChannel()
+	ch.runErr = errors.New("device unplugged")
+	a = NewChannelActivity(logrus.New(), "test", func() Interface { return ch })
+	require.NoError(t, ch.Close())
+	require.ErrorContains(t, a.Run(context.Background()), "device unplugged")
+
+	// ...but closing is a clean exit once Kill has closed the channel
+	ch = newScriptedChannel()
+	ch.runErr = fmt.Errorf("read: %w", os.ErrClosed)
+	a = NewChannelActivity(logrus.New(), "test", func() Interface { return ch })
+	runDone := make(chan error, 1)
+	go func() { runDone <- a.Run(context.Background()) }()
+	<-a.Ready()
+	require.NoError(t, a.Kill())
+	require.NoError(t, <-runDone)
+
+	// ...while other errors still come through
+	ch = newScriptedChannel()
+	ch.runErr = errors.New("read failed")
+	a = NewChannelActivity(logrus.New(), "test", func() Interface { return ch })
+	go func() { runDone <- a.Run(context.Background()) }()
+	<-a.Ready()
+	require.NoError(t, a.Kill())
+	require.ErrorContains(t, <-runDone, "read failed")
+}
+
+func TestChannelActivityRestartsUSBCAN(t *testing.T) {
+	transmitted := make(chan can.Frame, 16)
+	emulator := usbcantest.NewEmulator(usbcantest.EmulatorOptions{
+		FrameHandler: func(f can.Frame) { transmitted <- f },
+	})
+	t.Cleanup(func() { _ = emulator.Close() })
+
+	// Remember each connection, so the test can unplug one
+	var mu sync.Mutex
+	var ports []serial.Port
+	openPort := func(name string, mode *serial.Mode) (serial.Port, error) {
+		port, err := emulator.Open(name, mode)
+		if err == nil {
+			mu.Lock()
+			ports = append(ports, port)
+			mu.Unlock()
+		}
+		return port, err
+	}
+	connections := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ports)
+	}
+
+	log := logrus.New()
+	received := make(chan can.Frame, 16)
+	a := NewChannelActivity(log, "", func() Interface {
+		return NewUSBCANChannel(log, USBCANChannelOptions{
+			SerialBaudRate: 2_000_000,
+			BitRate:        250_000,
+			OpenPort:       openPort,
+			FrameHandler:   func(f can.Frame) { received <- f },
+		})
+	})
+
+	runner := service.NewRunner(log, time.Second, time.Second)
+	runner.RegisterActivities(a)
+	runner.SetRestartPolicy(a.Name(), service.RestartPolicy{Mode: service.RestartAlways, InitialBackoff: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runDone := make(chan *service.Result, 1)
+	go func() { runDone <- runner.RunWithResult(ctx) }()
+
+	// Frames get through both ways on each connection
+	exchange := func(id uint32) {
+		t.Helper()
+
+		frame := can.Frame{ID: id, Length: 1, Data: [8]byte{0x42}}
+		require.Eventually(t, func() bool { return a.WriteFrame(frame) == nil }, time.Second, time.Millisecond)
+		select {
+		case f := <-transmitted:
+			require.Equal(t, frame, f)
+		case <-time.After(time.Second):
+			t.Fatal("frame was never transmitted")
+		}
+
+		require.NoError(t, emulator.SendFrame(frame))
+		select {
+		case f := <-received:
+			require.Equal(t, frame, f)
+		case <-time.After(time.Second):
+			t.Fatal("frame was never received")
+		}
+	}
+
+	<-a.Ready()
+	require.Equal(t, 1, connections())
+	exchange(0x100)
+
+	// Unplugging ends Run, and the restart opens a new channel on a new connection
+	mu.Lock()
+	require.NoError(t, ports[0].Close())
+	mu.Unlock()
+	require.Eventually(t, func() bool { return connections() == 2 }, time.Second, time.Millisecond)
+	exchange(0x200)
+
+	cancel()
+	result := <-runDone
+	require.NoError(t, result.Err())
+	activity, ok := result.Activity(a.Name())
+	require.True(t, ok)
+	require.Equal(t, 1, activity.Restarts)
+}
@@ -0,0 +1,112 @@
+// Package canbustest has a fake canbus.Interface for tests of code that reads from or writes to a bus.  It doesn't
+// import canbus, so canbus's own tests can use it too.
+package canbustest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+)
+
+// ErrClosed is returned by Start and WriteFrame once the bus is closed.
+var ErrClosed = errors.New("test bus is closed")
+
+// Bus is a fake CAN bus that records every frame written to it, and when.  Received frames are injected by the
+// test with Receive.  It satisfies canbus.Interface.
+type Bus struct {
+	handler can.HandlerFunc
+	done    chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	writeErr error
+	frames   []can.Frame
+	times    []time.Time
+}
+
+// NewBus returns a bus that hands received frames to handler, which may be nil.
+func NewBus(handler can.HandlerFunc) *Bus {
+	return &Bus{handler: handler, done: make(chan struct{})}
+}
+
+// Start fails once the bus is closed.
+func (b *Bus) Start(context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Run waits until the bus is closed or ctx is done.
+func (b *Bus) Run(ctx context.Context) error {
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// Close stops Run, and makes later writes fail.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	return nil
+}
+
+// WriteFrame records the frame, or returns the error set with SetWriteErr.
+func (b *Bus) WriteFrame(frame can.Frame) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	if b.writeErr != nil {
+		return b.writeErr
+	}
+	b.frames = append(b.frames, frame)
+	b.times = append(b.times, time.Now())
+	return nil
+}
+
+// SetWriteErr makes every later WriteFrame fail with err, or succeed again if it's nil.
+func (b *Bus) SetWriteErr(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.writeErr = err
+}
+
+// Receive hands a frame to the bus's handler, as if it had arrived from the bus.
+func (b *Bus) Receive(frame can.Frame) {
+	if b.handler != nil {
+		b.handler(frame)
+	}
+}
+
+// Frames returns a copy of every frame written so far.
+func (b *Bus) Frames() []can.Frame {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]can.Frame(nil), b.frames...)
+}
+
+// Times returns when each of the frames returned by Frames was written.
+func (b *Bus) Times() []time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]time.Time(nil), b.times...)
+}
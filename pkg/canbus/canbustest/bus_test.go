@@ -0,0 +1,38 @@
+package canbustest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+var _ canbus.Interface = &Bus{}
+
+func TestBus(t *testing.T) {
+	var received []can.Frame
+	bus := NewBus(func(frame can.Frame) { received = append(received, frame) })
+	require.NoError(t, bus.Start(context.Background()))
+
+	frame := can.Frame{ID: 0x123, Length: 1, Data: [8]byte{0x42}}
+	bus.Receive(frame)
+	require.Equal(t, []can.Frame{frame}, received)
+
+	require.NoError(t, bus.WriteFrame(frame))
+	failure := errors.New("bus off")
+	bus.SetWriteErr(failure)
+	require.ErrorIs(t, bus.WriteFrame(frame), failure)
+	require.Equal(t, []can.Frame{frame}, bus.Frames())
+	require.Len(t, bus.Times(), 1)
+
+	runDone := make(chan error, 1)
+	go func() { runDone <- bus.Run(context.Background()) }()
+	require.NoError(t, bus.Close())
+	require.NoError(t, bus.Close())
+	require.NoError(t, <-runDone)
+	require.ErrorIs(t, bus.Start(context.Background()), ErrClosed)
+	require.ErrorIs(t, bus.WriteFrame(frame), ErrClosed)
+}
@@ -0,0 +1,131 @@
+package canbus
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brutella/can"
+)
+
+// Docs for the can-utils log and frame formats:
+// * https://github.com/linux-can/can-utils/blob/master/lib.h
+
+// CandumpRecord is a single line of a can-utils log file, as written by "candump -l" and read by canplayer
+type CandumpRecord struct {
+	Time      time.Time
+	Interface string
+	Frame     can.Frame
+}
+
+// ParseCandump parses a line such as "(1436509052.249713) can0 19F51323#012F3070002F3070".
+func ParseCandump(line string) (CandumpRecord, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "(") || !strings.HasSuffix(fields[0], ")") {
+		return CandumpRecord{}, fmt.Errorf("malformed candump line %q", line)
+	}
+
+	ts, err := parseCandumpTime(fields[0][1 : len(fields[0])-1])
+	if err != nil {
+		return CandumpRecord{}, err
+	}
+
+	frame, err := ParseCandumpFrame(fields[2])
+	if err != nil {
+		return CandumpRecord{}, err
+	}
+
+	return CandumpRecord{Time: ts, Interface: fields[1], Frame: frame}, nil
+}
+
+// String formats the record the way candump -l does.
+func (r CandumpRecord) String() string {
+	return fmt.Sprintf("(%d.%06d) %s %s", r.Time.Unix(), r.Time.Nanosecond()/1000, r.Interface, FormatCandumpFrame(r.Frame))
+}
+
+// FormatCandumpFrame formats a frame the way cansend takes it, i.e. "123#DEADBEEF" or "18EEFF00#R".  Extended IDs
+// always have 8 digits, which is how the format tells them apart.
+func FormatCandumpFrame(frame can.Frame) string {
+	var sb strings.Builder
+	if IsExtendedID(frame.ID) {
+		fmt.Fprintf(&sb, "%08X#", frame.ID&can.MaskIDEff)
+	} else {
+		fmt.Fprintf(&sb, "%03X#", frame.ID&can.MaskIDSff)
+	}
+
+	if frame.ID&can.MaskRtr != 0 {
+		sb.WriteString("R")
+		if frame.Length > 0 {
+			sb.WriteString(strconv.Itoa(int(frame.Length)))
+		}
+		return sb.String()
+	}
+
+	n := min(int(frame.Length), can.MaxFrameDataLength)
+	sb.WriteString(strings.ToUpper(hex.EncodeToString(frame.Data[:n])))
+	return sb.String()
+}
+
+// ParseCandumpFrame parses a frame in cansend syntax.  8-digit IDs get the EFF flag, and data bytes may be
+// separated by dots, i.e. "123#DE.AD.BE.EF".
+func ParseCandumpFrame(s string) (can.Frame, error) {
+	idStr, dataStr, ok := strings.Cut(s, "#")
+	if !ok {
+		return can.Frame{}, fmt.Errorf("malformed CAN frame %q", s)
+	}
+
+	var frame can.Frame
+	switch len(idStr) {
+	case 3:
+		id, err := strconv.ParseUint(idStr, 16, 32)
+		if err != nil || id > can.MaskIDSff {
+			return can.Frame{}, fmt.Errorf("invalid CAN ID %q", idStr)
+		}
+		frame.ID = uint32(id)
+	case 8:
+		id, err := strconv.ParseUint(idStr, 16, 32)
+		if err != nil || id > can.MaskIDEff|can.MaskErr {
+			return can.Frame{}, fmt.Errorf("invalid CAN ID %q", idStr)
+		}
+		frame.ID = uint32(id) | can.MaskEff
+	default:
+		return can.Frame{}, fmt.Errorf("invalid CAN ID %q", idStr)
+	}
+
+	if strings.HasPrefix(dataStr, "R") {
+		frame.ID |= can.MaskRtr
+		if len(dataStr) > 1 {
+			n, err := strconv.Atoi(dataStr[1:])
+			if err != nil || n < 0 || n > can.MaxFrameDataLength {
+				return can.Frame{}, fmt.Errorf("invalid RTR length %q", dataStr[1:])
+			}
+			frame.Length = uint8(n)
+		}
+		return frame, nil
+	}
+
+	data, err := hex.DecodeString(strings.ReplaceAll(dataStr, ".", ""))
+	if err != nil {
+		return can.Frame{}, fmt.Errorf("invalid CAN data %q", dataStr)
+	}
+	if len(data) > can.MaxFrameDataLength {
+		return can.Frame{}, fmt.Errorf("too much data: %d bytes", len(data))
+	}
+	frame.Length = uint8(len(data))
+	copy(frame.Data[:], data)
+	return frame, nil
+}
+
+// parseCandumpTime is a helper to parse the seconds.microseconds timestamp of a log line
+func parseCandumpTime(s string) (time.Time, error) {
+	secStr, usecStr, ok := strings.Cut(s, ".")
+	sec, err1 := strconv.ParseInt(secStr, 10, 64)
+	usec, err2 := strconv.ParseInt(usecStr, 10, 64)
+	if !ok || len(usecStr) != 6 || err1 != nil || err2 != nil {
+		return time.Time{}, fmt.Errorf("invalid candump time %q", s)
+	}
+
+	return time.Unix(sec, usec*int64(time.Microsecond)), nil
+}
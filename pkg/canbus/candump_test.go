@@ -0,0 +1,66 @@
+package canbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCandump(t *testing.T) {
+	rec, err := ParseCandump("(1436509052.249713) can0 19F51323#012F3070002F3070")
+	require.NoError(t, err)
+	require.Equal(t, CandumpRecord{
+		Time:      time.Unix(1436509052, 249713000),
+		Interface: "can0",
+		Frame: can.Frame{
+			ID:     0x19F51323 | can.MaskEff,
+			Length: 8,
+			Data:   [8]byte{0x01, 0x2F, 0x30, 0x70, 0x00, 0x2F, 0x30, 0x70},
+		},
+	}, rec)
+	require.Equal(t, "(1436509052.249713) can0 19F51323#012F3070002F3070", rec.String())
+
+	rec, err = ParseCandump("(0000000001.000001) vcan0 123#")
+	require.NoError(t, err)
+	require.Equal(t, can.Frame{ID: 0x123}, rec.Frame)
+	require.Equal(t, "(1.000001) vcan0 123#", rec.String())
+
+	for _, bad := range []string{
+		"1436509052.249713 can0 123#00",
+		"(1436509052.2497) can0 123#00",
+		"(1436509052.249713) can0",
+		"(1436509052.249713) can0 1234#00",
+	} {
+		_, err := ParseCandump(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestCandumpFrames(t *testing.T) {
+	for s, frame := range map[string]can.Frame{
+		"123#DEADBEEF":  {ID: 0x123, Length: 4, Data: [8]byte{0xde, 0xad, 0xbe, 0xef}},
+		"18EEFF00#0102": {ID: 0x18eeff00 | can.MaskEff, Length: 2, Data: [8]byte{0x01, 0x02}},
+		"7FF#":          {ID: 0x7ff},
+		"123#R":         {ID: 0x123 | can.MaskRtr},
+		"00000123#R3":   {ID: 0x123 | can.MaskEff | can.MaskRtr, Length: 3},
+	} {
+		got, err := ParseCandumpFrame(s)
+		require.NoError(t, err, s)
+		require.Equal(t, frame, got, s)
+		require.Equal(t, s, FormatCandumpFrame(frame))
+	}
+
+	got, err := ParseCandumpFrame("123#de.ad.be.ef")
+	require.NoError(t, err)
+	require.Equal(t, "123#DEADBEEF", FormatCandumpFrame(got))
+
+	// Extended IDs from serial channels have no EFF flag, but still get 8 digits
+	require.Equal(t, "09F8017F#50", FormatCandumpFrame(can.Frame{ID: 0x09f8017f, Length: 1, Data: [8]byte{0x50}}))
+
+	for _, bad := range []string{"123", "800#00", "12#00", "123#0", "123#001122334455667788", "123#R9", "G23#00"} {
+		_, err := ParseCandumpFrame(bad)
+		require.Error(t, err, bad)
+	}
+}
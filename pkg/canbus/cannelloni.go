@@ -0,0 +1,617 @@
+package canbus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+// Docs for the cannelloni protocol:
+// * https://github.com/mguentner/cannelloni/blob/master/doc/protocol.md
+// * https://github.com/mguentner/cannelloni/blob/master/cannelloni.h
+
+// CannelloniTransport is an enum for the network transport used to tunnel frames
+type CannelloniTransport int
+
+const (
+	CannelloniUDP CannelloniTransport = iota
+	CannelloniTCP
+)
+
+const (
+	cannelloniVersion    = 2
+	cannelloniOpData     = 0
+	cannelloniHeaderLen  = 5
+	cannelloniMagic      = "CANNELLONIv1"
+	cannelloniCANFDFlag  = 0x80
+	cannelloniMaxPayload = 1472
+)
+
+// CannelloniChannelOptions is a type that contains required options on a CannelloniChannel.
+type CannelloniChannelOptions struct {
+	Transport CannelloniTransport
+	// Listen makes this end the server: for TCP it accepts connections on LocalAddress, for UDP it learns the peer
+	// from incoming datagrams if RemoteAddress is empty.
+	Listen bool
+	// LocalAddress is the address to bind/listen on.  Optional for clients.
+	LocalAddress string
+	// RemoteAddress is the peer to send to (UDP) or connect to (TCP client).
+	RemoteAddress string
+	// MaxFramesPerPacket batches up to this many outgoing frames into a single datagram/write.  Zero or one sends
+	// every frame immediately.
+	MaxFramesPerPacket int
+	// BatchTimeout is how long a partial batch waits for more frames before it's sent anyway.
+	BatchTimeout time.Duration
+	FrameHandler can.HandlerFunc
+}
+
+// CannelloniStats are running counters for a CannelloniChannel
+type CannelloniStats struct {
+	PacketsSent     uint64
+	PacketsReceived uint64
+	FramesSent      uint64
+	FramesReceived  uint64
+	// SequenceGaps counts received UDP packets whose sequence number didn't follow the previous one, and
+	// PacketsLost is the sum of how many sequence numbers each of those gaps skipped.
+	SequenceGaps uint64
+	PacketsLost  uint64
+}
+
+// CannelloniChannel represents a canbus channel tunneled over the network to a cannelloni peer, which can be the
+// real cannelloni daemon or another CannelloniChannel.
+type CannelloniChannel struct {
+	options CannelloniChannelOptions
+
+	startMu  sync.Mutex
+	mu       sync.Mutex
+	closed   bool
+	udpConn  *net.UDPConn
+	udpPeer  *net.UDPAddr
+	listener net.Listener
+	tcpConn  net.Conn
+
+	batchMu    sync.Mutex
+	batch      []can.Frame
+	batchTimer *time.Timer
+	txSeq      uint8
+
+	statsMu  sync.Mutex
+	stats    CannelloniStats
+	rxSeq    uint8
+	rxSeqSet bool
+
+	tracer frameTracer
+
+	log *logrus.Logger
+}
+
+// NewCannelloniChannel returns a Channel object tunneled over cannelloni and the given options.  ChannelOptions are
+// required settings.
+func NewCannelloniChannel(log *logrus.Logger, options CannelloniChannelOptions) *CannelloniChannel {
+	c := CannelloniChannel{
+		options: options,
+		log:     log,
+	}
+
+	return &c
+}
+
+// Start synchronously binds the UDP socket, starts the TCP listener, or connects to the TCP server.
+func (c *CannelloniChannel) Start(ctx context.Context) error {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errors.New("cannelloni channel is closed")
+	}
+	if c.udpConn != nil || c.listener != nil || c.tcpConn != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	var lc net.ListenConfig
+	switch c.options.Transport {
+	case CannelloniUDP:
+		var peer *net.UDPAddr
+		if c.options.RemoteAddress != "" {
+			var err error
+			peer, err = net.ResolveUDPAddr("udp", c.options.RemoteAddress)
+			if err != nil {
+				return err
+			}
+		} else if !c.options.Listen {
+			return errors.New("cannelloni UDP client needs a RemoteAddress")
+		}
+		pc, err := lc.ListenPacket(ctx, "udp", c.options.LocalAddress)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.udpConn = pc.(*net.UDPConn)
+		c.udpPeer = peer
+		c.mu.Unlock()
+	case CannelloniTCP:
+		if c.options.Listen {
+			l, err := lc.Listen(ctx, "tcp", c.options.LocalAddress)
+			if err != nil {
+				return err
+			}
+			c.mu.Lock()
+			c.listener = l
+			c.mu.Unlock()
+		} else {
+			d := net.Dialer{}
+			if c.options.LocalAddress != "" {
+				local, err := net.ResolveTCPAddr("tcp", c.options.LocalAddress)
+				if err != nil {
+					return err
+				}
+				d.LocalAddr = local
+			}
+			conn, err := d.DialContext(ctx, "tcp", c.options.RemoteAddress)
+			if err != nil {
+				return err
+			}
+			if err := cannelloniHandshake(ctx, conn); err != nil {
+				_ = conn.Close()
+				return err
+			}
+			c.mu.Lock()
+			c.tcpConn = conn
+			c.mu.Unlock()
+		}
+	default:
+		return fmt.Errorf("unknown cannelloni transport %d", c.options.Transport)
+	}
+
+	// Close may have raced with us while we didn't hold the lock.
+	if c.isClosed() {
+		_ = c.closeConns()
+		return errors.New("cannelloni channel is closed")
+	}
+
+	c.log.WithField("localAddress", c.LocalAddr()).
+		WithField("remoteAddress", c.options.RemoteAddress).
+		Info("Opened cannelloni")
+
+	return nil
+}
+
+// LocalAddr returns the bound local address, mostly useful when listening on port 0.
+func (c *CannelloniChannel) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.udpConn != nil:
+		return c.udpConn.LocalAddr()
+	case c.listener != nil:
+		return c.listener.Addr()
+	case c.tcpConn != nil:
+		return c.tcpConn.LocalAddr()
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the channel's counters.
+func (c *CannelloniChannel) Stats() CannelloniStats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	return c.stats
+}
+
+// Run starts receiving after synchronously opening the network connection.  A TCP server keeps accepting a new
+// peer each time the previous one disconnects; a TCP client returns when its connection drops.
+func (c *CannelloniChannel) Run(ctx context.Context) error {
+	if err := c.Start(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	udpConn := c.udpConn
+	listener := c.listener
+	tcpConn := c.tcpConn
+	c.mu.Unlock()
+
+	c.log.WithField("localAddress", c.LocalAddr()).
+		Info("Listening on cannelloni")
+
+	var err error
+	switch {
+	case udpConn != nil:
+		err = c.runUDP(udpConn)
+	case listener != nil:
+		err = c.runTCPServer(ctx, listener)
+	case tcpConn != nil:
+		err = c.runTCPConn(tcpConn)
+	default:
+		return nil
+	}
+	if c.isClosed() {
+		return nil
+	}
+	return err
+}
+
+func (c *CannelloniChannel) runUDP(conn *net.UDPConn) error {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+
+		if c.options.Listen && c.options.RemoteAddress == "" {
+			c.mu.Lock()
+			if c.udpPeer == nil || c.udpPeer.String() != addr.String() {
+				c.log.WithField("peer", addr.String()).Info("cannelloni peer connected")
+				c.udpPeer = addr
+			}
+			c.mu.Unlock()
+		}
+
+		if err := c.handlePacket(buf[:n]); err != nil {
+			c.log.WithError(err).Debug("Bad cannelloni packet")
+		}
+	}
+}
+
+func (c *CannelloniChannel) runTCPServer(ctx context.Context, listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		if err := cannelloniHandshake(ctx, conn); err != nil {
+			c.log.WithError(err).Warn("cannelloni handshake failed")
+			_ = conn.Close()
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		c.tcpConn = conn
+		c.mu.Unlock()
+		c.log.WithField("peer", conn.RemoteAddr().String()).Info("cannelloni peer connected")
+
+		err = c.runTCPConn(conn)
+
+		c.mu.Lock()
+		if c.tcpConn == conn {
+			c.tcpConn = nil
+		}
+		c.mu.Unlock()
+		_ = conn.Close()
+		if c.isClosed() {
+			return nil
+		}
+		c.log.WithError(err).WithField("peer", conn.RemoteAddr().String()).Info("cannelloni peer disconnected")
+	}
+}
+
+func (c *CannelloniChannel) runTCPConn(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := readCannelloniFrame(r)
+		if err != nil {
+			return err
+		}
+		c.handleFrame(frame)
+	}
+}
+
+// handleFrame is a helper to count and trace a received frame and hand it to the frame handler
+func (c *CannelloniChannel) handleFrame(frame can.Frame) {
+	c.statsMu.Lock()
+	c.stats.FramesReceived++
+	c.statsMu.Unlock()
+	c.tracer.trace(c.log, TraceReceive, frame)
+	if c.options.FrameHandler != nil {
+		c.options.FrameHandler(frame)
+	}
+}
+
+// handlePacket is a helper to decode a UDP datagram and deliver its frames
+func (c *CannelloniChannel) handlePacket(buf []byte) error {
+	if len(buf) < cannelloniHeaderLen {
+		return fmt.Errorf("packet too short: %d bytes", len(buf))
+	}
+	if buf[0] != cannelloniVersion {
+		return fmt.Errorf("unsupported version %d", buf[0])
+	}
+	if buf[1] != cannelloniOpData {
+		return nil
+	}
+	seq := buf[2]
+	count := binary.BigEndian.Uint16(buf[3:5])
+
+	c.statsMu.Lock()
+	c.stats.PacketsReceived++
+	if c.rxSeqSet && seq != c.rxSeq+1 {
+		lost := seq - (c.rxSeq + 1)
+		c.stats.SequenceGaps++
+		c.stats.PacketsLost += uint64(lost)
+		c.log.WithField("expected", c.rxSeq+1).WithField("got", seq).Debug("cannelloni sequence gap")
+	}
+	c.rxSeq = seq
+	c.rxSeqSet = true
+	c.statsMu.Unlock()
+
+	r := bytes.NewReader(buf[cannelloniHeaderLen:])
+	for i := 0; i < int(count); i++ {
+		frame, err := readCannelloniFrame(r)
+		if err != nil {
+			return fmt.Errorf("frame %d of %d: %w", i+1, count, err)
+		}
+		c.handleFrame(frame)
+	}
+
+	return nil
+}
+
+// Close shuts down the channel, dropping any frames still waiting in a partial batch
+func (c *CannelloniChannel) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	c.batchMu.Lock()
+	if c.batchTimer != nil {
+		c.batchTimer.Stop()
+		c.batchTimer = nil
+	}
+	c.batch = nil
+	c.batchMu.Unlock()
+
+	return c.closeConns()
+}
+
+func (c *CannelloniChannel) closeConns() error {
+	c.mu.Lock()
+	udpConn, listener, tcpConn := c.udpConn, c.listener, c.tcpConn
+	c.udpConn, c.listener, c.tcpConn = nil, nil, nil
+	c.mu.Unlock()
+
+	var errs []error
+	if udpConn != nil {
+		errs = append(errs, udpConn.Close())
+	}
+	if listener != nil {
+		errs = append(errs, listener.Close())
+	}
+	if tcpConn != nil {
+		errs = append(errs, tcpConn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// WriteFrame will send a CAN frame to the peer, possibly batched with following frames
+func (c *CannelloniChannel) WriteFrame(frame can.Frame) error {
+	if err := c.writeFrame(frame); err != nil {
+		return err
+	}
+	c.tracer.trace(c.log, TraceTransmit, frame)
+	return nil
+}
+
+// SetTrace starts logging each frame received and transmitted, or stops if options is nil.  Batched frames are
+// traced as they're queued.
+func (c *CannelloniChannel) SetTrace(options *TraceOptions) {
+	c.tracer.set(options)
+}
+
+// writeFrame is a helper to send or batch a frame without tracing it
+func (c *CannelloniChannel) writeFrame(frame can.Frame) error {
+	if c.isClosed() {
+		return errors.New("cannelloni channel is closed")
+	}
+	if frame.Length > can.MaxFrameDataLength {
+		return fmt.Errorf("invalid frame length %d", frame.Length)
+	}
+
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+
+	if c.options.MaxFramesPerPacket <= 1 {
+		return c.sendLocked([]can.Frame{frame})
+	}
+
+	// Flush first if this frame won't fit in the datagram.
+	if cannelloniHeaderLen+(len(c.batch)+1)*cannelloniFrameMaxLen > cannelloniMaxPayload {
+		if err := c.flushLocked(); err != nil {
+			return err
+		}
+	}
+	c.batch = append(c.batch, frame)
+	if len(c.batch) >= c.options.MaxFramesPerPacket {
+		return c.flushLocked()
+	}
+	if c.batchTimer == nil {
+		c.batchTimer = time.AfterFunc(c.options.BatchTimeout, func() {
+			c.batchMu.Lock()
+			defer c.batchMu.Unlock()
+
+			if err := c.flushLocked(); err != nil {
+				c.log.WithError(err).Warn("cannelloni batch send failed")
+			}
+		})
+	}
+
+	return nil
+}
+
+// Flush sends any frames waiting in a partial batch right away.
+func (c *CannelloniChannel) Flush() error {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+
+	return c.flushLocked()
+}
+
+func (c *CannelloniChannel) flushLocked() error {
+	if c.batchTimer != nil {
+		c.batchTimer.Stop()
+		c.batchTimer = nil
+	}
+	if len(c.batch) == 0 {
+		return nil
+	}
+	frames := c.batch
+	c.batch = nil
+
+	return c.sendLocked(frames)
+}
+
+// sendLocked is a helper to encode and write frames.  The caller must hold batchMu, which also guards txSeq.
+func (c *CannelloniChannel) sendLocked(frames []can.Frame) error {
+	c.mu.Lock()
+	udpConn, udpPeer, tcpConn := c.udpConn, c.udpPeer, c.tcpConn
+	c.mu.Unlock()
+
+	buf := make([]byte, 0, cannelloniHeaderLen+len(frames)*cannelloniFrameMaxLen)
+	switch {
+	case udpConn != nil:
+		if udpPeer == nil {
+			return errors.New("cannelloni peer is not known yet")
+		}
+		buf = append(buf, cannelloniVersion, cannelloniOpData, c.txSeq)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(frames)))
+		for _, f := range frames {
+			buf = appendCannelloniFrame(buf, f)
+		}
+		if _, err := udpConn.WriteToUDP(buf, udpPeer); err != nil {
+			return err
+		}
+		c.txSeq++
+	case tcpConn != nil:
+		for _, f := range frames {
+			buf = appendCannelloniFrame(buf, f)
+		}
+		if _, err := tcpConn.Write(buf); err != nil {
+			return err
+		}
+	default:
+		return errors.New("cannelloni peer is not connected")
+	}
+
+	c.statsMu.Lock()
+	c.stats.PacketsSent++
+	c.stats.FramesSent += uint64(len(frames))
+	c.statsMu.Unlock()
+
+	return nil
+}
+
+func (c *CannelloniChannel) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+var (
+	_ Interface = (*CannelloniChannel)(nil)
+	_ Tracer    = (*CannelloniChannel)(nil)
+)
+
+// cannelloniFrameMaxLen is the largest encoded classic CAN frame: 4 byte ID, length, 8 data bytes
+const cannelloniFrameMaxLen = 4 + 1 + can.MaxFrameDataLength
+
+// appendCannelloniFrame is a helper to encode a frame in cannelloni's wire format, which is the Linux can_frame
+// ID (with EFF/RTR/ERR flags) in network byte order followed by the length and data.
+func appendCannelloniFrame(buf []byte, frame can.Frame) []byte {
+	id := frame.ID
+	if IsExtendedID(id) {
+		id |= can.MaskEff
+	}
+	buf = binary.BigEndian.AppendUint32(buf, id)
+	buf = append(buf, frame.Length)
+	if id&can.MaskRtr == 0 {
+		buf = append(buf, frame.Data[:frame.Length]...)
+	}
+	return buf
+}
+
+// readCannelloniFrame is a helper to decode a single frame from a stream or datagram
+func readCannelloniFrame(r io.ByteReader) (can.Frame, error) {
+	var frame can.Frame
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return can.Frame{}, err
+		}
+		frame.ID = frame.ID<<8 | uint32(b)
+	}
+
+	length, err := r.ReadByte()
+	if err != nil {
+		return can.Frame{}, err
+	}
+	if length&cannelloniCANFDFlag != 0 {
+		return can.Frame{}, errors.New("CAN FD frames are not supported")
+	}
+	if length > can.MaxFrameDataLength {
+		return can.Frame{}, fmt.Errorf("invalid frame length %d", length)
+	}
+	frame.Length = length
+
+	if frame.ID&can.MaskRtr == 0 {
+		for i := 0; i < int(length); i++ {
+			frame.Data[i], err = r.ReadByte()
+			if err != nil {
+				return can.Frame{}, err
+			}
+		}
+	}
+
+	return frame, nil
+}
+
+// cannelloniHandshake is a helper to exchange the TCP handshake, which both ends send as soon as they connect
+func cannelloniHandshake(ctx context.Context, conn net.Conn) error {
+	deadline := time.Now().Add(5 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+
+	if _, err := io.WriteString(conn, cannelloniMagic); err != nil {
+		return err
+	}
+	buf := make([]byte, len(cannelloniMagic))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if string(buf) != cannelloniMagic {
+		return fmt.Errorf("unexpected cannelloni handshake %q", buf)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package canbus
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func startCannelloni(t *testing.T, options CannelloniChannelOptions) (*CannelloniChannel, <-chan can.Frame) {
+	t.Helper()
+
+	frames := make(chan can.Frame, 16)
+	options.FrameHandler = func(f can.Frame) { frames <- f }
+	channel := NewCannelloniChannel(logrus.New(), options)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, channel.Start(ctx))
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, channel.Close())
+		require.NoError(t, <-runDone)
+	})
+
+	return channel, frames
+}
+
+func receiveFrame(t *testing.T, frames <-chan can.Frame) can.Frame {
+	t.Helper()
+
+	select {
+	case f := <-frames:
+		return f
+	case <-time.After(time.Second):
+		t.Fatal("frame was not delivered")
+		return can.Frame{}
+	}
+}
+
+func TestCannelloniUDPRoundTripWithBatching(t *testing.T) {
+	server, serverFrames := startCannelloni(t, CannelloniChannelOptions{
+		Transport:    CannelloniUDP,
+		Listen:       true,
+		LocalAddress: "127.0.0.1:0",
+	})
+	client, clientFrames := startCannelloni(t, CannelloniChannelOptions{
+		Transport:          CannelloniUDP,
+		LocalAddress:       "127.0.0.1:0",
+		RemoteAddress:      server.LocalAddr().String(),
+		MaxFramesPerPacket: 3,
+		BatchTimeout:       20 * time.Millisecond,
+	})
+
+	sent := []can.Frame{
+		{ID: 0x123, Length: 2, Data: [8]byte{1, 2}},
+		{ID: 0x09F80101, Length: 8, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{ID: 0x7ff | can.MaskRtr, Length: 4},
+		{ID: 0x100, Length: 0},
+	}
+	for _, f := range sent {
+		require.NoError(t, client.WriteFrame(f))
+	}
+
+	require.Equal(t, sent[0], receiveFrame(t, serverFrames))
+	require.Equal(t, can.Frame{ID: 0x09F80101 | can.MaskEff, Length: 8, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}, receiveFrame(t, serverFrames))
+	require.Equal(t, sent[2], receiveFrame(t, serverFrames))
+	// The fourth frame goes out on its own once the batch timeout fires.
+	require.Equal(t, sent[3], receiveFrame(t, serverFrames))
+	// The sender counts a packet after the write returns, which can be after the receiver has seen it.
+	require.Eventually(t, func() bool {
+		return client.Stats() == CannelloniStats{PacketsSent: 2, FramesSent: 4}
+	}, time.Second, time.Millisecond)
+	require.Equal(t, CannelloniStats{PacketsReceived: 2, FramesReceived: 4}, server.Stats())
+
+	// The server learned the client's address from its datagrams, so it can answer.
+	require.NoError(t, server.WriteFrame(can.Frame{ID: 0x42, Length: 1, Data: [8]byte{9}}))
+	require.Equal(t, can.Frame{ID: 0x42, Length: 1, Data: [8]byte{9}}, receiveFrame(t, clientFrames))
+}
+
+func TestCannelloniUDPDetectsSequenceGaps(t *testing.T) {
+	server, serverFrames := startCannelloni(t, CannelloniChannelOptions{
+		Transport:    CannelloniUDP,
+		Listen:       true,
+		LocalAddress: "127.0.0.1:0",
+	})
+
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	for _, seq := range []byte{254, 255, 2, 3} {
+		packet := []byte{cannelloniVersion, cannelloniOpData, seq, 0, 1}
+		packet = appendCannelloniFrame(packet, can.Frame{ID: uint32(seq), Length: 1, Data: [8]byte{seq}})
+		_, err := conn.Write(packet)
+		require.NoError(t, err)
+		receiveFrame(t, serverFrames)
+	}
+
+	stats := server.Stats()
+	require.Equal(t, uint64(1), stats.SequenceGaps)
+	require.Equal(t, uint64(2), stats.PacketsLost)
+}
+
+func TestCannelloniTCPServerAcceptsReconnects(t *testing.T) {
+	server, serverFrames := startCannelloni(t, CannelloniChannelOptions{
+		Transport:    CannelloniTCP,
+		Listen:       true,
+		LocalAddress: "127.0.0.1:0",
+	})
+
+	for i := 0; i < 2; i++ {
+		client := NewCannelloniChannel(logrus.New(), CannelloniChannelOptions{
+			Transport:     CannelloniTCP,
+			RemoteAddress: server.LocalAddr().String(),
+		})
+		require.NoError(t, client.Start(context.Background()))
+
+		frame := can.Frame{ID: 0x300 + uint32(i), Length: 3, Data: [8]byte{byte(i), 2, 3}}
+		require.NoError(t, client.WriteFrame(frame))
+		require.Equal(t, frame, receiveFrame(t, serverFrames))
+		require.NoError(t, client.Close())
+	}
+}
+
+func TestReadCannelloniFrameRejectsCANFD(t *testing.T) {
+	r := bytes.NewReader([]byte{0, 0, 1, 0x23, 0x80 | 12})
+	_, err := readCannelloniFrame(r)
+	require.ErrorContains(t, err, "CAN FD")
+}
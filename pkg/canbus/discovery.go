@@ -0,0 +1,193 @@
+package canbus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// arphrdCAN is the hardware type the kernel reports in /sys/class/net/*/type for can and vcan interfaces
+const arphrdCAN = 280
+
+// CANState is the error state of a CAN controller, as reported by netlink
+type CANState uint32
+
+const (
+	CANStateErrorActive CANState = iota
+	CANStateErrorWarning
+	CANStateErrorPassive
+	CANStateBusOff
+	CANStateStopped
+	CANStateSleeping
+)
+
+// String returns the state the way iproute2 prints it.
+func (s CANState) String() string {
+	switch s {
+	case CANStateErrorActive:
+		return "ERROR-ACTIVE"
+	case CANStateErrorWarning:
+		return "ERROR-WARNING"
+	case CANStateErrorPassive:
+		return "ERROR-PASSIVE"
+	case CANStateBusOff:
+		return "BUS-OFF"
+	case CANStateStopped:
+		return "STOPPED"
+	case CANStateSleeping:
+		return "SLEEPING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// CANControlMode is the set of controller mode flags (CAN_CTRLMODE_*) enabled on an interface
+type CANControlMode uint32
+
+const (
+	CANModeLoopback CANControlMode = 1 << iota
+	CANModeListenOnly
+	CANModeTripleSampling
+	CANModeOneShot
+	CANModeBusErrorReporting
+	CANModeFD
+	CANModePresumeAck
+	CANModeFDNonISO
+	CANModeCCLen8DLC
+)
+
+var canModeNames = []string{
+	"LOOPBACK", "LISTEN-ONLY", "TRIPLE-SAMPLING", "ONE-SHOT", "BERR-REPORTING", "FD", "PRESUME-ACK", "FD-NON-ISO",
+	"CC-LEN8-DLC",
+}
+
+// String returns the enabled flags joined with commas, i.e. "LOOPBACK,LISTEN-ONLY".
+func (m CANControlMode) String() string {
+	var names []string
+	for i, name := range canModeNames {
+		if m&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// CANInterfaceInfo describes a CAN network interface found by DiscoverCANInterfaces
+type CANInterfaceInfo struct {
+	// Name is the netdev name, i.e. "can0".  It depends on probe order, so it can change between boots.
+	Name string
+	// Type is the link type, "can" or "vcan".
+	Type string
+	// Bus is the subsystem of the parent device ("spi", "usb", "platform", ...), or empty for virtual interfaces.
+	Bus string
+	// Device is the parent device's name on its bus, i.e. "spi0.0" or "1-1.3:1.0".  Unlike Name, it's stable.
+	Device string
+	// Driver is the name of the parent device's driver, i.e. "mcp251x" or "gs_usb".
+	Driver string
+	// BitRate is the configured bitrate, or zero for vcan and unconfigured interfaces.
+	BitRate uint32
+	// OperState is the operational state, i.e. "up" or "down".
+	OperState string
+	// State is the controller's error state.  It's only meaningful for can interfaces that are up.
+	State CANState
+	// ControlMode is the set of controller modes that are enabled.
+	ControlMode CANControlMode
+}
+
+// DiscoveryOptions is a type that contains options for DiscoverCANInterfaces
+type DiscoveryOptions struct {
+	// SysfsRoot is where sysfs is mounted, defaulting to /sys.
+	SysfsRoot string
+	// LinkByName looks up an interface over netlink, defaulting to netlink.LinkByName.
+	LinkByName func(name string) (netlink.Link, error)
+}
+
+// DiscoverCANInterfaces lists every can and vcan interface on the system, sorted by name, along with the bus
+// device it hangs off.  Use the Device field rather than the name to find a particular adapter, since interface
+// numbering isn't stable across boots when there's more than one kind of adapter.
+func DiscoverCANInterfaces(options DiscoveryOptions) ([]CANInterfaceInfo, error) {
+	if options.SysfsRoot == "" {
+		options.SysfsRoot = string(os.PathSeparator) + "sys"
+	}
+	if options.LinkByName == nil {
+		options.LinkByName = netlink.LinkByName
+	}
+
+	netDir := filepath.Join(options.SysfsRoot, "class", "net")
+	entries, err := os.ReadDir(netDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var interfaces []CANInterfaceInfo
+	for _, e := range entries {
+		name := e.Name()
+		dir := filepath.Join(netDir, name)
+
+		hwType, err := readSysfsValue(dir, "type")
+		if err != nil {
+			// The interface went away while we were looking
+			continue
+		}
+		if t, err := strconv.Atoi(hwType); err != nil || t != arphrdCAN {
+			continue
+		}
+
+		info := CANInterfaceInfo{Name: name, Type: "vcan"}
+		info.OperState, _ = readSysfsValue(dir, "operstate")
+
+		// Virtual interfaces have no parent device
+		device := filepath.Join(dir, "device")
+		if _, err := os.Stat(device); err == nil {
+			info.Type = "can"
+			info.Device = readLinkBase(device)
+			info.Bus = readLinkBase(filepath.Join(device, "subsystem"))
+			info.Driver = readLinkBase(filepath.Join(device, "driver"))
+		}
+
+		link, err := options.LinkByName(name)
+		if err != nil {
+			var notFound netlink.LinkNotFoundError
+			if errors.As(err, &notFound) {
+				continue
+			}
+			return nil, fmt.Errorf("netlink lookup for %s: %w", name, err)
+		}
+		info.Type = link.Type()
+		if state := link.Attrs().OperState; state != netlink.OperUnknown {
+			info.OperState = state.String()
+		}
+		if c, ok := link.(*netlink.Can); ok {
+			info.BitRate = c.BitRate
+			info.State = CANState(c.State)
+			info.ControlMode = CANControlMode(c.Flags)
+		}
+
+		interfaces = append(interfaces, info)
+	}
+
+	return interfaces, nil
+}
+
+// readSysfsValue is a helper to read a single-line sysfs attribute
+func readSysfsValue(dir string, attr string) (string, error) {
+	b, err := os.ReadFile(filepath.Clean(filepath.Join(dir, attr)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// readLinkBase is a helper to get the last element of a sysfs symlink's target, or empty if it isn't one
+func readLinkBase(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
@@ -0,0 +1,95 @@
+package canbus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+// fakeSysfs is a minimal /sys tree with the net class and bus device layout
+type fakeSysfs struct {
+	t    *testing.T
+	root string
+}
+
+func (s *fakeSysfs) write(path string, content string) {
+	full := filepath.Join(s.root, path)
+	require.NoError(s.t, os.MkdirAll(filepath.Dir(full), 0o750))
+	require.NoError(s.t, os.WriteFile(full, []byte(content+"\n"), 0o600))
+}
+
+func (s *fakeSysfs) link(path string, target string) {
+	full := filepath.Join(s.root, path)
+	require.NoError(s.t, os.MkdirAll(filepath.Join(s.root, target), 0o750))
+	require.NoError(s.t, os.MkdirAll(filepath.Dir(full), 0o750))
+	require.NoError(s.t, os.Symlink(filepath.Join(s.root, target), full))
+}
+
+// addInterface adds a net interface, with a parent device when bus is set
+func (s *fakeSysfs) addInterface(name string, hwType string, bus string, device string, driver string) {
+	s.write(filepath.Join("class/net", name, "type"), hwType)
+	s.write(filepath.Join("class/net", name, "operstate"), "down")
+	if bus == "" {
+		return
+	}
+	devDir := filepath.Join("devices", device)
+	s.link(filepath.Join("class/net", name, "device"), devDir)
+	s.link(filepath.Join(devDir, "subsystem"), filepath.Join("bus", bus))
+	s.link(filepath.Join(devDir, "driver"), filepath.Join("bus", bus, "drivers", driver))
+}
+
+func TestDiscoverCANInterfaces(t *testing.T) {
+	sysfs := &fakeSysfs{t: t, root: t.TempDir()}
+	sysfs.addInterface("can0", "280", "usb", "1-1.3:1.0", "gs_usb")
+	sysfs.addInterface("can1", "280", "spi", "spi0.0", "mcp251x")
+	sysfs.addInterface("eth0", "1", "platform", "fe300000.ethernet", "bcmgenet")
+	sysfs.addInterface("gone0", "280", "", "", "")
+	sysfs.addInterface("vcan0", "280", "", "", "")
+
+	links := map[string]netlink.Link{
+		"can0": &netlink.Can{
+			LinkAttrs: netlink.LinkAttrs{Name: "can0", OperState: netlink.OperUp},
+			BitRate:   250000,
+			State:     uint32(CANStateErrorPassive),
+			Flags:     uint32(CANModeListenOnly | CANModeBusErrorReporting),
+		},
+		"can1":  &netlink.Can{LinkAttrs: netlink.LinkAttrs{Name: "can1", OperState: netlink.OperDown}},
+		"vcan0": &netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "vcan0", OperState: netlink.OperUnknown}, LinkType: "vcan"},
+	}
+	interfaces, err := DiscoverCANInterfaces(DiscoveryOptions{
+		SysfsRoot: sysfs.root,
+		LinkByName: func(name string) (netlink.Link, error) {
+			if link, ok := links[name]; ok {
+				return link, nil
+			}
+			return nil, netlink.LinkNotFoundError{}
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []CANInterfaceInfo{
+		{
+			Name:        "can0",
+			Type:        "can",
+			Bus:         "usb",
+			Device:      "1-1.3:1.0",
+			Driver:      "gs_usb",
+			BitRate:     250000,
+			OperState:   "up",
+			State:       CANStateErrorPassive,
+			ControlMode: CANModeListenOnly | CANModeBusErrorReporting,
+		},
+		{Name: "can1", Type: "can", Bus: "spi", Device: "spi0.0", Driver: "mcp251x", OperState: "down"},
+		{Name: "vcan0", Type: "vcan", OperState: "down"},
+	}, interfaces)
+
+	require.Equal(t, "ERROR-PASSIVE", interfaces[0].State.String())
+	require.Equal(t, "LISTEN-ONLY,BERR-REPORTING", interfaces[0].ControlMode.String())
+}
+
+func TestDiscoverCANInterfacesNoSysfs(t *testing.T) {
+	_, err := DiscoverCANInterfaces(DiscoveryOptions{SysfsRoot: filepath.Join(t.TempDir(), "missing")})
+	require.Error(t, err)
+}
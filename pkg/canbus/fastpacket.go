@@ -0,0 +1,91 @@
+package canbus
+
+import (
+	"sync"
+
+	"github.com/brutella/can"
+)
+
+// fastPacketKey identifies a series in flight: each source can be sending one series per PGN at a time
+type fastPacketKey struct {
+	source uint8
+	pgn    uint32
+}
+
+// fastPacketSeries is a partially received fast-packet message
+type fastPacketSeries struct {
+	header   N2KHeader
+	sequence uint8
+	counter  uint8
+	length   int
+	data     []byte
+}
+
+// FastPacketAssembler reassembles fast-packet series into complete messages.  Whether a PGN is fast-packet comes
+// from isFastPacket; frames for other PGNs are passed through as single-frame messages.
+type FastPacketAssembler struct {
+	isFastPacket func(pgn uint32) bool
+
+	mu      sync.Mutex
+	pending map[fastPacketKey]*fastPacketSeries
+}
+
+// NewFastPacketAssembler returns an assembler that asks isFastPacket which PGNs are sent as fast-packet series.
+func NewFastPacketAssembler(isFastPacket func(pgn uint32) bool) *FastPacketAssembler {
+	return &FastPacketAssembler{
+		isFastPacket: isFastPacket,
+		pending:      map[fastPacketKey]*fastPacketSeries{},
+	}
+}
+
+// Add feeds a frame in, returning a message when the frame completes one.  Series that arrive out of order, and
+// frames claiming more than 8 bytes, are dropped.
+func (a *FastPacketAssembler) Add(frame can.Frame) (PGNMessage, bool) {
+	if frame.Length > 8 {
+		return PGNMessage{}, false
+	}
+	h := ParseN2KHeader(frame.ID)
+	if !a.isFastPacket(h.PGN) {
+		return PGNMessage{N2KHeader: h, Data: append([]byte(nil), frame.Data[:frame.Length]...)}, true
+	}
+	if frame.Length < 2 {
+		return PGNMessage{}, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := fastPacketKey{source: h.Source, pgn: h.PGN}
+	sequence, counter := frame.Data[0]>>5, frame.Data[0]&0x1f
+
+	if counter == 0 {
+		s := &fastPacketSeries{
+			header:   h,
+			sequence: sequence,
+			length:   int(frame.Data[1]),
+			data:     make([]byte, 0, int(frame.Data[1])),
+		}
+		s.data = append(s.data, frame.Data[2:frame.Length]...)
+		a.pending[key] = s
+		return a.complete(key, s)
+	}
+
+	s, ok := a.pending[key]
+	if !ok || s.sequence != sequence || s.counter+1 != counter {
+		delete(a.pending, key)
+		return PGNMessage{}, false
+	}
+	s.counter = counter
+	s.data = append(s.data, frame.Data[1:frame.Length]...)
+	return a.complete(key, s)
+}
+
+// complete is a helper to hand back a series once it has all its bytes, dropping the padding
+func (a *FastPacketAssembler) complete(key fastPacketKey, s *fastPacketSeries) (PGNMessage, bool) {
+	if len(s.data) < s.length {
+		return PGNMessage{}, false
+	}
+
+	delete(a.pending, key)
+	return PGNMessage{N2KHeader: s.header, Data: s.data[:s.length]}, true
+}
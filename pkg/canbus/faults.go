@@ -0,0 +1,362 @@
+package canbus
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+// FaultConfig is the set of faults applied to frames travelling in one direction.  Rates are probabilities from 0
+// to 1, rolled independently for each frame.
+type FaultConfig struct {
+	// DropRate is the chance a frame is silently lost.
+	DropRate float64
+	// CorruptRate is the chance one random bit of the frame's data is flipped.
+	CorruptRate float64
+	// DuplicateRate is the chance a frame is delivered twice.
+	DuplicateRate float64
+	// ReorderRate is the chance a frame is held back and delivered after the next one.
+	ReorderRate float64
+	// Delay holds every frame back before delivering it, plus a random amount up to DelayJitter.  Jitter lets frames
+	// overtake each other.
+	Delay       time.Duration
+	DelayJitter time.Duration
+}
+
+// Faults is everything a FaultInjector does to the wrapped channel
+type Faults struct {
+	Receive  FaultConfig
+	Transmit FaultConfig
+	// WriteErrorRate is the chance WriteFrame fails without sending anything.
+	WriteErrorRate float64
+	// FailRunAfter makes Run fail after the channel has received this many frames, as if the bus went away.  Zero
+	// never fails.
+	FailRunAfter int
+}
+
+// FaultCounts counts the faults injected in one direction
+type FaultCounts struct {
+	Frames     uint64
+	Dropped    uint64
+	Corrupted  uint64
+	Duplicated uint64
+	Reordered  uint64
+	Delayed    uint64
+}
+
+// FaultStats counts the faults a FaultInjector has injected so far
+type FaultStats struct {
+	Received    FaultCounts
+	Transmitted FaultCounts
+	WriteErrors uint64
+	RunFailures uint64
+}
+
+// ErrInjectedWrite is returned by WriteFrame for an injected write error.
+var ErrInjectedWrite = errors.New("injected write error")
+
+// ErrInjectedRunFailure is returned by Run when FailRunAfter is reached.
+var ErrInjectedRunFailure = errors.New("injected run failure")
+
+// FaultInjectorOptions is a type that contains options on a FaultInjector.
+type FaultInjectorOptions struct {
+	Faults Faults
+	// Seed seeds the random rolls so a test run can be repeated.  Zero picks a random seed.
+	Seed uint64
+	// FrameHandler receives each frame that makes it through the receive faults.
+	FrameHandler can.HandlerFunc
+}
+
+// faultDirection is the fault state for one direction of traffic
+type faultDirection struct {
+	held    *can.Frame
+	pending sync.WaitGroup
+	counts  FaultCounts
+}
+
+// FaultInjector wraps a canbus Interface and injects bus faults into the frames going through it, for testing how
+// higher layers cope with lost, mangled and out-of-order traffic.  Since channels take their frame handler at
+// construction, the wrapped channel is built by a factory that's given the injector's handler.  The factory is
+// called again on the next Start after an injected Run failure, so reconnect logic sees a fresh channel.
+type FaultInjector struct {
+	options  FaultInjectorOptions
+	newInner func(handler can.HandlerFunc) Interface
+
+	startMu  sync.Mutex
+	mu       sync.Mutex
+	inner    Interface
+	failRun  chan struct{}
+	failOnce *sync.Once
+	received int
+	closed   bool
+	rng      *rand.Rand
+	rx       faultDirection
+	tx       faultDirection
+	stats    FaultStats
+
+	tracer frameTracer
+
+	log *logrus.Logger
+}
+
+// NewFaultInjector returns a FaultInjector around the channel built by newInner.
+func NewFaultInjector(log *logrus.Logger, options FaultInjectorOptions, newInner func(handler can.HandlerFunc) Interface) *FaultInjector {
+	seed := options.Seed
+	if seed == 0 {
+		seed = rand.Uint64() // #nosec G404 -- fault injection doesn't need cryptographic randomness.
+	}
+
+	return &FaultInjector{
+		options:  options,
+		newInner: newInner,
+		rng:      rand.New(rand.NewPCG(seed, seed)), // #nosec G404 -- fault injection doesn't need cryptographic randomness.
+		log:      log,
+	}
+}
+
+// SetFaults replaces the faults being injected, i.e. to let a test settle before the bus goes bad.
+func (f *FaultInjector) SetFaults(faults Faults) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.options.Faults = faults
+}
+
+// Stats returns the counts of the faults injected so far.
+func (f *FaultInjector) Stats() FaultStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stats := f.stats
+	stats.Received = f.rx.counts
+	stats.Transmitted = f.tx.counts
+	return stats
+}
+
+// Start builds the wrapped channel if needed and starts it.
+func (f *FaultInjector) Start(ctx context.Context) error {
+	f.startMu.Lock()
+	defer f.startMu.Unlock()
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return errors.New("fault injector is closed")
+	}
+	if f.inner == nil {
+		f.inner = f.newInner(f.handleFrame)
+		f.failRun = make(chan struct{})
+		f.failOnce = &sync.Once{}
+		f.received = 0
+	}
+	inner := f.inner
+	f.mu.Unlock()
+
+	return inner.Start(ctx)
+}
+
+// Run runs the wrapped channel until it stops or FailRunAfter frames have been received, in which case the wrapped
+// channel is closed and ErrInjectedRunFailure returned.
+func (f *FaultInjector) Run(ctx context.Context) error {
+	if err := f.Start(ctx); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	inner, failRun := f.inner, f.failRun
+	f.mu.Unlock()
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- inner.Run(ctx)
+	}()
+
+	select {
+	case err := <-runDone:
+		return err
+	case <-failRun:
+	}
+
+	f.mu.Lock()
+	f.stats.RunFailures++
+	if f.inner == inner {
+		f.inner = nil
+	}
+	f.mu.Unlock()
+
+	f.log.Debug("Injecting run failure")
+	_ = inner.Close()
+	<-runDone
+	return ErrInjectedRunFailure
+}
+
+// Close closes the wrapped channel, dropping any frames still held back.
+func (f *FaultInjector) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	inner := f.inner
+	f.rx.held = nil
+	f.tx.held = nil
+	f.mu.Unlock()
+
+	if inner == nil {
+		return nil
+	}
+	return inner.Close()
+}
+
+// WriteFrame sends a frame through the transmit faults to the wrapped channel.  Errors from writes that were
+// delayed can't be returned, so they're only logged.
+func (f *FaultInjector) WriteFrame(frame can.Frame) error {
+	f.mu.Lock()
+	if f.closed || f.inner == nil {
+		f.mu.Unlock()
+		return errors.New("fault injector is not running")
+	}
+	inner := f.inner
+	if f.roll(f.options.Faults.WriteErrorRate) {
+		f.stats.WriteErrors++
+		f.mu.Unlock()
+		return ErrInjectedWrite
+	}
+	deliveries, delay := f.applyLocked(&f.tx, f.options.Faults.Transmit, frame)
+	f.mu.Unlock()
+
+	if delay == 0 {
+		return f.transmit(inner, deliveries)
+	}
+
+	f.later(&f.tx, delay, func() {
+		if err := f.transmit(inner, deliveries); err != nil {
+			f.log.WithError(err).Debug("Delayed write failed")
+		}
+	})
+	return nil
+}
+
+// SetTrace starts logging each frame received and transmitted, or stops if options is nil.  Frames are traced as
+// they come out of the faults, i.e. as delivered to the frame handler or the wrapped channel.
+func (f *FaultInjector) SetTrace(options *TraceOptions) {
+	f.tracer.set(options)
+}
+
+// transmit is a helper to write frames that made it through the transmit faults to the wrapped channel
+func (f *FaultInjector) transmit(inner Interface, deliveries []can.Frame) error {
+	for _, d := range deliveries {
+		if err := inner.WriteFrame(d); err != nil {
+			return err
+		}
+		f.tracer.trace(f.log, TraceTransmit, d)
+	}
+	return nil
+}
+
+// deliver is a helper to hand frames that made it through the receive faults to the frame handler
+func (f *FaultInjector) deliver(deliveries []can.Frame) {
+	for _, d := range deliveries {
+		f.tracer.trace(f.log, TraceReceive, d)
+		if f.options.FrameHandler != nil {
+			f.options.FrameHandler(d)
+		}
+	}
+}
+
+// Wait blocks until every delayed frame has been delivered, so tests can check the results.
+func (f *FaultInjector) Wait() {
+	f.rx.pending.Wait()
+	f.tx.pending.Wait()
+}
+
+// handleFrame is the wrapped channel's frame handler
+func (f *FaultInjector) handleFrame(frame can.Frame) {
+	f.mu.Lock()
+	f.received++
+	if n := f.options.Faults.FailRunAfter; n > 0 && f.received >= n {
+		f.failOnce.Do(func() {
+			close(f.failRun)
+		})
+	}
+	deliveries, delay := f.applyLocked(&f.rx, f.options.Faults.Receive, frame)
+	f.mu.Unlock()
+
+	if delay == 0 {
+		f.deliver(deliveries)
+		return
+	}
+	f.later(&f.rx, delay, func() {
+		f.deliver(deliveries)
+	})
+}
+
+// applyLocked rolls the faults for a frame, returning the frames to deliver now and how long to delay them
+func (f *FaultInjector) applyLocked(dir *faultDirection, cfg FaultConfig, frame can.Frame) ([]can.Frame, time.Duration) {
+	dir.counts.Frames++
+
+	if f.roll(cfg.DropRate) {
+		dir.counts.Dropped++
+		return nil, 0
+	}
+
+	if frame.Length > 0 && frame.Length <= can.MaxFrameDataLength && f.roll(cfg.CorruptRate) {
+		dir.counts.Corrupted++
+		frame.Data[f.rng.IntN(int(frame.Length))] ^= 1 << f.rng.IntN(8)
+	}
+
+	deliveries := []can.Frame{frame}
+	if f.roll(cfg.DuplicateRate) {
+		dir.counts.Duplicated++
+		deliveries = append(deliveries, frame)
+	}
+
+	if dir.held != nil {
+		deliveries = append(deliveries, *dir.held)
+		dir.held = nil
+	} else if f.roll(cfg.ReorderRate) {
+		dir.counts.Reordered++
+		held := deliveries[0]
+		dir.held = &held
+		deliveries = deliveries[1:]
+	}
+
+	if len(deliveries) == 0 {
+		return nil, 0
+	}
+	delay := cfg.Delay
+	if cfg.DelayJitter > 0 {
+		delay += time.Duration(f.rng.Int64N(int64(cfg.DelayJitter)))
+	}
+	if delay > 0 {
+		dir.counts.Delayed++
+	}
+	return deliveries, delay
+}
+
+// roll is a helper that returns true with the given probability.  The caller must hold mu.
+func (f *FaultInjector) roll(rate float64) bool {
+	return rate > 0 && f.rng.Float64() < rate
+}
+
+// later is a helper to deliver frames after a delay, unless the injector has been closed by then
+func (f *FaultInjector) later(dir *faultDirection, delay time.Duration, deliver func()) {
+	dir.pending.Add(1)
+	time.AfterFunc(delay, func() {
+		defer dir.pending.Done()
+
+		f.mu.Lock()
+		closed := f.closed
+		f.mu.Unlock()
+		if !closed {
+			deliver()
+		}
+	})
+}
+
+var (
+	_ Interface = (*FaultInjector)(nil)
+	_ Tracer    = (*FaultInjector)(nil)
+)
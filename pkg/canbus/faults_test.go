@@ -0,0 +1,184 @@
+package canbus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus/canbustest"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFaultInjector returns a started injector, the channels it has built, and a record of the frames it delivers
+func newTestFaultInjector(t *testing.T, faults Faults) (*FaultInjector, *[]*canbustest.Bus, func() []can.Frame) {
+	var mu sync.Mutex
+	var received []can.Frame
+	var inners []*canbustest.Bus
+
+	f := NewFaultInjector(logrus.New(), FaultInjectorOptions{
+		Faults: faults,
+		Seed:   1,
+		FrameHandler: func(frame can.Frame) {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, frame)
+		},
+	}, func(handler can.HandlerFunc) Interface {
+		inner := canbustest.NewBus(handler)
+		inners = append(inners, inner)
+		return inner
+	})
+	require.NoError(t, f.Start(context.Background()))
+
+	return f, &inners, func() []can.Frame {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]can.Frame(nil), received...)
+	}
+}
+
+func numberedFrames(n int) []can.Frame {
+	frames := make([]can.Frame, n)
+	for i := range frames {
+		frames[i] = can.Frame{ID: uint32(0x100 + i), Length: 2, Data: [8]byte{byte(i), 0xaa}}
+	}
+	return frames
+}
+
+func TestFaultInjectorPassesThrough(t *testing.T) {
+	f, inners, received := newTestFaultInjector(t, Faults{})
+	inner := (*inners)[0]
+
+	frames := numberedFrames(3)
+	for _, frame := range frames {
+		inner.Receive(frame)
+		require.NoError(t, f.WriteFrame(frame))
+	}
+	require.Equal(t, frames, received())
+	require.Equal(t, frames, inner.Frames())
+	require.Equal(t, FaultStats{Received: FaultCounts{Frames: 3}, Transmitted: FaultCounts{Frames: 3}}, f.Stats())
+}
+
+func TestFaultInjectorTrace(t *testing.T) {
+	f, inners, _ := newTestFaultInjector(t, Faults{Receive: FaultConfig{DropRate: 1}})
+	log, out := newTraceLog()
+	f.log = log
+	f.SetTrace(&TraceOptions{})
+
+	// Frames are traced as they come out of the faults, so dropped ones aren't
+	(*inners)[0].Receive(can.Frame{ID: 0x100, Length: 1, Data: [8]byte{0xaa}})
+	require.NoError(t, f.WriteFrame(can.Frame{ID: 0x200, Length: 1, Data: [8]byte{0xbb}}))
+	require.Equal(t, []string{"level=info msg=tx 200 [1] BB"}, out.traces())
+}
+
+func TestFaultInjectorCertainFaults(t *testing.T) {
+	frames := numberedFrames(4)
+
+	t.Run("drop", func(t *testing.T) {
+		f, inners, received := newTestFaultInjector(t, Faults{Receive: FaultConfig{DropRate: 1}})
+		for _, frame := range frames {
+			(*inners)[0].Receive(frame)
+		}
+		require.Empty(t, received())
+		require.Equal(t, uint64(4), f.Stats().Received.Dropped)
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		f, inners, _ := newTestFaultInjector(t, Faults{Transmit: FaultConfig{DuplicateRate: 1}})
+		require.NoError(t, f.WriteFrame(frames[0]))
+		require.Equal(t, []can.Frame{frames[0], frames[0]}, (*inners)[0].Frames())
+	})
+
+	t.Run("reorder", func(t *testing.T) {
+		_, inners, received := newTestFaultInjector(t, Faults{Receive: FaultConfig{ReorderRate: 1}})
+		for _, frame := range frames {
+			(*inners)[0].Receive(frame)
+		}
+		require.Equal(t, []can.Frame{frames[1], frames[0], frames[3], frames[2]}, received())
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		_, inners, received := newTestFaultInjector(t, Faults{Receive: FaultConfig{CorruptRate: 1}})
+		(*inners)[0].Receive(frames[2])
+		got := received()[0]
+		require.Equal(t, frames[2].ID, got.ID)
+		flipped := 0
+		for i := range got.Data {
+			for diff := got.Data[i] ^ frames[2].Data[i]; diff != 0; diff &= diff - 1 {
+				flipped++
+			}
+		}
+		require.Equal(t, 1, flipped)
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		f, inners, _ := newTestFaultInjector(t, Faults{WriteErrorRate: 1})
+		require.ErrorIs(t, f.WriteFrame(frames[0]), ErrInjectedWrite)
+		require.Empty(t, (*inners)[0].Frames())
+		require.Equal(t, uint64(1), f.Stats().WriteErrors)
+	})
+}
+
+func TestFaultInjectorRatesAreSeeded(t *testing.T) {
+	run := func() []can.Frame {
+		_, inners, received := newTestFaultInjector(t, Faults{Receive: FaultConfig{DropRate: 0.3}})
+		for _, frame := range numberedFrames(1000) {
+			(*inners)[0].Receive(frame)
+		}
+		return received()
+	}
+
+	first := run()
+	require.InDelta(t, 700, len(first), 60)
+	require.Equal(t, first, run())
+}
+
+func TestFaultInjectorDelay(t *testing.T) {
+	f, inners, received := newTestFaultInjector(t, Faults{
+		Receive:  FaultConfig{Delay: 20 * time.Millisecond},
+		Transmit: FaultConfig{Delay: 10 * time.Millisecond, DelayJitter: 10 * time.Millisecond},
+	})
+	inner := (*inners)[0]
+
+	frames := numberedFrames(2)
+	inner.Receive(frames[0])
+	require.NoError(t, f.WriteFrame(frames[1]))
+	require.Empty(t, received())
+	require.Empty(t, inner.Frames())
+
+	f.Wait()
+	require.Equal(t, frames[:1], received())
+	require.Equal(t, frames[1:], inner.Frames())
+	require.Equal(t, uint64(1), f.Stats().Transmitted.Delayed)
+}
+
+func TestFaultInjectorRunFailure(t *testing.T) {
+	f, inners, received := newTestFaultInjector(t, Faults{FailRunAfter: 3})
+
+	runDone := make(chan error, 1)
+	go func() { runDone <- f.Run(context.Background()) }()
+
+	for _, frame := range numberedFrames(3) {
+		(*inners)[0].Receive(frame)
+	}
+	select {
+	case err := <-runDone:
+		require.ErrorIs(t, err, ErrInjectedRunFailure)
+	case <-time.After(time.Second):
+		t.Fatal("run didn't fail")
+	}
+	require.Len(t, received(), 3)
+	require.Error(t, f.WriteFrame(can.Frame{}))
+
+	// Reconnecting builds a fresh channel, which fails again after another three frames
+	go func() { runDone <- f.Run(context.Background()) }()
+	require.Eventually(t, func() bool { return f.WriteFrame(can.Frame{}) == nil }, time.Second, time.Millisecond)
+	require.Len(t, *inners, 2)
+
+	require.NoError(t, f.Close())
+	require.NoError(t, <-runDone)
+	require.Equal(t, uint64(1), f.Stats().RunFailures)
+}
@@ -0,0 +1,398 @@
+package canbus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+// GatewayFormat is an enum for the text format spoken by a network NMEA 2000 gateway
+type GatewayFormat int
+
+const (
+	// GatewayYDRaw is Yacht Devices RAW (YDWG-02, YDEN-02), which carries individual CAN frames.
+	GatewayYDRaw GatewayFormat = iota
+	// GatewayN2KASCII is Actisense N2K ASCII (W2K-1), which carries whole reassembled messages.
+	GatewayN2KASCII
+)
+
+// GatewayTransport is an enum for how we reach a network gateway
+type GatewayTransport int
+
+const (
+	GatewayTCP GatewayTransport = iota
+	GatewayUDP
+)
+
+// GatewayChannelOptions is a type that contains required options on a GatewayChannel.
+type GatewayChannelOptions struct {
+	Format    GatewayFormat
+	Transport GatewayTransport
+	// RemoteAddress is the gateway's host:port: the server to connect to for TCP, where to send for UDP.  A UDP
+	// channel without one is receive-only.
+	RemoteAddress string
+	// LocalAddress is the address to listen on for UDP, i.e. ":1456".
+	LocalAddress string
+	// FrameHandler receives each frame; N2K ASCII messages are split into fast-packet series where needed.
+	FrameHandler can.HandlerFunc
+	// MessageHandler receives each N2K ASCII message whole.  It isn't called for YD RAW, which has no reassembly.
+	MessageHandler func(PGNMessage)
+	// FastPacketPGN reports whether a PGN is sent as a fast-packet series; nmea2000.Registry's IsFastPacket fits.
+	// It only matters for N2K ASCII, as for ActisenseChannelOptions: WriteFrame reassembles fast-packet series
+	// into whole messages, and FrameHandler gets even short fast-packet messages as a series.
+	FastPacketPGN func(pgn uint32) bool
+}
+
+// GatewayChannel represents an NMEA 2000 network reached through a WiFi/Ethernet gateway speaking Yacht Devices RAW
+// or Actisense N2K ASCII over TCP or UDP.
+type GatewayChannel struct {
+	options GatewayChannelOptions
+
+	startMu sync.Mutex
+	mu      sync.Mutex
+	writeMu sync.Mutex
+	conn    net.Conn
+	udpConn *net.UDPConn
+	udpPeer *net.UDPAddr
+	closed  bool
+	rxSeq   uint8
+	txSeq   uint8
+	// txAssembler reassembles the fast-packet series written with WriteFrame, for N2K ASCII with FastPacketPGN set.
+	txAssembler *FastPacketAssembler
+
+	tracer frameTracer
+
+	log *logrus.Logger
+}
+
+// NewGatewayChannel returns a Channel object for a network gateway and the given options.  ChannelOptions are
+// required settings.
+func NewGatewayChannel(log *logrus.Logger, options GatewayChannelOptions) *GatewayChannel {
+	c := GatewayChannel{
+		options: options,
+		log:     log,
+	}
+	if options.Format == GatewayN2KASCII && options.FastPacketPGN != nil {
+		c.txAssembler = NewFastPacketAssembler(options.FastPacketPGN)
+	}
+
+	return &c
+}
+
+// Start synchronously connects to the gateway (TCP) or binds the local socket (UDP).
+func (c *GatewayChannel) Start(ctx context.Context) error {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errors.New("gateway channel is closed")
+	}
+	if c.conn != nil || c.udpConn != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	switch c.options.Transport {
+	case GatewayTCP:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", c.options.RemoteAddress)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.conn = conn
+		c.mu.Unlock()
+	case GatewayUDP:
+		var peer *net.UDPAddr
+		if c.options.RemoteAddress != "" {
+			var err error
+			peer, err = net.ResolveUDPAddr("udp", c.options.RemoteAddress)
+			if err != nil {
+				return err
+			}
+		}
+		var lc net.ListenConfig
+		pc, err := lc.ListenPacket(ctx, "udp", c.options.LocalAddress)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.udpConn = pc.(*net.UDPConn)
+		c.udpPeer = peer
+		c.mu.Unlock()
+	default:
+		return fmt.Errorf("unknown gateway transport %d", c.options.Transport)
+	}
+
+	if c.isClosed() {
+		_ = c.closeConns()
+		return errors.New("gateway channel is closed")
+	}
+
+	c.log.WithField("remoteAddress", c.options.RemoteAddress).
+		WithField("localAddress", c.options.LocalAddress).
+		Info("Opened gateway")
+
+	return nil
+}
+
+// LocalAddr returns the bound local address, mostly useful when listening on port 0.
+func (c *GatewayChannel) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.udpConn != nil:
+		return c.udpConn.LocalAddr()
+	case c.conn != nil:
+		return c.conn.LocalAddr()
+	}
+	return nil
+}
+
+// Run starts listening after synchronously connecting to the gateway.
+func (c *GatewayChannel) Run(ctx context.Context) error {
+	if err := c.Start(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	conn := c.conn
+	udpConn := c.udpConn
+	c.mu.Unlock()
+
+	c.log.WithField("remoteAddress", c.options.RemoteAddress).
+		WithField("localAddress", c.options.LocalAddress).
+		Info("Listening on gateway")
+
+	var err error
+	switch {
+	case conn != nil:
+		err = c.readLines(conn)
+	case udpConn != nil:
+		buf := make([]byte, 65536)
+		for {
+			var n int
+			n, err = udpConn.Read(buf)
+			if err != nil {
+				break
+			}
+			// Datagrams may carry several lines, but never split one.
+			_ = c.readLines(bytes.NewReader(buf[:n]))
+		}
+	}
+	if c.isClosed() {
+		return nil
+	}
+	return err
+}
+
+// readLines is a helper to handle every line from a reader until it ends
+func (c *GatewayChannel) readLines(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		c.handleLine(string(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (c *GatewayChannel) handleLine(line string) {
+	switch c.options.Format {
+	case GatewayYDRaw:
+		rec, err := ParseYDRaw(line)
+		if err != nil {
+			c.log.Debugf("Bad YD RAW line: %v\n", err)
+			return
+		}
+		c.tracer.trace(c.log, TraceReceive, rec.Frame)
+		if c.options.FrameHandler != nil {
+			c.options.FrameHandler(rec.Frame)
+		}
+	case GatewayN2KASCII:
+		rec, err := ParseN2KASCII(line)
+		if err != nil {
+			c.log.Debugf("Bad N2K ASCII line: %v\n", err)
+			return
+		}
+		if c.options.MessageHandler != nil {
+			c.options.MessageHandler(rec.Message)
+		}
+		msg := rec.Message
+		frames := msg.Frames(c.rxSeq)
+		if len(msg.Data) > can.MaxFrameDataLength || c.options.FastPacketPGN != nil && c.options.FastPacketPGN(msg.PGN) {
+			frames = msg.FastPacketFrames(c.rxSeq)
+			c.rxSeq = (c.rxSeq + 1) & 0x7
+		}
+		for _, f := range frames {
+			c.tracer.trace(c.log, TraceReceive, f)
+			if c.options.FrameHandler != nil {
+				c.options.FrameHandler(f)
+			}
+		}
+	}
+}
+
+// Close shuts down the channel
+func (c *GatewayChannel) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	return c.closeConns()
+}
+
+func (c *GatewayChannel) closeConns() error {
+	c.mu.Lock()
+	conn, udpConn := c.conn, c.udpConn
+	c.conn, c.udpConn = nil, nil
+	c.mu.Unlock()
+
+	var errs []error
+	if conn != nil {
+		errs = append(errs, conn.Close())
+	}
+	if udpConn != nil {
+		errs = append(errs, udpConn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// WriteFrame will send a CAN frame to the bus.  For N2K ASCII it is sent as a complete single-frame message, or
+// held on to until the rest of its fast-packet series arrives if FastPacketPGN says it's part of one.
+func (c *GatewayChannel) WriteFrame(frame can.Frame) error {
+	if frame.Length > can.MaxFrameDataLength {
+		return fmt.Errorf("invalid frame length %d", frame.Length)
+	}
+
+	var err error
+	switch c.options.Format {
+	case GatewayYDRaw:
+		err = c.writeLines(FormatYDRawFrame(frame))
+	case GatewayN2KASCII:
+		msg := PGNMessage{N2KHeader: ParseN2KHeader(frame.ID), Data: frame.Data[:frame.Length]}
+		if c.txAssembler != nil {
+			var ok bool
+			if msg, ok = c.txAssembler.Add(frame); !ok {
+				c.tracer.trace(c.log, TraceTransmit, frame)
+				return nil
+			}
+		}
+		err = c.writeN2KASCII(msg)
+	default:
+		return fmt.Errorf("unknown gateway format %d", c.options.Format)
+	}
+	if err != nil {
+		return err
+	}
+	c.tracer.trace(c.log, TraceTransmit, frame)
+	return nil
+}
+
+// SetTrace starts logging each frame received and transmitted, or stops if options is nil.  N2K ASCII messages
+// are traced as the frames they're carried in on the bus.
+func (c *GatewayChannel) SetTrace(options *TraceOptions) {
+	c.tracer.set(options)
+}
+
+// WriteMessage will send a complete NMEA 2000 message to the bus, splitting it into a fast-packet series itself
+// for YD RAW.
+func (c *GatewayChannel) WriteMessage(msg PGNMessage) error {
+	switch c.options.Format {
+	case GatewayYDRaw:
+		c.writeMu.Lock()
+		seq := c.txSeq
+		c.txSeq = (c.txSeq + 1) & 0x7
+		c.writeMu.Unlock()
+
+		frames := msg.Frames(seq)
+		lines := make([]string, len(frames))
+		for i, f := range frames {
+			lines[i] = FormatYDRawFrame(f)
+		}
+		if err := c.writeLines(lines...); err != nil {
+			return err
+		}
+		for _, f := range frames {
+			c.tracer.trace(c.log, TraceTransmit, f)
+		}
+		return nil
+	case GatewayN2KASCII:
+		if err := c.writeN2KASCII(msg); err != nil {
+			return err
+		}
+		c.tracer.traceMessage(c.log, TraceTransmit, msg)
+		return nil
+	default:
+		return fmt.Errorf("unknown gateway format %d", c.options.Format)
+	}
+}
+
+// writeN2KASCII is a helper to send a whole message as an N2K ASCII line without tracing it
+func (c *GatewayChannel) writeN2KASCII(msg PGNMessage) error {
+	rec := N2KASCIIRecord{Time: timeOfDay(time.Now()), Message: msg}
+	return c.writeLines(rec.String())
+}
+
+// writeLines is a helper to send CRLF-terminated lines in a single write/datagram
+func (c *GatewayChannel) writeLines(lines ...string) error {
+	var buf bytes.Buffer
+	for _, l := range lines {
+		buf.WriteString(l)
+		buf.WriteString("\r\n")
+	}
+
+	c.mu.Lock()
+	conn, udpConn, udpPeer := c.conn, c.udpConn, c.udpPeer
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var err error
+	switch {
+	case conn != nil:
+		_, err = conn.Write(buf.Bytes())
+	case udpConn != nil && udpPeer != nil:
+		_, err = udpConn.WriteToUDP(buf.Bytes(), udpPeer)
+	case udpConn != nil:
+		err = errors.New("gateway channel has no RemoteAddress to send to")
+	default:
+		err = errors.New("gateway channel is not open")
+	}
+	return err
+}
+
+func (c *GatewayChannel) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+var (
+	_ Interface = (*GatewayChannel)(nil)
+	_ Tracer    = (*GatewayChannel)(nil)
+)
@@ -0,0 +1,164 @@
+package canbus
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayChannelYDRawOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	frames := make(chan can.Frame, 4)
+	channel := NewGatewayChannel(logrus.New(), GatewayChannelOptions{
+		Format:        GatewayYDRaw,
+		Transport:     GatewayTCP,
+		RemoteAddress: listener.Addr().String(),
+		FrameHandler:  func(f can.Frame) { frames <- f },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, channel.Start(ctx))
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(ctx) }()
+
+	gateway, err := listener.Accept()
+	require.NoError(t, err)
+	defer gateway.Close()
+
+	_, err = gateway.Write([]byte("17:33:21.107 R 19F51323 01 2F\r\ngarbage\r\n17:33:21.108 T 0CF00400 FF\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, can.Frame{ID: 0x19F51323 | can.MaskEff, Length: 2, Data: [8]byte{0x01, 0x2F}}, receiveFrame(t, frames))
+	require.Equal(t, can.Frame{ID: 0x0CF00400 | can.MaskEff, Length: 1, Data: [8]byte{0xFF}}, receiveFrame(t, frames))
+
+	require.NoError(t, channel.WriteMessage(PGNMessage{
+		N2KHeader: N2KHeader{Priority: 6, PGN: 126996, Source: 0x42, Destination: N2KBroadcast},
+		Data:      []byte{1, 2, 3, 4, 5, 6, 7, 8, 9},
+	}))
+	r := bufio.NewReader(gateway)
+	for _, want := range []string{"19F01442 00 09 01 02 03 04 05 06\r\n", "19F01442 01 07 08 09 FF FF FF FF\r\n"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, want, line)
+	}
+
+	require.NoError(t, channel.Close())
+	require.NoError(t, <-runDone)
+}
+
+func TestGatewayChannelN2KASCIIOverUDP(t *testing.T) {
+	gateway, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer gateway.Close()
+
+	frames := make(chan can.Frame, 4)
+	messages := make(chan PGNMessage, 1)
+	channel := NewGatewayChannel(logrus.New(), GatewayChannelOptions{
+		Format:         GatewayN2KASCII,
+		Transport:      GatewayUDP,
+		LocalAddress:   "127.0.0.1:0",
+		RemoteAddress:  gateway.LocalAddr().String(),
+		FrameHandler:   func(f can.Frame) { frames <- f },
+		MessageHandler: func(m PGNMessage) { messages <- m },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, channel.Start(ctx))
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(ctx) }()
+
+	_, err = gateway.WriteTo([]byte("A173321.107 23FF7 1F513 012F3070002F30709F\r\n"), channel.LocalAddr())
+	require.NoError(t, err)
+	select {
+	case msg := <-messages:
+		require.Equal(t, uint32(0x1F513), msg.PGN)
+		require.Len(t, msg.Data, 9)
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+	require.Equal(t, [8]byte{0x00, 9, 0x01, 0x2F, 0x30, 0x70, 0x00, 0x2F}, receiveFrame(t, frames).Data)
+	require.Equal(t, [8]byte{0x01, 0x30, 0x70, 0x9F, 0xff, 0xff, 0xff, 0xff}, receiveFrame(t, frames).Data)
+
+	require.NoError(t, channel.WriteFrame(can.Frame{
+		ID:     N2KHeader{Priority: 2, PGN: 127250, Source: 0x10, Destination: N2KBroadcast}.ID(),
+		Length: 3,
+		Data:   [8]byte{0xAA, 0xBB, 0xCC},
+	}))
+	buf := make([]byte, 256)
+	require.NoError(t, gateway.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := gateway.Read(buf)
+	require.NoError(t, err)
+	line := strings.TrimSpace(string(buf[:n]))
+	rec, err := ParseN2KASCII(line)
+	require.NoError(t, err)
+	require.Equal(t, PGNMessage{
+		N2KHeader: N2KHeader{Priority: 2, PGN: 127250, Source: 0x10, Destination: 0xff},
+		Data:      []byte{0xAA, 0xBB, 0xCC},
+	}, rec.Message)
+
+	require.NoError(t, channel.Close())
+	require.NoError(t, <-runDone)
+}
+
+func TestGatewayChannelN2KASCIIFastPacket(t *testing.T) {
+	gateway, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer gateway.Close()
+
+	log, out := newTraceLog()
+	frames := make(chan can.Frame, 4)
+	channel := NewGatewayChannel(log, GatewayChannelOptions{
+		Format:        GatewayN2KASCII,
+		Transport:     GatewayUDP,
+		LocalAddress:  "127.0.0.1:0",
+		RemoteAddress: gateway.LocalAddr().String(),
+		FrameHandler:  func(f can.Frame) { frames <- f },
+		FastPacketPGN: func(pgn uint32) bool { return pgn == 126464 },
+	})
+	channel.SetTrace(&TraceOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, channel.Start(ctx))
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(ctx) }()
+
+	// A short fast-packet message still comes through as a series
+	list := PGNMessage{
+		N2KHeader: N2KHeader{Priority: 6, PGN: 126464, Source: 0x23, Destination: N2KBroadcast},
+		Data:      []byte{1, 0, 0xee, 0},
+	}
+	rec := N2KASCIIRecord{Message: list}
+	_, err = gateway.WriteTo([]byte(rec.String()+"\r\n"), channel.LocalAddr())
+	require.NoError(t, err)
+	require.Equal(t, list.FastPacketFrames(0)[0], receiveFrame(t, frames))
+
+	// Written series go out as one message, without the fast-packet header bytes
+	for _, f := range list.FastPacketFrames(3) {
+		require.NoError(t, channel.WriteFrame(f))
+	}
+	buf := make([]byte, 256)
+	require.NoError(t, gateway.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := gateway.Read(buf)
+	require.NoError(t, err)
+	rec, err = ParseN2KASCII(strings.TrimSpace(string(buf[:n])))
+	require.NoError(t, err)
+	require.Equal(t, list, rec.Message)
+
+	// Messages are traced as the frames they're carried in
+	require.Equal(t, []string{
+		"level=info msg=rx 19EEFF23 prio=6 pgn=126464 src=35 dst=255 [8] 00040100EE00FFFF",
+		"level=info msg=tx 19EEFF23 prio=6 pgn=126464 src=35 dst=255 [8] 60040100EE00FFFF",
+	}, out.traces())
+
+	require.NoError(t, channel.Close())
+	require.NoError(t, <-runDone)
+}
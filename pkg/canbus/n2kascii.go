@@ -0,0 +1,74 @@
+package canbus
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Docs for the Actisense N2K ASCII format:
+// * https://www.actisense.com/wp-content/uploads/2020/01/W2K-1-User-Manual.pdf (N2K ASCII appendix)
+// * https://github.com/canboat/canboat/blob/master/common/parse.c
+
+// N2KASCIIRecord is a single line of Actisense N2K ASCII, as sent by W2K-1 gateways and written to their logs
+type N2KASCIIRecord struct {
+	// Time is the gateway's time of day when the message was seen.
+	Time    time.Duration
+	Message PGNMessage
+}
+
+// ParseN2KASCII parses a line such as "A173321.107 23FF7 1F513 012F3070002F30709F": the time, then source,
+// destination and priority, then the PGN, then the whole (reassembled) message data.
+func ParseN2KASCII(line string) (N2KASCIIRecord, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || len(fields) > 4 || len(fields[0]) < 2 || fields[0][0] != 'A' {
+		return N2KASCIIRecord{}, fmt.Errorf("malformed N2K ASCII line %q", line)
+	}
+
+	tod, err := parseTimeOfDay(fields[0][1:], "")
+	if err != nil {
+		return N2KASCIIRecord{}, err
+	}
+
+	sdp, err := strconv.ParseUint(fields[1], 16, 20)
+	if err != nil || len(fields[1]) != 5 {
+		return N2KASCIIRecord{}, fmt.Errorf("invalid address field %q", fields[1])
+	}
+	pgn, err := strconv.ParseUint(fields[2], 16, 18)
+	if err != nil {
+		return N2KASCIIRecord{}, fmt.Errorf("invalid PGN %q", fields[2])
+	}
+
+	var data []byte
+	if len(fields) == 4 {
+		data, err = hex.DecodeString(fields[3])
+		if err != nil {
+			return N2KASCIIRecord{}, fmt.Errorf("invalid data %q", fields[3])
+		}
+	}
+
+	return N2KASCIIRecord{
+		Time: tod,
+		Message: PGNMessage{
+			N2KHeader: N2KHeader{
+				Source:      uint8(sdp >> 12),
+				Destination: uint8(sdp >> 4),
+				Priority:    uint8(sdp & 0xf),
+				PGN:         uint32(pgn),
+			},
+			Data: data,
+		},
+	}, nil
+}
+
+// String formats the record the way the gateway does, which is also what gets sent to a gateway to transmit it.
+func (r N2KASCIIRecord) String() string {
+	m := r.Message
+	s := fmt.Sprintf("A%s %02X%02X%X %05X", formatTimeOfDay(r.Time, ""), m.Source, m.Destination, m.Priority&0x7, m.PGN)
+	if len(m.Data) > 0 {
+		s += " " + strings.ToUpper(hex.EncodeToString(m.Data))
+	}
+	return s
+}
@@ -0,0 +1,31 @@
+package canbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseN2KASCII(t *testing.T) {
+	rec, err := ParseN2KASCII("A173321.107 23FF7 1F513 012F3070002F30709F")
+	require.NoError(t, err)
+	require.Equal(t, N2KASCIIRecord{
+		Time: 17*time.Hour + 33*time.Minute + 21107*time.Millisecond,
+		Message: PGNMessage{
+			N2KHeader: N2KHeader{Priority: 7, PGN: 0x1F513, Source: 0x23, Destination: 0xFF},
+			Data:      []byte{0x01, 0x2F, 0x30, 0x70, 0x00, 0x2F, 0x30, 0x70, 0x9F},
+		},
+	}, rec)
+	require.Equal(t, "A173321.107 23FF7 1F513 012F3070002F30709F", rec.String())
+
+	for _, bad := range []string{
+		"B173321.107 23FF7 1F513 01",
+		"A173321.107 23FF 1F513 01",
+		"A173321.107 23FF7 1F513 0",
+		"A1733.107 23FF7 1F513 01",
+	} {
+		_, err := ParseN2KASCII(bad)
+		require.Error(t, err, bad)
+	}
+}
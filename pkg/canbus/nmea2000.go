@@ -0,0 +1,95 @@
+package canbus
+
+import (
+	"github.com/brutella/can"
+)
+
+// N2KBroadcast is the NMEA 2000 global destination address
+const N2KBroadcast = 0xff
+
+// N2KHeader is the NMEA 2000 (ISO 11783) view of a 29-bit CAN identifier
+type N2KHeader struct {
+	Priority    uint8
+	PGN         uint32
+	Source      uint8
+	Destination uint8
+}
+
+// ParseN2KHeader splits a 29-bit CAN identifier into its NMEA 2000 fields.  PDU1 PGNs (PF below 240) carry their
+// destination in the low byte; PDU2 PGNs are always broadcast.
+func ParseN2KHeader(id uint32) N2KHeader {
+	id &= can.MaskIDEff
+
+	h := N2KHeader{
+		Priority:    uint8((id >> 26) & 0x7),
+		PGN:         (id >> 8) & 0x3ffff,
+		Source:      uint8(id),
+		Destination: N2KBroadcast,
+	}
+	if pf := (h.PGN >> 8) & 0xff; pf < 240 {
+		h.Destination = uint8(h.PGN)
+		h.PGN &^= 0xff
+	}
+
+	return h
+}
+
+// ID builds the 29-bit CAN identifier for the header, with the EFF flag set so it can be handed straight to
+// WriteFrame.
+func (h N2KHeader) ID() uint32 {
+	pgn := h.PGN & 0x3ffff
+	if pf := (pgn >> 8) & 0xff; pf < 240 {
+		pgn = pgn&^0xff | uint32(h.Destination)
+	}
+
+	return can.MaskEff | uint32(h.Priority&0x7)<<26 | pgn<<8 | uint32(h.Source)
+}
+
+// PGNMessage is a complete NMEA 2000 message, as exchanged with gateways that reassemble fast-packet PGNs
+// themselves.
+type PGNMessage struct {
+	N2KHeader
+	Data []byte
+}
+
+// Frames splits the message into CAN frames: a single frame if the data fits in 8 bytes, otherwise a fast-packet
+// series tagged with the given sequence number (0-7), padded with 0xff.
+func (m PGNMessage) Frames(sequence uint8) []can.Frame {
+	if len(m.Data) <= can.MaxFrameDataLength {
+		f := can.Frame{ID: m.ID(), Length: uint8(len(m.Data))}
+		copy(f.Data[:], m.Data)
+		return []can.Frame{f}
+	}
+
+	return m.FastPacketFrames(sequence)
+}
+
+// FastPacketFrames splits the message into a fast-packet series even if it would fit in a single frame, as
+// fast-packet PGNs must always be sent.
+func (m PGNMessage) FastPacketFrames(sequence uint8) []can.Frame {
+	id := m.ID()
+
+	seq := (sequence & 0x7) << 5
+	frames := make([]can.Frame, 0, 1+(max(len(m.Data)-6, 0)+7-1)/7)
+
+	first := can.Frame{ID: id, Length: can.MaxFrameDataLength}
+	first.Data[0] = seq
+	first.Data[1] = uint8(len(m.Data))
+	n := copy(first.Data[2:], m.Data)
+	for j := 2 + n; j < can.MaxFrameDataLength; j++ {
+		first.Data[j] = 0xff
+	}
+	frames = append(frames, first)
+
+	for i, counter := 6, uint8(1); i < len(m.Data); i, counter = i+7, counter+1 {
+		f := can.Frame{ID: id, Length: can.MaxFrameDataLength}
+		f.Data[0] = seq | (counter & 0x1f)
+		n := copy(f.Data[1:], m.Data[i:])
+		for j := 1 + n; j < can.MaxFrameDataLength; j++ {
+			f.Data[j] = 0xff
+		}
+		frames = append(frames, f)
+	}
+
+	return frames
+}
@@ -0,0 +1,46 @@
+package canbus
+
+import (
+	"testing"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestN2KHeader(t *testing.T) {
+	// PDU2: vessel heading from source 0x23
+	h := ParseN2KHeader(0x09F11223 | can.MaskEff)
+	require.Equal(t, N2KHeader{Priority: 2, PGN: 127250, Source: 0x23, Destination: N2KBroadcast}, h)
+	require.Equal(t, uint32(0x09F11223|can.MaskEff), h.ID())
+
+	// PDU1: ISO request from 0x01 to 0x42
+	h = ParseN2KHeader(0x18EA4201)
+	require.Equal(t, N2KHeader{Priority: 6, PGN: 59904, Source: 0x01, Destination: 0x42}, h)
+	require.Equal(t, uint32(0x18EA4201|can.MaskEff), h.ID())
+}
+
+func TestPGNMessageFrames(t *testing.T) {
+	single := PGNMessage{N2KHeader: N2KHeader{Priority: 2, PGN: 127250, Source: 1, Destination: N2KBroadcast}, Data: []byte{1, 2, 3}}
+	require.Equal(t, []can.Frame{{ID: single.ID(), Length: 3, Data: [8]byte{1, 2, 3}}}, single.Frames(0))
+
+	data := make([]byte, 15)
+	for i := range data {
+		data[i] = byte(i + 1)
+	}
+	fast := PGNMessage{N2KHeader: N2KHeader{Priority: 6, PGN: 126996, Source: 1, Destination: N2KBroadcast}, Data: data}
+	frames := fast.Frames(3)
+	require.Len(t, frames, 3)
+	require.Equal(t, [8]byte{0x60, 15, 1, 2, 3, 4, 5, 6}, frames[0].Data)
+	require.Equal(t, [8]byte{0x61, 7, 8, 9, 10, 11, 12, 13}, frames[1].Data)
+	require.Equal(t, [8]byte{0x62, 14, 15, 0xff, 0xff, 0xff, 0xff, 0xff}, frames[2].Data)
+
+	short := PGNMessage{N2KHeader: fast.N2KHeader, Data: []byte{1, 2, 3, 4, 5, 6, 7}}
+	frames = short.FastPacketFrames(1)
+	require.Len(t, frames, 2)
+	require.Equal(t, [8]byte{0x20, 7, 1, 2, 3, 4, 5, 6}, frames[0].Data)
+	require.Equal(t, [8]byte{0x21, 7, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, frames[1].Data)
+
+	frames = PGNMessage{N2KHeader: fast.N2KHeader, Data: []byte{1, 2}}.FastPacketFrames(0)
+	require.Len(t, frames, 1)
+	require.Equal(t, [8]byte{0x00, 2, 1, 2, 0xff, 0xff, 0xff, 0xff}, frames[0].Data)
+}
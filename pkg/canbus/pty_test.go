@@ -0,0 +1,22 @@
+package canbus
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boatkit-io/tugboat/pkg/usbcan/usbcantest"
+)
+
+// openTestPTY opens a pseudo-terminal pair for simulated serial devices, returning the controlling side and the
+// path of the terminal side to hand to the channel under test.  The test is skipped if ptys aren't available.
+func openTestPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+
+	master, path, err := usbcantest.OpenPTY()
+	if err != nil {
+		t.Skipf("ptys are not available: %v", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+
+	return master, path
+}
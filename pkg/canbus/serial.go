@@ -0,0 +1,23 @@
+package canbus
+
+import (
+	"go.bug.st/serial"
+)
+
+// serialPortOpener opens a serial port, like serial.Open
+type serialPortOpener func(string, *serial.Mode) (serial.Port, error)
+
+// serialOpenResult is the outcome of opening (and setting up) a serial port in the background, so Start can give
+// up on an open that's stuck without leaking the port
+type serialOpenResult struct {
+	port serial.Port
+	err  error
+}
+
+// closeAbandonedSerialPort is a helper to close a port whose open finished after the caller stopped waiting for it
+func closeAbandonedSerialPort(resultCh <-chan serialOpenResult) {
+	result := <-resultCh
+	if result.port != nil {
+		_ = result.port.Close()
+	}
+}
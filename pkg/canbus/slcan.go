@@ -0,0 +1,597 @@
+package canbus
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"go.bug.st/serial"
+)
+
+// Docs for the Lawicel/SLCAN ASCII protocol:
+// * http://www.can232.com/docs/can232_v3.pdf
+// * https://github.com/normaldotcom/canable-fw/blob/master/Src/slcan.c
+
+// SLCANStatusFlags is the status byte returned by the SLCAN F command
+type SLCANStatusFlags byte
+
+const (
+	SLCANStatusRxFIFOFull      SLCANStatusFlags = 1 << 0
+	SLCANStatusTxFIFOFull      SLCANStatusFlags = 1 << 1
+	SLCANStatusErrorWarning    SLCANStatusFlags = 1 << 2
+	SLCANStatusDataOverrun     SLCANStatusFlags = 1 << 3
+	SLCANStatusErrorPassive    SLCANStatusFlags = 1 << 5
+	SLCANStatusArbitrationLost SLCANStatusFlags = 1 << 6
+	SLCANStatusBusError        SLCANStatusFlags = 1 << 7
+)
+
+// defaultSLCANCommandTimeout is how long we wait for the adapter to answer a command if the options don't say.
+const defaultSLCANCommandTimeout = time.Second
+
+// SLCANChannelOptions is a type that contains required options on a SLCANChannel.
+type SLCANChannelOptions struct {
+	SerialPortName string
+	SerialBaudRate int
+	BitRate        int
+	// Timestamps asks the adapter to append its millisecond timestamp to every received frame.
+	Timestamps   bool
+	FrameHandler can.HandlerFunc
+	// TimestampedFrameHandler, if set and Timestamps is on, is called with each received frame and the adapter's
+	// timestamp (which wraps every 60 seconds).
+	TimestampedFrameHandler func(frame can.Frame, timestamp time.Duration)
+	// CommandTimeout bounds how long we wait for the adapter to acknowledge a command.  Defaults to one second.
+	CommandTimeout time.Duration
+	// NoTransmitAcks is for adapters that don't answer transmitted frames with z/Z, such as CANable firmware with
+	// auto-acknowledge turned off.  Without it, every transmit waits in line for an ack, and on those adapters the
+	// responses to later commands would be matched to the wrong command.
+	NoTransmitAcks bool
+}
+
+// slcanResponse is a single response line from the adapter, matched in order against the commands we've sent
+type slcanResponse struct {
+	line string
+	err  error
+}
+
+// SLCANChannel represents a single canbus channel on a serial adapter speaking the Lawicel/SLCAN protocol
+type SLCANChannel struct {
+	options SLCANChannelOptions
+
+	startMu  sync.Mutex
+	mu       sync.Mutex
+	port     serial.Port
+	closed   bool
+	done     chan struct{}
+	openPort serialPortOpener
+
+	// pending holds bytes read during Start that have not been parsed into a complete line yet.
+	pending []byte
+
+	// writeMu keeps the order of responseQueue in step with the order of writes to the port.
+	writeMu       sync.Mutex
+	queueMu       sync.Mutex
+	responseQueue []chan slcanResponse
+
+	tracer frameTracer
+
+	log *logrus.Logger
+}
+
+// NewSLCANChannel returns a Channel object based on a SLCAN serial adapter and the given options.  ChannelOptions
+// are required settings.
+func NewSLCANChannel(log *logrus.Logger, options SLCANChannelOptions) *SLCANChannel {
+	if options.CommandTimeout == 0 {
+		options.CommandTimeout = defaultSLCANCommandTimeout
+	}
+
+	c := SLCANChannel{
+		options:  options,
+		log:      log,
+		done:     make(chan struct{}),
+		openPort: serial.Open,
+	}
+
+	return &c
+}
+
+// Start synchronously opens the serial port and configures and opens the CAN channel on the adapter.
+func (c *SLCANChannel) Start(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errors.New("SLCAN channel is closed")
+	}
+	if c.port != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	done := c.done
+	c.mu.Unlock()
+
+	bitRateCmd, err := mapSLCANBitRate(c.options.BitRate)
+	if err != nil {
+		return err
+	}
+
+	mode := &serial.Mode{
+		BaudRate: c.options.SerialBaudRate,
+	}
+	resultCh := make(chan serialOpenResult, 1)
+	go func() {
+		port, err := c.openPort(c.options.SerialPortName, mode)
+		if err == nil {
+			err = c.configure(port, bitRateCmd)
+			if err != nil {
+				_ = port.Close()
+				port = nil
+			}
+		}
+		resultCh <- serialOpenResult{port: port, err: err}
+	}()
+
+	var result serialOpenResult
+	select {
+	case result = <-resultCh:
+	case <-ctx.Done():
+		go closeAbandonedSerialPort(resultCh)
+		return ctx.Err()
+	case <-done:
+		go closeAbandonedSerialPort(resultCh)
+		return errors.New("SLCAN channel is closed")
+	}
+	if result.err != nil {
+		return result.err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		_ = result.port.Close()
+		return errors.New("SLCAN channel is closed")
+	}
+	c.port = result.port
+	c.mu.Unlock()
+
+	c.log.WithField("portName", c.options.SerialPortName).
+		Info("Opened SLCAN")
+
+	return nil
+}
+
+// configure is a helper to run the startup command sequence, reading responses directly off the port since Run
+// isn't consuming it yet.
+func (c *SLCANChannel) configure(port serial.Port, bitRateCmd string) error {
+	// Close the channel in case a previous session left it open; the adapter refuses S while open, and will
+	// answer this with an error if it was already closed, which we don't care about.
+	_, _ = c.commandDirect(port, "C")
+
+	timestampsCmd := "Z0"
+	if c.options.Timestamps {
+		timestampsCmd = "Z1"
+	}
+	for _, cmd := range []string{bitRateCmd, timestampsCmd, "O"} {
+		if _, err := c.commandDirect(port, cmd); err != nil {
+			return fmt.Errorf("SLCAN command %q: %w", cmd, err)
+		}
+	}
+
+	return nil
+}
+
+// commandDirect is a helper to send a command and synchronously pump the port until it is answered
+func (c *SLCANChannel) commandDirect(port serial.Port, cmd string) (string, error) {
+	respCh, err := c.send(port, cmd, true)
+	if err != nil {
+		return "", err
+	}
+
+	if err := port.SetReadTimeout(10 * time.Millisecond); err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = port.SetReadTimeout(serial.NoTimeout)
+	}()
+
+	deadline := time.Now().Add(c.options.CommandTimeout)
+	working := make([]byte, 64)
+	for {
+		select {
+		case resp := <-respCh:
+			return resp.line, resp.err
+		default:
+		}
+
+		if !time.Now().Before(deadline) {
+			c.dropResponse(respCh)
+			return "", fmt.Errorf("no response within %s", c.options.CommandTimeout)
+		}
+
+		readBytes, err := port.Read(working)
+		if err != nil {
+			return "", err
+		}
+		c.pending = append(c.pending, working[0:readBytes]...)
+		c.parseLines(&c.pending)
+	}
+}
+
+// Command sends a raw SLCAN command (without the trailing carriage return) and returns the adapter's response
+// line.  Run must be active to receive the response.
+func (c *SLCANChannel) Command(ctx context.Context, cmd string) (string, error) {
+	port, err := c.openedPort()
+	if err != nil {
+		return "", err
+	}
+
+	respCh, err := c.send(port, cmd, true)
+	if err != nil {
+		return "", err
+	}
+
+	timer := time.NewTimer(c.options.CommandTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-respCh:
+		return resp.line, resp.err
+	case <-timer.C:
+		c.dropResponse(respCh)
+		return "", fmt.Errorf("SLCAN command %q: no response within %s", cmd, c.options.CommandTimeout)
+	case <-ctx.Done():
+		c.dropResponse(respCh)
+		return "", ctx.Err()
+	}
+}
+
+// QueryStatusFlags reads the adapter's status flags with the F command.  Run must be active to receive the
+// response.
+func (c *SLCANChannel) QueryStatusFlags(ctx context.Context) (SLCANStatusFlags, error) {
+	line, err := c.Command(ctx, "F")
+	if err != nil {
+		return 0, err
+	}
+	if len(line) != 3 || line[0] != 'F' {
+		return 0, fmt.Errorf("unexpected response to F command: %q", line)
+	}
+	flags, err := strconv.ParseUint(line[1:], 16, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected response to F command: %q", line)
+	}
+
+	return SLCANStatusFlags(flags), nil
+}
+
+// Run starts listening after synchronously opening the serial CAN interface.
+func (c *SLCANChannel) Run(ctx context.Context) error {
+	if err := c.Start(ctx); err != nil {
+		return err
+	}
+
+	port, err := c.openedPort()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	c.log.WithField("portName", c.options.SerialPortName).
+		Info("Listening on SLCAN")
+
+	working := make([]byte, 64)
+	for {
+		readBytes, err := port.Read(working)
+		if err != nil {
+			if c.isClosed() {
+				return nil
+			}
+			return err
+		}
+		pending = append(pending, working[0:readBytes]...)
+		c.parseLines(&pending)
+	}
+}
+
+// parseLines is a helper to handle every complete line waiting in the recv buffer, and update the recv buffer to
+// keep going.  Lines end in a carriage return, except errors which are a lone BEL.
+func (c *SLCANChannel) parseLines(bufAddr *[]byte) {
+	for {
+		buf := *bufAddr
+		idx := slices.IndexFunc(buf, func(b byte) bool { return b == '\r' || b == '\a' })
+		if idx == -1 {
+			return
+		}
+
+		if buf[idx] == '\a' {
+			c.handleResponse(slcanResponse{err: errors.New("SLCAN adapter returned error")})
+		} else {
+			c.handleLine(string(buf[:idx]))
+		}
+		*bufAddr = buf[idx+1:]
+	}
+}
+
+// handleLine is a helper to route a single received line to the frame handler or the oldest waiting command
+func (c *SLCANChannel) handleLine(line string) {
+	if line != "" {
+		switch line[0] {
+		case 't', 'T', 'r', 'R':
+			frame, timestamp, err := parseSLCANFrame(line, c.options.Timestamps)
+			if err != nil {
+				c.log.Debugf("Bad SLCAN frame %q: %v\n", line, err)
+				return
+			}
+			c.tracer.trace(c.log, TraceReceive, frame)
+			if c.options.FrameHandler != nil {
+				c.options.FrameHandler(frame)
+			}
+			if c.options.Timestamps && c.options.TimestampedFrameHandler != nil {
+				c.options.TimestampedFrameHandler(frame, timestamp)
+			}
+			return
+		}
+	}
+
+	// Anything else (an empty line, z/Z transmit acks, F/V/N replies) answers the oldest outstanding command.
+	c.handleResponse(slcanResponse{line: line})
+}
+
+func (c *SLCANChannel) handleResponse(resp slcanResponse) {
+	c.queueMu.Lock()
+	if len(c.responseQueue) == 0 {
+		c.queueMu.Unlock()
+		c.log.Debugf("Unsolicited SLCAN response: %q %v\n", resp.line, resp.err)
+		return
+	}
+	respCh := c.responseQueue[0]
+	c.responseQueue = c.responseQueue[1:]
+	c.queueMu.Unlock()
+
+	if respCh == nil {
+		// Fire-and-forget frame transmission.
+		if resp.err != nil {
+			c.log.WithError(resp.err).Warn("SLCAN adapter rejected frame")
+		}
+		return
+	}
+	respCh <- resp
+}
+
+// send is a helper to queue a response slot and write a command line to the port, keeping both in order
+func (c *SLCANChannel) send(port serial.Port, cmd string, wantResponse bool) (chan slcanResponse, error) {
+	var respCh chan slcanResponse
+	if wantResponse {
+		respCh = make(chan slcanResponse, 1)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.queueMu.Lock()
+	c.responseQueue = append(c.responseQueue, respCh)
+	c.queueMu.Unlock()
+
+	if err := writeSLCANLine(port, cmd); err != nil {
+		c.dropResponse(respCh)
+		return nil, err
+	}
+
+	return respCh, nil
+}
+
+// writeSLCANLine is a helper to write a command line to the port, treating a short write as an error
+func writeSLCANLine(port serial.Port, cmd string) error {
+	buf := []byte(cmd + "\r")
+	o, err := port.Write(buf)
+	if err == nil && o != len(buf) {
+		err = fmt.Errorf("SLCAN sent %d of %d bytes", o, len(buf))
+	}
+	return err
+}
+
+// dropResponse is a helper to stop waiting for a response.  The slot stays in the queue so later responses still
+// line up with their commands; it just discards whatever arrives.
+func (c *SLCANChannel) dropResponse(respCh chan slcanResponse) {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if i := slices.Index(c.responseQueue, respCh); i != -1 {
+		c.responseQueue[i] = nil
+	}
+}
+
+// Close closes the CAN channel on the adapter and shuts down the serial port
+func (c *SLCANChannel) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	port := c.port
+	c.port = nil
+	c.mu.Unlock()
+	if port == nil {
+		return nil
+	}
+
+	// Best effort: leave the adapter closed so the next session can set the bitrate.
+	_, _ = c.send(port, "C", false)
+
+	return port.Close()
+}
+
+// WriteFrame will send a CAN frame to the channel.  The adapter's acknowledgement is consumed by Run; a rejected
+// frame is logged.  With NoTransmitAcks there's no acknowledgement to wait for, so nothing is queued for one.
+func (c *SLCANChannel) WriteFrame(frame can.Frame) error {
+	port, err := c.openedPort()
+	if err != nil {
+		return err
+	}
+
+	line, err := formatSLCANFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	if c.options.NoTransmitAcks {
+		c.writeMu.Lock()
+		err = writeSLCANLine(port, line)
+		c.writeMu.Unlock()
+	} else {
+		_, err = c.send(port, line, false)
+	}
+	if err != nil {
+		return err
+	}
+	c.tracer.trace(c.log, TraceTransmit, frame)
+	return nil
+}
+
+// SetTrace starts logging each frame received and transmitted, or stops if options is nil.
+func (c *SLCANChannel) SetTrace(options *TraceOptions) {
+	c.tracer.set(options)
+}
+
+func (c *SLCANChannel) openedPort() (serial.Port, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.port == nil {
+		return nil, errors.New("SLCAN channel is not open")
+	}
+	return c.port, nil
+}
+
+func (c *SLCANChannel) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+var (
+	_ Interface = (*SLCANChannel)(nil)
+	_ Tracer    = (*SLCANChannel)(nil)
+)
+
+// formatSLCANFrame is a helper to encode a frame as a t/T/r/R command line (without the carriage return)
+func formatSLCANFrame(frame can.Frame) (string, error) {
+	if frame.Length > can.MaxFrameDataLength {
+		return "", fmt.Errorf("invalid frame length %d", frame.Length)
+	}
+
+	remote := frame.ID&can.MaskRtr != 0
+	var line string
+	if IsExtendedID(frame.ID) {
+		cmd := "T"
+		if remote {
+			cmd = "R"
+		}
+		line = fmt.Sprintf("%s%08X%d", cmd, frame.ID&can.MaskIDEff, frame.Length)
+	} else {
+		cmd := "t"
+		if remote {
+			cmd = "r"
+		}
+		line = fmt.Sprintf("%s%03X%d", cmd, frame.ID&can.MaskIDSff, frame.Length)
+	}
+	if !remote {
+		line += strings.ToUpper(hex.EncodeToString(frame.Data[:frame.Length]))
+	}
+
+	return line, nil
+}
+
+// parseSLCANFrame is a helper to decode a t/T/r/R line, with an optional trailing 4-digit millisecond timestamp
+func parseSLCANFrame(line string, timestamps bool) (can.Frame, time.Duration, error) {
+	idLen := 3
+	if line[0] == 'T' || line[0] == 'R' {
+		idLen = 8
+	}
+	remote := line[0] == 'r' || line[0] == 'R'
+
+	if len(line) < 1+idLen+1 {
+		return can.Frame{}, 0, errors.New("line too short")
+	}
+	id, err := strconv.ParseUint(line[1:1+idLen], 16, 32)
+	if err != nil {
+		return can.Frame{}, 0, err
+	}
+	length := line[1+idLen] - '0'
+	if length > can.MaxFrameDataLength {
+		return can.Frame{}, 0, fmt.Errorf("invalid length %q", line[1+idLen])
+	}
+
+	frame := can.Frame{
+		ID:     uint32(id),
+		Length: length,
+	}
+	rest := line[1+idLen+1:]
+	if remote {
+		frame.ID |= can.MaskRtr
+	} else {
+		dataHexLen := int(length) * 2
+		if len(rest) < dataHexLen {
+			return can.Frame{}, 0, errors.New("line too short for data")
+		}
+		if _, err := hex.Decode(frame.Data[:], []byte(rest[:dataHexLen])); err != nil {
+			return can.Frame{}, 0, err
+		}
+		rest = rest[dataHexLen:]
+	}
+
+	var timestamp time.Duration
+	if timestamps && len(rest) == 4 {
+		ms, err := strconv.ParseUint(rest, 16, 16)
+		if err != nil {
+			return can.Frame{}, 0, err
+		}
+		timestamp = time.Duration(ms) * time.Millisecond
+	} else if rest != "" {
+		return can.Frame{}, 0, fmt.Errorf("unexpected trailing data %q", rest)
+	}
+
+	return frame, timestamp, nil
+}
+
+// mapSLCANBitRate is a helper to map numeric bitrates to their S command
+func mapSLCANBitRate(bitRate int) (string, error) {
+	switch bitRate {
+	case 10000:
+		return "S0", nil
+	case 20000:
+		return "S1", nil
+	case 50000:
+		return "S2", nil
+	case 100000:
+		return "S3", nil
+	case 125000:
+		return "S4", nil
+	case 250000:
+		return "S5", nil
+	case 500000:
+		return "S6", nil
+	case 800000:
+		return "S7", nil
+	case 1000000:
+		return "S8", nil
+	default:
+		return "", fmt.Errorf("no matching SLCAN bitrate setting for %d", bitRate)
+	}
+}
@@ -0,0 +1,200 @@
+package canbus
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSLCANDevice answers SLCAN commands on the controlling side of a pty.
+type fakeSLCANDevice struct {
+	master *os.File
+
+	mu       sync.Mutex
+	commands []string
+	fakeSLCANBehavior
+}
+
+// fakeSLCANBehavior is how a fakeSLCANDevice deviates from a well-behaved adapter
+type fakeSLCANBehavior struct {
+	// rejectS answers bitrate commands with an error.
+	rejectS bool
+	// noAcks leaves transmitted frames unanswered, like an adapter with auto-acknowledge turned off.
+	noAcks bool
+}
+
+func runFakeSLCANDevice(t *testing.T, master *os.File, behavior fakeSLCANBehavior) *fakeSLCANDevice {
+	t.Helper()
+
+	d := &fakeSLCANDevice{master: master, fakeSLCANBehavior: behavior}
+	go func() {
+		r := bufio.NewReader(master)
+		for {
+			line, err := r.ReadString('\r')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\r")
+
+			d.mu.Lock()
+			d.commands = append(d.commands, line)
+			d.mu.Unlock()
+
+			var resp string
+			switch {
+			case line == "":
+				continue
+			case line[0] == 'S' && d.rejectS:
+				resp = "\a"
+			case strings.ContainsRune("tTrR", rune(line[0])) && d.noAcks:
+				continue
+			case line[0] == 't' || line[0] == 'r':
+				resp = "z\r"
+			case line[0] == 'T' || line[0] == 'R':
+				resp = "Z\r"
+			case line == "F":
+				resp = "F24\r"
+			default:
+				resp = "\r"
+			}
+			if _, err := master.WriteString(resp); err != nil {
+				return
+			}
+		}
+	}()
+
+	return d
+}
+
+func (d *fakeSLCANDevice) Commands() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string{}, d.commands...)
+}
+
+func TestSLCANChannelLifecycle(t *testing.T) {
+	master, portName := openTestPTY(t)
+	device := runFakeSLCANDevice(t, master, fakeSLCANBehavior{})
+
+	type stampedFrame struct {
+		frame     can.Frame
+		timestamp time.Duration
+	}
+	frames := make(chan stampedFrame, 4)
+	channel := NewSLCANChannel(logrus.New(), SLCANChannelOptions{
+		SerialPortName: portName,
+		SerialBaudRate: 115200,
+		BitRate:        250000,
+		Timestamps:     true,
+		TimestampedFrameHandler: func(frame can.Frame, timestamp time.Duration) {
+			frames <- stampedFrame{frame: frame, timestamp: timestamp}
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, channel.Start(ctx))
+	require.Equal(t, []string{"C", "S5", "Z1", "O"}, device.Commands())
+
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(ctx) }()
+
+	require.NoError(t, channel.WriteFrame(can.Frame{ID: 0x123, Length: 2, Data: [8]byte{0xde, 0xad}}))
+	require.NoError(t, channel.WriteFrame(can.Frame{ID: 0x09F80101 | can.MaskEff, Length: 1, Data: [8]byte{0x01}}))
+	require.NoError(t, channel.WriteFrame(can.Frame{ID: 0x7ff | can.MaskRtr, Length: 8}))
+
+	flags, err := channel.QueryStatusFlags(ctx)
+	require.NoError(t, err)
+	require.Equal(t, SLCANStatusErrorWarning|SLCANStatusErrorPassive, flags)
+	require.Equal(t, []string{"C", "S5", "Z1", "O", "t1232DEAD", "T09F80101101", "r7FF8", "F"}, device.Commands())
+
+	_, err = master.WriteString("T09F8020280102030405060708EA5F\r")
+	require.NoError(t, err)
+	select {
+	case got := <-frames:
+		require.Equal(t, uint32(0x09F80202), got.frame.ID)
+		require.Equal(t, uint8(8), got.frame.Length)
+		require.Equal(t, [8]byte{1, 2, 3, 4, 5, 6, 7, 8}, got.frame.Data)
+		require.Equal(t, 0xea5f*time.Millisecond, got.timestamp)
+	case <-time.After(time.Second):
+		t.Fatal("frame was not delivered")
+	}
+
+	require.NoError(t, channel.Close())
+	require.NoError(t, <-runDone)
+}
+
+func TestSLCANChannelWithoutTransmitAcks(t *testing.T) {
+	master, portName := openTestPTY(t)
+	device := runFakeSLCANDevice(t, master, fakeSLCANBehavior{noAcks: true})
+
+	channel := NewSLCANChannel(logrus.New(), SLCANChannelOptions{
+		SerialPortName: portName,
+		SerialBaudRate: 115200,
+		BitRate:        250000,
+		NoTransmitAcks: true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, channel.Start(ctx))
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(ctx) }()
+
+	// Without acks queued for the frames, the F reply still reaches the F command
+	require.NoError(t, channel.WriteFrame(can.Frame{ID: 0x123, Length: 2, Data: [8]byte{0xde, 0xad}}))
+	require.NoError(t, channel.WriteFrame(can.Frame{ID: 0x124, Length: 1, Data: [8]byte{0x01}}))
+	flags, err := channel.QueryStatusFlags(ctx)
+	require.NoError(t, err)
+	require.Equal(t, SLCANStatusErrorWarning|SLCANStatusErrorPassive, flags)
+	require.Equal(t, []string{"C", "S5", "Z0", "O", "t1232DEAD", "t124101", "F"}, device.Commands())
+
+	require.NoError(t, channel.Close())
+	require.NoError(t, <-runDone)
+}
+
+func TestSLCANStartFailsWhenBitRateRejected(t *testing.T) {
+	master, portName := openTestPTY(t)
+	runFakeSLCANDevice(t, master, fakeSLCANBehavior{rejectS: true})
+
+	channel := NewSLCANChannel(logrus.New(), SLCANChannelOptions{
+		SerialPortName: portName,
+		SerialBaudRate: 115200,
+		BitRate:        500000,
+	})
+
+	require.ErrorContains(t, channel.Start(context.Background()), `SLCAN command "S6"`)
+}
+
+func TestSLCANStartRejectsUnknownBitRate(t *testing.T) {
+	channel := NewSLCANChannel(logrus.New(), SLCANChannelOptions{
+		SerialPortName: "test-port",
+		BitRate:        333333,
+	})
+
+	require.ErrorContains(t, channel.Start(context.Background()), "no matching SLCAN bitrate")
+}
+
+func TestParseSLCANFrame(t *testing.T) {
+	frame, _, err := parseSLCANFrame("t1FF3AABBCC", false)
+	require.NoError(t, err)
+	require.Equal(t, can.Frame{ID: 0x1ff, Length: 3, Data: [8]byte{0xaa, 0xbb, 0xcc}}, frame)
+
+	frame, _, err = parseSLCANFrame("R1234ABCD0", false)
+	require.NoError(t, err)
+	require.Equal(t, can.Frame{ID: 0x1234abcd | can.MaskRtr}, frame)
+
+	_, _, err = parseSLCANFrame("t1FF3AA", false)
+	require.Error(t, err)
+	_, _, err = parseSLCANFrame("t1FF9", false)
+	require.Error(t, err)
+}
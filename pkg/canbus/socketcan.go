@@ -1,6 +1,7 @@
 package canbus
 
 import (
+	"bytes"
 	"context"
 	stderrors "errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/brutella/can"
 	pkgerrors "github.com/pkg/errors"
@@ -21,14 +23,28 @@ type SocketCANChannelOptions struct {
 	BitRate              int
 	ForceBounceInterface bool
 	MessageHandler       can.HandlerFunc
+	// ConfirmTransmit has the kernel echo our own frames back (CAN_RAW_RECV_OWN_MSGS), which WriteFrameConfirmed
+	// needs to tell when they've gone out.  It's off by default, since it costs a read for every frame sent.
+	ConfirmTransmit bool
+	// TransmitConfirmTimeout bounds how long WriteFrameConfirmed waits for a frame to go out.  Defaults to 1s.
+	TransmitConfirmTimeout time.Duration
 }
 
+// defaultTransmitConfirmTimeout is how long WriteFrameConfirmed waits by default.  A frame nobody acks is
+// retransmitted by the controller indefinitely, so this is mostly hit on an empty or disconnected bus.
+const defaultTransmitConfirmTimeout = time.Second
+
+// ErrTransmitTimeout is returned by WriteFrameConfirmed when the frame wasn't confirmed in time.
+var ErrTransmitTimeout = stderrors.New("timed out waiting for transmit confirmation")
+
 // SocketCANChannel represents a single canbus channel for sending/receiving CAN frames
 type SocketCANChannel struct {
 	options SocketCANChannelOptions
 
 	bus        *can.Bus
 	busHandler can.Handler
+	echoes     *txEchoes
+	tracer     frameTracer
 
 	log *logrus.Logger
 
@@ -151,29 +167,32 @@ linkReady:
 		return stderrors.New("SocketCAN channel is closed")
 	}
 
-	// Open the brutella can bus
-	bus, err := can.NewBusForInterfaceWithName(c.options.InterfaceName)
+	// Open the socket, with own-message echoes on if transmits are to be confirmed, and hand it to a brutella can bus
+	echoes := newTxEchoes()
+	var onEcho func(frame can.Frame, ts time.Time)
+	if c.options.ConfirmTransmit {
+		onEcho = echoes.echo
+	}
+	conn, err := openSocketCAN(c.options.InterfaceName, onEcho)
 	if err != nil {
 		return err
 	}
+	bus := can.NewBus(conn)
 
-	var busHandler can.Handler
-	if c.options.MessageHandler != nil {
-		busHandler = can.NewHandler(c.options.MessageHandler)
-		bus.Subscribe(busHandler)
-	}
+	// Always subscribe, so frames can be traced even without a MessageHandler
+	busHandler := can.NewHandler(c.handleFrame)
+	bus.Subscribe(busHandler)
 
 	c.mu.Lock()
 	closed := c.closed
 	if !closed {
 		c.bus = bus
 		c.busHandler = busHandler
+		c.echoes = echoes
 	}
 	c.mu.Unlock()
 	if closed {
-		if busHandler != nil {
-			bus.Unsubscribe(busHandler)
-		}
+		bus.Unsubscribe(busHandler)
 		if err := bus.Disconnect(); err != nil && !isClosedCANBusError(err) {
 			return pkgerrors.Wrap(err, "close underlying bus connection")
 		}
@@ -214,7 +233,10 @@ func (c *SocketCANChannel) Run(ctx context.Context) error {
 	return nil
 }
 
-var _ Interface = (*SocketCANChannel)(nil)
+var (
+	_ Interface = (*SocketCANChannel)(nil)
+	_ Tracer    = (*SocketCANChannel)(nil)
+)
 
 // Close shuts down the channel
 func (c *SocketCANChannel) Close() error {
@@ -229,10 +251,16 @@ func (c *SocketCANChannel) Close() error {
 	c.closed = true
 	bus := c.bus
 	busHandler := c.busHandler
+	echoes := c.echoes
 	c.bus = nil
 	c.busHandler = nil
+	c.echoes = nil
 	c.mu.Unlock()
 
+	if echoes != nil {
+		echoes.close()
+	}
+
 	if bus == nil {
 		return nil
 	}
@@ -258,7 +286,72 @@ func (c *SocketCANChannel) WriteFrame(frame can.Frame) error {
 		return stderrors.New("canbus channel is closed")
 	}
 
-	return bus.Publish(frame)
+	if err := bus.Publish(frame); err != nil {
+		return err
+	}
+	c.tracer.trace(c.log, TraceTransmit, frame)
+	return nil
+}
+
+// WriteFrameConfirmed sends a CAN frame and waits until the kernel echoes it back, which for drivers that echo on
+// TX-complete (most real controllers; vcan echoes straight away) means the frame has been acked on the bus.  It
+// returns the time of the echo.  Confirmations are read by Run, so the channel must be running, and opened with
+// ConfirmTransmit set.
+func (c *SocketCANChannel) WriteFrameConfirmed(ctx context.Context, frame can.Frame) (time.Time, error) {
+	if !socketCANConfirmsTransmit {
+		return time.Time{}, stderrors.New("transmit confirmation is not supported on this platform")
+	}
+	if !c.options.ConfirmTransmit {
+		return time.Time{}, stderrors.New("transmit confirmation is off; set ConfirmTransmit")
+	}
+
+	c.mu.Lock()
+	bus := c.bus
+	echoes := c.echoes
+	closed := c.closed
+	c.mu.Unlock()
+
+	if closed || bus == nil {
+		return time.Time{}, stderrors.New("canbus channel is closed")
+	}
+
+	timeout := c.options.TransmitConfirmTimeout
+	if timeout <= 0 {
+		timeout = defaultTransmitConfirmTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Expect the echo before writing, since it can come back before Publish returns
+	wait := echoes.expect(frame)
+	defer echoes.cancel(wait)
+
+	if err := bus.Publish(frame); err != nil {
+		return time.Time{}, err
+	}
+	c.tracer.trace(c.log, TraceTransmit, frame)
+
+	select {
+	case ts := <-wait.done:
+		return ts, nil
+	case <-echoes.closed:
+		return time.Time{}, stderrors.New("canbus channel is closed")
+	case <-ctx.Done():
+		return time.Time{}, fmt.Errorf("%w: %w", ErrTransmitTimeout, ctx.Err())
+	}
+}
+
+// SetTrace starts logging each frame received and transmitted, or stops if options is nil.
+func (c *SocketCANChannel) SetTrace(options *TraceOptions) {
+	c.tracer.set(options)
+}
+
+// handleFrame is a helper to trace a received frame and hand it to the message handler
+func (c *SocketCANChannel) handleFrame(frame can.Frame) {
+	c.tracer.trace(c.log, TraceReceive, frame)
+	if c.options.MessageHandler != nil {
+		c.options.MessageHandler(frame)
+	}
 }
 
 func (c *SocketCANChannel) isClosed() bool {
@@ -268,6 +361,79 @@ func (c *SocketCANChannel) isClosed() bool {
 	return c.closed
 }
 
+// txEcho is a write waiting for its echo
+type txEcho struct {
+	frame can.Frame
+	done  chan time.Time
+}
+
+// txEchoes matches the frames a socket gets echoed back against the writes waiting on them.  The kernel echoes in
+// send order, so the oldest waiter for a matching frame gets each echo.
+type txEchoes struct {
+	mu      sync.Mutex
+	pending []*txEcho
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newTxEchoes() *txEchoes {
+	return &txEchoes{closed: make(chan struct{})}
+}
+
+// expect registers a waiter for the echo of frame
+func (e *txEchoes) expect(frame can.Frame) *txEcho {
+	w := &txEcho{frame: frame, done: make(chan time.Time, 1)}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending = append(e.pending, w)
+	return w
+}
+
+// cancel drops a waiter, if it's still pending
+func (e *txEchoes) cancel(w *txEcho) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, p := range e.pending {
+		if p == w {
+			e.pending = append(e.pending[:i], e.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// echo completes the oldest waiter for frame.  Echoes nobody is waiting for, i.e. of plain WriteFrame calls, are
+// dropped.
+func (e *txEchoes) echo(frame can.Frame, ts time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, p := range e.pending {
+		if sameFrame(p.frame, frame) {
+			e.pending = append(e.pending[:i], e.pending[i+1:]...)
+			p.done <- ts
+			return
+		}
+	}
+}
+
+// close wakes up every waiter
+func (e *txEchoes) close() {
+	e.once.Do(func() {
+		close(e.closed)
+	})
+}
+
+// sameFrame reports whether two frames have the same ID and payload
+func sameFrame(a, b can.Frame) bool {
+	if a.ID != b.ID || a.Length != b.Length {
+		return false
+	}
+	n := min(int(a.Length), can.MaxFrameDataLength)
+	return bytes.Equal(a.Data[:n], b.Data[:n])
+}
+
 func isClosedCANBusError(err error) bool {
 	if err == nil {
 		return false
@@ -0,0 +1,201 @@
+package canbus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/brutella/can"
+	"golang.org/x/sys/unix"
+)
+
+// socketCANConfirmsTransmit is whether WriteFrameConfirmed works on this platform.
+const socketCANConfirmsTransmit = true
+
+// canFrameLen is the size of a classic struct can_frame
+const canFrameLen = 16
+
+// timestampOOBLen is the room needed for the SCM_TIMESTAMPNS control message on each read
+var timestampOOBLen = unix.CmsgSpace(int(unsafe.Sizeof(unix.Timespec{})))
+
+// socketCANConn is a CAN_RAW socket that can get its own frames echoed back once they've gone out on the bus.  Reads
+// hand echoes to onEcho along with the time the kernel saw them, and return everything else as usual.
+type socketCANConn struct {
+	file   *os.File
+	raw    syscall.RawConn
+	onEcho func(frame can.Frame, ts time.Time)
+	closed atomic.Bool
+
+	buf [canFrameLen]byte
+	oob []byte
+}
+
+// openSocketCAN opens a CAN_RAW socket on the named interface, with CAN_RAW_RECV_OWN_MSGS set if onEcho isn't nil.
+func openSocketCAN(ifaceName string, onEcho func(frame can.Frame, ts time.Time)) (can.ReadWriteCloser, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.CAN_RAW)
+	if err != nil {
+		return nil, fmt.Errorf("open CAN_RAW socket: %w", err)
+	}
+
+	if err := setupSocketCAN(fd, iface.Index, onEcho != nil); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	return newSocketCANConn(fd, ifaceName, onEcho)
+}
+
+// socketOption is a single integer socket option
+type socketOption struct {
+	level, opt int
+	name       string
+	value      int
+}
+
+// socketCANOptions is a helper to list the options a CAN_RAW socket is set up with.  Own-message echoes are only
+// turned on when asked for, since they double the reads for everything we send.
+func socketCANOptions(recvOwnMsgs bool) []socketOption {
+	recvOwn := 0
+	if recvOwnMsgs {
+		recvOwn = 1
+	}
+	return []socketOption{
+		{unix.SOL_CAN_RAW, unix.CAN_RAW_LOOPBACK, "CAN_RAW_LOOPBACK", 1},
+		{unix.SOL_CAN_RAW, unix.CAN_RAW_RECV_OWN_MSGS, "CAN_RAW_RECV_OWN_MSGS", recvOwn},
+		{unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, "SO_TIMESTAMPNS", 1},
+	}
+}
+
+// setupSocketCAN sets the socket's options, then binds it to the interface
+func setupSocketCAN(fd int, ifindex int, recvOwnMsgs bool) error {
+	for _, o := range socketCANOptions(recvOwnMsgs) {
+		if err := unix.SetsockoptInt(fd, o.level, o.opt, o.value); err != nil {
+			return fmt.Errorf("set %s: %w", o.name, err)
+		}
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrCAN{Ifindex: ifindex}); err != nil {
+		return fmt.Errorf("bind CAN_RAW socket: %w", err)
+	}
+	return nil
+}
+
+// newSocketCANConn wraps a non-blocking socket, taking ownership of fd
+func newSocketCANConn(fd int, name string, onEcho func(frame can.Frame, ts time.Time)) (*socketCANConn, error) {
+	file := os.NewFile(uintptr(fd), "socketcan "+name)
+	raw, err := file.SyscallConn()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &socketCANConn{
+		file:   file,
+		raw:    raw,
+		onEcho: onEcho,
+		oob:    make([]byte, timestampOOBLen),
+	}, nil
+}
+
+// ReadFrame reads the next frame sent by someone else, passing echoes of our own frames to onEcho on the way.
+func (c *socketCANConn) ReadFrame(frame *can.Frame) error {
+	for {
+		n, oobn, flags, err := c.recvmsg()
+		if err != nil {
+			if c.closed.Load() {
+				return os.ErrClosed
+			}
+			return err
+		}
+		if n < canFrameLen {
+			return fmt.Errorf("short CAN frame of %d bytes", n)
+		}
+
+		var f can.Frame
+		if err := can.Unmarshal(c.buf[:n], &f); err != nil {
+			return err
+		}
+
+		if flags&unix.MSG_CONFIRM != 0 {
+			if c.onEcho != nil {
+				c.onEcho(f, rxTimestamp(c.oob[:oobn]))
+			}
+			continue
+		}
+
+		*frame = f
+		return nil
+	}
+}
+
+// recvmsg reads one datagram, waiting on the runtime poller rather than blocking a thread
+func (c *socketCANConn) recvmsg() (n, oobn, flags int, err error) {
+	var rerr error
+	err = c.raw.Read(func(fd uintptr) bool {
+		n, oobn, flags, _, rerr = unix.Recvmsg(int(fd), c.buf[:], c.oob, 0)
+		return !errors.Is(rerr, unix.EAGAIN)
+	})
+	if err == nil {
+		err = rerr
+	}
+	return n, oobn, flags, err
+}
+
+// WriteFrame sends a frame.
+func (c *socketCANConn) WriteFrame(frame can.Frame) error {
+	b, err := can.Marshal(frame)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Write(b)
+	return err
+}
+
+// Read reads a raw struct can_frame, echoes included.
+func (c *socketCANConn) Read(b []byte) (int, error) {
+	return c.file.Read(b)
+}
+
+// Write writes a raw struct can_frame.
+func (c *socketCANConn) Write(b []byte) (int, error) {
+	return c.file.Write(b)
+}
+
+// Close closes the socket, waking up any read in progress.
+func (c *socketCANConn) Close() error {
+	c.closed.Store(true)
+	return c.file.Close()
+}
+
+// rxTimestamp pulls the SCM_TIMESTAMPNS receive time out of a message's control data, falling back to now
+func rxTimestamp(oob []byte) time.Time {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Now()
+	}
+
+	for _, m := range msgs {
+		if m.Header.Level != unix.SOL_SOCKET || m.Header.Type != unix.SCM_TIMESTAMPNS {
+			continue
+		}
+		if len(m.Data) < int(unsafe.Sizeof(unix.Timespec{})) {
+			continue
+		}
+		ts := *(*unix.Timespec)(unsafe.Pointer(&m.Data[0])) // #nosec G103 -- the kernel hands back a struct timespec.
+		return time.Unix(ts.Unix())
+	}
+	return time.Now()
+}
+
+var _ can.ReadWriteCloser = (*socketCANConn)(nil)
@@ -0,0 +1,106 @@
+package canbus
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// newTestSocketCANConn returns a socketCANConn over one end of a datagram socket pair, and the other end to write
+// frames into it.  Echoes can't be faked this way, but reads, timestamps and closing can.
+func newTestSocketCANConn(t *testing.T) (*socketCANConn, int) {
+	t.Helper()
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = unix.Close(fds[1]) })
+	require.NoError(t, unix.SetsockoptInt(fds[0], unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1))
+
+	conn, err := newSocketCANConn(fds[0], "test", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, fds[1]
+}
+
+func TestSocketCANConnReadsFrames(t *testing.T) {
+	conn, peer := newTestSocketCANConn(t)
+
+	frame := can.Frame{ID: 0x18eeff00 | can.MaskEff, Length: 3, Data: [8]byte{0x01, 0x02, 0x03}}
+	b, err := can.Marshal(frame)
+	require.NoError(t, err)
+	require.Len(t, b, canFrameLen)
+	_, err = unix.Write(peer, b)
+	require.NoError(t, err)
+
+	var got can.Frame
+	require.NoError(t, conn.ReadFrame(&got))
+	require.Equal(t, frame, got)
+
+	// Short datagrams aren't frames
+	_, err = unix.Write(peer, b[:4])
+	require.NoError(t, err)
+	require.ErrorContains(t, conn.ReadFrame(&got), "short CAN frame")
+}
+
+func TestSocketCANConnReadUnblocksOnClose(t *testing.T) {
+	conn, _ := newTestSocketCANConn(t)
+
+	done := make(chan error, 1)
+	go func() {
+		var frame can.Frame
+		done <- conn.ReadFrame(&frame)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("read returned early: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, os.ErrClosed)
+		require.True(t, isClosedCANBusError(err))
+	case <-time.After(time.Second):
+		t.Fatal("read didn't return after close")
+	}
+}
+
+func TestSocketCANOptions(t *testing.T) {
+	recvOwn := func(recvOwnMsgs bool) int {
+		for _, o := range socketCANOptions(recvOwnMsgs) {
+			if o.level == unix.SOL_CAN_RAW && o.opt == unix.CAN_RAW_RECV_OWN_MSGS {
+				return o.value
+			}
+		}
+		t.Fatal("CAN_RAW_RECV_OWN_MSGS isn't set either way")
+		return -1
+	}
+
+	require.Equal(t, 0, recvOwn(false))
+	require.Equal(t, 1, recvOwn(true))
+}
+
+func TestRxTimestamp(t *testing.T) {
+	conn, peer := newTestSocketCANConn(t)
+
+	before := time.Now()
+	_, err := unix.Write(peer, make([]byte, canFrameLen))
+	require.NoError(t, err)
+
+	n, oobn, _, err := conn.recvmsg()
+	require.NoError(t, err)
+	require.Equal(t, canFrameLen, n)
+	require.NotZero(t, oobn)
+
+	ts := rxTimestamp(conn.oob[:oobn])
+	require.WithinDuration(t, before, ts, time.Second)
+
+	// Without control data it's now
+	require.WithinDuration(t, time.Now(), rxTimestamp(nil), time.Second)
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package canbus
+
+import (
+	"net"
+	"time"
+
+	"github.com/brutella/can"
+)
+
+// socketCANConfirmsTransmit is whether WriteFrameConfirmed works on this platform.
+const socketCANConfirmsTransmit = false
+
+// openSocketCAN opens a plain brutella socket, since own-message echoes are Linux only.
+func openSocketCAN(ifaceName string, _ func(frame can.Frame, ts time.Time)) (can.ReadWriteCloser, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return can.NewReadWriteCloserForInterface(iface)
+}
@@ -156,6 +156,120 @@ func TestSocketCANChannelVCan0AllowsNilMessageHandler(t *testing.T) {
 	assert.NoError(t, channel.WriteFrame(testFrame))
 }
 
+func TestSocketCANChannelVCan0WriteFrameConfirmed(t *testing.T) {
+	requireVCan0(t)
+
+	received := make(chan can.Frame, 1)
+	channel := NewSocketCANChannel(logrus.New(), SocketCANChannelOptions{
+		InterfaceName: "vcan0",
+		BitRate:       250000,
+		MessageHandler: func(frame can.Frame) {
+			received <- frame
+		},
+		ConfirmTransmit: true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, channel.Start(ctx))
+	go func() { _ = channel.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, channel.Close())
+	})
+
+	before := time.Now()
+	ts, err := channel.WriteFrameConfirmed(ctx, can.Frame{ID: 0x125, Length: 2, Data: [8]byte{0x01, 0x02}})
+	require.NoError(t, err)
+	require.False(t, ts.Before(before.Add(-time.Second)))
+
+	// Our own echo isn't delivered as a received frame
+	select {
+	case frame := <-received:
+		t.Fatalf("echo was delivered to the message handler: %+v", frame)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSocketCANWriteFrameConfirmedAfterCloseReturnsError(t *testing.T) {
+	c := &SocketCANChannel{}
+
+	require.NoError(t, c.Close())
+	_, err := c.WriteFrameConfirmed(context.Background(), can.Frame{})
+	require.Error(t, err)
+}
+
+func TestSocketCANWriteFrameConfirmedNeedsOption(t *testing.T) {
+	if !socketCANConfirmsTransmit {
+		t.Skip("transmit confirmation is not supported on this platform")
+	}
+
+	c := &SocketCANChannel{bus: can.NewBus(alreadyClosedCANReadWriteCloser{}), echoes: newTxEchoes()}
+	_, err := c.WriteFrameConfirmed(context.Background(), can.Frame{ID: 0x100})
+	require.ErrorContains(t, err, "ConfirmTransmit")
+}
+
+func TestTxEchoesMatchOldestWaiter(t *testing.T) {
+	e := newTxEchoes()
+	frame := can.Frame{ID: 0x100, Length: 2, Data: [8]byte{0x01, 0x02}}
+	other := can.Frame{ID: 0x100, Length: 2, Data: [8]byte{0x01, 0x03}}
+
+	first := e.expect(frame)
+	second := e.expect(frame)
+	unrelated := e.expect(other)
+
+	ts := time.Unix(1700000000, 0)
+	e.echo(frame, ts)
+	require.Equal(t, ts, <-first.done)
+	require.Empty(t, second.done)
+	require.Empty(t, unrelated.done)
+
+	// Unexpected echoes are dropped, and cancelled waiters no longer match
+	e.cancel(second)
+	e.echo(frame, ts)
+	e.echo(can.Frame{ID: 0x200}, ts)
+	require.Empty(t, second.done)
+	require.Len(t, e.pending, 1)
+
+	// Bytes past the length don't count
+	other.Data[7] = 0xff
+	e.echo(other, ts.Add(time.Second))
+	require.Equal(t, ts.Add(time.Second), <-unrelated.done)
+	require.Empty(t, e.pending)
+}
+
+func TestSocketCANWriteFrameConfirmedTimesOut(t *testing.T) {
+	if !socketCANConfirmsTransmit {
+		t.Skip("transmit confirmation is not supported on this platform")
+	}
+
+	// A bus whose writes never come back
+	c := &SocketCANChannel{
+		options: SocketCANChannelOptions{ConfirmTransmit: true, TransmitConfirmTimeout: 20 * time.Millisecond},
+		bus:     can.NewBus(alreadyClosedCANReadWriteCloser{}),
+		echoes:  newTxEchoes(),
+	}
+
+	_, err := c.WriteFrameConfirmed(context.Background(), can.Frame{ID: 0x100})
+	require.ErrorIs(t, err, ErrTransmitTimeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Empty(t, c.echoes.pending)
+
+	// Closing the channel releases waiters
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.WriteFrameConfirmed(context.Background(), can.Frame{ID: 0x100})
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		c.echoes.mu.Lock()
+		defer c.echoes.mu.Unlock()
+		return len(c.echoes.pending) == 1
+	}, time.Second, time.Millisecond)
+	c.echoes.close()
+	require.ErrorContains(t, <-done, "canbus channel is closed")
+}
+
 func requireVCan0(t *testing.T) {
 	t.Helper()
 
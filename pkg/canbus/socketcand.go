@@ -0,0 +1,376 @@
+package canbus
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+// Docs for the socketcand protocol:
+// * https://github.com/linux-can/socketcand/blob/master/doc/protocol.md
+// * https://github.com/hardbyte/python-can/blob/main/can/interfaces/socketcand/socketcand.py
+
+// socketcandHandshakeTimeout bounds each step of the open/rawmode handshake
+const socketcandHandshakeTimeout = 5 * time.Second
+
+// SocketcandChannelOptions is a type that contains required options on a SocketcandChannel.
+type SocketcandChannelOptions struct {
+	// Address is the host:port of the socketcand server.
+	Address string
+	// BusName is the server-side interface to open, i.e. "can0".
+	BusName      string
+	FrameHandler can.HandlerFunc
+}
+
+// SocketcandChannel represents a single canbus channel on a remote socketcand server, in raw mode
+type SocketcandChannel struct {
+	options SocketcandChannelOptions
+
+	startMu sync.Mutex
+	mu      sync.Mutex
+	writeMu sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	closed  bool
+
+	tracer frameTracer
+
+	log *logrus.Logger
+}
+
+// NewSocketcandChannel returns a Channel object for a remote socketcand bus and the given options.  ChannelOptions
+// are required settings.
+func NewSocketcandChannel(log *logrus.Logger, options SocketcandChannelOptions) *SocketcandChannel {
+	c := SocketcandChannel{
+		options: options,
+		log:     log,
+	}
+
+	return &c
+}
+
+// Start synchronously connects to the server, opens the bus and switches to raw mode.
+func (c *SocketcandChannel) Start(ctx context.Context) error {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errors.New("socketcand channel is closed")
+	}
+	if c.conn != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.options.Address)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+
+	handshake := func() error {
+		if err := expectSocketcandMessage(conn, reader, "hi"); err != nil {
+			return err
+		}
+		if err := writeSocketcandMessage(conn, "open", c.options.BusName); err != nil {
+			return err
+		}
+		if err := expectSocketcandMessage(conn, reader, "ok"); err != nil {
+			return fmt.Errorf("open %s: %w", c.options.BusName, err)
+		}
+		if err := writeSocketcandMessage(conn, "rawmode"); err != nil {
+			return err
+		}
+		if err := expectSocketcandMessage(conn, reader, "ok"); err != nil {
+			return fmt.Errorf("rawmode: %w", err)
+		}
+		return nil
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	err = handshake()
+	if !stop() && err != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		_ = conn.Close()
+		return errors.New("socketcand channel is closed")
+	}
+	c.conn = conn
+	c.reader = reader
+	c.mu.Unlock()
+
+	c.log.WithField("address", c.options.Address).
+		WithField("busName", c.options.BusName).
+		Info("Opened socketcand")
+
+	return nil
+}
+
+// Run starts listening after synchronously connecting to the server.
+func (c *SocketcandChannel) Run(ctx context.Context) error {
+	if err := c.Start(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	reader := c.reader
+	c.mu.Unlock()
+	if reader == nil {
+		return nil
+	}
+
+	c.log.WithField("address", c.options.Address).
+		WithField("busName", c.options.BusName).
+		Info("Listening on socketcand")
+
+	for {
+		fields, err := readSocketcandMessage(reader)
+		if err != nil {
+			if c.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		switch fields[0] {
+		case "frame":
+			frame, err := parseSocketcandFrame(fields)
+			if err != nil {
+				c.log.Debugf("Bad socketcand frame %v: %v\n", fields, err)
+				continue
+			}
+			c.tracer.trace(c.log, TraceReceive, frame)
+			if c.options.FrameHandler != nil {
+				c.options.FrameHandler(frame)
+			}
+		case "error":
+			c.log.WithField("message", strings.Join(fields[1:], " ")).Warn("socketcand server error")
+		case "ok", "echo":
+		default:
+			c.log.Debugf("Unhandled socketcand message: %v\n", fields)
+		}
+	}
+}
+
+var (
+	_ Interface = (*SocketcandChannel)(nil)
+	_ Tracer    = (*SocketcandChannel)(nil)
+)
+
+// Close shuts down the channel
+func (c *SocketcandChannel) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// WriteFrame will send a CAN frame to the channel
+func (c *SocketcandChannel) WriteFrame(frame can.Frame) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return errors.New("socketcand channel is not open")
+	}
+	if frame.Length > can.MaxFrameDataLength {
+		return fmt.Errorf("invalid frame length %d", frame.Length)
+	}
+
+	c.writeMu.Lock()
+	err := writeSocketcandMessage(conn, formatSocketcandSend(frame)...)
+	c.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.tracer.trace(c.log, TraceTransmit, frame)
+	return nil
+}
+
+// SetTrace starts logging each frame received and transmitted, or stops if options is nil.
+func (c *SocketcandChannel) SetTrace(options *TraceOptions) {
+	c.tracer.set(options)
+}
+
+func (c *SocketcandChannel) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// readSocketcandMessage is a helper to read the next "< ... >" message and split it into its fields
+func readSocketcandMessage(r *bufio.Reader) ([]string, error) {
+	if _, err := r.ReadString('<'); err != nil {
+		return nil, err
+	}
+	body, err := r.ReadString('>')
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(body, ">"))
+	if len(fields) == 0 {
+		return nil, errors.New("empty socketcand message")
+	}
+	return fields, nil
+}
+
+// expectSocketcandMessage is a helper to read the next message during the handshake and check its command
+func expectSocketcandMessage(conn net.Conn, r *bufio.Reader, command string) error {
+	if err := conn.SetReadDeadline(time.Now().Add(socketcandHandshakeTimeout)); err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+
+	fields, err := readSocketcandMessage(r)
+	if err != nil {
+		return err
+	}
+	if fields[0] != command {
+		return fmt.Errorf("expected < %s >, got < %s >", command, strings.Join(fields, " "))
+	}
+	return nil
+}
+
+// writeSocketcandMessage is a helper to write a single "< ... >" message
+func writeSocketcandMessage(w io.Writer, fields ...string) error {
+	_, err := io.WriteString(w, "< "+strings.Join(fields, " ")+" >")
+	return err
+}
+
+// formatSocketcandID is a helper to format an ID the way socketcand tells standard and extended frames apart: 3
+// hex digits for standard, 8 for extended.
+func formatSocketcandID(id uint32) string {
+	if IsExtendedID(id) {
+		return fmt.Sprintf("%08X", id&can.MaskIDEff)
+	}
+	return fmt.Sprintf("%03X", id&can.MaskIDSff)
+}
+
+// parseSocketcandID is the inverse of formatSocketcandID.  8-digit IDs get the EFF flag, since an extended ID that
+// fits in 11 bits can't be told apart from a standard one otherwise.
+func parseSocketcandID(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	if id > can.MaskIDEff {
+		return 0, fmt.Errorf("invalid CAN ID %q", s)
+	}
+	if len(s) == 8 {
+		id |= can.MaskEff
+	}
+	return uint32(id), nil
+}
+
+// formatSocketcandSend is a helper to build the fields of a "< send id dlc data... >" message
+func formatSocketcandSend(frame can.Frame) []string {
+	fields := []string{"send", formatSocketcandID(frame.ID), strconv.Itoa(int(frame.Length))}
+	for _, b := range frame.Data[:frame.Length] {
+		fields = append(fields, fmt.Sprintf("%02X", b))
+	}
+	return fields
+}
+
+// parseSocketcandSend is a helper to decode a "< send id dlc data... >" message
+func parseSocketcandSend(fields []string) (can.Frame, error) {
+	if len(fields) < 3 {
+		return can.Frame{}, errors.New("send message too short")
+	}
+	id, err := parseSocketcandID(fields[1])
+	if err != nil {
+		return can.Frame{}, err
+	}
+	length, err := strconv.ParseUint(fields[2], 10, 8)
+	if err != nil || length > can.MaxFrameDataLength {
+		return can.Frame{}, fmt.Errorf("invalid length %q", fields[2])
+	}
+	if len(fields) != 3+int(length) {
+		return can.Frame{}, fmt.Errorf("length %d doesn't match %d data bytes", length, len(fields)-3)
+	}
+
+	frame := can.Frame{ID: id, Length: uint8(length)}
+	for i, s := range fields[3:] {
+		b, err := strconv.ParseUint(s, 16, 8)
+		if err != nil {
+			return can.Frame{}, err
+		}
+		frame.Data[i] = byte(b)
+	}
+	return frame, nil
+}
+
+// formatSocketcandFrame is a helper to build the fields of a "< frame id secs.usecs data >" message
+func formatSocketcandFrame(frame can.Frame, ts time.Time) []string {
+	fields := []string{
+		"frame",
+		formatSocketcandID(frame.ID),
+		fmt.Sprintf("%d.%06d", ts.Unix(), ts.Nanosecond()/1000),
+	}
+	if frame.Length > 0 {
+		fields = append(fields, strings.ToUpper(hex.EncodeToString(frame.Data[:frame.Length])))
+	}
+	return fields
+}
+
+// parseSocketcandFrame is a helper to decode a "< frame id secs.usecs data >" message
+func parseSocketcandFrame(fields []string) (can.Frame, error) {
+	if len(fields) < 3 || len(fields) > 4 {
+		return can.Frame{}, errors.New("malformed frame message")
+	}
+	id, err := parseSocketcandID(fields[1])
+	if err != nil {
+		return can.Frame{}, err
+	}
+
+	frame := can.Frame{ID: id}
+	if len(fields) == 4 {
+		data, err := hex.DecodeString(fields[3])
+		if err != nil {
+			return can.Frame{}, err
+		}
+		if len(data) > can.MaxFrameDataLength {
+			return can.Frame{}, fmt.Errorf("too much data: %d bytes", len(data))
+		}
+		frame.Length = uint8(len(data))
+		copy(frame.Data[:], data)
+	}
+	return frame, nil
+}
@@ -0,0 +1,300 @@
+package canbus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+// socketcandClientQueueLen is how many received frames we buffer per client before dropping frames for it
+const socketcandClientQueueLen = 256
+
+// SocketcandServerOptions is a type that contains required options on a SocketcandServer.
+type SocketcandServerOptions struct {
+	// Address is the host:port to listen on; socketcand's usual port is 29536.
+	Address string
+}
+
+// SocketcandServer exposes local canbus Interfaces to socketcand clients (Kayak, python-can and
+// SocketcandChannel) in raw mode.  Buses are added with AddBus, and frames received on them must be passed in
+// through HandleFrame, typically from the channel's frame handler.
+type SocketcandServer struct {
+	options SocketcandServerOptions
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+	buses    map[string]Interface
+	clients  map[*socketcandClient]struct{}
+	wg       sync.WaitGroup
+
+	log *logrus.Logger
+}
+
+// socketcandClient is a single connected client, and the bus it has opened in raw mode (if any)
+type socketcandClient struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	busName string
+	raw     bool
+	frames  chan can.Frame
+}
+
+// NewSocketcandServer returns a new socketcand server with the given options.
+func NewSocketcandServer(log *logrus.Logger, options SocketcandServerOptions) *SocketcandServer {
+	return &SocketcandServer{
+		options: options,
+		buses:   map[string]Interface{},
+		clients: map[*socketcandClient]struct{}{},
+		log:     log,
+	}
+}
+
+// AddBus makes a canbus Interface available to clients under the given name.
+func (s *SocketcandServer) AddBus(name string, bus Interface) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buses[name] = bus
+}
+
+// HandleFrame forwards a frame received on the named bus to every client that has it open in raw mode.  Clients
+// that fall behind have frames dropped rather than stalling the bus.
+func (s *SocketcandServer) HandleFrame(busName string, frame can.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for client := range s.clients {
+		client.mu.Lock()
+		if client.raw && client.busName == busName {
+			select {
+			case client.frames <- frame:
+			default:
+			}
+		}
+		client.mu.Unlock()
+	}
+}
+
+// Start synchronously starts listening for clients.
+func (s *SocketcandServer) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("socketcand server is closed")
+	}
+	if s.listener != nil {
+		return nil
+	}
+
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "tcp", s.options.Address)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+
+	s.log.WithField("address", l.Addr().String()).Info("Opened socketcand server")
+
+	return nil
+}
+
+// Addr returns the address the server is listening on, mostly useful when listening on port 0.
+func (s *SocketcandServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Run accepts and serves clients until the server is closed.
+func (s *SocketcandServer) Run(ctx context.Context) error {
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.wg.Wait()
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		client := &socketcandClient{
+			conn:   conn,
+			frames: make(chan can.Frame, socketcandClientQueueLen),
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			continue
+		}
+		s.clients[client] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveClient(client)
+	}
+}
+
+// Close stops listening and disconnects every client.
+func (s *SocketcandServer) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	listener := s.listener
+	clients := make([]*socketcandClient, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.Unlock()
+
+	for _, client := range clients {
+		_ = client.conn.Close()
+	}
+	if listener == nil {
+		return nil
+	}
+	return listener.Close()
+}
+
+func (s *SocketcandServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// serveClient runs the protocol state machine for one client until it disconnects
+func (s *SocketcandServer) serveClient(client *socketcandClient) {
+	defer s.wg.Done()
+
+	clog := s.log.WithField("client", client.conn.RemoteAddr().String())
+	clog.Info("socketcand client connected")
+
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+		_ = client.conn.Close()
+		clog.Info("socketcand client disconnected")
+	}()
+
+	go func() {
+		for {
+			select {
+			case frame := <-client.frames:
+				if err := client.send(formatSocketcandFrame(frame, time.Now())...); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if err := client.send("hi"); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(client.conn)
+	for {
+		fields, err := readSocketcandMessage(reader)
+		if err != nil {
+			return
+		}
+
+		if err := s.handleClientMessage(client, fields); err != nil {
+			clog.WithError(err).Debug("socketcand client error")
+			if err := client.send("error", err.Error()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleClientMessage is a helper to act on a single message from a client, returning an error to report back to
+// it
+func (s *SocketcandServer) handleClientMessage(client *socketcandClient, fields []string) error {
+	client.mu.Lock()
+	busName := client.busName
+	raw := client.raw
+	client.mu.Unlock()
+
+	switch fields[0] {
+	case "open":
+		if busName != "" {
+			return errors.New("bus already open")
+		}
+		if len(fields) != 2 {
+			return errors.New("usage: open <bus>")
+		}
+		s.mu.Lock()
+		_, exists := s.buses[fields[1]]
+		s.mu.Unlock()
+		if !exists {
+			return errors.New("could not open bus " + fields[1])
+		}
+		client.mu.Lock()
+		client.busName = fields[1]
+		client.mu.Unlock()
+		return client.send("ok")
+	case "rawmode":
+		if busName == "" {
+			return errors.New("no bus open")
+		}
+		client.mu.Lock()
+		client.raw = true
+		client.mu.Unlock()
+		return client.send("ok")
+	case "send":
+		if !raw {
+			return errors.New("send is only supported in raw mode")
+		}
+		frame, err := parseSocketcandSend(fields)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		bus := s.buses[busName]
+		s.mu.Unlock()
+		return bus.WriteFrame(frame)
+	case "echo":
+		return client.send("echo")
+	default:
+		return errors.New("unsupported command " + strings.Join(fields, " "))
+	}
+}
+
+func (c *socketcandClient) send(fields ...string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return writeSocketcandMessage(c.conn, fields...)
+}
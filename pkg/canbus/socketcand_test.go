@@ -0,0 +1,149 @@
+package canbus
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus/canbustest"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func startSocketcandServer(t *testing.T, bus Interface) *SocketcandServer {
+	t.Helper()
+
+	server := NewSocketcandServer(logrus.New(), SocketcandServerOptions{Address: "127.0.0.1:0"})
+	server.AddBus("can0", bus)
+	require.NoError(t, server.Start(context.Background()))
+	runDone := make(chan error, 1)
+	go func() { runDone <- server.Run(context.Background()) }()
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+		require.NoError(t, <-runDone)
+	})
+
+	return server
+}
+
+func TestSocketcandClientAndServer(t *testing.T) {
+	bus := canbustest.NewBus(nil)
+	server := startSocketcandServer(t, bus)
+
+	frames := make(chan can.Frame, 4)
+	client := NewSocketcandChannel(logrus.New(), SocketcandChannelOptions{
+		Address:      server.Addr().String(),
+		BusName:      "can0",
+		FrameHandler: func(f can.Frame) { frames <- f },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, client.Start(ctx))
+	runDone := make(chan error, 1)
+	go func() { runDone <- client.Run(ctx) }()
+
+	sent := []can.Frame{
+		{ID: 0x123, Length: 3, Data: [8]byte{0x11, 0x22, 0x33}},
+		{ID: 0x09F80101 | can.MaskEff, Length: 8, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{ID: 0x123 | can.MaskEff, Length: 1, Data: [8]byte{0x44}},
+	}
+	for _, f := range sent {
+		require.NoError(t, client.WriteFrame(f))
+	}
+	require.Eventually(t, func() bool { return len(bus.Frames()) == 3 }, time.Second, time.Millisecond)
+	require.Equal(t, sent, bus.Frames())
+
+	server.HandleFrame("can0", can.Frame{ID: 0x1FFFFFFF | can.MaskEff, Length: 1, Data: [8]byte{0xab}})
+	server.HandleFrame("can1", can.Frame{ID: 0x1, Length: 0})
+	server.HandleFrame("can0", can.Frame{ID: 0x7ff, Length: 0})
+	server.HandleFrame("can0", can.Frame{ID: 0x123 | can.MaskEff, Length: 0})
+	require.Equal(t, can.Frame{ID: 0x1FFFFFFF | can.MaskEff, Length: 1, Data: [8]byte{0xab}}, receiveFrame(t, frames))
+	require.Equal(t, can.Frame{ID: 0x7ff}, receiveFrame(t, frames))
+	require.Equal(t, can.Frame{ID: 0x123 | can.MaskEff}, receiveFrame(t, frames))
+
+	require.NoError(t, client.Close())
+	require.NoError(t, <-runDone)
+}
+
+func TestSocketcandChannelTrace(t *testing.T) {
+	server := startSocketcandServer(t, canbustest.NewBus(nil))
+
+	log, out := newTraceLog()
+	frames := make(chan can.Frame, 1)
+	client := NewSocketcandChannel(log, SocketcandChannelOptions{
+		Address:      server.Addr().String(),
+		BusName:      "can0",
+		FrameHandler: func(f can.Frame) { frames <- f },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, client.Start(ctx))
+	runDone := make(chan error, 1)
+	go func() { runDone <- client.Run(ctx) }()
+
+	client.SetTrace(&TraceOptions{})
+	require.NoError(t, client.WriteFrame(can.Frame{ID: 0x100, Length: 1, Data: [8]byte{0xaa}}))
+	server.HandleFrame("can0", can.Frame{ID: 0x09F80101 | can.MaskEff, Length: 2, Data: [8]byte{1, 2}})
+	receiveFrame(t, frames)
+	require.Equal(t, []string{
+		"level=info msg=tx 100 [1] AA",
+		"level=info msg=rx 09F80101 prio=2 pgn=129025 src=1 dst=255 [2] 0102",
+	}, out.traces())
+
+	require.NoError(t, client.Close())
+	require.NoError(t, <-runDone)
+}
+
+func TestSocketcandServerRejectsUnknownBus(t *testing.T) {
+	server := startSocketcandServer(t, canbustest.NewBus(nil))
+
+	client := NewSocketcandChannel(logrus.New(), SocketcandChannelOptions{
+		Address: server.Addr().String(),
+		BusName: "can7",
+	})
+	require.ErrorContains(t, client.Start(context.Background()), "open can7")
+}
+
+func TestSocketcandServerRequiresRawModeToSend(t *testing.T) {
+	bus := canbustest.NewBus(nil)
+	server := startSocketcandServer(t, bus)
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	expect := func(want ...string) {
+		t.Helper()
+		fields, err := readSocketcandMessage(r)
+		require.NoError(t, err)
+		require.Equal(t, want, fields[:len(want)])
+	}
+	expect("hi")
+	require.NoError(t, writeSocketcandMessage(conn, "open", "can0"))
+	expect("ok")
+	require.NoError(t, writeSocketcandMessage(conn, "send", "123", "0"))
+	expect("error")
+	require.NoError(t, writeSocketcandMessage(conn, "echo"))
+	expect("echo")
+	require.Empty(t, bus.Frames())
+}
+
+func TestParseSocketcandMessages(t *testing.T) {
+	frame, err := parseSocketcandFrame([]string{"frame", "123", "1470313524.123456", "112233"})
+	require.NoError(t, err)
+	require.Equal(t, can.Frame{ID: 0x123, Length: 3, Data: [8]byte{0x11, 0x22, 0x33}}, frame)
+
+	require.Equal(t, []string{"frame", "00000456", "2.000005"}, formatSocketcandFrame(can.Frame{ID: 0x456 | can.MaskEff}, time.Unix(2, 5000)))
+	frame, err = parseSocketcandFrame([]string{"frame", "00000456", "2.000005"})
+	require.NoError(t, err)
+	require.Equal(t, can.Frame{ID: 0x456 | can.MaskEff}, frame)
+
+	_, err = parseSocketcandSend([]string{"send", "123", "2", "11"})
+	require.Error(t, err)
+	_, err = parseSocketcandSend([]string{"send", "123", "9"})
+	require.Error(t, err)
+}
@@ -0,0 +1,188 @@
+package canbus
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+// TraceDirection is whether a traced frame was received or transmitted
+type TraceDirection string
+
+const (
+	TraceReceive  TraceDirection = "rx"
+	TraceTransmit TraceDirection = "tx"
+)
+
+// TraceOptions controls which frames a channel traces.
+type TraceOptions struct {
+	// SampleEvery traces only every Nth frame of each ID in each direction, starting with the first.  Zero or one
+	// traces them all.
+	SampleEvery int
+	// RateLimit caps how many frames of each ID in each direction are traced per second, with bursts of up to one
+	// second's worth.  Zero is no limit.  Frames skipped by the limit are counted on the next one traced.
+	RateLimit float64
+	// PGNName names the PGN of extended frames, i.e. nmea2000.Registry.Name.  An empty name is left out.
+	PGNName func(pgn uint32) string
+	// Level is the level traces are logged at.  Zero is logrus.InfoLevel, since tracing is turned on on purpose.
+	Level logrus.Level
+}
+
+// Tracer is implemented by channels that can trace the frames they receive and transmit.
+type Tracer interface {
+	// SetTrace starts tracing frames with the given options, or stops if they're nil.  It can be called at any
+	// time, from any goroutine.
+	SetTrace(options *TraceOptions)
+}
+
+// maxTracedIDs bounds the per-ID sampling state, so random IDs can't grow it forever
+const maxTracedIDs = 4096
+
+// traceKey is what sampling and rate limits are kept per
+type traceKey struct {
+	dir TraceDirection
+	id  uint32
+}
+
+// traceState is the sampling and rate limit state for one traceKey
+type traceState struct {
+	seen       uint64
+	tokens     float64
+	refilled   time.Time
+	suppressed uint64
+}
+
+// frameTracer logs a channel's frames when tracing is on.  The zero value is off.
+type frameTracer struct {
+	enabled atomic.Bool
+
+	mu      sync.Mutex
+	options TraceOptions
+	states  map[traceKey]*traceState
+	now     func() time.Time
+}
+
+// set replaces the trace options, resetting the sampling and rate limits; nil turns tracing off.
+func (t *frameTracer) set(options *TraceOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if options == nil {
+		t.enabled.Store(false)
+		t.states = nil
+		return
+	}
+	t.options = *options
+	if t.options.Level == 0 {
+		t.options.Level = logrus.InfoLevel
+	}
+	t.states = map[traceKey]*traceState{}
+	if t.now == nil {
+		t.now = time.Now
+	}
+	t.enabled.Store(true)
+}
+
+// trace logs a frame if tracing is on and the frame's ID isn't being sampled or rate limited out.
+func (t *frameTracer) trace(log *logrus.Logger, dir TraceDirection, frame can.Frame) {
+	if !t.enabled.Load() {
+		return
+	}
+
+	t.mu.Lock()
+	if t.states == nil {
+		t.mu.Unlock()
+		return
+	}
+	suppressed, ok := t.admitLocked(traceKey{dir: dir, id: frame.ID &^ can.MaskRtr}, t.now())
+	options := t.options
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	line := FormatTrace(dir, frame, options.PGNName)
+	if suppressed > 0 {
+		line += fmt.Sprintf(" (+%d suppressed)", suppressed)
+	}
+	log.Log(options.Level, line)
+}
+
+// traceMessage traces a whole message written to a gateway as the frames it goes out as on the bus.
+func (t *frameTracer) traceMessage(log *logrus.Logger, dir TraceDirection, msg PGNMessage) {
+	if !t.enabled.Load() {
+		return
+	}
+	for _, f := range msg.Frames(0) {
+		t.trace(log, dir, f)
+	}
+}
+
+// admitLocked applies the sampling and rate limit to a frame, returning whether to trace it and, if so, how many
+// frames of its ID the rate limit has skipped since the last one traced
+func (t *frameTracer) admitLocked(key traceKey, now time.Time) (uint64, bool) {
+	st, ok := t.states[key]
+	if !ok {
+		if len(t.states) >= maxTracedIDs {
+			clear(t.states)
+		}
+		st = &traceState{tokens: max(t.options.RateLimit, 1), refilled: now}
+		t.states[key] = st
+	}
+
+	st.seen++
+	if t.options.SampleEvery > 1 && (st.seen-1)%uint64(t.options.SampleEvery) != 0 {
+		return 0, false
+	}
+
+	if limit := t.options.RateLimit; limit > 0 {
+		st.tokens = min(st.tokens+now.Sub(st.refilled).Seconds()*limit, max(limit, 1))
+		st.refilled = now
+		if st.tokens < 1 {
+			st.suppressed++
+			return 0, false
+		}
+		st.tokens--
+	}
+
+	suppressed := st.suppressed
+	st.suppressed = 0
+	return suppressed, true
+}
+
+// FormatTrace formats a frame as a single trace line.  Extended frames are split into their NMEA 2000 fields:
+//
+//	rx 09F80102 prio=2 pgn=129025 src=2 dst=255 [8] 0102030405060708 Position, Rapid Update
+//	tx 123 [2] 0102
+func FormatTrace(dir TraceDirection, frame can.Frame, pgnName func(pgn uint32) string) string {
+	var sb strings.Builder
+	sb.WriteString(string(dir))
+
+	// Not every driver sets the EFF flag, so IDs beyond 11 bits count as extended too
+	var name string
+	if IsExtendedID(frame.ID) {
+		h := ParseN2KHeader(frame.ID)
+		fmt.Fprintf(&sb, " %08X prio=%d pgn=%d src=%d dst=%d", frame.ID&can.MaskIDEff, h.Priority, h.PGN, h.Source, h.Destination)
+		if pgnName != nil {
+			name = pgnName(h.PGN)
+		}
+	} else {
+		fmt.Fprintf(&sb, " %03X", frame.ID&can.MaskIDSff)
+	}
+
+	fmt.Fprintf(&sb, " [%d]", frame.Length)
+	if frame.ID&can.MaskRtr != 0 {
+		sb.WriteString(" remote request")
+	} else if n := min(int(frame.Length), can.MaxFrameDataLength); n > 0 {
+		fmt.Fprintf(&sb, " %X", frame.Data[:n])
+	}
+	if name != "" {
+		sb.WriteString(" " + name)
+	}
+	return sb.String()
+}
@@ -0,0 +1,143 @@
+package canbus
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// traceLog is a logger that keeps its lines, without timestamps
+type traceLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *traceLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *traceLog) lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.FieldsFunc(l.buf.String(), func(r rune) bool { return r == '\n' })
+}
+
+// traces is the lines that are frame traces, leaving out the channel's other logging
+func (l *traceLog) traces() []string {
+	var traces []string
+	for _, line := range l.lines() {
+		if strings.Contains(line, " msg=rx ") || strings.Contains(line, " msg=tx ") {
+			traces = append(traces, line)
+		}
+	}
+	return traces
+}
+
+func newTraceLog() (*logrus.Logger, *traceLog) {
+	out := &traceLog{}
+	log := logrus.New()
+	log.Out = out
+	log.Formatter = &logrus.TextFormatter{DisableTimestamp: true, DisableQuote: true}
+	return log, out
+}
+
+func TestFormatTrace(t *testing.T) {
+	names := func(pgn uint32) string {
+		if pgn == 129025 {
+			return "Position, Rapid Update"
+		}
+		return ""
+	}
+
+	frame := can.Frame{ID: 0x09f80102 | can.MaskEff, Length: 8, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	require.Equal(t, "rx 09F80102 prio=2 pgn=129025 src=2 dst=255 [8] 0102030405060708 Position, Rapid Update",
+		FormatTrace(TraceReceive, frame, names))
+
+	// Without the EFF flag, as the USB-CAN analyzer delivers them, and addressed to a destination
+	frame = can.Frame{ID: 0x18ea2301, Length: 3, Data: [8]byte{0x14, 0xf0, 0x01}}
+	require.Equal(t, "tx 18EA2301 prio=6 pgn=59904 src=1 dst=35 [3] 14F001", FormatTrace(TraceTransmit, frame, names))
+
+	require.Equal(t, "tx 123 [2] ABCD", FormatTrace(TraceTransmit, can.Frame{ID: 0x123, Length: 2, Data: [8]byte{0xab, 0xcd}}, nil))
+	require.Equal(t, "rx 123 [0]", FormatTrace(TraceReceive, can.Frame{ID: 0x123}, nil))
+	require.Equal(t, "rx 123 [4] remote request", FormatTrace(TraceReceive, can.Frame{ID: 0x123 | can.MaskRtr, Length: 4}, nil))
+}
+
+func TestFrameTracerOffByDefault(t *testing.T) {
+	log, out := newTraceLog()
+	var tracer frameTracer
+	tracer.trace(log, TraceReceive, can.Frame{ID: 0x123})
+	require.Empty(t, out.lines())
+
+	tracer.set(&TraceOptions{})
+	tracer.trace(log, TraceReceive, can.Frame{ID: 0x123})
+	require.Equal(t, []string{"level=info msg=rx 123 [0]"}, out.lines())
+
+	tracer.set(nil)
+	tracer.trace(log, TraceReceive, can.Frame{ID: 0x123})
+	require.Len(t, out.lines(), 1)
+}
+
+func TestFrameTracerSamples(t *testing.T) {
+	log, out := newTraceLog()
+	var tracer frameTracer
+	tracer.set(&TraceOptions{SampleEvery: 3, Level: logrus.WarnLevel})
+
+	// Every third frame of each ID, counted separately for each direction
+	for i := range 7 {
+		tracer.trace(log, TraceReceive, can.Frame{ID: 0x100, Length: 1, Data: [8]byte{byte(i)}})
+		tracer.trace(log, TraceReceive, can.Frame{ID: 0x200, Length: 1, Data: [8]byte{byte(i)}})
+	}
+	tracer.trace(log, TraceTransmit, can.Frame{ID: 0x100})
+	require.Equal(t, []string{
+		"level=warning msg=rx 100 [1] 00",
+		"level=warning msg=rx 200 [1] 00",
+		"level=warning msg=rx 100 [1] 03",
+		"level=warning msg=rx 200 [1] 03",
+		"level=warning msg=rx 100 [1] 06",
+		"level=warning msg=rx 200 [1] 06",
+		"level=warning msg=tx 100 [0]",
+	}, out.lines())
+}
+
+func TestFrameTracerRateLimits(t *testing.T) {
+	log, out := newTraceLog()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracer := frameTracer{now: func() time.Time { return now }}
+	tracer.set(&TraceOptions{RateLimit: 2})
+
+	// A burst of two gets through, then the rest of the second's frames are held back and counted
+	for i := range 5 {
+		tracer.trace(log, TraceReceive, can.Frame{ID: 0x100, Length: 1, Data: [8]byte{byte(i)}})
+	}
+	tracer.trace(log, TraceReceive, can.Frame{ID: 0x200})
+	now = now.Add(500 * time.Millisecond)
+	tracer.trace(log, TraceReceive, can.Frame{ID: 0x100, Length: 1, Data: [8]byte{5}})
+	tracer.trace(log, TraceReceive, can.Frame{ID: 0x100, Length: 1, Data: [8]byte{6}})
+
+	require.Equal(t, []string{
+		"level=info msg=rx 100 [1] 00",
+		"level=info msg=rx 100 [1] 01",
+		"level=info msg=rx 200 [0]",
+		"level=info msg=rx 100 [1] 05 (+3 suppressed)",
+	}, out.lines())
+}
+
+func TestFrameTracerBoundsIDs(t *testing.T) {
+	log, _ := newTraceLog()
+	log.Out = &bytes.Buffer{}
+	var tracer frameTracer
+	tracer.set(&TraceOptions{SampleEvery: 2})
+
+	for id := range uint32(maxTracedIDs + 10) {
+		tracer.trace(log, TraceReceive, can.Frame{ID: id | can.MaskEff})
+	}
+	require.LessOrEqual(t, len(tracer.states), maxTracedIDs)
+}
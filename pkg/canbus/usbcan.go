@@ -6,48 +6,68 @@ import (
 	"fmt"
 	"slices"
 	"sync"
+	"time"
 
+	"github.com/boatkit-io/tugboat/pkg/usbcan"
 	"github.com/brutella/can"
 	"github.com/sirupsen/logrus"
 	"go.bug.st/serial"
 )
 
-// Docs for this whole crappy thing:
-// * https://github.com/SeeedDocument/USB-CAN-Analyzer/blob/master/res/Document/USB%20(Serial%20port)%20to%20CAN%20protocol%20defines.pdf
-// * https://github.com/SeeedDocument/USB-CAN-Analyzer?tab=readme-ov-file
-// * https://github.com/kobolt/usb-can/blob/master/canusb.c
+// The wire format lives in the usbcan package; these aliases keep the names this package has always used.
 
 // CANUSBMode is an enum for the CANUSB device's modes, which we don't really understand
-type CANUSBMode byte
+type CANUSBMode = usbcan.Mode
 
 const (
-	ModeNormal         CANUSBMode = 0
-	ModeLoopback       CANUSBMode = 1
-	ModeSilent         CANUSBMode = 2
-	ModeLoopbackSilent CANUSBMode = 3
+	ModeNormal         = usbcan.ModeNormal
+	ModeLoopback       = usbcan.ModeLoopback
+	ModeSilent         = usbcan.ModeSilent
+	ModeLoopbackSilent = usbcan.ModeLoopbackSilent
 )
 
 // CANUSBFrame is an enum for the frame type
-type CANUSBFrame byte
+type CANUSBFrame = usbcan.FrameType
 
 const (
-	FrameStandard CANUSBFrame = 1
-	FrameExtended CANUSBFrame = 2
+	FrameStandard = usbcan.FrameStandard
+	FrameExtended = usbcan.FrameExtended
 )
 
+// USBCANCommand is an enum for the command byte of 0xaa 0x55 command frames
+type USBCANCommand = usbcan.Command
+
+const (
+	CommandSetVariable = usbcan.CommandSetVariable
+	CommandStatus      = usbcan.CommandStatus
+	CommandSetFixed    = usbcan.CommandSetFixed
+)
+
+// USBCANCommandResponse is a decoded 0xaa 0x55 command frame received from the analyzer.
+type USBCANCommandResponse = usbcan.CommandFrame
+
+// USBCANStatus is the CAN controller status reported by the analyzer in response to a CommandStatus query.
+type USBCANStatus = usbcan.Status
+
 // USBCANChannelOptions is a type that contains required options on a SocketCANChannel.
 type USBCANChannelOptions struct {
 	SerialPortName string
 	SerialBaudRate int
 	BitRate        int
 	FrameHandler   can.HandlerFunc
+	// CommandHandler, if set, is called with every command frame received from the analyzer.
+	CommandHandler func(USBCANCommandResponse)
+	// SettingsResponseTimeout, if non-zero, makes Start wait up to this long for the analyzer to acknowledge the
+	// settings frame, failing Start if it does not or if the bitrate and mode it reports don't match.  Zero keeps
+	// the old fire-and-forget behavior, since the status layout hasn't been checked against every firmware.
+	SettingsResponseTimeout time.Duration
+	// OpenPort opens the serial port, defaulting to serial.Open.  Tests can point it at a usbcantest.Emulator.
+	OpenPort func(name string, mode *serial.Mode) (serial.Port, error)
 }
 
-type serialPortOpener func(string, *serial.Mode) (serial.Port, error)
-
-type usbCANOpenResult struct {
-	port serial.Port
-	err  error
+type usbCANCommandWaiter struct {
+	command USBCANCommand
+	ch      chan USBCANCommandResponse
 }
 
 // USBCANChannel represents a single USB-CAN-based canbus channel for sending/receiving CAN frames
@@ -62,19 +82,35 @@ type USBCANChannel struct {
 	opening  chan struct{}
 	openPort serialPortOpener
 
+	// decoder holds bytes read but not yet decoded.  Start's settings confirmation feeds it first, then Run picks
+	// up where that left off; they never run at the same time.
+	decoder *usbcan.Decoder
+
+	waitersMu sync.Mutex
+	waiters   []*usbCANCommandWaiter
+
+	tracer frameTracer
+
 	log *logrus.Logger
 }
 
 // NewUSBCANChannel returns a Channel object based on USBCAN and the given options.  ChannelOptions are required settings.
 func NewUSBCANChannel(log *logrus.Logger, options USBCANChannelOptions) *USBCANChannel {
-	c := USBCANChannel{
+	c := &USBCANChannel{
 		options:  options,
 		log:      log,
 		done:     make(chan struct{}),
-		openPort: serial.Open,
+		openPort: options.OpenPort,
 	}
+	if c.openPort == nil {
+		c.openPort = serial.Open
+	}
+	c.decoder = usbcan.NewDecoder(usbcan.DecoderOptions{
+		FrameHandler:   c.handleFrame,
+		CommandHandler: c.handleCommandFrame,
+	})
 
-	return &c
+	return c
 }
 
 // Start synchronously opens and configures the serial CAN interface.
@@ -125,16 +161,20 @@ func (c *USBCANChannel) Start(ctx context.Context) error {
 	mode := &serial.Mode{
 		BaudRate: c.options.SerialBaudRate,
 	}
-	resultCh := make(chan usbCANOpenResult, 1)
+	resultCh := make(chan serialOpenResult, 1)
 	go func() {
+		c.decoder.Reset()
 		port, err := openPort(c.options.SerialPortName, mode)
 		if err == nil {
 			err = c.sendSettingsFrame(port)
 		}
-		resultCh <- usbCANOpenResult{port: port, err: err}
+		if err == nil && c.options.SettingsResponseTimeout > 0 {
+			err = c.confirmSettings(port, c.options.SettingsResponseTimeout)
+		}
+		resultCh <- serialOpenResult{port: port, err: err}
 	}()
 
-	var result usbCANOpenResult
+	var result serialOpenResult
 	select {
 	case result = <-resultCh:
 	case <-ctx.Done():
@@ -171,12 +211,9 @@ func (c *USBCANChannel) Start(ctx context.Context) error {
 	return nil
 }
 
-func (c *USBCANChannel) abandonOpen(opening chan struct{}, resultCh <-chan usbCANOpenResult) {
+func (c *USBCANChannel) abandonOpen(opening chan struct{}, resultCh <-chan serialOpenResult) {
 	go func() {
-		result := <-resultCh
-		if result.port != nil {
-			_ = result.port.Close()
-		}
+		closeAbandonedSerialPort(resultCh)
 		c.mu.Lock()
 		c.finishOpenLocked(opening)
 		c.mu.Unlock()
@@ -206,111 +243,23 @@ func (c *USBCANChannel) Run(ctx context.Context) error {
 	c.log.WithField("portName", c.options.SerialPortName).
 		Info("Listening on USBCAN")
 
-	pending := []byte{}
 	for {
-		working := make([]byte, 32)
-		readBytes, err := port.Read(working)
-		if err != nil {
-			return err
-		}
-		pending = append(pending, working[0:readBytes]...)
-
-		if err := c.parseFrames(&pending); err != nil {
+		if err := c.readOnce(port); err != nil {
 			return err
 		}
 	}
 }
 
-// parseFrames is a helper to parse any waiting frames from the recv buffer, and update the recv buffer to keep going
-func (c *USBCANChannel) parseFrames(bufAddr *[]byte) error {
-	for {
-		buf := *bufAddr
-
-		if len(buf) == 0 {
-			return nil
-		}
-
-		if buf[0] != 0xaa {
-			idx := slices.Index(buf, 0xaa)
-			if idx == -1 {
-				c.log.Debugf("Error frame: %+v\n", buf)
-				*bufAddr = []byte{}
-				return nil
-			}
-			c.log.Debugf("Error frame, skipping %d bytes: %+v\n", idx, buf)
-
-			*bufAddr = buf[idx:]
-			continue
-		}
-
-		if len(buf) < 2 {
-			return nil
-		}
+// readOnce is a helper to do a single read from the port and decode it
+func (c *USBCANChannel) readOnce(port serial.Port) error {
+	skipped := c.decoder.Skipped()
+	_, err := c.decoder.ReadOnce(port)
 
-		if buf[1] == 0x55 {
-			// command frame
-			if len(buf) < 20 {
-				return nil
-			}
-
-			c.log.Debugf("Command frame: %+v\n", buf[0:20])
-
-			*bufAddr = buf[20:]
-			continue
-		}
-
-		if (buf[1] >> 6) == 3 {
-			// data frame
-			frameLen := byte(2)
-			extendedFrame := (buf[1] & 0x20) > 0
-			remoteFrame := (buf[1] & 0x10) > 0
-			if extendedFrame {
-				frameLen += 4
-			} else {
-				frameLen += 2
-			}
-
-			dataLen := buf[1] & 0xf
-			frameLen += dataLen + 1
-			if len(buf) < int(frameLen) {
-				return nil
-			}
-
-			var frameID uint32
-			if extendedFrame {
-				frameID = (uint32(buf[2])) | (uint32(buf[3]) << 8) | (uint32(buf[4]) << 16) | (uint32(buf[5]) << 24)
-			} else {
-				frameID = (uint32(buf[2])) | (uint32(buf[3]) << 8)
-			}
-
-			endByte := buf[frameLen-1]
-
-			dataBytes := buf[frameLen-1-dataLen : frameLen-1]
-			if endByte != 0x55 {
-				c.log.Debugf("Data frame with bad end byte: %v %v %X %+v EB: %X\n", extendedFrame, remoteFrame, frameID, dataBytes, endByte)
-				*bufAddr = []byte{}
-				return nil
-			}
-
-			fData := [8]byte{}
-			for i := 0; i < int(dataLen); i++ {
-				fData[i] = dataBytes[i]
-			}
-			fd := can.Frame{
-				ID:     frameID,
-				Length: dataLen,
-				Data:   fData,
-			}
-			c.options.FrameHandler(fd)
-
-			*bufAddr = buf[frameLen:]
-			continue
-		}
-
-		c.log.Debugf("Unknown frame: %+v\n", buf)
-		*bufAddr = []byte{}
-		return nil
+	// Check the level first so the steady state doesn't allocate for a log line nobody sees
+	if n := c.decoder.Skipped() - skipped; n > 0 && c.log.IsLevelEnabled(logrus.DebugLevel) {
+		c.log.WithField("bytes", n).Debug("Skipped USBCAN bytes that weren't part of a frame")
 	}
+	return err
 }
 
 // Close shuts down the channel
@@ -344,114 +293,177 @@ func (c *USBCANChannel) WriteFrame(frame can.Frame) error {
 		return errors.New("USBCAN channel is not open")
 	}
 
-	buf := []byte{
-		0xaa,
-		0xC0 | frame.Length,
-		byte(frame.ID),
-		byte(frame.ID >> 8),
+	if err := usbcan.NewEncoder(port).WriteFrame(frame); err != nil {
+		return err
 	}
-	// Not sure if this is the right way to do it, but for now give it a shot
-	// (we're only sending standard frames over calex, so need to test this on n2k someday if we care...)
-	if frame.ID > 0xffff {
-		// switch to extended frame
-		buf[1] |= 0x20
-		buf = append(buf, byte(frame.ID>>16), byte(frame.ID>>24))
+	c.tracer.trace(c.log, TraceTransmit, frame)
+	return nil
+}
+
+// SetTrace starts logging each frame received and transmitted, or stops if options is nil.
+func (c *USBCANChannel) SetTrace(options *TraceOptions) {
+	c.tracer.set(options)
+}
+
+// sendSettingsFrame is a helper to send the startup settings frame to set the bitrate appropriately
+func (c *USBCANChannel) sendSettingsFrame(port serial.Port) error {
+	return usbcan.NewEncoder(port).WriteSettings(c.settings())
+}
+
+// settings returns the analyzer configuration for our options
+func (c *USBCANChannel) settings() usbcan.Settings {
+	return usbcan.Settings{
+		BitRate:   c.options.BitRate,
+		FrameType: FrameStandard,
+		Mode:      ModeNormal,
 	}
-	buf = append(buf, frame.Data[0:frame.Length]...)
-	buf = append(buf, 0x55)
+}
 
-	o, err := port.Write(buf)
-	if o != len(buf) {
-		return fmt.Errorf("WriteFrame sent %d of %d bytes", o, len(buf))
+// confirmSettings is a helper that queries the analyzer's status right after the settings frame and waits for it
+// to answer, reading the port directly since Run is not consuming it yet.  Some firmwares echo the settings frame
+// back instead.  Either way the bitrate and mode the analyzer reports have to match what we asked for, if it reports
+// a bitrate at all.
+func (c *USBCANChannel) confirmSettings(port serial.Port, timeout time.Duration) error {
+	settingsWaiter := c.addCommandWaiter(CommandSetFixed)
+	defer c.removeCommandWaiter(settingsWaiter)
+	statusWaiter := c.addCommandWaiter(CommandStatus)
+	defer c.removeCommandWaiter(statusWaiter)
+
+	if err := usbcan.NewEncoder(port).WriteCommand(CommandStatus, nil); err != nil {
+		return err
 	}
-	if err != nil {
+
+	if err := port.SetReadTimeout(timeout); err != nil {
 		return err
 	}
+	defer func() {
+		_ = port.SetReadTimeout(serial.NoTimeout)
+	}()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		select {
+		case resp := <-settingsWaiter.ch:
+			echoed := usbcan.ParseSettings(resp)
+			return c.checkSettings(echoed.BitRate, echoed.Mode)
+		case resp := <-statusWaiter.ch:
+			status := resp.Status()
+			return c.checkSettings(status.BitRate, status.Mode)
+		default:
+		}
 
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("USBCAN did not acknowledge settings within %s", timeout)
+		}
+
+		if err := c.readOnce(port); err != nil {
+			return err
+		}
+	}
+}
+
+// checkSettings is a helper that compares the settings the analyzer reports with the ones we sent.  A zero (or
+// unknown) bitrate means the firmware doesn't report its settings the way we expect, so there's nothing to verify.
+func (c *USBCANChannel) checkSettings(bitRate int, mode CANUSBMode) error {
+	want := c.settings()
+	if bitRate == 0 {
+		c.log.WithField("portName", c.options.SerialPortName).Debug("USBCAN doesn't report its settings, not verifying them")
+		return nil
+	}
+	if bitRate != want.BitRate || mode != want.Mode {
+		return fmt.Errorf("USBCAN rejected settings: requested bitrate %d mode %d, device reports bitrate %d mode %d",
+			want.BitRate, want.Mode, bitRate, mode)
+	}
 	return nil
 }
 
-// sendSettingsFrame is a helper to send the startup settings frame to set the bitrate appropriately
-func (c *USBCANChannel) sendSettingsFrame(port serial.Port) error {
-	br, err := mapBitRate(c.options.BitRate)
-	if err != nil {
-		return err
+// SendCommand sends a command frame with the given payload (up to 16 bytes) and waits for the analyzer to answer
+// with a command frame of the same type.  Run must be active to receive the response.  Commands beyond the
+// documented ones are firmware-specific, so callers probing for e.g. version information should expect timeouts.
+func (c *USBCANChannel) SendCommand(ctx context.Context, command USBCANCommand, payload []byte) (USBCANCommandResponse, error) {
+	c.mu.Lock()
+	port := c.port
+	closed := c.closed
+	c.mu.Unlock()
+	if closed || port == nil {
+		return USBCANCommandResponse{}, errors.New("USBCAN channel is not open")
 	}
 
-	buf := []byte{
-		0xaa,
-		0x55,
-		0x12,
-		br,
-		byte(FrameStandard),
-		0,
-		0,
-		0,
-		0,
-		0,
-		0,
-		0,
-		0,
-		byte(ModeNormal),
-		0x01,
-		0,
-		0,
-		0,
-		0,
-		0,
-	}
-	buf[19] = calcChecksum(buf, 2, 17)
-
-	o, err := port.Write(buf)
-	if o != len(buf) {
-		return fmt.Errorf("sendSettingsFrame sent %d of %d bytes", o, len(buf))
+	waiter := c.addCommandWaiter(command)
+	defer c.removeCommandWaiter(waiter)
+
+	if err := usbcan.NewEncoder(port).WriteCommand(command, payload); err != nil {
+		return USBCANCommandResponse{}, err
 	}
+
+	select {
+	case resp := <-waiter.ch:
+		return resp, nil
+	case <-ctx.Done():
+		return USBCANCommandResponse{}, ctx.Err()
+	}
+}
+
+// QueryStatus asks the analyzer for its CAN controller status.  Run must be active to receive the response.
+func (c *USBCANChannel) QueryStatus(ctx context.Context) (USBCANStatus, error) {
+	resp, err := c.SendCommand(ctx, CommandStatus, nil)
 	if err != nil {
-		return err
+		return USBCANStatus{}, err
 	}
 
-	return nil
+	return resp.Status(), nil
 }
 
-var _ Interface = (*USBCANChannel)(nil)
-
-// mapBitRate is a helper to map numeric bitrates to their byte values
-func mapBitRate(bitRate int) (byte, error) {
-	switch bitRate {
-	case 1000000:
-		return 0x01, nil
-	case 800000:
-		return 0x02, nil
-	case 500000:
-		return 0x03, nil
-	case 400000:
-		return 0x04, nil
-	case 250000:
-		return 0x05, nil
-	case 200000:
-		return 0x06, nil
-	case 125000:
-		return 0x07, nil
-	case 100000:
-		return 0x08, nil
-	case 50000:
-		return 0x09, nil
-	case 20000:
-		return 0x0a, nil
-	case 10000:
-		return 0x0b, nil
-	case 5000:
-		return 0x0c, nil
-	default:
-		return 0, fmt.Errorf("no matching bitrate setting for %d", bitRate)
+// handleFrame is a helper to hand a data frame from the analyzer to the frame handler
+func (c *USBCANChannel) handleFrame(frame can.Frame) {
+	c.tracer.trace(c.log, TraceReceive, frame)
+	if c.options.FrameHandler != nil {
+		c.options.FrameHandler(frame)
 	}
 }
 
-// calcChecksum is a crappy function that calcs checksum the way they do
-func calcChecksum(buf []byte, start, length int) byte {
-	cs := byte(0)
-	for i := start; i < start+length; i++ {
-		cs += buf[i]
+// handleCommandFrame is a helper to hand a command frame from the analyzer to any waiters
+func (c *USBCANChannel) handleCommandFrame(resp USBCANCommandResponse) {
+	if c.options.CommandHandler != nil {
+		c.options.CommandHandler(resp)
+	}
+
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+	for i, w := range c.waiters {
+		if w.command == resp.Command {
+			w.ch <- resp
+			c.waiters = slices.Delete(c.waiters, i, i+1)
+			return
+		}
 	}
-	return cs
+
+	c.log.WithField("command", resp.Command).Debug("Unsolicited command frame")
+}
+
+func (c *USBCANChannel) addCommandWaiter(command USBCANCommand) *usbCANCommandWaiter {
+	w := &usbCANCommandWaiter{
+		command: command,
+		ch:      make(chan USBCANCommandResponse, 1),
+	}
+
+	c.waitersMu.Lock()
+	c.waiters = append(c.waiters, w)
+	c.waitersMu.Unlock()
+
+	return w
 }
+
+func (c *USBCANChannel) removeCommandWaiter(w *usbCANCommandWaiter) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+
+	if i := slices.Index(c.waiters, w); i != -1 {
+		c.waiters = slices.Delete(c.waiters, i, i+1)
+	}
+}
+
+var (
+	_ Interface = (*USBCANChannel)(nil)
+	_ Tracer    = (*USBCANChannel)(nil)
+)
@@ -2,10 +2,16 @@ package canbus
 
 import (
 	"context"
+	"io"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/boatkit-io/tugboat/pkg/usbcan"
+	"github.com/boatkit-io/tugboat/pkg/usbcan/usbcantest"
+	"github.com/brutella/can"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"go.bug.st/serial"
@@ -101,3 +107,340 @@ func TestUSBCANCloseInterruptsBlockedSerialOpen(t *testing.T) {
 	close(openRelease)
 	require.Eventually(t, port.closed.Load, time.Second, time.Millisecond)
 }
+
+// scriptedSerialPort is a fake serial port that feeds Read from whatever respond returns for each Write.
+type scriptedSerialPort struct {
+	lifecycleSerialPort
+
+	respond func([]byte) []byte
+
+	mu          sync.Mutex
+	readTimeout time.Duration
+	incoming    chan []byte
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newScriptedSerialPort(respond func([]byte) []byte) *scriptedSerialPort {
+	return &scriptedSerialPort{
+		respond:     respond,
+		readTimeout: serial.NoTimeout,
+		incoming:    make(chan []byte, 16),
+		done:        make(chan struct{}),
+	}
+}
+
+func (p *scriptedSerialPort) Write(b []byte) (int, error) {
+	if p.respond != nil {
+		if resp := p.respond(slices.Clone(b)); len(resp) > 0 {
+			p.incoming <- resp
+		}
+	}
+	return len(b), nil
+}
+
+func (p *scriptedSerialPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	timeout := p.readTimeout
+	p.mu.Unlock()
+
+	var timeoutCh <-chan time.Time
+	if timeout != serial.NoTimeout {
+		timeoutCh = time.After(timeout)
+	}
+	select {
+	case data := <-p.incoming:
+		return copy(b, data), nil
+	case <-timeoutCh:
+		return 0, nil
+	case <-p.done:
+		return 0, io.EOF
+	}
+}
+
+func (p *scriptedSerialPort) SetReadTimeout(t time.Duration) error {
+	p.mu.Lock()
+	p.readTimeout = t
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *scriptedSerialPort) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+	return p.lifecycleSerialPort.Close()
+}
+
+func testCommandFrame(command USBCANCommand, payload ...byte) []byte {
+	buf, err := usbcan.AppendCommand(nil, command, payload)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// testStatusFrame is the analyzer's answer to a status query once it's configured for 250k in normal mode
+func testStatusFrame(counters ...byte) []byte {
+	buf, err := usbcan.AppendStatus(nil, usbcan.Status{RxErrorCount: counters[0], TxErrorCount: counters[1], ErrorFlags: counters[2],
+		BitRate: 250_000, Mode: ModeNormal})
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func newScriptedUSBCANChannel(port *scriptedSerialPort, timeout time.Duration) *USBCANChannel {
+	channel := NewUSBCANChannel(logrus.New(), USBCANChannelOptions{
+		SerialPortName:          "test-port",
+		SerialBaudRate:          2_000_000,
+		BitRate:                 250_000,
+		SettingsResponseTimeout: timeout,
+	})
+	channel.openPort = func(string, *serial.Mode) (serial.Port, error) {
+		return port, nil
+	}
+	return channel
+}
+
+func TestUSBCANDecodesCommandFrames(t *testing.T) {
+	var got []USBCANCommandResponse
+	channel := NewUSBCANChannel(logrus.New(), USBCANChannelOptions{
+		CommandHandler: func(resp USBCANCommandResponse) { got = append(got, resp) },
+	})
+
+	buf := testCommandFrame(CommandStatus, 3, 7, 0x20)
+	corrupt := testCommandFrame(CommandStatus, 1)
+	corrupt[19]++
+	buf = append(buf, corrupt...)
+	_, err := channel.decoder.Write(buf)
+	require.NoError(t, err)
+
+	require.Zero(t, channel.decoder.Buffered())
+	require.Len(t, got, 1)
+	require.Equal(t, CommandStatus, got[0].Command)
+	require.Equal(t, USBCANStatus{RxErrorCount: 3, TxErrorCount: 7, ErrorFlags: 0x20}, got[0].Status())
+}
+
+func TestUSBCANStartConfirmsSettings(t *testing.T) {
+	port := newScriptedSerialPort(func(b []byte) []byte {
+		if b[2] == byte(CommandStatus) {
+			// Tack a data frame on after the status response to check frames read during Start are still delivered.
+			return append(testStatusFrame(0, 0, 0), 0xaa, 0xc1, 0x23, 0x01, 0x42, 0x55)
+		}
+		return nil
+	})
+	channel := newScriptedUSBCANChannel(port, time.Second)
+	frames := make(chan can.Frame, 1)
+	channel.options.FrameHandler = func(f can.Frame) { frames <- f }
+
+	require.NoError(t, channel.Start(context.Background()))
+	t.Cleanup(func() { require.NoError(t, channel.Close()) })
+
+	select {
+	case f := <-frames:
+		require.Equal(t, uint32(0x123), f.ID)
+	case <-time.After(time.Second):
+		t.Fatal("data frame read during Start was not delivered")
+	}
+}
+
+func TestUSBCANStartFailsWhenStatusReportsOtherSettings(t *testing.T) {
+	port := newScriptedSerialPort(func(b []byte) []byte {
+		if b[2] == byte(CommandStatus) {
+			buf, _ := usbcan.AppendStatus(nil, usbcan.Status{BitRate: 250_000, Mode: ModeSilent})
+			return buf
+		}
+		return nil
+	})
+	channel := newScriptedUSBCANChannel(port, time.Second)
+
+	require.ErrorContains(t, channel.Start(context.Background()), "device reports bitrate 250000 mode 2")
+	require.True(t, port.closed.Load())
+}
+
+func TestUSBCANStartWithoutSettingsConfirmation(t *testing.T) {
+	port := newScriptedSerialPort(nil)
+	channel := newScriptedUSBCANChannel(port, 0)
+
+	require.NoError(t, channel.Start(context.Background()))
+	require.NoError(t, channel.Close())
+}
+
+func TestUSBCANStartAcceptsUnreportedSettings(t *testing.T) {
+	// Firmwares that leave the settings bytes of the status reply at zero can't be checked
+	port := newScriptedSerialPort(func(b []byte) []byte {
+		if b[2] == byte(CommandStatus) {
+			return testCommandFrame(CommandStatus, 0, 0, 0)
+		}
+		return nil
+	})
+	channel := newScriptedUSBCANChannel(port, time.Second)
+
+	require.NoError(t, channel.Start(context.Background()))
+	require.NoError(t, channel.Close())
+}
+
+func TestUSBCANStartFailsWhenSettingsRejected(t *testing.T) {
+	port := newScriptedSerialPort(func(b []byte) []byte {
+		if b[2] == byte(CommandSetFixed) {
+			return testCommandFrame(CommandSetFixed, 0x01)
+		}
+		return nil
+	})
+	channel := newScriptedUSBCANChannel(port, time.Second)
+
+	require.ErrorContains(t, channel.Start(context.Background()), "rejected settings")
+	require.True(t, port.closed.Load())
+}
+
+func TestUSBCANStartFailsWhenSettingsUnacknowledged(t *testing.T) {
+	port := newScriptedSerialPort(nil)
+	channel := newScriptedUSBCANChannel(port, 50*time.Millisecond)
+
+	require.ErrorContains(t, channel.Start(context.Background()), "did not acknowledge")
+}
+
+func TestUSBCANQueryStatus(t *testing.T) {
+	port := newScriptedSerialPort(func(b []byte) []byte {
+		if b[2] == byte(CommandStatus) {
+			return testStatusFrame(0, 128, 0x01)
+		}
+		return nil
+	})
+	channel := newScriptedUSBCANChannel(port, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, channel.Start(ctx))
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(ctx) }()
+
+	queryCtx, queryCancel := context.WithTimeout(ctx, time.Second)
+	defer queryCancel()
+	status, err := channel.QueryStatus(queryCtx)
+	require.NoError(t, err)
+	require.Equal(t, USBCANStatus{RxErrorCount: 0, TxErrorCount: 128, ErrorFlags: 0x01, BitRate: 250_000}, status)
+
+	require.NoError(t, channel.Close())
+	require.Error(t, <-runDone)
+}
+
+// runEmulatedUSBCAN starts and runs a channel against an emulator, returning the frames it delivers
+func runEmulatedUSBCAN(t *testing.T, options USBCANChannelOptions) (*USBCANChannel, <-chan can.Frame, <-chan error) {
+	t.Helper()
+
+	frames := make(chan can.Frame, 16)
+	options.SerialBaudRate = 2_000_000
+	options.BitRate = 250_000
+	if options.SettingsResponseTimeout == 0 {
+		options.SettingsResponseTimeout = time.Second
+	}
+	options.FrameHandler = func(f can.Frame) { frames <- f }
+	channel := NewUSBCANChannel(logrus.New(), options)
+
+	require.NoError(t, channel.Start(context.Background()))
+	runDone := make(chan error, 1)
+	go func() { runDone <- channel.Run(context.Background()) }()
+	return channel, frames, runDone
+}
+
+func TestUSBCANEmulatedLifecycle(t *testing.T) {
+	transmitted := make(chan can.Frame, 4)
+	emulator := usbcantest.NewEmulator(usbcantest.EmulatorOptions{
+		Status:       USBCANStatus{RxErrorCount: 2, TxErrorCount: 1},
+		FrameHandler: func(f can.Frame) { transmitted <- f },
+		Seed:         1,
+	})
+	defer emulator.Close()
+	channel, frames, runDone := runEmulatedUSBCAN(t, USBCANChannelOptions{OpenPort: emulator.Open})
+
+	settings, ok := emulator.Settings()
+	require.True(t, ok)
+	require.Equal(t, 250_000, settings.BitRate)
+	require.Equal(t, ModeNormal, settings.Mode)
+
+	// Frames from the bus arrive, including the good ones right after corrupt ones
+	sent := can.Frame{ID: 0x09F80101, Length: 8, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	require.NoError(t, emulator.SendFrame(sent))
+	require.Equal(t, sent, receiveFrame(t, frames))
+	require.NoError(t, emulator.SendCorruptFrame(sent, usbcantest.CorruptEndByte))
+	require.NoError(t, emulator.SendCorruptFrame(sent, usbcantest.CorruptTruncate))
+	require.NoError(t, emulator.SendFrame(can.Frame{ID: 0x42, Length: 1, Data: [8]byte{9}}))
+	// The truncated frame swallows what follows until enough bytes arrive to show its end byte is wrong
+	require.NoError(t, emulator.SendFrame(sent))
+	require.Equal(t, can.Frame{ID: 0x42, Length: 1, Data: [8]byte{9}}, receiveFrame(t, frames))
+	require.Equal(t, sent, receiveFrame(t, frames))
+
+	// Frames we write go out on the bus
+	require.NoError(t, channel.WriteFrame(can.Frame{ID: 0x100, Length: 2, Data: [8]byte{0xaa, 0x55}}))
+	select {
+	case f := <-transmitted:
+		require.Equal(t, can.Frame{ID: 0x100, Length: 2, Data: [8]byte{0xaa, 0x55}}, f)
+	case <-time.After(time.Second):
+		t.Fatal("frame was not transmitted")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	status, err := channel.QueryStatus(ctx)
+	require.NoError(t, err)
+	require.Equal(t, USBCANStatus{RxErrorCount: 2, TxErrorCount: 1, BitRate: 250_000, Mode: ModeNormal}, status)
+
+	require.NoError(t, channel.Close())
+	require.Error(t, <-runDone)
+	require.ErrorIs(t, emulator.SendFrame(sent), usbcantest.ErrNotConnected)
+}
+
+func TestUSBCANEmulatedTrace(t *testing.T) {
+	emulator := usbcantest.NewEmulator(usbcantest.EmulatorOptions{})
+	defer emulator.Close()
+	channel, frames, runDone := runEmulatedUSBCAN(t, USBCANChannelOptions{OpenPort: emulator.Open})
+	out := &traceLog{}
+	channel.log.SetOutput(out)
+	channel.log.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true, DisableQuote: true})
+
+	// Nothing is traced until it's turned on
+	rx := can.Frame{ID: 0x09F80101, Length: 2, Data: [8]byte{1, 2}}
+	require.NoError(t, emulator.SendFrame(rx))
+	receiveFrame(t, frames)
+	require.Empty(t, out.traces())
+
+	channel.SetTrace(&TraceOptions{PGNName: func(uint32) string { return "Position, Rapid Update" }})
+	require.NoError(t, emulator.SendFrame(rx))
+	receiveFrame(t, frames)
+	require.NoError(t, channel.WriteFrame(can.Frame{ID: 0x100, Length: 1, Data: [8]byte{0xaa}}))
+	require.Equal(t, []string{
+		"level=info msg=rx 09F80101 prio=2 pgn=129025 src=1 dst=255 [2] 0102 Position, Rapid Update",
+		"level=info msg=tx 100 [1] AA",
+	}, out.traces())
+
+	require.NoError(t, channel.Close())
+	require.Error(t, <-runDone)
+}
+
+func TestUSBCANEmulatedStartTimesOut(t *testing.T) {
+	emulator := usbcantest.NewEmulator(usbcantest.EmulatorOptions{Unresponsive: true})
+	defer emulator.Close()
+	channel := NewUSBCANChannel(logrus.New(), USBCANChannelOptions{
+		BitRate:                 250_000,
+		SettingsResponseTimeout: 50 * time.Millisecond,
+		OpenPort:                emulator.Open,
+	})
+
+	require.ErrorContains(t, channel.Start(context.Background()), "did not acknowledge")
+}
+
+func TestUSBCANEmulatedOverPTY(t *testing.T) {
+	emulator := usbcantest.NewEmulator(usbcantest.EmulatorOptions{EchoSettings: true})
+	defer emulator.Close()
+	path, err := emulator.ListenPTY()
+	if err != nil {
+		t.Skipf("ptys are not available: %v", err)
+	}
+
+	channel, frames, runDone := runEmulatedUSBCAN(t, USBCANChannelOptions{SerialPortName: path})
+	sent := can.Frame{ID: 0x123, Length: 3, Data: [8]byte{1, 2, 3}}
+	require.NoError(t, emulator.SendFrame(sent))
+	require.Equal(t, sent, receiveFrame(t, frames))
+
+	require.NoError(t, channel.Close())
+	<-runDone
+}
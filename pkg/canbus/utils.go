@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/brutella/can"
 )
 
 // GetCanInterfaceNameForSpiDevice returns the can interface name (i.e. can0/can1) for
@@ -22,3 +24,10 @@ func GetCanInterfaceNameForSpiDevice(spiName string) (string, error) {
 
 	return files[0].Name(), nil
 }
+
+// IsExtendedID reports whether a frame ID needs a 29-bit extended identifier, either because the EFF flag is set or
+// because the ID doesn't fit in 11 bits.  Serial adapters deliver extended IDs without the flag, so this is the
+// check to use on frames from any channel.
+func IsExtendedID(id uint32) bool {
+	return id&can.MaskEff != 0 || id&can.MaskIDEff > can.MaskIDSff
+}
@@ -3,6 +3,7 @@ package canbus
 import (
 	"testing"
 
+	"github.com/brutella/can"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -10,3 +11,10 @@ func TestGetCanInterfaceNameForSpiDevice(t *testing.T) {
 	_, err := GetCanInterfaceNameForSpiDevice("fake")
 	assert.Error(t, err, "expected getCanInterfaceNameForSpiDevice() to fail")
 }
+
+func TestIsExtendedID(t *testing.T) {
+	assert.False(t, IsExtendedID(0x7ff))
+	assert.True(t, IsExtendedID(0x800))
+	assert.True(t, IsExtendedID(0x123|can.MaskEff))
+	assert.False(t, IsExtendedID(0x123|can.MaskRtr))
+}
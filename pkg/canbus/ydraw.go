@@ -0,0 +1,134 @@
+package canbus
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brutella/can"
+)
+
+// Docs for the Yacht Devices RAW format:
+// * https://www.yachtd.com/downloads/ydwg02.pdf (appendix E)
+
+// YDRawRecord is a single line of Yacht Devices RAW, as sent by YDWG-02/YDEN-02 gateways and written to their logs
+type YDRawRecord struct {
+	// Time is the gateway's time of day when the frame was seen.
+	Time time.Duration
+	// Direction is 'R' for frames received from the bus, 'T' for frames the gateway transmitted.
+	Direction byte
+	Frame     can.Frame
+}
+
+// ParseYDRaw parses a line such as "17:33:21.107 R 19F51323 01 2F 30 70 00 2F 30 70".  IDs with more than 3 hex
+// digits are extended and get the EFF flag, even when the ID itself would fit in 11 bits.
+func ParseYDRaw(line string) (YDRawRecord, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return YDRawRecord{}, fmt.Errorf("malformed YD RAW line %q", line)
+	}
+
+	tod, err := parseTimeOfDay(fields[0], ":")
+	if err != nil {
+		return YDRawRecord{}, err
+	}
+	if fields[1] != "R" && fields[1] != "T" {
+		return YDRawRecord{}, fmt.Errorf("unknown YD RAW direction %q", fields[1])
+	}
+
+	frame, err := parseYDRawFrame(fields[2:])
+	if err != nil {
+		return YDRawRecord{}, err
+	}
+
+	return YDRawRecord{Time: tod, Direction: fields[1][0], Frame: frame}, nil
+}
+
+// String formats the record the way the gateway does.
+func (r YDRawRecord) String() string {
+	return formatTimeOfDay(r.Time, ":") + " " + string(r.Direction) + " " + FormatYDRawFrame(r.Frame)
+}
+
+// FormatYDRawFrame formats a frame as "ID DATA..." without the time and direction, which is also what gets sent
+// to a gateway to transmit the frame.
+func FormatYDRawFrame(frame can.Frame) string {
+	var sb strings.Builder
+	if IsExtendedID(frame.ID) {
+		fmt.Fprintf(&sb, "%08X", frame.ID&can.MaskIDEff)
+	} else {
+		fmt.Fprintf(&sb, "%03X", frame.ID&can.MaskIDSff)
+	}
+	for _, b := range frame.Data[:frame.Length] {
+		fmt.Fprintf(&sb, " %02X", b)
+	}
+	return sb.String()
+}
+
+func parseYDRawFrame(fields []string) (can.Frame, error) {
+	id, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil || id > can.MaskIDEff {
+		return can.Frame{}, fmt.Errorf("invalid CAN ID %q", fields[0])
+	}
+	if len(fields[0]) > 3 {
+		id |= can.MaskEff
+	}
+	data := fields[1:]
+	if len(data) > can.MaxFrameDataLength {
+		return can.Frame{}, fmt.Errorf("too much data: %d bytes", len(data))
+	}
+
+	frame := can.Frame{ID: uint32(id), Length: uint8(len(data))}
+	for i, s := range data {
+		if len(s) != 2 {
+			return can.Frame{}, fmt.Errorf("invalid data byte %q", s)
+		}
+		if _, err := hex.Decode(frame.Data[i:i+1], []byte(s)); err != nil {
+			return can.Frame{}, err
+		}
+	}
+	return frame, nil
+}
+
+// parseTimeOfDay is a helper to parse hh:mm:ss.ddd (or hhmmss.ddd with an empty separator) into a duration since
+// midnight
+func parseTimeOfDay(s string, sep string) (time.Duration, error) {
+	parts := []string{}
+	rest := s
+	for i := 0; i < 2; i++ {
+		if len(rest) < 2+len(sep) || rest[2:2+len(sep)] != sep {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		parts = append(parts, rest[:2])
+		rest = rest[2+len(sep):]
+	}
+	if len(rest) < 2 || (len(rest) > 2 && rest[2] != '.') {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	parts = append(parts, rest)
+
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	seconds, err3 := strconv.ParseFloat(parts[2], 64)
+	if err := errors.Join(err1, err2, err3); err != nil || hours > 23 || minutes > 59 || seconds >= 61 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second)).Round(time.Millisecond), nil
+}
+
+// formatTimeOfDay is the inverse of parseTimeOfDay
+func formatTimeOfDay(d time.Duration, sep string) string {
+	d = d.Round(time.Millisecond)
+	return fmt.Sprintf("%02d%s%02d%s%02d.%03d",
+		int(d/time.Hour)%24, sep, int(d/time.Minute)%60, sep, int(d/time.Second)%60, int(d/time.Millisecond)%1000)
+}
+
+// timeOfDay is a helper to get the duration since local midnight for stamping outgoing lines
+func timeOfDay(t time.Time) time.Duration {
+	y, m, d := t.Date()
+	return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+}
@@ -0,0 +1,58 @@
+package canbus
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseYDRaw(t *testing.T) {
+	rec, err := ParseYDRaw("17:33:21.107 R 19F51323 01 2F 30 70 00 2F 30 70")
+	require.NoError(t, err)
+	require.Equal(t, YDRawRecord{
+		Time:      17*time.Hour + 33*time.Minute + 21107*time.Millisecond,
+		Direction: 'R',
+		Frame:     can.Frame{ID: 0x19F51323 | can.MaskEff, Length: 8, Data: [8]byte{0x01, 0x2F, 0x30, 0x70, 0x00, 0x2F, 0x30, 0x70}},
+	}, rec)
+	require.Equal(t, "17:33:21.107 R 19F51323 01 2F 30 70 00 2F 30 70", rec.String())
+
+	rec, err = ParseYDRaw("00:00:00.001 T 123")
+	require.NoError(t, err)
+	require.Equal(t, can.Frame{ID: 0x123}, rec.Frame)
+
+	rec, err = ParseYDRaw("00:00:00.001 T 00000123 01")
+	require.NoError(t, err)
+	require.Equal(t, can.Frame{ID: 0x123 | can.MaskEff, Length: 1, Data: [8]byte{0x01}}, rec.Frame)
+	require.Equal(t, "00:00:00.001 T 00000123 01", rec.String())
+
+	for _, bad := range []string{
+		"17:33:21.107 X 19F51323 01",
+		"17:33 R 19F51323 01",
+		"17:33:21.107 R 3FFFFFFF 01",
+		"17:33:21.107 R 19F51323 01 02 03 04 05 06 07 08 09",
+		"17:33:21.107 R 19F51323 1",
+	} {
+		_, err := ParseYDRaw(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestParseYDRawLog(t *testing.T) {
+	log := "16:29:27.082 R 09F8017F 50 C3 B8 13 47 D8 2B C6\r\n" +
+		"16:29:27.083 R 09F8027F 00 FC FF FF 00 00 FF FF\r\n"
+
+	var frames []can.Frame
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	for scanner.Scan() {
+		rec, err := ParseYDRaw(scanner.Text())
+		require.NoError(t, err)
+		frames = append(frames, rec.Frame)
+	}
+	require.Len(t, frames, 2)
+	require.Equal(t, uint32(129025), ParseN2KHeader(frames[0].ID).PGN)
+	require.Equal(t, uint32(129026), ParseN2KHeader(frames[1].ID).PGN)
+}
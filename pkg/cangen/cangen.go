@@ -0,0 +1,394 @@
+// Package cangen generates synthetic CAN traffic for load and soak testing: fixed or random IDs sent on a schedule,
+// in bursts, topped up with filler frames to hold the bus at a target load, or a mix of the PGNs a real NMEA 2000
+// network carries.  A Generator writes to any canbus.Interface.
+package cangen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultBitRate is the NMEA 2000 bitrate, used to work out bus load when GeneratorOptions.BitRate isn't set.
+	DefaultBitRate = 250000
+	// DefaultFillerID is the lowest priority extended ID there is, so filler frames lose arbitration to everything
+	// else on a real bus.
+	DefaultFillerID = can.MaskIDEff
+
+	// maxFastPacketLength is the most data a fast-packet series can carry
+	maxFastPacketLength = 223
+)
+
+// Stream is a message sent over and over on a schedule.
+type Stream struct {
+	// ID is the CAN ID to send with.  IDs above the 11-bit range are sent as extended frames; MaskEff may also be
+	// set to force an extended frame.
+	ID uint32
+	// RandomID picks a new ID between IDMin and IDMax, inclusive, for every message instead of using ID.
+	RandomID bool
+	IDMin    uint32
+	IDMax    uint32
+
+	// Data is the payload.  RandomData sends Length random bytes instead.
+	Data       []byte
+	RandomData bool
+	Length     int
+	// FastPacket sends the payload as an NMEA 2000 fast-packet series, which is required for payloads over 8
+	// bytes.  The ID is taken as an NMEA 2000 header.
+	FastPacket bool
+
+	// Period is the time between messages, and Offset delays the first.  Jitter adds up to this much random delay
+	// to each message without letting the schedule drift.
+	Period time.Duration
+	Offset time.Duration
+	Jitter time.Duration
+	// Burst sends this many messages back to back each period.  Zero is one.
+	Burst int
+}
+
+// GeneratorOptions is a type that contains options on a Generator.
+type GeneratorOptions struct {
+	Streams []Stream
+	// BitRate is the bus bitrate, used for BusLoad.  Zero is DefaultBitRate.
+	BitRate int
+	// BusLoad adds filler frames, random 8 byte frames with FillerID, to bring the bus up to this fraction (0-1) of
+	// its capacity.
+	BusLoad float64
+	// FillerID is the ID filler frames are sent with.  Zero is DefaultFillerID.
+	FillerID uint32
+	// Seed seeds the random IDs, data and jitter so a run can be repeated.  Zero picks a random seed.
+	Seed uint64
+	// Duration and MaxFrames stop Run after this long, or after this many frames.  Zero is no limit.
+	Duration  time.Duration
+	MaxFrames uint64
+	// IgnoreWriteErrors counts failed writes and carries on, instead of stopping Run at the first one.
+	IgnoreWriteErrors bool
+}
+
+// Event is a frame and when it's due, relative to the start of the run
+type Event struct {
+	At    time.Duration
+	Frame can.Frame
+}
+
+// Stats counts what a Generator has sent
+type Stats struct {
+	Frames      uint64
+	Bits        uint64
+	WriteErrors uint64
+	// MaxLag is the furthest behind schedule a frame went out, e.g. because the bus couldn't keep up.
+	MaxLag  time.Duration
+	Elapsed time.Duration
+}
+
+// Load is the fraction of a bus's capacity the sent frames used.
+func (s Stats) Load(bitRate int) float64 {
+	if s.Elapsed <= 0 || bitRate <= 0 {
+		return 0
+	}
+	return float64(s.Bits) / (float64(bitRate) * s.Elapsed.Seconds())
+}
+
+// streamState is where a stream is up to in the schedule
+type streamState struct {
+	stream Stream
+	// base is the undelayed time of the next message, and due is base plus its jitter
+	base time.Duration
+	due  time.Duration
+	seq  uint8
+}
+
+// Generator is a schedule of frames that can be written to a bus
+type Generator struct {
+	log     *logrus.Logger
+	options GeneratorOptions
+	rng     *rand.Rand
+	streams []*streamState
+	queue   []Event
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewGenerator checks the streams and builds the schedule, adding a filler stream if a bus load is asked for.
+func NewGenerator(log *logrus.Logger, options GeneratorOptions) (*Generator, error) {
+	if options.BitRate == 0 {
+		options.BitRate = DefaultBitRate
+	}
+	if options.FillerID == 0 {
+		options.FillerID = DefaultFillerID
+	}
+	if options.BitRate < 0 {
+		return nil, fmt.Errorf("invalid bitrate %d", options.BitRate)
+	}
+	if options.BusLoad < 0 || options.BusLoad > 1 {
+		return nil, fmt.Errorf("bus load %g isn't between 0 and 1", options.BusLoad)
+	}
+	if len(options.Streams) == 0 && options.BusLoad == 0 {
+		return nil, errors.New("nothing to generate")
+	}
+
+	seed := options.Seed
+	if seed == 0 {
+		seed = rand.Uint64() // #nosec G404 -- test traffic doesn't need cryptographic randomness.
+	}
+
+	g := &Generator{
+		log:     log,
+		options: options,
+		rng:     rand.New(rand.NewPCG(seed, seed)), // #nosec G404 -- test traffic doesn't need cryptographic randomness.
+	}
+
+	load := 0.0
+	for i, s := range options.Streams {
+		if err := validateStream(&s); err != nil {
+			return nil, fmt.Errorf("stream %d: %w", i, err)
+		}
+		load += StreamBitsPerSecond(s) / float64(options.BitRate)
+		g.addStream(s)
+	}
+
+	if options.BusLoad > 0 {
+		if filler, ok := fillerStream(options.FillerID, options.BusLoad-load, options.BitRate); ok {
+			g.addStream(filler)
+			log.WithField("period", filler.Period).Debug("Adding filler frames for bus load")
+		} else {
+			log.WithField("load", load).WithField("target", options.BusLoad).
+				Warn("Streams already exceed the target bus load")
+		}
+	}
+
+	return g, nil
+}
+
+// validateStream checks a stream and fills in its defaults
+func validateStream(s *Stream) error {
+	if s.Period <= 0 {
+		return fmt.Errorf("invalid period %v", s.Period)
+	}
+	if s.Offset < 0 || s.Jitter < 0 || s.Burst < 0 {
+		return errors.New("offset, jitter and burst can't be negative")
+	}
+	if s.Burst == 0 {
+		s.Burst = 1
+	}
+
+	if s.RandomID {
+		if s.IDMin > s.IDMax {
+			return fmt.Errorf("ID range %X-%X is backwards", s.IDMin, s.IDMax)
+		}
+		if s.IDMax&^can.MaskEff > can.MaskIDEff {
+			return fmt.Errorf("ID %X is out of range", s.IDMax)
+		}
+	} else if s.ID&^can.MaskEff > can.MaskIDEff {
+		return fmt.Errorf("ID %X is out of range", s.ID)
+	}
+
+	if !s.RandomData {
+		s.Length = len(s.Data)
+	}
+	limit := can.MaxFrameDataLength
+	if s.FastPacket {
+		limit = maxFastPacketLength
+	}
+	if s.Length < 0 || s.Length > limit {
+		return fmt.Errorf("%d bytes of data doesn't fit", s.Length)
+	}
+	return nil
+}
+
+// fillerStream is the stream of random 8 byte frames that adds the given fraction of load to a bus
+func fillerStream(id uint32, load float64, bitRate int) (Stream, bool) {
+	if load <= 0 {
+		return Stream{}, false
+	}
+	filler := Stream{ID: id | can.MaskEff, RandomData: true, Length: can.MaxFrameDataLength, Burst: 1}
+	bits := float64(FrameBits(can.Frame{ID: filler.ID, Length: can.MaxFrameDataLength}))
+	filler.Period = time.Duration(float64(time.Second) * bits / (load * float64(bitRate)))
+	return filler, filler.Period > 0
+}
+
+func (g *Generator) addStream(s Stream) {
+	st := &streamState{stream: s, base: s.Offset}
+	st.due = st.base + g.jitter(s)
+	g.streams = append(g.streams, st)
+}
+
+func (g *Generator) jitter(s Stream) time.Duration {
+	if s.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(g.rng.Int64N(int64(s.Jitter) + 1))
+}
+
+// Next returns the next frame in the schedule.  Frames come out in order of when they're due, and streams due at
+// the same time in the order they were given.
+func (g *Generator) Next() Event {
+	for len(g.queue) == 0 {
+		g.fill()
+	}
+	ev := g.queue[0]
+	g.queue = g.queue[1:]
+	return ev
+}
+
+// fill queues the frames of the stream that's due next, and moves the stream on a period
+func (g *Generator) fill() {
+	next := g.streams[0]
+	for _, st := range g.streams[1:] {
+		if st.due < next.due {
+			next = st
+		}
+	}
+
+	for range next.stream.Burst {
+		for _, frame := range g.message(next) {
+			g.queue = append(g.queue, Event{At: next.due, Frame: frame})
+		}
+	}
+
+	next.base += next.stream.Period
+	next.due = next.base + g.jitter(next.stream)
+}
+
+// message builds the frames for one message of a stream
+func (g *Generator) message(st *streamState) []can.Frame {
+	s := st.stream
+
+	id := s.ID
+	if s.RandomID {
+		id = s.IDMin + uint32(g.rng.Uint64N(uint64(s.IDMax-s.IDMin)+1))
+	}
+	if canbus.IsExtendedID(id) {
+		id |= can.MaskEff
+	}
+
+	data := s.Data
+	if s.RandomData {
+		data = make([]byte, s.Length)
+		for i := range data {
+			data[i] = byte(g.rng.Uint32())
+		}
+	}
+
+	if s.FastPacket {
+		msg := canbus.PGNMessage{N2KHeader: canbus.ParseN2KHeader(id), Data: data}
+		frames := msg.FastPacketFrames(st.seq)
+		st.seq = (st.seq + 1) & 0x7
+		return frames
+	}
+
+	frame := can.Frame{ID: id, Length: uint8(len(data))}
+	copy(frame.Data[:], data)
+	return []can.Frame{frame}
+}
+
+// Stats returns what's been sent so far.
+func (g *Generator) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.stats
+}
+
+// Run writes the schedule to a bus in real time until ctx is done or a limit is reached.  When the bus can't keep
+// up, frames go out as fast as it takes them until the schedule is caught up.
+func (g *Generator) Run(ctx context.Context, bus canbus.Interface) error {
+	start := time.Now()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	defer func() {
+		g.mu.Lock()
+		g.stats.Elapsed = time.Since(start)
+		g.mu.Unlock()
+	}()
+
+	for sent := uint64(0); g.options.MaxFrames == 0 || sent < g.options.MaxFrames; sent++ {
+		ev := g.Next()
+		if g.options.Duration > 0 && ev.At >= g.options.Duration {
+			// Sleep out the rest of the run, so Elapsed and the bus load it implies are right
+			ev.At = g.options.Duration
+		}
+
+		lag := time.Duration(0)
+		if wait := ev.At - time.Since(start); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-timer.C:
+			}
+		} else {
+			lag = -wait
+		}
+		if ctx.Err() != nil || (g.options.Duration > 0 && ev.At >= g.options.Duration) {
+			return nil
+		}
+
+		err := bus.WriteFrame(ev.Frame)
+
+		g.mu.Lock()
+		g.stats.MaxLag = max(g.stats.MaxLag, lag)
+		if err != nil {
+			g.stats.WriteErrors++
+		} else {
+			g.stats.Frames++
+			g.stats.Bits += FrameBits(ev.Frame)
+		}
+		g.mu.Unlock()
+
+		if err != nil {
+			if !g.options.IgnoreWriteErrors {
+				return fmt.Errorf("write frame: %w", err)
+			}
+			g.log.WithError(err).Debug("Writing generated frame failed")
+		}
+	}
+	return nil
+}
+
+// FrameBits is how many bits a frame takes on the wire, ignoring bit stuffing: SOF, arbitration, control, data,
+// CRC, ACK, EOF and the interframe space.  IDs beyond the 11-bit range count as extended.
+func FrameBits(frame can.Frame) uint64 {
+	n := uint64(min(int(frame.Length), can.MaxFrameDataLength))
+	if frame.ID&can.MaskRtr != 0 {
+		n = 0
+	}
+	if canbus.IsExtendedID(frame.ID) {
+		return 67 + 8*n
+	}
+	return 47 + 8*n
+}
+
+// StreamBitsPerSecond is roughly how much of a bus a stream uses, counting random ID streams as extended if their
+// range reaches the extended IDs.
+func StreamBitsPerSecond(s Stream) float64 {
+	if s.Period <= 0 {
+		return 0
+	}
+
+	id := s.ID
+	if s.RandomID {
+		id = s.IDMax
+	}
+	length := s.Length
+	if !s.RandomData {
+		length = len(s.Data)
+	}
+
+	bits := FrameBits(can.Frame{ID: id, Length: uint8(min(length, can.MaxFrameDataLength))})
+	if s.FastPacket {
+		frames := 1 + (max(length-6, 0)+6)/7
+		bits = uint64(frames) * FrameBits(can.Frame{ID: id | can.MaskEff, Length: can.MaxFrameDataLength})
+	}
+	return float64(bits) * float64(max(s.Burst, 1)) / s.Period.Seconds()
+}
@@ -0,0 +1,226 @@
+package cangen
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus/canbustest"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// failingBus returns a test bus whose writes all fail with err
+func failingBus(err error) *canbustest.Bus {
+	bus := canbustest.NewBus(nil)
+	bus.SetWriteErr(err)
+	return bus
+}
+
+func TestFrameBits(t *testing.T) {
+	require.Equal(t, uint64(47), FrameBits(can.Frame{ID: 0x123}))
+	require.Equal(t, uint64(111), FrameBits(can.Frame{ID: 0x123, Length: 8}))
+	require.Equal(t, uint64(131), FrameBits(can.Frame{ID: 0x09f80102, Length: 8}))
+	require.Equal(t, uint64(67), FrameBits(can.Frame{ID: 0x09f80102 | can.MaskEff | can.MaskRtr, Length: 8}))
+	require.Equal(t, uint64(75), FrameBits(can.Frame{ID: 0x001 | can.MaskEff, Length: 1}))
+}
+
+func TestGeneratorSchedule(t *testing.T) {
+	g, err := NewGenerator(logrus.StandardLogger(), GeneratorOptions{
+		Streams: []Stream{
+			{ID: 0x100, Data: []byte{1}, Period: 10 * time.Millisecond},
+			{ID: 0x200, Data: []byte{2}, Period: 25 * time.Millisecond, Offset: 5 * time.Millisecond, Burst: 2},
+		},
+	})
+	require.NoError(t, err)
+
+	type due struct {
+		at time.Duration
+		id uint32
+	}
+	var got []due
+	for range 8 {
+		ev := g.Next()
+		got = append(got, due{ev.At, ev.Frame.ID})
+	}
+	require.Equal(t, []due{
+		{0, 0x100},
+		{5 * time.Millisecond, 0x200},
+		{5 * time.Millisecond, 0x200},
+		{10 * time.Millisecond, 0x100},
+		{20 * time.Millisecond, 0x100},
+		{30 * time.Millisecond, 0x100},
+		{30 * time.Millisecond, 0x200},
+		{30 * time.Millisecond, 0x200},
+	}, got)
+}
+
+func TestGeneratorRandomIDsAreRepeatable(t *testing.T) {
+	options := GeneratorOptions{
+		Streams: []Stream{{RandomID: true, IDMin: 0x7f0, IDMax: 0x80f, RandomData: true, Length: 4, Period: time.Millisecond}},
+		Seed:    42,
+	}
+	first, err := NewGenerator(logrus.StandardLogger(), options)
+	require.NoError(t, err)
+	second, err := NewGenerator(logrus.StandardLogger(), options)
+	require.NoError(t, err)
+
+	sawExtended := false
+	for range 200 {
+		a, b := first.Next(), second.Next()
+		require.Equal(t, a, b)
+		id := a.Frame.ID & can.MaskIDEff
+		require.GreaterOrEqual(t, id, uint32(0x7f0))
+		require.LessOrEqual(t, id, uint32(0x80f))
+		require.Equal(t, id > can.MaskIDSff, a.Frame.ID&can.MaskEff != 0)
+		sawExtended = sawExtended || id > can.MaskIDSff
+		require.Equal(t, uint8(4), a.Frame.Length)
+	}
+	require.True(t, sawExtended)
+}
+
+func TestGeneratorJitterDoesNotDrift(t *testing.T) {
+	g, err := NewGenerator(logrus.StandardLogger(), GeneratorOptions{
+		Streams: []Stream{{ID: 0x100, Period: 10 * time.Millisecond, Jitter: 3 * time.Millisecond}},
+		Seed:    1,
+	})
+	require.NoError(t, err)
+
+	for i := range 100 {
+		at := g.Next().At
+		base := time.Duration(i) * 10 * time.Millisecond
+		require.GreaterOrEqual(t, at, base)
+		require.LessOrEqual(t, at, base+3*time.Millisecond)
+	}
+}
+
+func TestGeneratorFastPacket(t *testing.T) {
+	data := make([]byte, 20)
+	g, err := NewGenerator(logrus.StandardLogger(), GeneratorOptions{
+		Streams: []Stream{{ID: 0x09f80102, Data: data, FastPacket: true, Period: time.Second}},
+	})
+	require.NoError(t, err)
+
+	// 20 bytes is a first frame of 6 and two more of 7, and the sequence moves on with each message
+	for msg := range 9 {
+		for i := range 3 {
+			ev := g.Next()
+			require.Equal(t, time.Duration(msg)*time.Second, ev.At)
+			require.Equal(t, uint32(0x09f80102)|can.MaskEff, ev.Frame.ID)
+			require.Equal(t, uint8(msg%8)<<5|uint8(i), ev.Frame.Data[0])
+		}
+	}
+}
+
+func TestGeneratorBusLoad(t *testing.T) {
+	g, err := NewGenerator(logrus.StandardLogger(), GeneratorOptions{
+		Streams: []Stream{{ID: 0x100, Data: make([]byte, 8), Period: time.Millisecond}},
+		BitRate: 250000,
+		BusLoad: 0.5,
+	})
+	require.NoError(t, err)
+
+	// The 111 bit stream is 44.4% of the bus, so filler makes up the other 5.6%
+	var bits uint64
+	var last time.Duration
+	for range 10000 {
+		ev := g.Next()
+		bits += FrameBits(ev.Frame)
+		last = ev.At
+		if ev.Frame.ID != 0x100 {
+			require.Equal(t, uint32(DefaultFillerID)|can.MaskEff, ev.Frame.ID)
+		}
+	}
+	load := float64(bits) / (250000 * last.Seconds())
+	require.InDelta(t, 0.5, load, 0.01)
+
+	// Asking for less than the streams already use adds nothing
+	g, err = NewGenerator(logrus.StandardLogger(), GeneratorOptions{
+		Streams: []Stream{{ID: 0x100, Data: make([]byte, 8), Period: time.Millisecond}},
+		BusLoad: 0.1,
+	})
+	require.NoError(t, err)
+	require.Len(t, g.streams, 1)
+}
+
+func TestNewGeneratorValidates(t *testing.T) {
+	for name, options := range map[string]GeneratorOptions{
+		"nothing":        {},
+		"no period":      {Streams: []Stream{{ID: 0x100}}},
+		"bad load":       {BusLoad: 1.5},
+		"too much data":  {Streams: []Stream{{ID: 0x100, Data: make([]byte, 9), Period: time.Second}}},
+		"backwards":      {Streams: []Stream{{RandomID: true, IDMin: 2, IDMax: 1, Period: time.Second}}},
+		"ID too big":     {Streams: []Stream{{ID: 0x20000000, Period: time.Second}}},
+		"negative burst": {Streams: []Stream{{ID: 0x100, Period: time.Second, Burst: -1}}},
+	} {
+		_, err := NewGenerator(logrus.StandardLogger(), options)
+		require.Error(t, err, name)
+	}
+}
+
+func TestGeneratorRun(t *testing.T) {
+	g, err := NewGenerator(logrus.StandardLogger(), GeneratorOptions{
+		Streams:   []Stream{{ID: 0x100, Data: []byte{1, 2}, Period: 5 * time.Millisecond, Burst: 2}},
+		MaxFrames: 10,
+	})
+	require.NoError(t, err)
+
+	bus := canbustest.NewBus(nil)
+	start := time.Now()
+	require.NoError(t, g.Run(context.Background(), bus))
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	require.Len(t, bus.Frames(), 10)
+
+	stats := g.Stats()
+	require.Equal(t, uint64(10), stats.Frames)
+	require.Equal(t, 10*uint64(63), stats.Bits)
+	require.Positive(t, stats.Elapsed)
+	require.Positive(t, stats.Load(250000))
+}
+
+func TestGeneratorRunDuration(t *testing.T) {
+	g, err := NewGenerator(logrus.StandardLogger(), GeneratorOptions{
+		Streams:  []Stream{{ID: 0x100, Period: 10 * time.Millisecond}},
+		Duration: 45 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	bus := canbustest.NewBus(nil)
+	require.NoError(t, g.Run(context.Background(), bus))
+	require.Len(t, bus.Frames(), 5)
+	require.GreaterOrEqual(t, g.Stats().Elapsed, 45*time.Millisecond)
+}
+
+func TestGeneratorRunWriteErrors(t *testing.T) {
+	failure := errors.New("bus off")
+	options := GeneratorOptions{
+		Streams:   []Stream{{ID: 0x100, Period: time.Microsecond}},
+		MaxFrames: 5,
+	}
+
+	g, err := NewGenerator(logrus.StandardLogger(), options)
+	require.NoError(t, err)
+	require.ErrorIs(t, g.Run(context.Background(), failingBus(failure)), failure)
+	require.Equal(t, uint64(1), g.Stats().WriteErrors)
+
+	options.IgnoreWriteErrors = true
+	g, err = NewGenerator(logrus.StandardLogger(), options)
+	require.NoError(t, err)
+	require.NoError(t, g.Run(context.Background(), failingBus(failure)))
+	require.Equal(t, uint64(5), g.Stats().WriteErrors)
+	require.Zero(t, g.Stats().Frames)
+}
+
+func TestGeneratorRunStopsWithContext(t *testing.T) {
+	g, err := NewGenerator(logrus.StandardLogger(), GeneratorOptions{
+		Streams: []Stream{{ID: 0x100, Period: time.Hour, Offset: time.Hour}},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.NoError(t, g.Run(ctx, canbustest.NewBus(nil)))
+	require.Zero(t, g.Stats().Frames)
+}
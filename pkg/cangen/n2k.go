@@ -0,0 +1,110 @@
+package cangen
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/nmea2000"
+)
+
+// maxN2KDevices is how many devices fit in the NMEA 2000 address space, leaving out the null and broadcast
+// addresses
+const maxN2KDevices = 252
+
+// n2kPGN is a PGN a device sends, with its usual priority, rate and size
+type n2kPGN struct {
+	pgn        uint32
+	priority   uint8
+	period     time.Duration
+	length     int
+	fastPacket bool
+}
+
+// n2kDevice is a kind of device found on boats, by what it sends
+type n2kDevice struct {
+	pgns []n2kPGN
+}
+
+// The PGNs the nmea2000 package has no constants for, and how often every device sends a heartbeat
+const (
+	n2kRudder         uint32 = 127245
+	n2kRateOfTurn     uint32 = 127251
+	n2kAttitude       uint32 = 127257
+	n2kBatteryStatus  uint32 = 127508
+	n2kPositionGNSS   uint32 = 129029
+	n2kSystemTime     uint32 = 126992
+	n2kHeartbeatEvery        = time.Minute
+)
+
+// n2kDevices are the devices N2KMix cycles through, busiest first, with rates taken from typical NMEA 2000 gear
+var n2kDevices = []n2kDevice{
+	// gps
+	{pgns: []n2kPGN{
+		{pgn: nmea2000.PGNPositionRapid, priority: 2, period: 100 * time.Millisecond, length: 8},
+		{pgn: nmea2000.PGNCOGSOGRapid, priority: 2, period: 250 * time.Millisecond, length: 8},
+		{pgn: n2kPositionGNSS, priority: 3, period: time.Second, length: 43, fastPacket: true},
+		{pgn: n2kSystemTime, priority: 3, period: time.Second, length: 8},
+	}},
+	// compass
+	{pgns: []n2kPGN{
+		{pgn: nmea2000.PGNVesselHeading, priority: 2, period: 100 * time.Millisecond, length: 8},
+		{pgn: n2kRateOfTurn, priority: 2, period: 100 * time.Millisecond, length: 8},
+		{pgn: n2kAttitude, priority: 3, period: time.Second, length: 8},
+	}},
+	// engine
+	{pgns: []n2kPGN{
+		{pgn: nmea2000.PGNEngineParametersRapid, priority: 2, period: 100 * time.Millisecond, length: 8},
+		{pgn: nmea2000.PGNEngineParametersDynamic, priority: 2, period: 500 * time.Millisecond, length: 26, fastPacket: true},
+	}},
+	// wind
+	{pgns: []n2kPGN{
+		{pgn: nmea2000.PGNWindData, priority: 2, period: 100 * time.Millisecond, length: 8},
+	}},
+	// rudder
+	{pgns: []n2kPGN{
+		{pgn: n2kRudder, priority: 2, period: 100 * time.Millisecond, length: 8},
+	}},
+	// depth
+	{pgns: []n2kPGN{
+		{pgn: nmea2000.PGNWaterDepth, priority: 3, period: time.Second, length: 8},
+		{pgn: nmea2000.PGNSpeed, priority: 2, period: time.Second, length: 8},
+		{pgn: nmea2000.PGNTemperature, priority: 5, period: 2 * time.Second, length: 8},
+	}},
+	// battery
+	{pgns: []n2kPGN{
+		{pgn: n2kBatteryStatus, priority: 6, period: 1500 * time.Millisecond, length: 8},
+	}},
+}
+
+// N2KMix is the traffic of a network of NMEA 2000 devices: GPS, compass, engine, wind, rudder, depth and battery
+// sensors, repeated until there are as many devices as asked for.  Each device gets its own source address, from 1
+// up, and sends its PGNs at their usual rates with random data, plus a heartbeat.  Devices are staggered so they
+// don't all start at once, as on a real network.
+func N2KMix(devices int) ([]Stream, error) {
+	if devices < 1 || devices > maxN2KDevices {
+		return nil, fmt.Errorf("%d devices doesn't fit on an NMEA 2000 network", devices)
+	}
+
+	var streams []Stream
+	for i := range devices {
+		device := n2kDevices[i%len(n2kDevices)]
+		source := uint8(i + 1)
+		pgns := slices.Concat(device.pgns, []n2kPGN{
+			{pgn: nmea2000.PGNHeartbeat, priority: 7, period: n2kHeartbeatEvery, length: 8},
+		})
+		for j, p := range pgns {
+			h := canbus.N2KHeader{Priority: p.priority, PGN: p.pgn, Source: source, Destination: canbus.N2KBroadcast}
+			streams = append(streams, Stream{
+				ID:         h.ID(),
+				RandomData: true,
+				Length:     p.length,
+				FastPacket: p.fastPacket,
+				Period:     p.period,
+				Offset:     (time.Duration(i)*7*time.Millisecond + time.Duration(j)*time.Millisecond) % p.period,
+			})
+		}
+	}
+	return streams, nil
+}
@@ -0,0 +1,63 @@
+package cangen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/nmea2000"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestN2KMix(t *testing.T) {
+	streams, err := N2KMix(10)
+	require.NoError(t, err)
+
+	sources := map[uint8]bool{}
+	pgns := map[uint32]bool{}
+	load := 0.0
+	for _, s := range streams {
+		h := canbus.ParseN2KHeader(s.ID)
+		sources[h.Source] = true
+		pgns[h.PGN] = true
+		require.Equal(t, uint8(canbus.N2KBroadcast), h.Destination)
+		require.Less(t, s.Offset, s.Period)
+		load += StreamBitsPerSecond(s) / DefaultBitRate
+	}
+	require.Len(t, sources, 10)
+	require.True(t, pgns[nmea2000.PGNHeartbeat])
+	require.True(t, pgns[nmea2000.PGNVesselHeading])
+	require.True(t, pgns[n2kPositionGNSS])
+	// Ten devices is a busy but realistic network
+	require.InDelta(t, 0.07, load, 0.03)
+
+	_, err = NewGenerator(logrus.StandardLogger(), GeneratorOptions{Streams: streams})
+	require.NoError(t, err)
+
+	_, err = N2KMix(0)
+	require.Error(t, err)
+	_, err = N2KMix(253)
+	require.Error(t, err)
+}
+
+func TestN2KMixFastPacket(t *testing.T) {
+	streams, err := N2KMix(1)
+	require.NoError(t, err)
+
+	g, err := NewGenerator(logrus.StandardLogger(), GeneratorOptions{Streams: streams, Seed: 1})
+	require.NoError(t, err)
+
+	// The GPS's position report is a 43 byte fast-packet series, 7 frames
+	gnss := 0
+	for {
+		ev := g.Next()
+		if ev.At >= time.Second {
+			break
+		}
+		if canbus.ParseN2KHeader(ev.Frame.ID).PGN == n2kPositionGNSS {
+			gnss++
+		}
+	}
+	require.Equal(t, 7, gnss)
+}
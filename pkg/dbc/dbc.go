@@ -0,0 +1,605 @@
+// Package dbc parses Vector DBC files and uses them to decode and encode CAN frames for buses that aren't NMEA
+// 2000, such as engine ECUs, battery management systems and inverters.
+package dbc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/brutella/can"
+)
+
+// ByteOrder is an enum for how a signal's bits are laid out
+type ByteOrder int
+
+const (
+	// BigEndian is Motorola order ("@0"): the start bit is the most significant bit.
+	BigEndian ByteOrder = iota
+	// LittleEndian is Intel order ("@1"): the start bit is the least significant bit.
+	LittleEndian
+)
+
+// ValueType is an enum for how a signal's raw bits are interpreted, from SIG_VALTYPE_
+type ValueType int
+
+const (
+	ValueInteger ValueType = iota
+	ValueFloat32
+	ValueFloat64
+)
+
+// extendedIDFlag marks extended (29-bit) message IDs in DBC files
+const extendedIDFlag = 0x80000000
+
+// independentSignalsMessage is the pseudo-message Vector tools use to hold signals not attached to any message
+const independentSignalsMessage = "VECTOR__INDEPENDENT_SIG_MSG"
+
+// Signal is a single SG_ definition
+type Signal struct {
+	Name      string
+	StartBit  int
+	Length    int
+	ByteOrder ByteOrder
+	Signed    bool
+	ValueType ValueType
+	Factor    float64
+	Offset    float64
+	Min       float64
+	Max       float64
+	Unit      string
+	Receivers []string
+	Comment   string
+
+	// IsMultiplexer is set on the message's multiplexer switch ("M").
+	IsMultiplexer bool
+	// Multiplexed is set on signals only present when the switch equals MultiplexValue ("m<value>").
+	Multiplexed    bool
+	MultiplexValue uint64
+
+	// Values maps raw values to their descriptions, from VAL_.
+	Values map[int64]string
+}
+
+// Message is a single BO_ definition
+type Message struct {
+	// ID is the CAN identifier without the DBC extended flag; Extended says which kind it is.
+	ID          uint32
+	Extended    bool
+	Name        string
+	Length      int
+	Transmitter string
+	Signals     []*Signal
+	Comment     string
+}
+
+// Signal returns the named signal, if the message has it.
+func (m *Message) Signal(name string) (*Signal, bool) {
+	for _, s := range m.Signals {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Multiplexer returns the message's multiplexer switch signal, if it has one.
+func (m *Message) Multiplexer() (*Signal, bool) {
+	for _, s := range m.Signals {
+		if s.IsMultiplexer {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// messageKey identifies a message, as the same number can be used for a standard and an extended ID
+type messageKey struct {
+	id       uint32
+	extended bool
+}
+
+// Database is a parsed DBC file
+type Database struct {
+	Version  string
+	Nodes    []string
+	Messages []*Message
+	// ValueTables are the named VAL_TABLE_ definitions.
+	ValueTables map[string]map[int64]string
+
+	byID   map[messageKey]*Message
+	byName map[string]*Message
+}
+
+// ParseFile parses the DBC file at the given path.
+func ParseFile(path string) (*Database, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse parses a DBC file.  Statements this package doesn't use (attributes, environment variables, signal groups
+// and so on) are skipped.  Only simple multiplexing is supported; extended multiplexing (SG_MUL_VAL_) is ignored.
+func Parse(r io.Reader) (*Database, error) {
+	db := &Database{
+		ValueTables: map[string]map[int64]string{},
+		byID:        map[messageKey]*Message{},
+		byName:      map[string]*Message{},
+	}
+
+	var current *Message
+	err := readStatements(r, func(line int, tokens []string) error {
+		var err error
+		switch tokens[0] {
+		case "VERSION":
+			if len(tokens) > 1 {
+				db.Version = unquote(tokens[1])
+			}
+		case "BU_":
+			db.Nodes = append(db.Nodes, tokens[min(2, len(tokens)):]...)
+		case "BO_":
+			current, err = db.parseMessage(tokens)
+		case "SG_":
+			if current == nil {
+				return errors.New("SG_ outside of a BO_")
+			}
+			var sig *Signal
+			sig, err = parseSignal(tokens)
+			if err == nil {
+				current.Signals = append(current.Signals, sig)
+			}
+		case "CM_":
+			err = db.parseComment(tokens)
+		case "VAL_TABLE_":
+			err = db.parseValueTable(tokens)
+		case "VAL_":
+			err = db.parseValues(tokens)
+		case "SIG_VALTYPE_":
+			err = db.parseValueType(tokens)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Message returns the message for a frame ID.  The ID may carry the EFF flag (SocketCAN) or not (serial drivers);
+// IDs that don't fit in 11 bits are always looked up as extended.
+func (db *Database) Message(id uint32) (*Message, bool) {
+	extended := canbus.IsExtendedID(id)
+	id &= can.MaskIDEff
+	if m, ok := db.byID[messageKey{id: id, extended: extended}]; ok {
+		return m, true
+	}
+	if !extended {
+		m, ok := db.byID[messageKey{id: id, extended: true}]
+		return m, ok
+	}
+	return nil, false
+}
+
+// MessageByName returns the named message.
+func (db *Database) MessageByName(name string) (*Message, bool) {
+	m, ok := db.byName[name]
+	return m, ok
+}
+
+// parseMessage is a helper for "BO_ <id> <name>: <length> <transmitter>"
+func (db *Database) parseMessage(tokens []string) (*Message, error) {
+	if len(tokens) < 5 || tokens[3] != ":" {
+		return nil, errors.New("malformed BO_")
+	}
+	id, err := strconv.ParseUint(tokens[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad message ID %q", tokens[1])
+	}
+	length, err := strconv.Atoi(tokens[4])
+	if err != nil || length < 0 || length > 64 {
+		return nil, fmt.Errorf("bad message length %q", tokens[4])
+	}
+
+	m := &Message{
+		ID:       uint32(id) &^ extendedIDFlag,
+		Extended: uint32(id)&extendedIDFlag != 0,
+		Name:     tokens[2],
+		Length:   length,
+	}
+	if len(tokens) > 5 {
+		m.Transmitter = tokens[5]
+	}
+	if m.Name == independentSignalsMessage {
+		// Keep parsing its signals, but don't make them decodable.
+		return m, nil
+	}
+
+	db.Messages = append(db.Messages, m)
+	db.byID[messageKey{id: m.ID, extended: m.Extended}] = m
+	db.byName[m.Name] = m
+	return m, nil
+}
+
+// parseSignal is a helper for
+// "SG_ <name> [M|m<n>] : <start>|<length>@<order><sign> (<factor>,<offset>) [<min>|<max>] "<unit>" <receivers>"
+func parseSignal(tokens []string) (*Signal, error) {
+	if len(tokens) < 2 {
+		return nil, errors.New("malformed SG_")
+	}
+	sig := &Signal{Name: tokens[1]}
+
+	rest := tokens[2:]
+	if len(rest) > 0 && rest[0] != ":" {
+		if err := sig.parseMultiplex(rest[0]); err != nil {
+			return nil, err
+		}
+		rest = rest[1:]
+	}
+
+	// : start | length @ order+sign ( factor , offset ) [ min | max ] unit receivers...
+	if len(rest) < 17 || rest[0] != ":" || rest[2] != "|" || rest[4] != "@" || rest[6] != "(" || rest[8] != "," ||
+		rest[10] != ")" || rest[11] != "[" || rest[13] != "|" || rest[15] != "]" {
+		return nil, fmt.Errorf("malformed SG_ %s", sig.Name)
+	}
+
+	var err error
+	if sig.StartBit, err = strconv.Atoi(rest[1]); err != nil {
+		return nil, fmt.Errorf("signal %s: bad start bit %q", sig.Name, rest[1])
+	}
+	if sig.Length, err = strconv.Atoi(rest[3]); err != nil || sig.Length < 1 || sig.Length > 64 {
+		return nil, fmt.Errorf("signal %s: bad length %q", sig.Name, rest[3])
+	}
+	switch rest[5] {
+	case "0+", "0-":
+		sig.ByteOrder = BigEndian
+	case "1+", "1-":
+		sig.ByteOrder = LittleEndian
+	default:
+		return nil, fmt.Errorf("signal %s: bad byte order %q", sig.Name, rest[5])
+	}
+	sig.Signed = strings.HasSuffix(rest[5], "-")
+
+	floats := []*float64{&sig.Factor, &sig.Offset, &sig.Min, &sig.Max}
+	for i, idx := range []int{7, 9, 12, 14} {
+		if *floats[i], err = strconv.ParseFloat(rest[idx], 64); err != nil {
+			return nil, fmt.Errorf("signal %s: bad number %q", sig.Name, rest[idx])
+		}
+	}
+
+	sig.Unit = unquote(rest[16])
+	for _, receivers := range rest[17:] {
+		for _, r := range strings.Split(receivers, ",") {
+			if r != "" && r != "," {
+				sig.Receivers = append(sig.Receivers, r)
+			}
+		}
+	}
+
+	return sig, nil
+}
+
+// parseMultiplex is a helper for the "M", "m<n>" and "m<n>M" multiplexer indicators
+func (s *Signal) parseMultiplex(indicator string) error {
+	if indicator == "M" {
+		s.IsMultiplexer = true
+		return nil
+	}
+	if !strings.HasPrefix(indicator, "m") {
+		return fmt.Errorf("signal %s: bad multiplexer indicator %q", s.Name, indicator)
+	}
+
+	value := strings.TrimPrefix(indicator, "m")
+	if strings.HasSuffix(value, "M") {
+		// Extended multiplexing: this signal is itself a switch for others, which we don't support beyond
+		// treating it as a plain multiplexed signal.
+		value = strings.TrimSuffix(value, "M")
+	}
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("signal %s: bad multiplexer indicator %q", s.Name, indicator)
+	}
+	s.Multiplexed = true
+	s.MultiplexValue = v
+	return nil
+}
+
+// parseComment is a helper for "CM_ [BO_ <id> | SG_ <id> <signal> | BU_ <node>] "<text>";"
+func (db *Database) parseComment(tokens []string) error {
+	if len(tokens) < 2 {
+		return errors.New("malformed CM_")
+	}
+	text := unquote(tokens[len(tokens)-1])
+
+	switch tokens[1] {
+	case "BO_":
+		if len(tokens) < 4 {
+			return errors.New("malformed CM_ BO_")
+		}
+		if m, ok := db.messageByDBCID(tokens[2]); ok {
+			m.Comment = text
+		}
+	case "SG_":
+		if len(tokens) < 5 {
+			return errors.New("malformed CM_ SG_")
+		}
+		if s, ok := db.signal(tokens[2], tokens[3]); ok {
+			s.Comment = text
+		}
+	}
+	return nil
+}
+
+// parseValueTable is a helper for "VAL_TABLE_ <name> <value> "<description>" ... ;"
+func (db *Database) parseValueTable(tokens []string) error {
+	if len(tokens) < 2 {
+		return errors.New("malformed VAL_TABLE_")
+	}
+	values, err := parseValueDescriptions(tokens[2:])
+	if err != nil {
+		return err
+	}
+	db.ValueTables[tokens[1]] = values
+	return nil
+}
+
+// parseValues is a helper for "VAL_ <id> <signal> <value> "<description>" ... ;".  VAL_ for environment
+// variables has no message ID and is skipped.
+func (db *Database) parseValues(tokens []string) error {
+	if len(tokens) < 3 {
+		return errors.New("malformed VAL_")
+	}
+	if _, err := strconv.ParseUint(tokens[1], 10, 32); err != nil {
+		return nil
+	}
+	values, err := parseValueDescriptions(tokens[3:])
+	if err != nil {
+		return err
+	}
+	if s, ok := db.signal(tokens[1], tokens[2]); ok {
+		s.Values = values
+	}
+	return nil
+}
+
+// parseValueType is a helper for "SIG_VALTYPE_ <id> <signal> : <type>;"
+func (db *Database) parseValueType(tokens []string) error {
+	if len(tokens) < 5 || tokens[3] != ":" {
+		return errors.New("malformed SIG_VALTYPE_")
+	}
+	s, ok := db.signal(tokens[1], tokens[2])
+	if !ok {
+		return nil
+	}
+	switch tokens[4] {
+	case "0":
+		s.ValueType = ValueInteger
+	case "1":
+		s.ValueType = ValueFloat32
+		if s.Length != 32 {
+			return fmt.Errorf("signal %s: float32 must be 32 bits", s.Name)
+		}
+	case "2":
+		s.ValueType = ValueFloat64
+		if s.Length != 64 {
+			return fmt.Errorf("signal %s: float64 must be 64 bits", s.Name)
+		}
+	default:
+		return fmt.Errorf("signal %s: bad value type %q", s.Name, tokens[4])
+	}
+	return nil
+}
+
+// messageByDBCID is a helper to find a message by the ID as written in the file, extended flag and all
+func (db *Database) messageByDBCID(s string) (*Message, bool) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return nil, false
+	}
+	m, ok := db.byID[messageKey{id: uint32(id) &^ extendedIDFlag, extended: uint32(id)&extendedIDFlag != 0}]
+	return m, ok
+}
+
+func (db *Database) signal(messageID, name string) (*Signal, bool) {
+	m, ok := db.messageByDBCID(messageID)
+	if !ok {
+		return nil, false
+	}
+	return m.Signal(name)
+}
+
+// parseValueDescriptions is a helper for the "<value> "<description>" ..." lists in VAL_ and VAL_TABLE_
+func parseValueDescriptions(tokens []string) (map[int64]string, error) {
+	if len(tokens)%2 != 0 {
+		return nil, errors.New("unpaired value description")
+	}
+
+	values := make(map[int64]string, len(tokens)/2)
+	for i := 0; i < len(tokens); i += 2 {
+		v, err := strconv.ParseInt(tokens[i], 10, 64)
+		if err != nil {
+			// Some tools write large unsigned values.
+			u, uerr := strconv.ParseUint(tokens[i], 10, 64)
+			if uerr != nil {
+				return nil, fmt.Errorf("bad value %q", tokens[i])
+			}
+			v = int64(u)
+		}
+		values[v] = unquote(tokens[i+1])
+	}
+	return values, nil
+}
+
+// statementKeywords are statements that run until a semicolon, possibly over several lines
+var statementKeywords = map[string]bool{
+	"CM_":            true,
+	"VAL_":           true,
+	"VAL_TABLE_":     true,
+	"SIG_VALTYPE_":   true,
+	"BA_":            true,
+	"BA_DEF_":        true,
+	"BA_DEF_DEF_":    true,
+	"BA_DEF_REL_":    true,
+	"BA_REL_":        true,
+	"BA_DEF_SGTYPE_": true,
+	"EV_":            true,
+	"ENVVAR_DATA_":   true,
+	"SGTYPE_":        true,
+	"SIG_GROUP_":     true,
+	"SG_MUL_VAL_":    true,
+	"BO_TX_BU_":      true,
+	"SIG_TYPE_REF_":  true,
+}
+
+// readStatements is a helper to split a DBC file into tokenized statements, joining the ones that span lines and
+// skipping the NS_ block.  Quoted strings are kept as single tokens, quotes included.
+func readStatements(r io.Reader, handle func(line int, tokens []string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	inNS := false
+	var pending strings.Builder
+	pendingLine := 0
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+
+		if pending.Len() > 0 {
+			pending.WriteString("\n")
+			pending.WriteString(line)
+			if !statementComplete(pending.String()) {
+				continue
+			}
+			tokens, err := tokenize(pending.String())
+			pending.Reset()
+			if err != nil {
+				return fmt.Errorf("line %d: %w", pendingLine, err)
+			}
+			if err := handle(pendingLine, tokens); err != nil {
+				return err
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "//") {
+			continue
+		}
+		if inNS {
+			if line[0] == ' ' || line[0] == '\t' {
+				continue
+			}
+			inNS = false
+		}
+
+		keyword := strings.FieldsFunc(trimmed, func(r rune) bool { return r == ' ' || r == '\t' || r == ':' })[0]
+		if keyword == "NS_" {
+			inNS = true
+			continue
+		}
+		if statementKeywords[keyword] && !statementComplete(trimmed) {
+			pending.WriteString(trimmed)
+			pendingLine = lineNum
+			continue
+		}
+
+		tokens, err := tokenize(trimmed)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if err := handle(lineNum, tokens); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if pending.Len() > 0 {
+		return fmt.Errorf("line %d: unterminated statement", pendingLine)
+	}
+	return nil
+}
+
+// statementComplete reports whether a statement has its terminating semicolon outside of any string
+func statementComplete(s string) bool {
+	inString := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case ';':
+			if !inString {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tokenize is a helper to split a statement into words, quoted strings and the punctuation DBC uses.  The
+// terminating semicolon is dropped.
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == ';':
+			return tokens, nil
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		case strings.IndexByte(":|@()[],", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\r\n;\":|@()[],", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// unquote is a helper to strip the quotes (and escapes) from a string token
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	if strings.ContainsRune(s, '\\') {
+		s = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+	}
+	return s
+}
@@ -0,0 +1,171 @@
+package dbc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testDBC = `VERSION "1.2"
+
+NS_ :
+	NS_DESC_
+	CM_
+	BA_DEF_
+	VAL_
+
+BS_:
+
+BU_: ECU BMS Display
+
+VAL_TABLE_ GearTable 0 "Neutral" 1 "Forward" 2 "Reverse" ;
+
+BO_ 256 EngineData: 8 ECU
+ SG_ EngineSpeed : 7|16@0+ (0.125,0) [0|8031.875] "rpm" Display
+ SG_ CoolantTemp : 16|8@1+ (1,-40) [-40|215] "degC" Display,BMS
+ SG_ Current : 24|16@1- (0.1,0) [-3276.8|3276.7] "A" Display
+ SG_ Gear : 52|4@0+ (1,0) [0|15] "" Display
+
+BO_ 2566844926 BatteryCells: 8 BMS
+ SG_ CellGroup M : 0|8@1+ (1,0) [0|255] "" Display
+ SG_ Cell1 m0 : 8|16@1+ (0.001,0) [0|65.535] "V" Display
+ SG_ Cell2 m0 : 24|16@1+ (0.001,0) [0|65.535] "V" Display
+ SG_ Cell5 m1 : 8|16@1+ (0.001,0) [0|65.535] "V" Display
+ SG_ PackTemp : 32|32@1- (1,0) [0|0] "C" Display
+
+BO_ 3221225472 VECTOR__INDEPENDENT_SIG_MSG: 0 Vector__XXX
+ SG_ Orphan : 0|8@1+ (1,0) [0|0] "" Vector__XXX
+
+CM_ "Test database";
+CM_ BO_ 256 "Engine data
+sent every 100ms";
+CM_ SG_ 256 CoolantTemp "Engine \"coolant\" temperature";
+BA_DEF_ BO_ "GenMsgCycleTime" INT 0 65535;
+BA_ "GenMsgCycleTime" BO_ 256 100;
+VAL_ 256 Gear 0 "Neutral" 1 "Forward" 2 "Reverse" ;
+VAL_ 2566844926 CellGroup 0 "Cells 1-4" 1 "Cells 5-8" ;
+SIG_VALTYPE_ 2566844926 PackTemp : 1;
+`
+
+func parseTestDBC(t *testing.T) *Database {
+	t.Helper()
+
+	db, err := Parse(strings.NewReader(testDBC))
+	require.NoError(t, err)
+	return db
+}
+
+func TestParse(t *testing.T) {
+	db := parseTestDBC(t)
+
+	require.Equal(t, "1.2", db.Version)
+	require.Equal(t, []string{"ECU", "BMS", "Display"}, db.Nodes)
+	require.Len(t, db.Messages, 2)
+	require.Equal(t, map[int64]string{0: "Neutral", 1: "Forward", 2: "Reverse"}, db.ValueTables["GearTable"])
+
+	engine, ok := db.MessageByName("EngineData")
+	require.True(t, ok)
+	require.Equal(t, uint32(256), engine.ID)
+	require.False(t, engine.Extended)
+	require.Equal(t, 8, engine.Length)
+	require.Equal(t, "ECU", engine.Transmitter)
+	require.Equal(t, "Engine data\nsent every 100ms", engine.Comment)
+	require.Len(t, engine.Signals, 4)
+
+	speed, ok := engine.Signal("EngineSpeed")
+	require.True(t, ok)
+	require.Equal(t, &Signal{
+		Name:      "EngineSpeed",
+		StartBit:  7,
+		Length:    16,
+		ByteOrder: BigEndian,
+		Factor:    0.125,
+		Max:       8031.875,
+		Unit:      "rpm",
+		Receivers: []string{"Display"},
+	}, speed)
+
+	coolant, _ := engine.Signal("CoolantTemp")
+	require.Equal(t, LittleEndian, coolant.ByteOrder)
+	require.Equal(t, -40.0, coolant.Offset)
+	require.Equal(t, -40.0, coolant.Min)
+	require.Equal(t, []string{"Display", "BMS"}, coolant.Receivers)
+	require.Equal(t, `Engine "coolant" temperature`, coolant.Comment)
+
+	current, _ := engine.Signal("Current")
+	require.True(t, current.Signed)
+
+	gear, _ := engine.Signal("Gear")
+	require.Equal(t, "Forward", gear.Values[1])
+
+	cells, ok := db.MessageByName("BatteryCells")
+	require.True(t, ok)
+	require.Equal(t, uint32(0x18fef1fe), cells.ID)
+	require.True(t, cells.Extended)
+	mux, ok := cells.Multiplexer()
+	require.True(t, ok)
+	require.Equal(t, "CellGroup", mux.Name)
+	cell5, _ := cells.Signal("Cell5")
+	require.True(t, cell5.Multiplexed)
+	require.Equal(t, uint64(1), cell5.MultiplexValue)
+	packTemp, _ := cells.Signal("PackTemp")
+	require.Equal(t, ValueFloat32, packTemp.ValueType)
+
+	_, ok = db.MessageByName(independentSignalsMessage)
+	require.False(t, ok)
+}
+
+func TestMessageLookup(t *testing.T) {
+	db := parseTestDBC(t)
+
+	m, ok := db.Message(256)
+	require.True(t, ok)
+	require.Equal(t, "EngineData", m.Name)
+
+	// Extended IDs are found with or without the EFF flag
+	m, ok = db.Message(0x18fef1fe)
+	require.True(t, ok)
+	require.Equal(t, "BatteryCells", m.Name)
+	m, ok = db.Message(0x98fef1fe)
+	require.True(t, ok)
+	require.Equal(t, "BatteryCells", m.Name)
+
+	// A standard ID doesn't match an extended message with the EFF flag set
+	_, ok = db.Message(0x80000100)
+	require.False(t, ok)
+	_, ok = db.Message(257)
+	require.False(t, ok)
+}
+
+func TestParseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.dbc")
+	require.NoError(t, os.WriteFile(path, []byte(testDBC), 0o600))
+
+	db, err := ParseFile(path)
+	require.NoError(t, err)
+	require.Len(t, db.Messages, 2)
+
+	_, err = ParseFile(filepath.Join(t.TempDir(), "missing.dbc"))
+	require.Error(t, err)
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, dbc := range map[string]string{
+		"signal without message": ` SG_ A : 0|8@1+ (1,0) [0|0] "" X`,
+		"bad message id":         `BO_ abc Foo: 8 X`,
+		"bad byte order":         "BO_ 1 Foo: 8 X\n SG_ A : 0|8@2+ (1,0) [0|0] \"\" X",
+		"truncated signal":       "BO_ 1 Foo: 8 X\n SG_ A : 0|8@1+ (1,0)",
+		"bad multiplexer":        "BO_ 1 Foo: 8 X\n SG_ A x1 : 0|8@1+ (1,0) [0|0] \"\" X",
+		"unterminated comment":   `CM_ "never ends`,
+		"unpaired value":         "BO_ 1 Foo: 8 X\n SG_ A : 0|8@1+ (1,0) [0|0] \"\" X\nVAL_ 1 A 0 \"Off\" 1 ;",
+		"bad float length":       "BO_ 1 Foo: 8 X\n SG_ A : 0|8@1+ (1,0) [0|0] \"\" X\nSIG_VALTYPE_ 1 A : 1;",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(dbc))
+			require.Error(t, err)
+		})
+	}
+}
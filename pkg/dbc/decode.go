@@ -0,0 +1,240 @@
+package dbc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/brutella/can"
+)
+
+// SignalValue is a decoded signal
+type SignalValue struct {
+	Signal *Signal
+	// Raw is the signal's bits, sign-extended for signed signals.
+	Raw int64
+	// Value is the physical value: Raw scaled by the factor and offset, or the float for float signals.
+	Value float64
+}
+
+// Label returns the value description for the raw value, if the signal has one.
+func (v SignalValue) Label() (string, bool) {
+	label, ok := v.Signal.Values[v.Raw]
+	return label, ok
+}
+
+// Quantity returns the value as a units type, if the signal's unit is one we know.
+func (v SignalValue) Quantity() (any, bool) {
+	return ToUnits(v.Signal.Unit, v.Value)
+}
+
+// DecodedMessage is a frame decoded against its message definition
+type DecodedMessage struct {
+	Message *Message
+	Signals []SignalValue
+}
+
+// Signal returns the named signal's value, if it was present in the frame.
+func (m *DecodedMessage) Signal(name string) (SignalValue, bool) {
+	for _, s := range m.Signals {
+		if s.Signal.Name == name {
+			return s, true
+		}
+	}
+	return SignalValue{}, false
+}
+
+// Decode looks up the frame's message and decodes its signals.
+func (db *Database) Decode(frame can.Frame) (*DecodedMessage, error) {
+	m, ok := db.Message(frame.ID)
+	if !ok {
+		return nil, fmt.Errorf("unknown message ID 0x%x", frame.ID&can.MaskIDEff)
+	}
+	if frame.Length > can.MaxFrameDataLength {
+		return nil, fmt.Errorf("invalid frame length %d", frame.Length)
+	}
+
+	return &DecodedMessage{
+		Message: m,
+		Signals: m.Decode(frame.Data[:frame.Length]),
+	}, nil
+}
+
+// Decode decodes every signal present in the data.  Multiplexed signals are only included when the multiplexer
+// selects them, and signals that run past the end of short data are left out.
+func (m *Message) Decode(data []byte) []SignalValue {
+	var muxValue uint64
+	mux, hasMux := m.Multiplexer()
+	if hasMux {
+		raw, ok := extractSignal(data, mux)
+		if !ok {
+			hasMux = false
+		}
+		muxValue = raw
+	}
+
+	values := make([]SignalValue, 0, len(m.Signals))
+	for _, s := range m.Signals {
+		if s.Multiplexed && (!hasMux || s.MultiplexValue != muxValue) {
+			continue
+		}
+		raw, ok := extractSignal(data, s)
+		if !ok {
+			continue
+		}
+		values = append(values, s.decode(raw))
+	}
+	return values
+}
+
+// decode is a helper to turn a signal's raw bits into its value
+func (s *Signal) decode(raw uint64) SignalValue {
+	v := SignalValue{Signal: s, Raw: int64(raw)}
+	switch s.ValueType {
+	case ValueFloat32:
+		v.Value = float64(math.Float32frombits(uint32(raw)))
+	case ValueFloat64:
+		v.Value = math.Float64frombits(raw)
+	default:
+		if s.Signed && s.Length < 64 && raw&(1<<(s.Length-1)) != 0 {
+			v.Raw = int64(raw | ^uint64(0)<<s.Length)
+		}
+		v.Value = float64(v.Raw)*s.Factor + s.Offset
+	}
+	return v
+}
+
+// Encode builds a frame for the named message from physical signal values.
+func (db *Database) Encode(name string, values map[string]float64) (can.Frame, error) {
+	m, ok := db.MessageByName(name)
+	if !ok {
+		return can.Frame{}, fmt.Errorf("unknown message %q", name)
+	}
+	return m.Encode(values)
+}
+
+// Encode builds a frame from physical signal values.  Signals that aren't given are sent as raw zero.  If any
+// multiplexed signal is given, the multiplexer must be too and must select it.  Extended IDs get the EFF flag so
+// the frame can go straight to any channel's WriteFrame.
+func (m *Message) Encode(values map[string]float64) (can.Frame, error) {
+	if m.Length > can.MaxFrameDataLength {
+		return can.Frame{}, fmt.Errorf("message %s is %d bytes, too long for classic CAN", m.Name, m.Length)
+	}
+
+	frame := can.Frame{ID: m.ID, Length: uint8(m.Length)}
+	if m.Extended {
+		frame.ID |= can.MaskEff
+	}
+
+	var muxValue uint64
+	mux, hasMux := m.Multiplexer()
+	if hasMux {
+		if v, ok := values[mux.Name]; ok {
+			raw, err := mux.encode(v)
+			if err != nil {
+				return can.Frame{}, err
+			}
+			muxValue = raw
+		} else {
+			hasMux = false
+		}
+	}
+
+	for name, v := range values {
+		s, ok := m.Signal(name)
+		if !ok {
+			return can.Frame{}, fmt.Errorf("message %s has no signal %q", m.Name, name)
+		}
+		if s.Multiplexed && (!hasMux || s.MultiplexValue != muxValue) {
+			return can.Frame{}, fmt.Errorf("signal %s isn't selected by the multiplexer", name)
+		}
+		raw, err := s.encode(v)
+		if err != nil {
+			return can.Frame{}, err
+		}
+		if err := insertSignal(frame.Data[:frame.Length], s, raw); err != nil {
+			return can.Frame{}, err
+		}
+	}
+
+	return frame, nil
+}
+
+// encode is a helper to turn a physical value into the signal's raw bits
+func (s *Signal) encode(v float64) (uint64, error) {
+	switch s.ValueType {
+	case ValueFloat32:
+		return uint64(math.Float32bits(float32(v))), nil
+	case ValueFloat64:
+		return math.Float64bits(v), nil
+	}
+
+	if s.Factor == 0 {
+		return 0, fmt.Errorf("signal %s has a zero factor", s.Name)
+	}
+	raw := math.Round((v - s.Offset) / s.Factor)
+
+	minRaw, maxRaw := 0.0, math.Ldexp(1, s.Length)-1
+	if s.Signed {
+		minRaw, maxRaw = -math.Ldexp(1, s.Length-1), math.Ldexp(1, s.Length-1)-1
+	}
+	if raw < minRaw || raw > maxRaw {
+		return 0, fmt.Errorf("signal %s: value %v doesn't fit in %d bits", s.Name, v, s.Length)
+	}
+
+	if raw < 0 {
+		return uint64(int64(raw)), nil
+	}
+	return uint64(raw), nil
+}
+
+// signalBits returns the data bit positions of a signal, most significant first
+func signalBits(s *Signal) []int {
+	bits := make([]int, s.Length)
+	if s.ByteOrder == LittleEndian {
+		for i := range bits {
+			bits[s.Length-1-i] = s.StartBit + i
+		}
+		return bits
+	}
+
+	// Motorola signals run from the start bit down through each byte, then on to the top of the next byte.
+	pos := s.StartBit
+	for i := range bits {
+		bits[i] = pos
+		if pos%8 == 0 {
+			pos += 15
+		} else {
+			pos--
+		}
+	}
+	return bits
+}
+
+// extractSignal is a helper to pull a signal's raw bits out of the data, reporting false if it doesn't fit
+func extractSignal(data []byte, s *Signal) (uint64, bool) {
+	var v uint64
+	for _, bit := range signalBits(s) {
+		if bit < 0 || bit/8 >= len(data) {
+			return 0, false
+		}
+		v = v<<1 | uint64(data[bit/8]>>(bit%8)&1)
+	}
+	return v, true
+}
+
+// insertSignal is the inverse of extractSignal
+func insertSignal(data []byte, s *Signal, v uint64) error {
+	bits := signalBits(s)
+	for i, bit := range bits {
+		if bit < 0 || bit/8 >= len(data) {
+			return errors.New("signal " + s.Name + " doesn't fit in the message")
+		}
+		if v>>(len(bits)-1-i)&1 != 0 {
+			data[bit/8] |= 1 << (bit % 8)
+		} else {
+			data[bit/8] &^= 1 << (bit % 8)
+		}
+	}
+	return nil
+}
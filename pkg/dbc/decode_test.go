@@ -0,0 +1,142 @@
+package dbc
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/boatkit-io/tugboat/pkg/units"
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	db := parseTestDBC(t)
+
+	frame := can.Frame{ID: 256, Length: 8, Data: [8]byte{0x1f, 0x40, 120, 0x83, 0xff, 0, 0x04, 0}}
+	msg, err := db.Decode(frame)
+	require.NoError(t, err)
+	require.Equal(t, "EngineData", msg.Message.Name)
+	require.Len(t, msg.Signals, 4)
+
+	speed, ok := msg.Signal("EngineSpeed")
+	require.True(t, ok)
+	require.Equal(t, int64(8000), speed.Raw)
+	require.Equal(t, 1000.0, speed.Value)
+
+	coolant, _ := msg.Signal("CoolantTemp")
+	require.Equal(t, 80.0, coolant.Value)
+	q, ok := coolant.Quantity()
+	require.True(t, ok)
+	require.Equal(t, units.NewTemperature(units.Celsius, 80), q)
+
+	current, _ := msg.Signal("Current")
+	require.Equal(t, int64(-125), current.Raw)
+	require.InDelta(t, -12.5, current.Value, 1e-9)
+	_, ok = current.Quantity()
+	require.False(t, ok)
+
+	gear, _ := msg.Signal("Gear")
+	require.Equal(t, 2.0, gear.Value)
+	label, ok := gear.Label()
+	require.True(t, ok)
+	require.Equal(t, "Reverse", label)
+
+	_, err = db.Decode(can.Frame{ID: 0x123, Length: 8})
+	require.Error(t, err)
+}
+
+func TestDecodeMultiplexed(t *testing.T) {
+	db := parseTestDBC(t)
+
+	data := [8]byte{0, 0xa0, 0x0f, 0x68, 0x10}
+	binary.LittleEndian.PutUint32(data[4:], math.Float32bits(24.5))
+	msg, err := db.Decode(can.Frame{ID: 0x98fef1fe, Length: 8, Data: data})
+	require.NoError(t, err)
+
+	cell1, ok := msg.Signal("Cell1")
+	require.True(t, ok)
+	require.InDelta(t, 4.0, cell1.Value, 1e-9)
+	_, ok = msg.Signal("Cell5")
+	require.False(t, ok)
+	group, _ := msg.Signal("CellGroup")
+	label, _ := group.Label()
+	require.Equal(t, "Cells 1-4", label)
+
+	packTemp, ok := msg.Signal("PackTemp")
+	require.True(t, ok)
+	require.Equal(t, 24.5, packTemp.Value)
+
+	data[0] = 1
+	msg, err = db.Decode(can.Frame{ID: 0x18fef1fe, Length: 8, Data: data})
+	require.NoError(t, err)
+	_, ok = msg.Signal("Cell1")
+	require.False(t, ok)
+	cell5, ok := msg.Signal("Cell5")
+	require.True(t, ok)
+	require.InDelta(t, 4.0, cell5.Value, 1e-9)
+}
+
+func TestDecodeShortFrame(t *testing.T) {
+	db := parseTestDBC(t)
+
+	msg, err := db.Decode(can.Frame{ID: 256, Length: 3, Data: [8]byte{0x1f, 0x40, 120}})
+	require.NoError(t, err)
+	_, ok := msg.Signal("EngineSpeed")
+	require.True(t, ok)
+	_, ok = msg.Signal("Current")
+	require.False(t, ok)
+}
+
+func TestEncode(t *testing.T) {
+	db := parseTestDBC(t)
+
+	frame, err := db.Encode("EngineData", map[string]float64{
+		"EngineSpeed": 1000,
+		"CoolantTemp": 80,
+		"Current":     -12.5,
+		"Gear":        2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, can.Frame{ID: 256, Length: 8, Data: [8]byte{0x1f, 0x40, 120, 0x83, 0xff, 0, 0x04, 0}}, frame)
+
+	frame, err = db.Encode("BatteryCells", map[string]float64{"CellGroup": 1, "Cell5": 3.3, "PackTemp": -5.25})
+	require.NoError(t, err)
+	require.Equal(t, uint32(can.MaskEff|0x18fef1fe), frame.ID)
+
+	msg, err := db.Decode(frame)
+	require.NoError(t, err)
+	cell5, ok := msg.Signal("Cell5")
+	require.True(t, ok)
+	require.InDelta(t, 3.3, cell5.Value, 1e-9)
+	packTemp, _ := msg.Signal("PackTemp")
+	require.Equal(t, -5.25, packTemp.Value)
+
+	_, err = db.Encode("Nope", nil)
+	require.Error(t, err)
+	_, err = db.Encode("EngineData", map[string]float64{"Nope": 1})
+	require.ErrorContains(t, err, "no signal")
+	_, err = db.Encode("EngineData", map[string]float64{"CoolantTemp": 300})
+	require.ErrorContains(t, err, "doesn't fit")
+	_, err = db.Encode("EngineData", map[string]float64{"Current": -4000})
+	require.ErrorContains(t, err, "doesn't fit")
+	_, err = db.Encode("BatteryCells", map[string]float64{"Cell5": 3.3})
+	require.ErrorContains(t, err, "multiplexer")
+	_, err = db.Encode("BatteryCells", map[string]float64{"CellGroup": 0, "Cell5": 3.3})
+	require.ErrorContains(t, err, "multiplexer")
+}
+
+func TestSignalBits(t *testing.T) {
+	// Motorola signals that cross byte boundaries away from the byte edge
+	s := &Signal{Name: "X", StartBit: 12, Length: 10, ByteOrder: BigEndian}
+	require.Equal(t, []int{12, 11, 10, 9, 8, 23, 22, 21, 20, 19}, signalBits(s))
+
+	data := make([]byte, 3)
+	require.NoError(t, insertSignal(data, s, 0x2a5))
+	v, ok := extractSignal(data, s)
+	require.True(t, ok)
+	require.Equal(t, uint64(0x2a5), v)
+
+	s = &Signal{Name: "Y", StartBit: 60, Length: 8, ByteOrder: LittleEndian}
+	require.Error(t, insertSignal(make([]byte, 8), s, 1))
+}
@@ -0,0 +1,59 @@
+package dbc
+
+import (
+	"strings"
+
+	"github.com/boatkit-io/tugboat/pkg/units"
+)
+
+// unitConversions maps the unit strings commonly found in DBC files (lowercased) to units values
+var unitConversions = map[string]func(v float32) any{
+	"m/s":   func(v float32) any { return units.NewVelocity(units.MetersPerSecond, v) },
+	"km/h":  func(v float32) any { return units.NewVelocity(units.Kph, v) },
+	"kph":   func(v float32) any { return units.NewVelocity(units.Kph, v) },
+	"mph":   func(v float32) any { return units.NewVelocity(units.Mph, v) },
+	"kn":    func(v float32) any { return units.NewVelocity(units.Knots, v) },
+	"kt":    func(v float32) any { return units.NewVelocity(units.Knots, v) },
+	"knots": func(v float32) any { return units.NewVelocity(units.Knots, v) },
+
+	"m":   func(v float32) any { return units.NewDistance(units.Meter, v) },
+	"km":  func(v float32) any { return units.NewDistance(units.Meter, v*1000) },
+	"ft":  func(v float32) any { return units.NewDistance(units.Foot, v) },
+	"mi":  func(v float32) any { return units.NewDistance(units.Mile, v) },
+	"nmi": func(v float32) any { return units.NewDistance(units.NauticalMile, v) },
+
+	"k":    func(v float32) any { return units.NewTemperature(units.Kelvin, v) },
+	"degc": func(v float32) any { return units.NewTemperature(units.Celsius, v) },
+	"°c":   func(v float32) any { return units.NewTemperature(units.Celsius, v) },
+	"c":    func(v float32) any { return units.NewTemperature(units.Celsius, v) },
+	"degf": func(v float32) any { return units.NewTemperature(units.Fahrenheit, v) },
+	"°f":   func(v float32) any { return units.NewTemperature(units.Fahrenheit, v) },
+	"f":    func(v float32) any { return units.NewTemperature(units.Fahrenheit, v) },
+
+	"pa":   func(v float32) any { return units.NewPressure(units.Pa, v) },
+	"hpa":  func(v float32) any { return units.NewPressure(units.Hpa, v) },
+	"mbar": func(v float32) any { return units.NewPressure(units.Hpa, v) },
+	"kpa":  func(v float32) any { return units.NewPressure(units.Pa, v*1000) },
+	"bar":  func(v float32) any { return units.NewPressure(units.Pa, v*100000) },
+	"psi":  func(v float32) any { return units.NewPressure(units.Psi, v) },
+
+	"l":   func(v float32) any { return units.NewVolume(units.Liter, v) },
+	"m3":  func(v float32) any { return units.NewVolume(units.MetersCubed, v) },
+	"gal": func(v float32) any { return units.NewVolume(units.Gallon, v) },
+
+	"l/h":   func(v float32) any { return units.NewFlow(units.LitersPerHour, v) },
+	"gal/h": func(v float32) any { return units.NewFlow(units.GallonsPerHour, v) },
+	"gph":   func(v float32) any { return units.NewFlow(units.GallonsPerHour, v) },
+	"gpm":   func(v float32) any { return units.NewFlow(units.GallonsPerMinute, v) },
+}
+
+// ToUnits converts a value in the given DBC unit string into the matching units type (units.Velocity,
+// units.Distance, units.Temperature, units.Pressure, units.Volume or units.Flow), reporting false for units it
+// doesn't know.
+func ToUnits(unit string, value float64) (any, bool) {
+	conv, ok := unitConversions[strings.ToLower(strings.TrimSpace(unit))]
+	if !ok {
+		return nil, false
+	}
+	return conv(float32(value)), true
+}
@@ -0,0 +1,37 @@
+package dbc
+
+import (
+	"testing"
+
+	"github.com/boatkit-io/tugboat/pkg/units"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToUnits(t *testing.T) {
+	v, ok := ToUnits("km/h", 18)
+	require.True(t, ok)
+	require.Equal(t, units.NewVelocity(units.Kph, 18), v)
+
+	v, ok = ToUnits(" kPa", 101.5)
+	require.True(t, ok)
+	require.Equal(t, units.NewPressure(units.Pa, 101500), v)
+
+	v, ok = ToUnits("L/h", 12)
+	require.True(t, ok)
+	require.Equal(t, units.NewFlow(units.LitersPerHour, 12), v)
+
+	v, ok = ToUnits("degF", 100)
+	require.True(t, ok)
+	require.Equal(t, units.NewTemperature(units.Fahrenheit, 100), v)
+
+	v, ok = ToUnits("gal", 20)
+	require.True(t, ok)
+	require.Equal(t, units.NewVolume(units.Gallon, 20), v)
+
+	v, ok = ToUnits("nmi", 2)
+	require.True(t, ok)
+	require.Equal(t, units.NewDistance(units.NauticalMile, 2), v)
+
+	_, ok = ToUnits("rpm", 1000)
+	require.False(t, ok)
+}
@@ -0,0 +1,461 @@
+package nmea2000
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/service"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultHeartbeatInterval is the NMEA 2000 standard heartbeat interval
+const defaultHeartbeatInterval = 60 * time.Second
+
+// minHeartbeatInterval and maxHeartbeatInterval bound what the heartbeat's 10ms offset field can carry; 0xfffe
+// and 0xffff are reserved
+const (
+	minHeartbeatInterval = 10 * time.Millisecond
+	maxHeartbeatInterval = 0xfffd * 10 * time.Millisecond
+)
+
+// deviceRequestQueueLen is how many ISO requests (and contending address claims) we buffer before dropping them
+const deviceRequestQueueLen = 32
+
+// addressClaimWait is how long a device waits after claiming an address for another device to contest it, before
+// it sends anything else
+const addressClaimWait = 250 * time.Millisecond
+
+// maxClaimableAddress is the highest address a device may claim; 252 and up are reserved
+const maxClaimableAddress = 251
+
+// productInfoStringLen is the fixed length of each string in Product Information
+const productInfoStringLen = 32
+
+// ProductInfo is the content of PGN 126996 Product Information
+type ProductInfo struct {
+	// NMEA2000Version is the version of the standard the device complies with, in thousandths: 2100 is 2.100.
+	NMEA2000Version uint16
+	// ProductCode is the code assigned to the product by NMEA.
+	ProductCode     uint16
+	ModelID         string
+	SoftwareVersion string
+	ModelVersion    string
+	ModelSerialCode string
+	// CertificationLevel is 0 (level A) or 1 (level B).
+	CertificationLevel uint8
+	// LoadEquivalency is the bus load in units of 50mA.
+	LoadEquivalency uint8
+}
+
+// ConfigurationInfo is the content of PGN 126998 Configuration Information
+type ConfigurationInfo struct {
+	InstallationDescription1 string
+	InstallationDescription2 string
+	ManufacturerInformation  string
+}
+
+// DeviceOptions is a type that contains required options on a Device.
+type DeviceOptions struct {
+	// Name is the ISO NAME the device claims its address with, which is how displays tell devices apart.  Its
+	// UniqueNumber must be unique among devices with the same ManufacturerCode.
+	Name DeviceName
+	// Address is the source address the device claims.  If another device with a lower NAME has it, the device
+	// moves to the next free address if Name is ArbitraryAddressCapable, and otherwise goes quiet.
+	Address           uint8
+	ProductInfo       ProductInfo
+	ConfigurationInfo ConfigurationInfo
+	// TransmitPGNs and ReceivePGNs are advertised in the PGN list, along with the PGNs the device itself handles.
+	TransmitPGNs []uint32
+	ReceivePGNs  []uint32
+	// HeartbeatInterval defaults to 60 seconds, and has to be between 10ms and about 655 seconds.
+	HeartbeatInterval time.Duration
+}
+
+// isoRequest is a single request waiting to be answered
+type isoRequest struct {
+	pgn       uint32
+	requester uint8
+	// addressed is set when the request was sent to us rather than broadcast
+	addressed bool
+}
+
+// Device makes a tugboat process show up as a proper NMEA 2000 device: it claims an address, answers ISO requests
+// for its address claim, product information, configuration information and the PGN list, and sends the
+// heartbeat.  It doesn't own the bus; received frames must be passed in through HandleFrame, typically from the
+// channel's frame handler.
+type Device struct {
+	options  DeviceOptions
+	bus      canbus.Interface
+	registry *Registry
+
+	requests  chan isoRequest
+	claims    chan uint64
+	claimWait time.Duration
+	done      chan struct{}
+	doneOnce  sync.Once
+
+	mu            sync.Mutex
+	started       bool
+	address       uint8
+	moves         int
+	fastPacketSeq uint8
+	heartbeatSeq  uint8
+
+	log *logrus.Logger
+}
+
+// Ensure that Device implements the Activity and Starter interfaces.
+var (
+	_ service.Activity = &Device{}
+	_ service.Starter  = &Device{}
+)
+
+// NewDevice returns a new device on the given bus with the given options.
+func NewDevice(log *logrus.Logger, bus canbus.Interface, options DeviceOptions) *Device {
+	if options.HeartbeatInterval == 0 {
+		options.HeartbeatInterval = defaultHeartbeatInterval
+	}
+
+	return &Device{
+		options:   options,
+		bus:       bus,
+		registry:  NewRegistry(),
+		requests:  make(chan isoRequest, deviceRequestQueueLen),
+		claims:    make(chan uint64, deviceRequestQueueLen),
+		claimWait: addressClaimWait,
+		done:      make(chan struct{}),
+		address:   options.Address,
+		log:       log,
+	}
+}
+
+// Name returns the name of the Device Activity.
+func (*Device) Name() string {
+	return "nmea2000-device"
+}
+
+// Address returns the address the device has claimed, or 254 if it couldn't claim one.
+func (d *Device) Address() uint8 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.address
+}
+
+// HandleFrame looks for ISO requests addressed to the device (or broadcast), and other devices claiming its
+// address, and queues them to be dealt with by Run.  Replies aren't sent from here, since some channels can't write
+// from inside their own frame handler.
+func (d *Device) HandleFrame(frame can.Frame) {
+	h := canbus.ParseN2KHeader(frame.ID)
+	address := d.Address()
+
+	if h.PGN == PGNISOAddressClaim && frame.Length == 8 {
+		name := binary.LittleEndian.Uint64(frame.Data[:])
+		if h.Source != address || address == nullAddress || name == d.options.Name.Uint64() {
+			return
+		}
+		select {
+		case d.claims <- name:
+		default:
+			d.log.Debug("Dropped address claim")
+		}
+		return
+	}
+
+	if h.PGN != PGNISORequest || frame.Length < 3 {
+		return
+	}
+	if h.Destination != address && h.Destination != canbus.N2KBroadcast {
+		return
+	}
+
+	req := isoRequest{
+		pgn:       uint32(frame.Data[0]) | uint32(frame.Data[1])<<8 | uint32(frame.Data[2])<<16,
+		requester: h.Source,
+		addressed: h.Destination != canbus.N2KBroadcast,
+	}
+	select {
+	case d.requests <- req:
+	default:
+		d.log.WithField("pgn", req.pgn).Debug("Dropped ISO request")
+	}
+}
+
+// Start claims the device's address and waits for other devices to contest it, moving to another address if
+// one with a lower NAME does.  Run calls it if it hasn't been called already.
+func (d *Device) Start(ctx context.Context) error {
+	if d.options.HeartbeatInterval < minHeartbeatInterval || d.options.HeartbeatInterval > maxHeartbeatInterval {
+		return fmt.Errorf("heartbeat interval %v is out of range", d.options.HeartbeatInterval)
+	}
+
+	d.mu.Lock()
+	started := d.started
+	d.started = true
+	d.mu.Unlock()
+	if started {
+		return nil
+	}
+
+	d.send(d.addressClaim())
+
+	timer := time.NewTimer(d.claimWait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// The claim didn't finish, so a later Start has to make it again.
+			d.mu.Lock()
+			d.started = false
+			d.mu.Unlock()
+			return ctx.Err()
+		case <-d.done:
+			return nil
+		case name := <-d.claims:
+			if d.contest(name) {
+				// A new address has to go unchallenged for the full wait too.
+				timer.Reset(d.claimWait)
+			}
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// Run sends the heartbeat and answers ISO requests until the context is canceled or Shutdown is called.
+func (d *Device) Run(ctx context.Context) error {
+	if err := d.Start(ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(d.options.HeartbeatInterval)
+	defer ticker.Stop()
+
+	d.sendHeartbeat()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.done:
+			return nil
+		case <-ticker.C:
+			d.sendHeartbeat()
+		case name := <-d.claims:
+			d.contest(name)
+		case req := <-d.requests:
+			for _, msg := range d.answer(req) {
+				d.send(msg)
+			}
+		}
+	}
+}
+
+// contest is a helper to settle another device claiming our address: the lower NAME keeps it.  It sends our claim
+// for whatever address we end up with (or that we can't claim one), and reports whether we moved.
+func (d *Device) contest(name uint64) bool {
+	d.mu.Lock()
+	moved := false
+	switch {
+	case name > d.options.Name.Uint64():
+		// We keep the address, and say so again.
+	case d.options.Name.ArbitraryAddressCapable && d.moves < maxClaimableAddress:
+		d.address = (d.address + 1) % (maxClaimableAddress + 1)
+		d.moves++
+		moved = true
+	default:
+		d.address = nullAddress
+	}
+	address := d.address
+	d.mu.Unlock()
+
+	switch {
+	case moved:
+		d.log.WithField("address", address).Info("Moved to a new NMEA 2000 address")
+	case address == nullAddress:
+		d.log.Warn("Couldn't claim an NMEA 2000 address")
+	}
+	d.send(d.addressClaim())
+	return moved
+}
+
+// Shutdown stops Run.
+func (d *Device) Shutdown(_ context.Context) error {
+	d.doneOnce.Do(func() {
+		close(d.done)
+	})
+	return nil
+}
+
+// Kill stops Run.
+func (d *Device) Kill() error {
+	return d.Shutdown(context.Background())
+}
+
+// answer is a helper to build the replies to a single ISO request.  A device that couldn't claim an address only
+// answers for its address claim.
+func (d *Device) answer(req isoRequest) []canbus.PGNMessage {
+	if req.pgn == PGNISOAddressClaim {
+		return []canbus.PGNMessage{d.addressClaim()}
+	}
+	if d.Address() == nullAddress {
+		return nil
+	}
+
+	switch req.pgn {
+	case PGNProductInformation:
+		return []canbus.PGNMessage{d.message(PGNProductInformation, canbus.N2KBroadcast, d.productInfo())}
+	case PGNConfigurationInformation:
+		return []canbus.PGNMessage{d.message(PGNConfigurationInformation, canbus.N2KBroadcast, d.configurationInfo())}
+	case PGNPGNList:
+		return []canbus.PGNMessage{
+			d.message(PGNPGNList, canbus.N2KBroadcast, pgnList(0, d.transmitPGNs())),
+			d.message(PGNPGNList, canbus.N2KBroadcast, pgnList(1, d.receivePGNs())),
+		}
+	case PGNHeartbeat:
+		return []canbus.PGNMessage{d.heartbeat()}
+	}
+
+	// Only requests sent to us get a NAK; nobody expects every device to answer a broadcast.
+	if !req.addressed {
+		return nil
+	}
+	data := []byte{1, 0xff, 0xff, 0xff, 0xff, byte(req.pgn), byte(req.pgn >> 8), byte(req.pgn >> 16)}
+	return []canbus.PGNMessage{d.message(PGNISOAcknowledgement, req.requester, data)}
+}
+
+// send is a helper to write a message to the bus, splitting it into a fast-packet series if needed
+func (d *Device) send(msg canbus.PGNMessage) {
+	d.mu.Lock()
+	seq := d.fastPacketSeq
+	d.fastPacketSeq = (d.fastPacketSeq + 1) & 0x7
+	d.mu.Unlock()
+
+	for _, frame := range d.registry.Frames(msg, seq) {
+		if err := d.bus.WriteFrame(frame); err != nil {
+			d.log.WithError(err).WithField("pgn", msg.PGN).Warn("Failed to send NMEA 2000 message")
+			return
+		}
+	}
+}
+
+func (d *Device) message(pgn uint32, destination uint8, data []byte) canbus.PGNMessage {
+	priority := uint8(6)
+	if pgn == PGNHeartbeat {
+		priority = 7
+	}
+	return canbus.PGNMessage{
+		N2KHeader: canbus.N2KHeader{
+			Priority:    priority,
+			PGN:         pgn,
+			Source:      d.Address(),
+			Destination: destination,
+		},
+		Data: data,
+	}
+}
+
+// addressClaim is a helper to build our 60928 address claim, which is just the NAME
+func (d *Device) addressClaim() canbus.PGNMessage {
+	return d.message(PGNISOAddressClaim, canbus.N2KBroadcast, binary.LittleEndian.AppendUint64(nil, d.options.Name.Uint64()))
+}
+
+// sendHeartbeat is a helper to send the next heartbeat, unless we couldn't claim an address
+func (d *Device) sendHeartbeat() {
+	if d.Address() != nullAddress {
+		d.send(d.heartbeat())
+	}
+}
+
+// heartbeat is a helper to build the next 126993 heartbeat
+func (d *Device) heartbeat() canbus.PGNMessage {
+	d.mu.Lock()
+	seq := d.heartbeatSeq
+	// 0xfe and 0xff are reserved values for the counter.
+	d.heartbeatSeq = (d.heartbeatSeq + 1) % 0xfe
+	d.mu.Unlock()
+
+	data := []byte{0, 0, seq, 0xff, 0xff, 0xff, 0xff, 0xff}
+	binary.LittleEndian.PutUint16(data, uint16(d.options.HeartbeatInterval/(10*time.Millisecond)))
+	// Controller 1 and 2 error active, equipment operational; the rest is reserved.
+	data[3] = 0xc0
+
+	return d.message(PGNHeartbeat, canbus.N2KBroadcast, data)
+}
+
+// productInfo is a helper to build the 126996 payload: two numbers, four fixed-length strings padded with 0xff,
+// and two more numbers
+func (d *Device) productInfo() []byte {
+	info := d.options.ProductInfo
+
+	data := make([]byte, 0, 4+4*productInfoStringLen+2)
+	data = binary.LittleEndian.AppendUint16(data, info.NMEA2000Version)
+	data = binary.LittleEndian.AppendUint16(data, info.ProductCode)
+	for _, s := range []string{info.ModelID, info.SoftwareVersion, info.ModelVersion, info.ModelSerialCode} {
+		field := make([]byte, productInfoStringLen)
+		n := copy(field, s)
+		for i := n; i < len(field); i++ {
+			field[i] = 0xff
+		}
+		data = append(data, field...)
+	}
+	return append(data, info.CertificationLevel, info.LoadEquivalency)
+}
+
+// configurationInfo is a helper to build the 126998 payload: three length-prefixed ASCII strings
+func (d *Device) configurationInfo() []byte {
+	info := d.options.ConfigurationInfo
+
+	var data []byte
+	for _, s := range []string{info.InstallationDescription1, info.InstallationDescription2, info.ManufacturerInformation} {
+		// Strings are capped so the whole message fits the 223 byte fast-packet limit.
+		if len(s) > 70 {
+			s = s[:70]
+		}
+		// The length byte counts itself and the encoding byte, and 1 means ASCII.
+		data = append(data, byte(len(s)+2), 1)
+		data = append(data, s...)
+	}
+	return data
+}
+
+// transmitPGNs returns the sorted, deduplicated list of PGNs the device sends
+func (d *Device) transmitPGNs() []uint32 {
+	own := []uint32{
+		PGNISOAcknowledgement, PGNISOAddressClaim, PGNPGNList, PGNHeartbeat, PGNProductInformation,
+		PGNConfigurationInformation,
+	}
+	return sortedPGNs(append(own, d.options.TransmitPGNs...))
+}
+
+// receivePGNs returns the sorted, deduplicated list of PGNs the device listens for
+func (d *Device) receivePGNs() []uint32 {
+	return sortedPGNs(append([]uint32{PGNISORequest, PGNISOAddressClaim}, d.options.ReceivePGNs...))
+}
+
+func sortedPGNs(pgns []uint32) []uint32 {
+	sort.Slice(pgns, func(i, j int) bool { return pgns[i] < pgns[j] })
+
+	out := pgns[:0]
+	for i, pgn := range pgns {
+		if i == 0 || pgn != pgns[i-1] {
+			out = append(out, pgn)
+		}
+	}
+	return out
+}
+
+// pgnList is a helper to build a 126464 payload: the function code (0 transmit, 1 receive) and 3 bytes per PGN
+func pgnList(function byte, pgns []uint32) []byte {
+	data := make([]byte, 0, 1+3*len(pgns))
+	data = append(data, function)
+	for _, pgn := range pgns {
+		data = append(data, byte(pgn), byte(pgn>>8), byte(pgn>>16))
+	}
+	return data
+}
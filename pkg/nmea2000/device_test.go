@@ -0,0 +1,287 @@
+package nmea2000
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/canbus/canbustest"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// messages reassembles everything written to the bus so far
+func messages(bus *canbustest.Bus) []canbus.PGNMessage {
+	a := NewFastPacketAssembler(NewRegistry())
+	var msgs []canbus.PGNMessage
+	for _, f := range bus.Frames() {
+		if msg, ok := a.Add(f); ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func waitForMessages(t *testing.T, bus *canbustest.Bus, n int) []canbus.PGNMessage {
+	t.Helper()
+
+	var msgs []canbus.PGNMessage
+	require.Eventually(t, func() bool {
+		msgs = messages(bus)
+		return len(msgs) >= n
+	}, time.Second, time.Millisecond)
+	return msgs
+}
+
+func isoRequestFrame(source, destination uint8, pgn uint32) can.Frame {
+	h := canbus.N2KHeader{Priority: 6, PGN: PGNISORequest, Source: source, Destination: destination}
+	return can.Frame{ID: h.ID(), Length: 3, Data: [8]byte{byte(pgn), byte(pgn >> 8), byte(pgn >> 16)}}
+}
+
+func startDevice(t *testing.T, options DeviceOptions) (*Device, *canbustest.Bus) {
+	t.Helper()
+
+	bus := canbustest.NewBus(nil)
+	d := NewDevice(logrus.New(), bus, options)
+	d.claimWait = 10 * time.Millisecond
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- d.Run(context.Background())
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, d.Shutdown(context.Background()))
+		require.NoError(t, <-runDone)
+	})
+
+	return d, bus
+}
+
+func TestDeviceHeartbeat(t *testing.T) {
+	_, bus := startDevice(t, DeviceOptions{Address: 42, HeartbeatInterval: 20 * time.Millisecond})
+
+	msgs := waitForMessages(t, bus, 4)
+	require.Equal(t, PGNISOAddressClaim, msgs[0].PGN)
+	for i, msg := range msgs[1:4] {
+		require.Equal(t, PGNHeartbeat, msg.PGN)
+		require.Equal(t, uint8(42), msg.Source)
+		require.Equal(t, uint8(7), msg.Priority)
+
+		decoded, err := NewRegistry().Decode(msg.N2KHeader, msg.Data)
+		require.NoError(t, err)
+		offset, _ := decoded.Float("Data Transmit Offset")
+		require.InDelta(t, 0.02, offset, 1e-9)
+		seq, _ := decoded.Uint("Sequence Counter")
+		require.Equal(t, uint64(i), seq)
+		status, ok := decoded.Uint("Equipment Status")
+		require.True(t, ok)
+		require.Equal(t, uint64(0), status)
+	}
+}
+
+func TestDeviceProductInfo(t *testing.T) {
+	d, bus := startDevice(t, DeviceOptions{
+		Address: 42,
+		ProductInfo: ProductInfo{
+			NMEA2000Version:    2100,
+			ProductCode:        1234,
+			ModelID:            "Tugboat Gateway",
+			SoftwareVersion:    "1.2.3",
+			ModelVersion:       "A",
+			ModelSerialCode:    "SN0001",
+			CertificationLevel: 1,
+			LoadEquivalency:    2,
+		},
+	})
+	waitForMessages(t, bus, 2)
+
+	d.HandleFrame(isoRequestFrame(7, 42, PGNProductInformation))
+	msgs := waitForMessages(t, bus, 3)
+	msg := msgs[2]
+	require.Equal(t, PGNProductInformation, msg.PGN)
+	require.Len(t, msg.Data, 134)
+	require.Equal(t, uint16(2100), binary.LittleEndian.Uint16(msg.Data[0:]))
+	require.Equal(t, uint16(1234), binary.LittleEndian.Uint16(msg.Data[2:]))
+	require.Equal(t, "Tugboat Gateway", string(msg.Data[4:19]))
+	require.Equal(t, byte(0xff), msg.Data[19])
+	require.Equal(t, "SN0001", string(msg.Data[100:106]))
+
+	decoded, err := NewRegistry().Decode(msg.N2KHeader, msg.Data)
+	require.NoError(t, err)
+	version, _ := decoded.Float("NMEA 2000 Version")
+	require.InDelta(t, 2.1, version, 1e-9)
+	lea, _ := decoded.Uint("Load Equivalency")
+	require.Equal(t, uint64(2), lea)
+}
+
+func TestDeviceConfigurationInfoAndPGNList(t *testing.T) {
+	d, bus := startDevice(t, DeviceOptions{
+		Address: 42,
+		ConfigurationInfo: ConfigurationInfo{
+			InstallationDescription1: "Engine room",
+			ManufacturerInformation:  "boatkit",
+		},
+		TransmitPGNs: []uint32{PGNTemperature, PGNFluidLevel, PGNTemperature},
+		ReceivePGNs:  []uint32{PGNWaterDepth},
+	})
+	waitForMessages(t, bus, 2)
+
+	d.HandleFrame(isoRequestFrame(7, canbus.N2KBroadcast, PGNConfigurationInformation))
+	msgs := waitForMessages(t, bus, 3)
+	require.Equal(t, PGNConfigurationInformation, msgs[2].PGN)
+	require.Equal(t, append(append([]byte{13, 1}, "Engine room"...), append([]byte{2, 1, 9, 1}, "boatkit"...)...),
+		msgs[2].Data)
+
+	d.HandleFrame(isoRequestFrame(7, 42, PGNPGNList))
+	msgs = waitForMessages(t, bus, 5)
+	require.Equal(t, PGNPGNList, msgs[3].PGN)
+	require.Equal(t, pgnList(0, []uint32{
+		PGNISOAcknowledgement, PGNISOAddressClaim, PGNPGNList, PGNHeartbeat, PGNProductInformation,
+		PGNConfigurationInformation, PGNFluidLevel, PGNTemperature,
+	}), msgs[3].Data)
+	require.Equal(t, PGNPGNList, msgs[4].PGN)
+	require.Equal(t, []byte{1, 0x00, 0xea, 0x00, 0x00, 0xee, 0x00, 0x0b, 0xf5, 0x01}, msgs[4].Data)
+}
+
+func TestDeviceUnsupportedRequest(t *testing.T) {
+	d, bus := startDevice(t, DeviceOptions{Address: 42})
+	waitForMessages(t, bus, 2)
+
+	// Broadcast requests for things we don't send are ignored, as are requests for other devices
+	d.HandleFrame(isoRequestFrame(7, canbus.N2KBroadcast, PGNWaterDepth))
+	d.HandleFrame(isoRequestFrame(7, 43, PGNProductInformation))
+	d.HandleFrame(isoRequestFrame(7, 42, PGNWaterDepth))
+
+	msgs := waitForMessages(t, bus, 3)
+	require.Len(t, msgs, 3)
+	nak := msgs[2]
+	require.Equal(t, PGNISOAcknowledgement, nak.PGN)
+	require.Equal(t, uint8(7), nak.Destination)
+	require.Equal(t, uint8(42), nak.Source)
+
+	decoded, err := NewRegistry().Decode(nak.N2KHeader, nak.Data)
+	require.NoError(t, err)
+	control, _ := decoded.Uint("Control")
+	require.Equal(t, uint64(1), control)
+	pgn, _ := decoded.Uint("PGN")
+	require.Equal(t, uint64(PGNWaterDepth), pgn)
+}
+
+func TestDeviceAddressClaim(t *testing.T) {
+	d, bus := startDevice(t, DeviceOptions{Name: testName, Address: 42})
+
+	msgs := waitForMessages(t, bus, 2)
+	claim := msgs[0]
+	require.Equal(t, PGNISOAddressClaim, claim.PGN)
+	require.Equal(t, uint8(42), claim.Source)
+	require.Equal(t, uint8(canbus.N2KBroadcast), claim.Destination)
+	require.Equal(t, testName, ParseDeviceName(binary.LittleEndian.Uint64(claim.Data)))
+	require.Equal(t, PGNHeartbeat, msgs[1].PGN)
+
+	// Our own claim echoed back isn't a contest
+	d.HandleFrame(addressClaimFrame(42, testName))
+	d.HandleFrame(isoRequestFrame(7, canbus.N2KBroadcast, PGNISOAddressClaim))
+	msgs = waitForMessages(t, bus, 3)
+	require.Len(t, msgs, 3)
+	require.Equal(t, claim, msgs[2])
+	require.Equal(t, uint8(42), d.Address())
+}
+
+func TestDeviceAddressContention(t *testing.T) {
+	lower, higher := testName, testName
+	lower.UniqueNumber--
+	higher.UniqueNumber++
+
+	t.Run("keeps address against a higher name", func(t *testing.T) {
+		d, bus := startDevice(t, DeviceOptions{Name: testName, Address: 42})
+		waitForMessages(t, bus, 2)
+
+		d.HandleFrame(addressClaimFrame(42, higher))
+		msgs := waitForMessages(t, bus, 3)
+		require.Equal(t, PGNISOAddressClaim, msgs[2].PGN)
+		require.Equal(t, uint8(42), msgs[2].Source)
+		require.Equal(t, uint8(42), d.Address())
+	})
+
+	t.Run("moves on from a lower name", func(t *testing.T) {
+		d, bus := startDevice(t, DeviceOptions{Name: testName, Address: 251, HeartbeatInterval: 20 * time.Millisecond})
+		waitForMessages(t, bus, 2)
+
+		d.HandleFrame(addressClaimFrame(251, lower))
+		require.Eventually(t, func() bool { return d.Address() == 0 }, time.Second, time.Millisecond)
+
+		msgs := waitForMessages(t, bus, 5)
+		var claimed bool
+		for _, msg := range msgs[2:] {
+			if msg.PGN == PGNISOAddressClaim {
+				require.Equal(t, uint8(0), msg.Source)
+				claimed = true
+			} else if claimed {
+				require.Equal(t, uint8(0), msg.Source)
+			}
+		}
+		require.True(t, claimed)
+	})
+
+	t.Run("goes quiet if it can't move", func(t *testing.T) {
+		name := testName
+		name.ArbitraryAddressCapable = false
+		lowerFixed := name
+		lowerFixed.UniqueNumber--
+		d, bus := startDevice(t, DeviceOptions{Name: name, Address: 42})
+		waitForMessages(t, bus, 2)
+
+		d.HandleFrame(addressClaimFrame(42, lowerFixed))
+		msgs := waitForMessages(t, bus, 3)
+		require.Equal(t, PGNISOAddressClaim, msgs[2].PGN)
+		require.Equal(t, uint8(nullAddress), msgs[2].Source)
+		require.Equal(t, uint8(nullAddress), d.Address())
+
+		// Only address claim requests are answered now
+		d.HandleFrame(isoRequestFrame(7, canbus.N2KBroadcast, PGNProductInformation))
+		d.HandleFrame(isoRequestFrame(7, canbus.N2KBroadcast, PGNISOAddressClaim))
+		msgs = waitForMessages(t, bus, 4)
+		require.Len(t, msgs, 4)
+		require.Equal(t, msgs[2], msgs[3])
+	})
+}
+
+func TestDeviceHeartbeatIntervalRange(t *testing.T) {
+	for _, interval := range []time.Duration{-time.Second, 5 * time.Millisecond, time.Hour, 0xfffe * 10 * time.Millisecond} {
+		d := NewDevice(logrus.New(), canbustest.NewBus(nil), DeviceOptions{HeartbeatInterval: interval})
+		require.Error(t, d.Start(context.Background()))
+		require.Error(t, d.Run(context.Background()))
+	}
+}
+
+func TestDeviceStartCanceled(t *testing.T) {
+	bus := canbustest.NewBus(nil)
+	d := NewDevice(logrus.New(), bus, DeviceOptions{Address: 42})
+	d.claimWait = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, d.Start(ctx), context.Canceled)
+
+	// The canceled claim doesn't count; the next Start claims again and waits it out.
+	start := time.Now()
+	require.NoError(t, d.Start(context.Background()))
+	require.GreaterOrEqual(t, time.Since(start), d.claimWait)
+	require.Len(t, bus.Frames(), 2)
+}
+
+func TestDeviceKill(t *testing.T) {
+	d := NewDevice(logrus.New(), canbustest.NewBus(nil), DeviceOptions{})
+	require.Equal(t, "nmea2000-device", d.Name())
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- d.Run(context.Background())
+	}()
+	require.NoError(t, d.Kill())
+	require.NoError(t, d.Kill())
+	require.NoError(t, <-runDone)
+}
@@ -0,0 +1,370 @@
+package nmea2000
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/service"
+	"github.com/boatkit-io/tugboat/pkg/subscribableevent"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDirectoryTimeout is three missed heartbeats at the standard interval
+const defaultDirectoryTimeout = 3 * defaultHeartbeatInterval
+
+// minDirectoryPruneInterval keeps Run's ticker valid for tiny timeouts
+const minDirectoryPruneInterval = time.Millisecond
+
+// nullAddress is the source address used by a device that failed to claim an address
+const nullAddress = 254
+
+// DeviceName is the 64-bit ISO NAME a device claims its address with
+type DeviceName struct {
+	UniqueNumber            uint32
+	ManufacturerCode        uint16
+	DeviceInstance          uint8
+	DeviceFunction          uint8
+	DeviceClass             uint8
+	SystemInstance          uint8
+	IndustryGroup           uint8
+	ArbitraryAddressCapable bool
+}
+
+// ParseDeviceName splits a NAME, as sent little-endian in PGN 60928, into its fields.
+func ParseDeviceName(name uint64) DeviceName {
+	return DeviceName{
+		UniqueNumber:            uint32(name & 0x1fffff),
+		ManufacturerCode:        uint16((name >> 21) & 0x7ff),
+		DeviceInstance:          uint8((name >> 32) & 0xff),
+		DeviceFunction:          uint8((name >> 40) & 0xff),
+		DeviceClass:             uint8((name >> 49) & 0x7f),
+		SystemInstance:          uint8((name >> 56) & 0xf),
+		IndustryGroup:           uint8((name >> 60) & 0x7),
+		ArbitraryAddressCapable: name>>63 != 0,
+	}
+}
+
+// Uint64 packs the NAME back into its 64-bit form.
+func (n DeviceName) Uint64() uint64 {
+	v := uint64(n.UniqueNumber&0x1fffff) |
+		uint64(n.ManufacturerCode&0x7ff)<<21 |
+		uint64(n.DeviceInstance)<<32 |
+		uint64(n.DeviceFunction)<<40 |
+		uint64(n.DeviceClass&0x7f)<<49 |
+		uint64(n.SystemInstance&0xf)<<56 |
+		uint64(n.IndustryGroup&0x7)<<60
+	if n.ArbitraryAddressCapable {
+		v |= 1 << 63
+	}
+	return v
+}
+
+// DeviceInfo is everything the directory knows about a device.  Name and ProductInfo are nil until the device
+// has sent an address claim or product information.
+type DeviceInfo struct {
+	Address     uint8
+	Name        *DeviceName
+	ProductInfo *ProductInfo
+	// HeartbeatInterval is zero until a heartbeat has been seen.
+	HeartbeatInterval time.Duration
+	FirstSeen         time.Time
+	LastSeen          time.Time
+}
+
+// AddressChange is fired when a device claims a new address
+type AddressChange struct {
+	Name       DeviceName
+	OldAddress uint8
+	NewAddress uint8
+}
+
+// DirectoryOptions is a type that contains optional settings on a Directory.
+type DirectoryOptions struct {
+	// Timeout is how long a device can go unheard before it's considered to have left.  Defaults to 3 minutes,
+	// three missed heartbeats, if it isn't positive.
+	Timeout time.Duration
+}
+
+// Directory passively watches the bus to keep a live table of the devices on it, from their address claims,
+// product information, heartbeats and any other traffic.  Received frames must be passed in through HandleFrame.
+type Directory struct {
+	// DeviceJoined is fired the first time traffic is seen from an address.
+	DeviceJoined subscribableevent.Event[func(DeviceInfo)]
+	// DeviceLeft is fired when a device times out, gives up its address, or loses it to another device.
+	DeviceLeft subscribableevent.Event[func(DeviceInfo)]
+	// AddressChanged is fired when a known NAME claims a different address.
+	AddressChanged subscribableevent.Event[func(AddressChange)]
+
+	options   DirectoryOptions
+	assembler *FastPacketAssembler
+
+	mu      sync.Mutex
+	devices map[uint8]*DeviceInfo
+	now     func() time.Time
+
+	done     chan struct{}
+	doneOnce sync.Once
+
+	log *logrus.Logger
+}
+
+// Ensure that Directory implements the Activity interface.
+var _ service.Activity = &Directory{}
+
+// NewDirectory returns a new, empty directory with the given options.
+func NewDirectory(log *logrus.Logger, options DirectoryOptions) *Directory {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultDirectoryTimeout
+	}
+
+	return &Directory{
+		DeviceJoined:   subscribableevent.NewEvent[func(DeviceInfo)](),
+		DeviceLeft:     subscribableevent.NewEvent[func(DeviceInfo)](),
+		AddressChanged: subscribableevent.NewEvent[func(AddressChange)](),
+		options:        options,
+		assembler:      NewFastPacketAssembler(NewRegistry()),
+		devices:        map[uint8]*DeviceInfo{},
+		now:            time.Now,
+		done:           make(chan struct{}),
+		log:            log,
+	}
+}
+
+// Name returns the name of the Directory Activity.
+func (*Directory) Name() string {
+	return "nmea2000-directory"
+}
+
+// Devices returns a snapshot of every known device, ordered by address.
+func (d *Directory) Devices() []DeviceInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	devices := make([]DeviceInfo, 0, len(d.devices))
+	for _, info := range d.devices {
+		devices = append(devices, *info)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Address < devices[j].Address })
+	return devices
+}
+
+// Device returns the device at an address, if there is one.
+func (d *Directory) Device(address uint8) (DeviceInfo, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	info, ok := d.devices[address]
+	if !ok {
+		return DeviceInfo{}, false
+	}
+	return *info, true
+}
+
+// HandleFrame updates the directory from a received frame.  Standard frames aren't NMEA 2000, and are ignored.
+func (d *Directory) HandleFrame(frame can.Frame) {
+	if !canbus.IsExtendedID(frame.ID) {
+		return
+	}
+	msg, ok := d.assembler.Add(frame)
+	if !ok {
+		return
+	}
+
+	var ev directoryEvents
+	d.mu.Lock()
+	if msg.PGN == PGNISOAddressClaim && len(msg.Data) >= 8 {
+		d.handleAddressClaim(msg, &ev)
+	} else {
+		d.handleMessage(msg, &ev)
+	}
+	d.mu.Unlock()
+
+	d.fire(ev)
+}
+
+// directoryEvents collects the events from a single update, to fire once the lock is released
+type directoryEvents struct {
+	joined []DeviceInfo
+	left   []DeviceInfo
+	moved  []AddressChange
+}
+
+// handleAddressClaim is a helper to track NAMEs as they claim, move between and give up addresses.  It must be
+// called with the lock held.
+func (d *Directory) handleAddressClaim(msg canbus.PGNMessage, ev *directoryEvents) {
+	name := ParseDeviceName(binary.LittleEndian.Uint64(msg.Data))
+	now := d.now()
+
+	if msg.Source == nullAddress {
+		// The device couldn't claim an address, so it's gone from the bus until it tries again.
+		for addr, info := range d.devices {
+			if info.Name != nil && *info.Name == name {
+				ev.left = append(ev.left, *info)
+				delete(d.devices, addr)
+			}
+		}
+		return
+	}
+
+	info, exists := d.devices[msg.Source]
+	if exists && info.Name != nil && *info.Name != name {
+		// Another device has taken this address over.
+		ev.left = append(ev.left, *info)
+		delete(d.devices, msg.Source)
+		exists = false
+	}
+	for addr, other := range d.devices {
+		if addr == msg.Source || other.Name == nil || *other.Name != name {
+			continue
+		}
+		delete(d.devices, addr)
+		if !exists {
+			// Carry what we knew over to the new address.
+			info = other
+			info.Address = msg.Source
+			d.devices[msg.Source] = info
+			exists = true
+		} else if info.ProductInfo == nil {
+			info.ProductInfo = other.ProductInfo
+		}
+		ev.moved = append(ev.moved, AddressChange{Name: name, OldAddress: addr, NewAddress: msg.Source})
+	}
+	if !exists {
+		info = &DeviceInfo{Address: msg.Source, FirstSeen: now}
+		d.devices[msg.Source] = info
+	}
+	info.Name = &name
+	info.LastSeen = now
+	if !exists {
+		ev.joined = append(ev.joined, *info)
+	}
+}
+
+// handleMessage is a helper to note traffic from a device, picking up its product information and heartbeat
+// interval.  Traffic from the null address isn't a device we can track.  It must be called with the lock held.
+func (d *Directory) handleMessage(msg canbus.PGNMessage, ev *directoryEvents) {
+	if msg.Source == nullAddress {
+		return
+	}
+	now := d.now()
+
+	info, exists := d.devices[msg.Source]
+	if !exists {
+		info = &DeviceInfo{Address: msg.Source, FirstSeen: now}
+		d.devices[msg.Source] = info
+	}
+	info.LastSeen = now
+
+	switch msg.PGN {
+	case PGNProductInformation:
+		if pi, ok := parseProductInfo(msg.Data); ok {
+			info.ProductInfo = &pi
+		}
+	case PGNHeartbeat:
+		if len(msg.Data) >= 2 {
+			offset := binary.LittleEndian.Uint16(msg.Data)
+			if offset < 0xfffe {
+				info.HeartbeatInterval = time.Duration(offset) * 10 * time.Millisecond
+			}
+		}
+	}
+
+	if !exists {
+		ev.joined = append(ev.joined, *info)
+	}
+}
+
+// fire is a helper to send events outside the lock, leaves first so a takeover reads as leave-then-join
+func (d *Directory) fire(ev directoryEvents) {
+	for _, info := range ev.left {
+		d.log.WithField("address", info.Address).Debug("NMEA 2000 device left")
+		d.DeviceLeft.Fire(info)
+	}
+	for _, change := range ev.moved {
+		d.log.WithField("oldAddress", change.OldAddress).
+			WithField("newAddress", change.NewAddress).
+			Debug("NMEA 2000 device changed address")
+		d.AddressChanged.Fire(change)
+	}
+	for _, info := range ev.joined {
+		d.log.WithField("address", info.Address).Debug("NMEA 2000 device joined")
+		d.DeviceJoined.Fire(info)
+	}
+}
+
+// Prune drops every device that hasn't been heard from within the timeout.  Run calls it periodically.
+func (d *Directory) Prune() {
+	var ev directoryEvents
+
+	d.mu.Lock()
+	cutoff := d.now().Add(-d.options.Timeout)
+	for addr, info := range d.devices {
+		if info.LastSeen.Before(cutoff) {
+			ev.left = append(ev.left, *info)
+			delete(d.devices, addr)
+		}
+	}
+	d.mu.Unlock()
+
+	d.fire(ev)
+}
+
+// Run prunes silent devices until the context is canceled or Shutdown is called.
+func (d *Directory) Run(ctx context.Context) error {
+	ticker := time.NewTicker(max(d.options.Timeout/4, minDirectoryPruneInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.done:
+			return nil
+		case <-ticker.C:
+			d.Prune()
+		}
+	}
+}
+
+// Shutdown stops Run.
+func (d *Directory) Shutdown(_ context.Context) error {
+	d.doneOnce.Do(func() {
+		close(d.done)
+	})
+	return nil
+}
+
+// Kill stops Run.
+func (d *Directory) Kill() error {
+	return d.Shutdown(context.Background())
+}
+
+// parseProductInfo is the inverse of Device.productInfo
+func parseProductInfo(data []byte) (ProductInfo, bool) {
+	if len(data) < 4+4*productInfoStringLen+2 {
+		return ProductInfo{}, false
+	}
+
+	str := func(i int) string {
+		b := data[4+i*productInfoStringLen : 4+(i+1)*productInfoStringLen]
+		// Devices pad with any of these.
+		return string(bytes.TrimRight(b, "\xff\x00 @"))
+	}
+	end := 4 + 4*productInfoStringLen
+	return ProductInfo{
+		NMEA2000Version:    binary.LittleEndian.Uint16(data),
+		ProductCode:        binary.LittleEndian.Uint16(data[2:]),
+		ModelID:            str(0),
+		SoftwareVersion:    str(1),
+		ModelVersion:       str(2),
+		ModelSerialCode:    str(3),
+		CertificationLevel: data[end],
+		LoadEquivalency:    data[end+1],
+	}, true
+}
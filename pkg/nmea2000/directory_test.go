@@ -0,0 +1,222 @@
+package nmea2000
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/canbus/canbustest"
+	"github.com/brutella/can"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// directoryRecorder collects every event a directory fires
+type directoryRecorder struct {
+	mu     sync.Mutex
+	events []string
+	moves  []AddressChange
+}
+
+func recordDirectory(d *Directory) *directoryRecorder {
+	r := &directoryRecorder{}
+	d.DeviceJoined.Subscribe(func(info DeviceInfo) { r.add("join", info.Address) })
+	d.DeviceLeft.Subscribe(func(info DeviceInfo) { r.add("leave", info.Address) })
+	d.AddressChanged.Subscribe(func(change AddressChange) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.moves = append(r.moves, change)
+		r.events = append(r.events, "move")
+	})
+	return r
+}
+
+func (r *directoryRecorder) add(kind string, addr uint8) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf("%s %d", kind, addr))
+}
+
+func (r *directoryRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func newTestDirectory() (*Directory, *time.Time) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDirectory(logrus.New(), DirectoryOptions{Timeout: time.Minute})
+	d.now = func() time.Time { return now }
+	return d, &now
+}
+
+func addressClaimFrame(source uint8, name DeviceName) can.Frame {
+	h := canbus.N2KHeader{Priority: 6, PGN: PGNISOAddressClaim, Source: source, Destination: canbus.N2KBroadcast}
+	f := can.Frame{ID: h.ID(), Length: 8}
+	binary.LittleEndian.PutUint64(f.Data[:], name.Uint64())
+	return f
+}
+
+func sendMessage(d *Directory, msg canbus.PGNMessage) {
+	for _, f := range NewRegistry().Frames(msg, 0) {
+		d.HandleFrame(f)
+	}
+}
+
+var testName = DeviceName{
+	UniqueNumber:            123456,
+	ManufacturerCode:        275,
+	DeviceInstance:          1,
+	DeviceFunction:          130,
+	DeviceClass:             25,
+	IndustryGroup:           4,
+	ArbitraryAddressCapable: true,
+}
+
+func TestDeviceName(t *testing.T) {
+	require.Equal(t, testName, ParseDeviceName(testName.Uint64()))
+
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, testName.Uint64())
+	msg, err := NewRegistry().Decode(canbus.N2KHeader{PGN: PGNISOAddressClaim}, data)
+	require.NoError(t, err)
+	manufacturer, _ := msg.Uint("Manufacturer Code")
+	require.Equal(t, uint64(275), manufacturer)
+	class, _ := msg.Uint("Device Class")
+	require.Equal(t, uint64(25), class)
+	function, _ := msg.Uint("Device Function")
+	require.Equal(t, uint64(130), function)
+}
+
+func TestDirectoryTracksDevices(t *testing.T) {
+	d, now := newTestDirectory()
+	rec := recordDirectory(d)
+
+	d.HandleFrame(addressClaimFrame(3, testName))
+	require.Equal(t, []string{"join 3"}, rec.take())
+
+	// Product info and heartbeat fill in the details without re-joining
+	dev := NewDevice(logrus.New(), canbustest.NewBus(nil), DeviceOptions{
+		Address:           3,
+		ProductInfo:       ProductInfo{NMEA2000Version: 2100, ModelID: "Depth Sounder", SoftwareVersion: "4.5"},
+		HeartbeatInterval: 30 * time.Second,
+	})
+	*now = now.Add(10 * time.Second)
+	sendMessage(d, dev.message(PGNProductInformation, canbus.N2KBroadcast, dev.productInfo()))
+	sendMessage(d, dev.heartbeat())
+	require.Empty(t, rec.take())
+
+	info, ok := d.Device(3)
+	require.True(t, ok)
+	require.Equal(t, testName, *info.Name)
+	require.Equal(t, "Depth Sounder", info.ProductInfo.ModelID)
+	require.Equal(t, "4.5", info.ProductInfo.SoftwareVersion)
+	require.Equal(t, 30*time.Second, info.HeartbeatInterval)
+	require.Equal(t, now.Add(-10*time.Second), info.FirstSeen)
+	require.Equal(t, *now, info.LastSeen)
+
+	// Plain traffic from an unknown address is a join too
+	depth := canbus.PGNMessage{
+		N2KHeader: canbus.N2KHeader{PGN: PGNWaterDepth, Source: 5, Destination: canbus.N2KBroadcast},
+		Data:      make([]byte, 8),
+	}
+	sendMessage(d, depth)
+	require.Equal(t, []string{"join 5"}, rec.take())
+	devices := d.Devices()
+	require.Len(t, devices, 2)
+	require.Equal(t, uint8(3), devices[0].Address)
+	require.Nil(t, devices[1].Name)
+
+	// Address 5 goes quiet and times out
+	*now = now.Add(45 * time.Second)
+	sendMessage(d, dev.heartbeat())
+	*now = now.Add(30 * time.Second)
+	d.Prune()
+	require.Equal(t, []string{"leave 5"}, rec.take())
+	require.Len(t, d.Devices(), 1)
+
+	// Nothing from the null address or in standard frames counts as a device
+	depth.Source = nullAddress
+	sendMessage(d, depth)
+	d.HandleFrame(can.Frame{ID: 0x123, Length: 1})
+	d.HandleFrame(can.Frame{ID: 0x7ff, Length: 8})
+	require.Empty(t, rec.take())
+	require.Len(t, d.Devices(), 1)
+}
+
+func TestDirectoryAddressChanges(t *testing.T) {
+	d, _ := newTestDirectory()
+	rec := recordDirectory(d)
+
+	d.HandleFrame(addressClaimFrame(3, testName))
+	dev := NewDevice(logrus.New(), canbustest.NewBus(nil), DeviceOptions{Address: 3, ProductInfo: ProductInfo{ModelID: "Sounder"}})
+	sendMessage(d, dev.message(PGNProductInformation, canbus.N2KBroadcast, dev.productInfo()))
+	rec.take()
+
+	// The same NAME reclaiming somewhere else is a move, and keeps its details
+	d.HandleFrame(addressClaimFrame(7, testName))
+	require.Equal(t, []string{"move"}, rec.take())
+	require.Equal(t, []AddressChange{{Name: testName, OldAddress: 3, NewAddress: 7}}, rec.moves)
+	_, ok := d.Device(3)
+	require.False(t, ok)
+	info, ok := d.Device(7)
+	require.True(t, ok)
+	require.Equal(t, "Sounder", info.ProductInfo.ModelID)
+
+	// A different NAME taking over the address is a leave and a join
+	other := testName
+	other.UniqueNumber++
+	d.HandleFrame(addressClaimFrame(7, other))
+	require.Equal(t, []string{"leave 7", "join 7"}, rec.take())
+	info, _ = d.Device(7)
+	require.Equal(t, other, *info.Name)
+	require.Nil(t, info.ProductInfo)
+
+	// Claiming the null address means the device gave up
+	d.HandleFrame(addressClaimFrame(nullAddress, other))
+	require.Equal(t, []string{"leave 7"}, rec.take())
+	require.Empty(t, d.Devices())
+}
+
+func TestDirectoryRun(t *testing.T) {
+	d := NewDirectory(logrus.New(), DirectoryOptions{Timeout: 20 * time.Millisecond})
+	require.Equal(t, "nmea2000-directory", d.Name())
+
+	left := make(chan DeviceInfo, 1)
+	d.DeviceLeft.Subscribe(func(info DeviceInfo) { left <- info })
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- d.Run(context.Background())
+	}()
+
+	d.HandleFrame(addressClaimFrame(3, testName))
+	select {
+	case info := <-left:
+		require.Equal(t, uint8(3), info.Address)
+	case <-time.After(time.Second):
+		t.Fatal("device never timed out")
+	}
+
+	require.NoError(t, d.Shutdown(context.Background()))
+	require.NoError(t, <-runDone)
+}
+
+func TestDirectoryTimeoutBounds(t *testing.T) {
+	require.Equal(t, defaultDirectoryTimeout, NewDirectory(logrus.New(), DirectoryOptions{Timeout: -time.Second}).options.Timeout)
+
+	// A tiny timeout still prunes instead of panicking on the ticker
+	d := NewDirectory(logrus.New(), DirectoryOptions{Timeout: time.Nanosecond})
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- d.Run(context.Background())
+	}()
+	require.NoError(t, d.Shutdown(context.Background()))
+	require.NoError(t, <-runDone)
+}
@@ -0,0 +1,205 @@
+package nmea2000
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/units"
+	"github.com/brutella/can"
+)
+
+// TypedMessage is implemented by the message structs that can be encoded for transmission
+type TypedMessage interface {
+	// PGN returns the PGN the message is sent as.
+	PGN() uint32
+	// Values returns the message's fields by name, in the types Decode produces.  Fields that are left out are
+	// sent as "not available".
+	Values() map[string]any
+}
+
+// Encode builds a complete message ready for Frames (and then WriteFrame), or a gateway's
+// WriteMessage.  The header's PGN is taken from the message, and PDU2 PGNs are always sent to the broadcast
+// address.
+func (r *Registry) Encode(header canbus.N2KHeader, m TypedMessage) (canbus.PGNMessage, error) {
+	header.PGN = m.PGN()
+	if pf := (header.PGN >> 8) & 0xff; pf >= 240 {
+		header.Destination = canbus.N2KBroadcast
+	}
+
+	data, err := r.EncodeValues(header.PGN, m.Values())
+	if err != nil {
+		return canbus.PGNMessage{}, err
+	}
+
+	return canbus.PGNMessage{N2KHeader: header, Data: data}, nil
+}
+
+// Frames splits a message into CAN frames, using a fast-packet series for PGNs registered as fast-packet even
+// when the payload would fit in a single frame.
+func (r *Registry) Frames(msg canbus.PGNMessage, sequence uint8) []can.Frame {
+	if def, ok := r.Lookup(msg.PGN); ok && def.FastPacket {
+		return msg.FastPacketFrames(sequence)
+	}
+	return msg.Frames(sequence)
+}
+
+// EncodeValues packs field values (keyed by field name) into a payload for a registered PGN.  Missing fields are
+// sent as "not available" and reserved bits are set to ones.
+func (r *Registry) EncodeValues(pgn uint32, values map[string]any) ([]byte, error) {
+	def, ok := r.Lookup(pgn)
+	if !ok {
+		return nil, fmt.Errorf("unknown PGN %d", pgn)
+	}
+
+	known := make(map[string]bool, len(def.Fields))
+	data := make([]byte, def.Length)
+	for i := range data {
+		data[i] = 0xff
+	}
+	for i := range def.Fields {
+		f := &def.Fields[i]
+		known[f.Name] = true
+		if f.Type == FieldReserved {
+			continue
+		}
+
+		raw := notAvailable(f.BitLength, f.Signed)
+		if v, ok := values[f.Name]; ok && v != nil {
+			var err error
+			raw, err = f.encode(v)
+			if err != nil {
+				return nil, fmt.Errorf("PGN %d field %q: %w", pgn, f.Name, err)
+			}
+		}
+		insertBits(data, f.BitOffset, f.BitLength, raw)
+	}
+
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("PGN %d has no field %q", pgn, name)
+		}
+	}
+
+	return data, nil
+}
+
+// encode is a helper to turn a value into the field's raw bits, checking it doesn't collide with the reserved
+// values
+func (f *Field) encode(v any) (uint64, error) {
+	var scaled float64
+	switch val := v.(type) {
+	case units.Velocity:
+		scaled = float64(val.Convert(units.MetersPerSecond).Value)
+	case units.Distance:
+		scaled = float64(val.Convert(units.Meter).Value)
+	case units.Temperature:
+		scaled = float64(val.Convert(units.Kelvin).Value)
+	case units.Pressure:
+		scaled = float64(val.Convert(units.Pa).Value)
+	case units.Volume:
+		scaled = float64(val.Convert(units.Liter).Value)
+	case units.Flow:
+		scaled = float64(val.Convert(units.LitersPerHour).Value)
+	default:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			scaled = float64(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			scaled = float64(rv.Uint())
+		case reflect.Float32, reflect.Float64:
+			scaled = rv.Float()
+		default:
+			return 0, fmt.Errorf("unsupported value type %T", v)
+		}
+	}
+	if err := f.checkType(v); err != nil {
+		return 0, err
+	}
+
+	res := f.Resolution
+	if res == 0 || f.Type == FieldLookup {
+		res = 1
+	}
+	raw := math.Round(scaled / res)
+
+	// The top one (lookups) or two values are reserved, so they're out of range for real data.
+	maxValue := float64(uint64(math.MaxUint64) >> (64 - f.BitLength))
+	minValue := 0.0
+	if f.Signed {
+		maxValue = float64(uint64(math.MaxUint64) >> (65 - f.BitLength))
+		minValue = -maxValue - 1
+	}
+	switch {
+	case f.Type == FieldLookup || f.BitLength < 2:
+		if f.BitLength >= 2 {
+			maxValue--
+		}
+	case f.BitLength < 4:
+		maxValue--
+	default:
+		maxValue -= 2
+	}
+	if raw < minValue || raw > maxValue {
+		return 0, fmt.Errorf("value %v out of range", v)
+	}
+
+	if raw < 0 {
+		return uint64(int64(raw)) & (uint64(math.MaxUint64) >> (64 - f.BitLength)), nil
+	}
+	return uint64(raw), nil
+}
+
+// checkType is a helper to make sure a units value is going into a field of the matching kind, so a distance
+// can't silently land in a temperature field
+func (f *Field) checkType(v any) error {
+	var want FieldType
+	switch v.(type) {
+	case units.Velocity:
+		want = FieldVelocity
+	case units.Distance:
+		want = FieldDistance
+	case units.Temperature:
+		want = FieldTemperature
+	case units.Pressure:
+		want = FieldPressure
+	case units.Volume:
+		want = FieldVolume
+	case units.Flow:
+		want = FieldFlow
+	default:
+		switch f.Type {
+		case FieldNumber, FieldLookup, FieldAngle, FieldLatLon:
+			return nil
+		default:
+			return fmt.Errorf("field needs a units value, got %T", v)
+		}
+	}
+	if f.Type != want {
+		return fmt.Errorf("unexpected value type %T", v)
+	}
+	return nil
+}
+
+// notAvailable returns the raw "not available" value for a field
+func notAvailable(length int, signed bool) uint64 {
+	v := uint64(math.MaxUint64) >> (64 - length)
+	if signed {
+		v >>= 1
+	}
+	return v
+}
+
+// insertBits is the inverse of extractBits
+func insertBits(data []byte, offset, length int, v uint64) {
+	for i := 0; i < length; i++ {
+		bit := offset + i
+		if v&(1<<i) != 0 {
+			data[bit/8] |= 1 << (bit % 8)
+		} else {
+			data[bit/8] &^= 1 << (bit % 8)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package nmea2000
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/units"
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeTemperature(t *testing.T) {
+	r := NewRegistry()
+	msg, err := r.Encode(canbus.N2KHeader{Priority: 2, Source: 35, Destination: 12}, Temperature{
+		Instance: 1,
+		Source:   TemperatureInside,
+		Actual:   units.NewTemperature(units.Celsius, 27),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xff, 0x01, 0x02, 0x3f, 0x75, 0xff, 0xff, 0xff}, msg.Data)
+	require.Equal(t, uint8(canbus.N2KBroadcast), msg.Destination)
+	require.Equal(t, uint32(can.MaskEff|0x09fd0823), msg.ID())
+
+	frames := msg.Frames(0)
+	require.Len(t, frames, 1)
+	require.Equal(t, uint8(8), frames[0].Length)
+
+	decoded, err := r.Decode(canbus.ParseN2KHeader(frames[0].ID), frames[0].Data[:frames[0].Length])
+	require.NoError(t, err)
+	require.Equal(t, msg.N2KHeader, decoded.Header)
+	actual, ok := decoded.Temperature("Actual Temperature")
+	require.True(t, ok)
+	require.InDelta(t, 27, actual.Convert(units.Celsius).Value, 1e-3)
+	_, ok = decoded.Temperature("Set Temperature")
+	require.False(t, ok)
+	source, _ := decoded.Uint("Source")
+	require.Equal(t, uint64(TemperatureInside), source)
+}
+
+func TestEncodeFluidLevel(t *testing.T) {
+	r := NewRegistry()
+	capacity := units.NewVolume(units.Gallon, 52.83441)
+	msg, err := r.Encode(canbus.N2KHeader{Priority: 6, Source: 10}, FluidLevel{
+		Instance: 2,
+		Type:     FluidWater,
+		Level:    75,
+		Capacity: &capacity,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x12, 0x3e, 0x49, 0xd0, 0x07, 0x00, 0x00, 0xff}, msg.Data)
+
+	decoded, err := r.Decode(msg.N2KHeader, msg.Data)
+	require.NoError(t, err)
+	level, _ := decoded.Float("Level")
+	require.InDelta(t, 75, level, 1e-9)
+	volume, _ := decoded.Volume("Capacity")
+	require.InDelta(t, 200, volume.Value, 1e-4)
+}
+
+func TestEncodeEngineParameters(t *testing.T) {
+	r := NewRegistry()
+
+	msg, err := r.Encode(canbus.N2KHeader{Priority: 2, Source: 0}, EngineParametersRapid{Speed: 2400})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x80, 0x25, 0xff, 0xff, 0x7f, 0xff, 0xff}, msg.Data)
+
+	oilPressure := units.NewPressure(units.Psi, 50)
+	coolant := units.NewTemperature(units.Fahrenheit, 180)
+	fuelRate := units.NewFlow(units.GallonsPerHour, 3)
+	hours := 1234 * time.Hour
+	load := int8(-5)
+	msg, err = r.Encode(canbus.N2KHeader{Priority: 2, Source: 0}, EngineParametersDynamic{
+		Instance:         1,
+		OilPressure:      &oilPressure,
+		Temperature:      &coolant,
+		FuelRate:         &fuelRate,
+		TotalEngineHours: &hours,
+		DiscreteStatus1:  0x0001,
+		EngineLoad:       &load,
+	})
+	require.NoError(t, err)
+	require.Len(t, msg.Data, 26)
+	require.Len(t, msg.Frames(3), 4)
+
+	decoded, err := r.Decode(msg.N2KHeader, msg.Data)
+	require.NoError(t, err)
+	p, ok := decoded.Pressure("Oil Pressure")
+	require.True(t, ok)
+	require.InDelta(t, 50, p.Convert(units.Psi).Value, 0.02)
+	temp, ok := decoded.Temperature("Temperature")
+	require.True(t, ok)
+	require.InDelta(t, 180, temp.Convert(units.Fahrenheit).Value, 0.02)
+	flow, ok := decoded.Flow("Fuel Rate")
+	require.True(t, ok)
+	require.InDelta(t, 3, flow.Convert(units.GallonsPerHour).Value, 0.03)
+	engineHours, _ := decoded.Float("Total Engine Hours")
+	require.Equal(t, hours.Seconds(), engineHours)
+	status, _ := decoded.Uint("Discrete Status 1")
+	require.Equal(t, uint64(1), status)
+	engineLoad, _ := decoded.Float("Engine Load")
+	require.Equal(t, -5.0, engineLoad)
+	_, ok = decoded.Temperature("Oil Temperature")
+	require.False(t, ok)
+	_, ok = decoded.Float("Engine Torque")
+	require.False(t, ok)
+}
+
+func TestEncodeValuesErrors(t *testing.T) {
+	r := NewRegistry()
+
+	_, err := r.EncodeValues(65280, nil)
+	require.Error(t, err)
+
+	_, err = r.EncodeValues(PGNTemperature, map[string]any{"Bogus": 1})
+	require.ErrorContains(t, err, "no field")
+
+	_, err = r.EncodeValues(PGNTemperature, map[string]any{"Actual Temperature": units.NewDistance(units.Meter, 1)})
+	require.ErrorContains(t, err, "unexpected value type")
+
+	_, err = r.EncodeValues(PGNTemperature, map[string]any{"Actual Temperature": 300.0})
+	require.ErrorContains(t, err, "needs a units value")
+
+	_, err = r.EncodeValues(PGNTemperature, map[string]any{"Actual Temperature": units.NewTemperature(units.Kelvin, -1)})
+	require.ErrorContains(t, err, "out of range")
+
+	// 0xfe is "out of range" on the wire, so it can't be sent as a real value
+	_, err = r.EncodeValues(PGNWaterDepth, map[string]any{"Range": units.NewDistance(units.Meter, 2540)})
+	require.ErrorContains(t, err, "out of range")
+	_, err = r.EncodeValues(PGNWaterDepth, map[string]any{"Range": units.NewDistance(units.Meter, 2530)})
+	require.NoError(t, err)
+
+	_, err = r.EncodeValues(PGNVesselHeading, map[string]any{"Reference": "magnetic"})
+	require.ErrorContains(t, err, "unsupported value type")
+}
+
+func TestEncodeValuesRoundTrip(t *testing.T) {
+	r := NewRegistry()
+	data, err := r.EncodeValues(PGNVesselHeading, map[string]any{
+		"Heading":   1.5708,
+		"Deviation": -0.0123,
+		"Reference": uint8(1),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xff, 0x5c, 0x3d, 0x85, 0xff, 0xff, 0x7f, 0xfd}, data)
+
+	msg, err := r.Decode(canbus.N2KHeader{PGN: PGNVesselHeading}, data)
+	require.NoError(t, err)
+	deviation, ok := msg.Float("Deviation")
+	require.True(t, ok)
+	require.InDelta(t, -0.0123, deviation, 1e-9)
+}
@@ -0,0 +1,14 @@
+package nmea2000
+
+import (
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+)
+
+// FastPacketAssembler reassembles fast-packet series into complete messages.  Whether a PGN is fast-packet comes
+// from the registry; frames for unknown PGNs are passed through as single-frame messages.
+type FastPacketAssembler = canbus.FastPacketAssembler
+
+// NewFastPacketAssembler returns an assembler that looks PGNs up in the given registry.
+func NewFastPacketAssembler(registry *Registry) *FastPacketAssembler {
+	return canbus.NewFastPacketAssembler(registry.IsFastPacket)
+}
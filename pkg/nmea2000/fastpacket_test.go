@@ -0,0 +1,85 @@
+package nmea2000
+
+import (
+	"testing"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFastPacketAssembler(t *testing.T) {
+	r := NewRegistry()
+	a := NewFastPacketAssembler(r)
+
+	data := make([]byte, 26)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	msg := canbus.PGNMessage{
+		N2KHeader: canbus.N2KHeader{Priority: 2, PGN: PGNEngineParametersDynamic, Source: 3, Destination: canbus.N2KBroadcast},
+		Data:      data,
+	}
+	other := msg
+	other.Source = 4
+
+	// Interleave two sources sending the same PGN
+	frames, otherFrames := msg.Frames(2), other.Frames(5)
+	var done []canbus.PGNMessage
+	for i := range frames {
+		for _, f := range []can.Frame{frames[i], otherFrames[i]} {
+			if m, ok := a.Add(f); ok {
+				done = append(done, m)
+			}
+		}
+	}
+	require.Equal(t, []canbus.PGNMessage{msg, other}, done)
+
+	// A missing frame drops the series
+	for i, f := range frames {
+		if i == 1 {
+			continue
+		}
+		_, ok := a.Add(f)
+		require.False(t, ok)
+	}
+
+	// A new series restarts cleanly
+	for i, f := range msg.Frames(3) {
+		m, ok := a.Add(f)
+		require.Equal(t, i == len(frames)-1, ok)
+		if ok {
+			require.Equal(t, msg, m)
+		}
+	}
+
+	// Short fast-packet PGNs still come as a series
+	list := canbus.PGNMessage{
+		N2KHeader: canbus.N2KHeader{PGN: PGNPGNList, Source: 9, Destination: canbus.N2KBroadcast},
+		Data:      []byte{1, 0, 0xea, 0},
+	}
+	listFrames := r.Frames(list, 0)
+	require.Len(t, listFrames, 1)
+	m, ok := a.Add(listFrames[0])
+	require.True(t, ok)
+	require.Equal(t, list, m)
+
+	// Single-frame and unknown PGNs pass straight through
+	single := canbus.PGNMessage{
+		N2KHeader: canbus.N2KHeader{PGN: 65280, Source: 9, Destination: canbus.N2KBroadcast},
+		Data:      []byte{1, 2},
+	}
+	m, ok = a.Add(single.Frames(0)[0])
+	require.True(t, ok)
+	require.Equal(t, single, m)
+
+	// Frames claiming more than 8 bytes are dropped rather than overrunning the data
+	bad := single.Frames(0)[0]
+	bad.Length = 9
+	_, ok = a.Add(bad)
+	require.False(t, ok)
+	bad = msg.Frames(0)[0]
+	bad.Length = 15
+	_, ok = a.Add(bad)
+	require.False(t, ok)
+}
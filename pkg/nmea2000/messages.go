@@ -0,0 +1,172 @@
+package nmea2000
+
+import (
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/units"
+)
+
+// TemperatureSource is the lookup for what a temperature reading is of
+type TemperatureSource uint8
+
+const (
+	TemperatureSea TemperatureSource = iota
+	TemperatureOutside
+	TemperatureInside
+	TemperatureEngineRoom
+	TemperatureMainCabin
+	TemperatureLiveWell
+	TemperatureBaitWell
+	TemperatureRefrigeration
+	TemperatureHeatingSystem
+	TemperatureDewPoint
+	TemperatureApparentWindChill
+	TemperatureTheoreticalWindChill
+	TemperatureHeatIndex
+	TemperatureFreezer
+	TemperatureExhaustGas
+	TemperatureShaftSeal
+)
+
+// FluidType is the lookup for what a tank holds
+type FluidType uint8
+
+const (
+	FluidFuel FluidType = iota
+	FluidWater
+	FluidGrayWater
+	FluidLiveWell
+	FluidOil
+	FluidBlackWater
+	FluidFuelGasoline
+)
+
+// Temperature is PGN 130312.
+type Temperature struct {
+	Instance uint8
+	Source   TemperatureSource
+	Actual   units.Temperature
+	// Set is the thermostat set point, if there is one.
+	Set *units.Temperature
+}
+
+// PGN returns the PGN the message is sent as.
+func (m Temperature) PGN() uint32 {
+	return PGNTemperature
+}
+
+// Values returns the message's fields by name.
+func (m Temperature) Values() map[string]any {
+	v := map[string]any{
+		"Instance":           m.Instance,
+		"Source":             m.Source,
+		"Actual Temperature": m.Actual,
+	}
+	setIf(v, "Set Temperature", m.Set)
+	return v
+}
+
+// FluidLevel is PGN 127505.
+type FluidLevel struct {
+	// Instance is 0-15.
+	Instance uint8
+	Type     FluidType
+	// Level is a percentage of capacity.
+	Level    float64
+	Capacity *units.Volume
+}
+
+// PGN returns the PGN the message is sent as.
+func (m FluidLevel) PGN() uint32 {
+	return PGNFluidLevel
+}
+
+// Values returns the message's fields by name.
+func (m FluidLevel) Values() map[string]any {
+	v := map[string]any{
+		"Instance": m.Instance,
+		"Type":     m.Type,
+		"Level":    m.Level,
+	}
+	setIf(v, "Capacity", m.Capacity)
+	return v
+}
+
+// EngineParametersRapid is PGN 127488.
+type EngineParametersRapid struct {
+	Instance uint8
+	// Speed is in rpm.
+	Speed         float64
+	BoostPressure *units.Pressure
+	// TiltTrim is a percentage.
+	TiltTrim *int8
+}
+
+// PGN returns the PGN the message is sent as.
+func (m EngineParametersRapid) PGN() uint32 {
+	return PGNEngineParametersRapid
+}
+
+// Values returns the message's fields by name.
+func (m EngineParametersRapid) Values() map[string]any {
+	v := map[string]any{
+		"Instance": m.Instance,
+		"Speed":    m.Speed,
+	}
+	setIf(v, "Boost Pressure", m.BoostPressure)
+	setIf(v, "Tilt/Trim", m.TiltTrim)
+	return v
+}
+
+// EngineParametersDynamic is PGN 127489.  Every reading is optional, since few engines report them all.
+type EngineParametersDynamic struct {
+	Instance       uint8
+	OilPressure    *units.Pressure
+	OilTemperature *units.Temperature
+	Temperature    *units.Temperature
+	// AlternatorPotential is in volts.
+	AlternatorPotential *float64
+	FuelRate            *units.Flow
+	TotalEngineHours    *time.Duration
+	CoolantPressure     *units.Pressure
+	FuelPressure        *units.Pressure
+	DiscreteStatus1     uint16
+	DiscreteStatus2     uint16
+	// EngineLoad and EngineTorque are percentages.
+	EngineLoad   *int8
+	EngineTorque *int8
+}
+
+// PGN returns the PGN the message is sent as.
+func (m EngineParametersDynamic) PGN() uint32 {
+	return PGNEngineParametersDynamic
+}
+
+// Values returns the message's fields by name.
+func (m EngineParametersDynamic) Values() map[string]any {
+	v := map[string]any{
+		"Instance":          m.Instance,
+		"Discrete Status 1": m.DiscreteStatus1,
+		"Discrete Status 2": m.DiscreteStatus2,
+	}
+	setIf(v, "Oil Pressure", m.OilPressure)
+	setIf(v, "Oil Temperature", m.OilTemperature)
+	setIf(v, "Temperature", m.Temperature)
+	setIf(v, "Alternator Potential", m.AlternatorPotential)
+	setIf(v, "Fuel Rate", m.FuelRate)
+	if m.TotalEngineHours != nil {
+		v["Total Engine Hours"] = m.TotalEngineHours.Seconds()
+	}
+	setIf(v, "Coolant Pressure", m.CoolantPressure)
+	setIf(v, "Fuel Pressure", m.FuelPressure)
+	setIf(v, "Engine Load", m.EngineLoad)
+	setIf(v, "Engine Torque", m.EngineTorque)
+	return v
+}
+
+// setIf is a helper to add an optional field to a values map
+func setIf[T any](values map[string]any, name string, v *T) {
+	if v != nil {
+		values[name] = *v
+	}
+}
@@ -0,0 +1,325 @@
+// Package nmea2000 decodes and encodes NMEA 2000 PGNs using a registry of field definitions, returning physical
+// quantities as units values so they can go straight to the UI.
+package nmea2000
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/units"
+)
+
+// FieldType is an enum for how a field's raw value is interpreted
+type FieldType int
+
+const (
+	// FieldNumber is a plain scaled number, decoded as float64.
+	FieldNumber FieldType = iota
+	// FieldLookup is an enumerated value or instance number, decoded as uint64.
+	FieldLookup
+	// FieldAngle is an angle in radians, decoded as float64.
+	FieldAngle
+	// FieldLatLon is a latitude or longitude in degrees, decoded as float64.
+	FieldLatLon
+	// FieldVelocity is in meters per second, decoded as units.Velocity.
+	FieldVelocity
+	// FieldDistance is in meters, decoded as units.Distance.
+	FieldDistance
+	// FieldTemperature is in kelvin, decoded as units.Temperature.
+	FieldTemperature
+	// FieldPressure is in pascals, decoded as units.Pressure.
+	FieldPressure
+	// FieldVolume is in liters, decoded as units.Volume.
+	FieldVolume
+	// FieldFlow is in liters per hour, decoded as units.Flow.
+	FieldFlow
+	// FieldReserved is padding and isn't decoded.
+	FieldReserved
+)
+
+// Field describes a single field in a PGN's payload
+type Field struct {
+	Name string
+	// BitOffset and BitLength locate the field in the payload, counting bits from the least significant bit of
+	// the first byte, as NMEA 2000 packs them.
+	BitOffset int
+	BitLength int
+	// Resolution scales the raw integer into the field's unit.  Zero means 1.
+	Resolution float64
+	Signed     bool
+	Type       FieldType
+	// Unit is informational, for FieldNumber fields such as "rpm" or "%".
+	Unit string
+}
+
+// PGNDefinition describes the layout of a single PGN
+type PGNDefinition struct {
+	PGN  uint32
+	Name string
+	// FastPacket is set for PGNs that are sent as a fast-packet series rather than a single frame.
+	FastPacket bool
+	// Length is the payload length in bytes.
+	Length int
+	Fields []Field
+}
+
+// FieldValue is a decoded field.  Value is nil when the field held one of the reserved "not available" or "out of
+// range" values, or was past the end of a short payload.
+type FieldValue struct {
+	Name  string
+	Raw   int64
+	Value any
+}
+
+// Message is a decoded PGN
+type Message struct {
+	Header     canbus.N2KHeader
+	Definition *PGNDefinition
+	Fields     []FieldValue
+}
+
+// Registry is a set of PGN definitions to decode and encode against
+type Registry struct {
+	mu   sync.RWMutex
+	defs map[uint32]*PGNDefinition
+}
+
+// NewRegistry returns a registry preloaded with the core PGNs this package knows about.
+func NewRegistry() *Registry {
+	r := &Registry{
+		defs: map[uint32]*PGNDefinition{},
+	}
+	for _, def := range coreDefinitions {
+		if err := r.Register(def); err != nil {
+			panic(fmt.Sprintf("Invalid core PGN definition: %v", err))
+		}
+	}
+
+	return r
+}
+
+// Register adds or replaces a PGN definition, after checking every field fits in its declared length.
+func (r *Registry) Register(def PGNDefinition) error {
+	for _, f := range def.Fields {
+		if f.BitLength <= 0 || f.BitLength > 64 {
+			return fmt.Errorf("PGN %d field %q: invalid bit length %d", def.PGN, f.Name, f.BitLength)
+		}
+		if f.BitOffset < 0 || f.BitOffset+f.BitLength > def.Length*8 {
+			return fmt.Errorf("PGN %d field %q: bits %d-%d don't fit in %d bytes", def.PGN, f.Name, f.BitOffset,
+				f.BitOffset+f.BitLength, def.Length)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.defs[def.PGN] = &def
+
+	return nil
+}
+
+// Lookup returns the definition for a PGN, if registered.
+func (r *Registry) Lookup(pgn uint32) (*PGNDefinition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	def, ok := r.defs[pgn]
+	return def, ok
+}
+
+// IsFastPacket reports whether a PGN is registered as sent in fast-packet series.  It fits the FastPacketPGN
+// option of the canbus gateway channels.
+func (r *Registry) IsFastPacket(pgn uint32) bool {
+	def, ok := r.Lookup(pgn)
+	return ok && def.FastPacket
+}
+
+// PGNs returns every registered PGN in ascending order.
+func (r *Registry) PGNs() []uint32 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pgns := make([]uint32, 0, len(r.defs))
+	for pgn := range r.defs {
+		pgns = append(pgns, pgn)
+	}
+	sort.Slice(pgns, func(i, j int) bool { return pgns[i] < pgns[j] })
+	return pgns
+}
+
+// Name returns the name of a registered PGN, or "" if it isn't known.
+func (r *Registry) Name(pgn uint32) string {
+	def, ok := r.Lookup(pgn)
+	if !ok {
+		return ""
+	}
+	return def.Name
+}
+
+// Decode decodes a complete (already reassembled) payload for the PGN in the header.
+func (r *Registry) Decode(header canbus.N2KHeader, data []byte) (*Message, error) {
+	def, ok := r.Lookup(header.PGN)
+	if !ok {
+		return nil, fmt.Errorf("unknown PGN %d", header.PGN)
+	}
+
+	msg := &Message{
+		Header:     header,
+		Definition: def,
+		Fields:     make([]FieldValue, 0, len(def.Fields)),
+	}
+	for i := range def.Fields {
+		f := &def.Fields[i]
+		if f.Type == FieldReserved {
+			continue
+		}
+
+		fv := FieldValue{Name: f.Name}
+		raw, ok := extractBits(data, f.BitOffset, f.BitLength)
+		if ok {
+			fv.Raw = signExtend(raw, f.BitLength, f.Signed)
+			if !isSpecialValue(raw, f.BitLength, f.Signed, f.Type == FieldLookup) {
+				fv.Value = f.decode(fv.Raw)
+			}
+		}
+		msg.Fields = append(msg.Fields, fv)
+	}
+
+	return msg, nil
+}
+
+// decode is a helper to turn a raw (sign-extended) value into the field's Go type
+func (f *Field) decode(raw int64) any {
+	if f.Type == FieldLookup {
+		return uint64(raw)
+	}
+
+	res := f.Resolution
+	if res == 0 {
+		res = 1
+	}
+	v := float64(raw) * res
+
+	switch f.Type {
+	case FieldVelocity:
+		return units.NewVelocity(units.MetersPerSecond, float32(v))
+	case FieldDistance:
+		return units.NewDistance(units.Meter, float32(v))
+	case FieldTemperature:
+		return units.NewTemperature(units.Kelvin, float32(v))
+	case FieldPressure:
+		return units.NewPressure(units.Pa, float32(v))
+	case FieldVolume:
+		return units.NewVolume(units.Liter, float32(v))
+	case FieldFlow:
+		return units.NewFlow(units.LitersPerHour, float32(v))
+	default:
+		return v
+	}
+}
+
+// Field returns the named field, if the message has it.
+func (m *Message) Field(name string) (FieldValue, bool) {
+	for _, f := range m.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return FieldValue{}, false
+}
+
+// Float returns a FieldNumber, FieldAngle or FieldLatLon field, reporting false if it's missing or not available.
+func (m *Message) Float(name string) (float64, bool) {
+	return fieldAs[float64](m, name)
+}
+
+// Uint returns a FieldLookup field, reporting false if it's missing or not available.
+func (m *Message) Uint(name string) (uint64, bool) {
+	return fieldAs[uint64](m, name)
+}
+
+// Velocity returns a FieldVelocity field, reporting false if it's missing or not available.
+func (m *Message) Velocity(name string) (units.Velocity, bool) {
+	return fieldAs[units.Velocity](m, name)
+}
+
+// Distance returns a FieldDistance field, reporting false if it's missing or not available.
+func (m *Message) Distance(name string) (units.Distance, bool) {
+	return fieldAs[units.Distance](m, name)
+}
+
+// Temperature returns a FieldTemperature field, reporting false if it's missing or not available.
+func (m *Message) Temperature(name string) (units.Temperature, bool) {
+	return fieldAs[units.Temperature](m, name)
+}
+
+// Pressure returns a FieldPressure field, reporting false if it's missing or not available.
+func (m *Message) Pressure(name string) (units.Pressure, bool) {
+	return fieldAs[units.Pressure](m, name)
+}
+
+// Volume returns a FieldVolume field, reporting false if it's missing or not available.
+func (m *Message) Volume(name string) (units.Volume, bool) {
+	return fieldAs[units.Volume](m, name)
+}
+
+// Flow returns a FieldFlow field, reporting false if it's missing or not available.
+func (m *Message) Flow(name string) (units.Flow, bool) {
+	return fieldAs[units.Flow](m, name)
+}
+
+func fieldAs[T any](m *Message, name string) (T, bool) {
+	var zero T
+	f, ok := m.Field(name)
+	if !ok || f.Value == nil {
+		return zero, false
+	}
+	v, ok := f.Value.(T)
+	return v, ok
+}
+
+// extractBits is a helper to pull a little-endian bit field out of a payload, reporting false if the payload is
+// too short to hold it
+func extractBits(data []byte, offset, length int) (uint64, bool) {
+	if offset+length > len(data)*8 {
+		return 0, false
+	}
+
+	var v uint64
+	for i := 0; i < length; i++ {
+		bit := offset + i
+		if data[bit/8]&(1<<(bit%8)) != 0 {
+			v |= 1 << i
+		}
+	}
+	return v, true
+}
+
+// signExtend is a helper to turn an n-bit raw value into an int64
+func signExtend(raw uint64, length int, signed bool) int64 {
+	if signed && length < 64 && raw&(1<<(length-1)) != 0 {
+		return int64(raw | ^uint64(0)<<length)
+	}
+	return int64(raw)
+}
+
+// isSpecialValue reports whether a raw value is one of the top values NMEA 2000 reserves: all ones (or the
+// largest positive value for signed fields) means "not available", and for fields of 4 bits or more the value
+// below that means "out of range".  Lookups only reserve the all-ones value, and 1-bit fields reserve nothing.
+func isSpecialValue(raw uint64, length int, signed bool, lookup bool) bool {
+	if length < 2 {
+		return false
+	}
+
+	maxValue := uint64(math.MaxUint64) >> (64 - length)
+	if signed {
+		maxValue >>= 1
+	}
+	if raw == maxValue {
+		return true
+	}
+	return !lookup && length >= 4 && raw == maxValue-1
+}
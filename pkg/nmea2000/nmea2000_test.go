@@ -0,0 +1,266 @@
+package nmea2000
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/boatkit-io/tugboat/pkg/canbus"
+	"github.com/boatkit-io/tugboat/pkg/units"
+	"github.com/stretchr/testify/require"
+)
+
+func header(pgn uint32) canbus.N2KHeader {
+	return canbus.N2KHeader{Priority: 2, PGN: pgn, Source: 35, Destination: canbus.N2KBroadcast}
+}
+
+func TestDecodeVesselHeading(t *testing.T) {
+	r := NewRegistry()
+	msg, err := r.Decode(header(PGNVesselHeading), []byte{0xff, 0x5c, 0x3d, 0xff, 0x7f, 0xff, 0x7f, 0xfd})
+	require.NoError(t, err)
+	require.Equal(t, "Vessel Heading", msg.Definition.Name)
+
+	heading, ok := msg.Float("Heading")
+	require.True(t, ok)
+	require.InDelta(t, 1.5708, heading, 1e-9)
+
+	_, ok = msg.Float("Deviation")
+	require.False(t, ok, "0x7fff is not available")
+	_, ok = msg.Uint("SID")
+	require.False(t, ok)
+
+	ref, ok := msg.Uint("Reference")
+	require.True(t, ok)
+	require.Equal(t, uint64(1), ref)
+
+	_, ok = msg.Field("Reserved")
+	require.False(t, ok, "reserved fields aren't decoded")
+}
+
+func TestDecodeWaterDepth(t *testing.T) {
+	r := NewRegistry()
+	msg, err := r.Decode(header(PGNWaterDepth), []byte{0x00, 0xd2, 0x04, 0x00, 0x00, 0x0c, 0xfe, 0xff})
+	require.NoError(t, err)
+
+	depth, ok := msg.Distance("Depth")
+	require.True(t, ok)
+	require.Equal(t, units.Meter, depth.Unit)
+	require.InDelta(t, 12.34, depth.Value, 1e-4)
+
+	offset, ok := msg.Distance("Offset")
+	require.True(t, ok)
+	require.InDelta(t, -0.5, offset.Value, 1e-6)
+
+	_, ok = msg.Distance("Range")
+	require.False(t, ok)
+
+	// Asking for the wrong type is reported as missing
+	_, ok = msg.Velocity("Depth")
+	require.False(t, ok)
+}
+
+func TestDecodePosition(t *testing.T) {
+	data := make([]byte, 8)
+	lat, lon := int32(476062000), int32(-1223321000)
+	binary.LittleEndian.PutUint32(data[0:], uint32(lat))
+	binary.LittleEndian.PutUint32(data[4:], uint32(lon))
+
+	msg, err := NewRegistry().Decode(header(PGNPositionRapid), data)
+	require.NoError(t, err)
+
+	latitude, ok := msg.Float("Latitude")
+	require.True(t, ok)
+	require.InDelta(t, 47.6062, latitude, 1e-9)
+	longitude, ok := msg.Float("Longitude")
+	require.True(t, ok)
+	require.InDelta(t, -122.3321, longitude, 1e-9)
+}
+
+func TestDecodeEngineDynamic(t *testing.T) {
+	data := make([]byte, 26)
+	for i := range data {
+		data[i] = 0xff
+	}
+	data[0] = 1
+	binary.LittleEndian.PutUint16(data[1:], 3500)  // 350 kPa oil pressure
+	binary.LittleEndian.PutUint16(data[5:], 35315) // 353.15 K coolant
+	binary.LittleEndian.PutUint16(data[9:], uint16(int16(125)))
+	binary.LittleEndian.PutUint32(data[11:], 3600*1234)
+	data[24] = 55
+
+	msg, err := NewRegistry().Decode(header(PGNEngineParametersDynamic), data)
+	require.NoError(t, err)
+	require.True(t, msg.Definition.FastPacket)
+
+	instance, ok := msg.Uint("Instance")
+	require.True(t, ok)
+	require.Equal(t, uint64(1), instance)
+
+	oil, ok := msg.Pressure("Oil Pressure")
+	require.True(t, ok)
+	require.Equal(t, units.Pa, oil.Unit)
+	require.InDelta(t, 350000, oil.Value, 1e-3)
+
+	temp, ok := msg.Temperature("Temperature")
+	require.True(t, ok)
+	require.InDelta(t, 80, temp.Convert(units.Celsius).Value, 1e-3)
+
+	fuel, ok := msg.Flow("Fuel Rate")
+	require.True(t, ok)
+	require.Equal(t, units.LitersPerHour, fuel.Unit)
+	require.InDelta(t, 12.5, fuel.Value, 1e-4)
+
+	hours, ok := msg.Float("Total Engine Hours")
+	require.True(t, ok)
+	require.Equal(t, float64(3600*1234), hours)
+
+	load, ok := msg.Float("Engine Load")
+	require.True(t, ok)
+	require.Equal(t, float64(55), load)
+
+	_, ok = msg.Pressure("Coolant Pressure")
+	require.False(t, ok)
+	_, ok = msg.Temperature("Oil Temperature")
+	require.False(t, ok)
+}
+
+func TestDecodeFluidLevel(t *testing.T) {
+	data := []byte{0x12, 0, 0, 0, 0, 0, 0, 0xff}
+	binary.LittleEndian.PutUint16(data[1:], 18750) // 75%
+	binary.LittleEndian.PutUint32(data[3:], 2000)  // 200 L
+
+	msg, err := NewRegistry().Decode(header(PGNFluidLevel), data)
+	require.NoError(t, err)
+
+	instance, _ := msg.Uint("Instance")
+	require.Equal(t, uint64(2), instance)
+	fluidType, _ := msg.Uint("Type")
+	require.Equal(t, uint64(1), fluidType)
+
+	level, ok := msg.Float("Level")
+	require.True(t, ok)
+	require.InDelta(t, 75, level, 1e-9)
+
+	capacity, ok := msg.Volume("Capacity")
+	require.True(t, ok)
+	require.Equal(t, units.Liter, capacity.Unit)
+	require.InDelta(t, 200, capacity.Value, 1e-4)
+}
+
+func TestDecodeWindAndSpeed(t *testing.T) {
+	r := NewRegistry()
+
+	wind := []byte{0, 0, 0, 0, 0, 0xfa, 0xff, 0xff}
+	binary.LittleEndian.PutUint16(wind[1:], 515) // 5.15 m/s
+	binary.LittleEndian.PutUint16(wind[3:], 7854)
+	msg, err := r.Decode(header(PGNWindData), wind)
+	require.NoError(t, err)
+	speed, ok := msg.Velocity("Wind Speed")
+	require.True(t, ok)
+	require.InDelta(t, 10.01, speed.Convert(units.Knots).Value, 0.01)
+	ref, _ := msg.Uint("Reference")
+	require.Equal(t, uint64(2), ref)
+
+	sog := []byte{0, 0xfc, 0, 0, 0, 0, 0xff, 0xff}
+	binary.LittleEndian.PutUint16(sog[2:], 31416)
+	binary.LittleEndian.PutUint16(sog[4:], 250)
+	msg, err = r.Decode(header(PGNCOGSOGRapid), sog)
+	require.NoError(t, err)
+	cog, ok := msg.Float("COG")
+	require.True(t, ok)
+	require.InDelta(t, 3.1416, cog, 1e-9)
+	v, ok := msg.Velocity("SOG")
+	require.True(t, ok)
+	require.InDelta(t, 2.5, v.Value, 1e-6)
+}
+
+func TestDecodeEnvironmental(t *testing.T) {
+	r := NewRegistry()
+
+	temp := []byte{0, 1, 2, 0, 0, 0xff, 0xff, 0xff}
+	binary.LittleEndian.PutUint16(temp[3:], 30015)
+	msg, err := r.Decode(header(PGNTemperature), temp)
+	require.NoError(t, err)
+	actual, ok := msg.Temperature("Actual Temperature")
+	require.True(t, ok)
+	require.InDelta(t, 27, actual.Convert(units.Celsius).Value, 1e-3)
+	_, ok = msg.Temperature("Set Temperature")
+	require.False(t, ok)
+
+	pressure := []byte{0, 0, 0, 0, 0, 0, 0, 0xff}
+	binary.LittleEndian.PutUint32(pressure[3:], 1013250)
+	msg, err = r.Decode(header(PGNActualPressure), pressure)
+	require.NoError(t, err)
+	p, ok := msg.Pressure("Pressure")
+	require.True(t, ok)
+	require.InDelta(t, 1013.25, p.Convert(units.Hpa).Value, 1e-2)
+
+	ext := []byte{0, 0, 0, 0, 0, 0, 0xff, 0xff}
+	ext[3], ext[4], ext[5] = 0x10, 0x27, 0x00 // 10 K
+	msg, err = r.Decode(header(PGNTemperatureExtendedRange), ext)
+	require.NoError(t, err)
+	low, ok := msg.Temperature("Temperature")
+	require.True(t, ok)
+	require.InDelta(t, 10, low.Value, 1e-4)
+}
+
+func TestDecodeShortPayload(t *testing.T) {
+	msg, err := NewRegistry().Decode(header(PGNWaterDepth), []byte{0x00, 0xd2, 0x04, 0x00, 0x00})
+	require.NoError(t, err)
+
+	_, ok := msg.Distance("Depth")
+	require.True(t, ok)
+	f, ok := msg.Field("Offset")
+	require.True(t, ok)
+	require.Nil(t, f.Value)
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	_, err := r.Decode(header(65280), []byte{1, 2, 3})
+	require.Error(t, err)
+
+	require.Equal(t, "Water Depth", r.Name(PGNWaterDepth))
+	require.Equal(t, "", r.Name(65280))
+	require.Contains(t, r.PGNs(), PGNTemperatureExtendedRange)
+
+	err = r.Register(PGNDefinition{PGN: 65280, Length: 2, Fields: []Field{{Name: "Too Big", BitOffset: 8, BitLength: 16}}})
+	require.Error(t, err)
+	err = r.Register(PGNDefinition{PGN: 65280, Length: 2, Fields: []Field{{Name: "Empty", BitOffset: 0}}})
+	require.Error(t, err)
+
+	err = r.Register(PGNDefinition{
+		PGN:    65280,
+		Name:   "Proprietary",
+		Length: 2,
+		Fields: []Field{{Name: "Value", BitOffset: 0, BitLength: 16, Resolution: 0.5, Signed: true}},
+	})
+	require.NoError(t, err)
+	msg, err := r.Decode(header(65280), []byte{0xfe, 0xff})
+	require.NoError(t, err)
+	v, ok := msg.Float("Value")
+	require.True(t, ok)
+	require.Equal(t, -1.0, v)
+}
+
+func TestSpecialValues(t *testing.T) {
+	require.True(t, isSpecialValue(0xff, 8, false, false))
+	require.True(t, isSpecialValue(0xfe, 8, false, false))
+	require.False(t, isSpecialValue(0xfd, 8, false, false))
+	require.False(t, isSpecialValue(0xfe, 8, false, true))
+	require.True(t, isSpecialValue(0x7fff, 16, true, false))
+	require.True(t, isSpecialValue(0x7ffe, 16, true, false))
+	require.False(t, isSpecialValue(0xffff, 16, true, false), "-1 is a real value")
+	require.True(t, isSpecialValue(0x3, 2, false, true))
+	require.False(t, isSpecialValue(0x2, 2, false, false))
+	require.False(t, isSpecialValue(0x1, 1, false, true))
+
+	require.Equal(t, int64(-2), signExtend(0xe, 4, true))
+	require.Equal(t, int64(14), signExtend(0xe, 4, false))
+
+	v, ok := extractBits([]byte{0xab, 0xcd}, 4, 8)
+	require.True(t, ok)
+	require.Equal(t, uint64(0xda), v)
+	_, ok = extractBits([]byte{0xab}, 4, 8)
+	require.False(t, ok)
+}
@@ -0,0 +1,325 @@
+package nmea2000
+
+// Field layouts follow the canboat PGN database: https://github.com/canboat/canboat/blob/master/analyzer/pgn.h
+
+// Commonly used PGNs
+const (
+	PGNISOAcknowledgement            uint32 = 59392
+	PGNISORequest                    uint32 = 59904
+	PGNISOAddressClaim               uint32 = 60928
+	PGNPGNList                       uint32 = 126464
+	PGNHeartbeat                     uint32 = 126993
+	PGNProductInformation            uint32 = 126996
+	PGNConfigurationInformation      uint32 = 126998
+	PGNVesselHeading                 uint32 = 127250
+	PGNEngineParametersRapid         uint32 = 127488
+	PGNEngineParametersDynamic       uint32 = 127489
+	PGNFluidLevel                    uint32 = 127505
+	PGNSpeed                         uint32 = 128259
+	PGNWaterDepth                    uint32 = 128267
+	PGNPositionRapid                 uint32 = 129025
+	PGNCOGSOGRapid                   uint32 = 129026
+	PGNWindData                      uint32 = 130306
+	PGNEnvironmentalParametersLegacy uint32 = 130310
+	PGNEnvironmentalParameters       uint32 = 130311
+	PGNTemperature                   uint32 = 130312
+	PGNHumidity                      uint32 = 130313
+	PGNActualPressure                uint32 = 130314
+	PGNSetPressure                   uint32 = 130315
+	PGNTemperatureExtendedRange      uint32 = 130316
+)
+
+// Field helpers for the layouts that come up over and over
+func sidField() Field {
+	return Field{Name: "SID", BitOffset: 0, BitLength: 8, Type: FieldLookup}
+}
+
+func lookupField(name string, offset, length int) Field {
+	return Field{Name: name, BitOffset: offset, BitLength: length, Type: FieldLookup}
+}
+
+func reservedField(offset, length int) Field {
+	return Field{Name: "Reserved", BitOffset: offset, BitLength: length, Type: FieldReserved}
+}
+
+// coreDefinitions is the set of PGNs every registry starts with
+var coreDefinitions = []PGNDefinition{
+	{
+		PGN:    PGNISOAcknowledgement,
+		Name:   "ISO Acknowledgement",
+		Length: 8,
+		Fields: []Field{
+			lookupField("Control", 0, 8),
+			lookupField("Group Function", 8, 8),
+			reservedField(16, 24),
+			lookupField("PGN", 40, 24),
+		},
+	},
+	{
+		PGN:    PGNISORequest,
+		Name:   "ISO Request",
+		Length: 3,
+		Fields: []Field{
+			lookupField("PGN", 0, 24),
+		},
+	},
+	{
+		PGN:    PGNISOAddressClaim,
+		Name:   "ISO Address Claim",
+		Length: 8,
+		Fields: []Field{
+			lookupField("Unique Number", 0, 21),
+			lookupField("Manufacturer Code", 21, 11),
+			lookupField("Device Instance Lower", 32, 3),
+			lookupField("Device Instance Upper", 35, 5),
+			lookupField("Device Function", 40, 8),
+			reservedField(48, 1),
+			lookupField("Device Class", 49, 7),
+			lookupField("System Instance", 56, 4),
+			lookupField("Industry Group", 60, 3),
+			lookupField("Arbitrary Address Capable", 63, 1),
+		},
+	},
+	{
+		// The PGN list is a repeating field, which the registry can only name.
+		PGN:        PGNPGNList,
+		Name:       "PGN List (Transmit and Receive)",
+		FastPacket: true,
+	},
+	{
+		PGN:    PGNHeartbeat,
+		Name:   "Heartbeat",
+		Length: 8,
+		Fields: []Field{
+			{Name: "Data Transmit Offset", BitOffset: 0, BitLength: 16, Resolution: 0.01, Type: FieldNumber, Unit: "s"},
+			lookupField("Sequence Counter", 16, 8),
+			lookupField("Controller 1 State", 24, 2),
+			lookupField("Controller 2 State", 26, 2),
+			lookupField("Equipment Status", 28, 2),
+			reservedField(30, 34),
+		},
+	},
+	{
+		// The four fixed 32-byte strings between product code and certification level aren't decoded.
+		PGN:        PGNProductInformation,
+		Name:       "Product Information",
+		FastPacket: true,
+		Length:     134,
+		Fields: []Field{
+			{Name: "NMEA 2000 Version", BitOffset: 0, BitLength: 16, Resolution: 0.001, Type: FieldNumber},
+			lookupField("Product Code", 16, 16),
+			lookupField("Certification Level", 1056, 8),
+			lookupField("Load Equivalency", 1064, 8),
+		},
+	},
+	{
+		// Configuration information is three variable-length strings, which the registry can only name.
+		PGN:        PGNConfigurationInformation,
+		Name:       "Configuration Information",
+		FastPacket: true,
+	},
+	{
+		PGN:    PGNVesselHeading,
+		Name:   "Vessel Heading",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			{Name: "Heading", BitOffset: 8, BitLength: 16, Resolution: 0.0001, Type: FieldAngle},
+			{Name: "Deviation", BitOffset: 24, BitLength: 16, Resolution: 0.0001, Signed: true, Type: FieldAngle},
+			{Name: "Variation", BitOffset: 40, BitLength: 16, Resolution: 0.0001, Signed: true, Type: FieldAngle},
+			lookupField("Reference", 56, 2),
+			reservedField(58, 6),
+		},
+	},
+	{
+		PGN:    PGNEngineParametersRapid,
+		Name:   "Engine Parameters, Rapid Update",
+		Length: 8,
+		Fields: []Field{
+			lookupField("Instance", 0, 8),
+			{Name: "Speed", BitOffset: 8, BitLength: 16, Resolution: 0.25, Type: FieldNumber, Unit: "rpm"},
+			{Name: "Boost Pressure", BitOffset: 24, BitLength: 16, Resolution: 100, Type: FieldPressure},
+			{Name: "Tilt/Trim", BitOffset: 40, BitLength: 8, Signed: true, Type: FieldNumber, Unit: "%"},
+			reservedField(48, 16),
+		},
+	},
+	{
+		PGN:        PGNEngineParametersDynamic,
+		Name:       "Engine Parameters, Dynamic",
+		FastPacket: true,
+		Length:     26,
+		Fields: []Field{
+			lookupField("Instance", 0, 8),
+			{Name: "Oil Pressure", BitOffset: 8, BitLength: 16, Resolution: 100, Type: FieldPressure},
+			{Name: "Oil Temperature", BitOffset: 24, BitLength: 16, Resolution: 0.1, Type: FieldTemperature},
+			{Name: "Temperature", BitOffset: 40, BitLength: 16, Resolution: 0.01, Type: FieldTemperature},
+			{Name: "Alternator Potential", BitOffset: 56, BitLength: 16, Resolution: 0.01, Signed: true, Type: FieldNumber, Unit: "V"},
+			{Name: "Fuel Rate", BitOffset: 72, BitLength: 16, Resolution: 0.1, Signed: true, Type: FieldFlow},
+			{Name: "Total Engine Hours", BitOffset: 88, BitLength: 32, Type: FieldNumber, Unit: "s"},
+			{Name: "Coolant Pressure", BitOffset: 120, BitLength: 16, Resolution: 100, Type: FieldPressure},
+			{Name: "Fuel Pressure", BitOffset: 136, BitLength: 16, Resolution: 1000, Type: FieldPressure},
+			reservedField(152, 8),
+			lookupField("Discrete Status 1", 160, 16),
+			lookupField("Discrete Status 2", 176, 16),
+			{Name: "Engine Load", BitOffset: 192, BitLength: 8, Signed: true, Type: FieldNumber, Unit: "%"},
+			{Name: "Engine Torque", BitOffset: 200, BitLength: 8, Signed: true, Type: FieldNumber, Unit: "%"},
+		},
+	},
+	{
+		PGN:    PGNFluidLevel,
+		Name:   "Fluid Level",
+		Length: 8,
+		Fields: []Field{
+			lookupField("Instance", 0, 4),
+			lookupField("Type", 4, 4),
+			{Name: "Level", BitOffset: 8, BitLength: 16, Resolution: 0.004, Signed: true, Type: FieldNumber, Unit: "%"},
+			{Name: "Capacity", BitOffset: 24, BitLength: 32, Resolution: 0.1, Type: FieldVolume},
+			reservedField(56, 8),
+		},
+	},
+	{
+		PGN:    PGNSpeed,
+		Name:   "Speed",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			{Name: "Speed Water Referenced", BitOffset: 8, BitLength: 16, Resolution: 0.01, Type: FieldVelocity},
+			{Name: "Speed Ground Referenced", BitOffset: 24, BitLength: 16, Resolution: 0.01, Type: FieldVelocity},
+			lookupField("Speed Water Referenced Type", 40, 8),
+			lookupField("Speed Direction", 48, 4),
+			reservedField(52, 12),
+		},
+	},
+	{
+		PGN:    PGNWaterDepth,
+		Name:   "Water Depth",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			{Name: "Depth", BitOffset: 8, BitLength: 32, Resolution: 0.01, Type: FieldDistance},
+			{Name: "Offset", BitOffset: 40, BitLength: 16, Resolution: 0.001, Signed: true, Type: FieldDistance},
+			{Name: "Range", BitOffset: 56, BitLength: 8, Resolution: 10, Type: FieldDistance},
+		},
+	},
+	{
+		PGN:    PGNPositionRapid,
+		Name:   "Position, Rapid Update",
+		Length: 8,
+		Fields: []Field{
+			{Name: "Latitude", BitOffset: 0, BitLength: 32, Resolution: 1e-7, Signed: true, Type: FieldLatLon},
+			{Name: "Longitude", BitOffset: 32, BitLength: 32, Resolution: 1e-7, Signed: true, Type: FieldLatLon},
+		},
+	},
+	{
+		PGN:    PGNCOGSOGRapid,
+		Name:   "COG & SOG, Rapid Update",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			lookupField("COG Reference", 8, 2),
+			reservedField(10, 6),
+			{Name: "COG", BitOffset: 16, BitLength: 16, Resolution: 0.0001, Type: FieldAngle},
+			{Name: "SOG", BitOffset: 32, BitLength: 16, Resolution: 0.01, Type: FieldVelocity},
+			reservedField(48, 16),
+		},
+	},
+	{
+		PGN:    PGNWindData,
+		Name:   "Wind Data",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			{Name: "Wind Speed", BitOffset: 8, BitLength: 16, Resolution: 0.01, Type: FieldVelocity},
+			{Name: "Wind Angle", BitOffset: 24, BitLength: 16, Resolution: 0.0001, Type: FieldAngle},
+			lookupField("Reference", 40, 3),
+			reservedField(43, 21),
+		},
+	},
+	{
+		PGN:    PGNEnvironmentalParametersLegacy,
+		Name:   "Environmental Parameters (obsolete)",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			{Name: "Water Temperature", BitOffset: 8, BitLength: 16, Resolution: 0.01, Type: FieldTemperature},
+			{Name: "Outside Ambient Air Temperature", BitOffset: 24, BitLength: 16, Resolution: 0.01, Type: FieldTemperature},
+			{Name: "Atmospheric Pressure", BitOffset: 40, BitLength: 16, Resolution: 100, Type: FieldPressure},
+			reservedField(56, 8),
+		},
+	},
+	{
+		PGN:    PGNEnvironmentalParameters,
+		Name:   "Environmental Parameters",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			lookupField("Temperature Source", 8, 6),
+			lookupField("Humidity Source", 14, 2),
+			{Name: "Temperature", BitOffset: 16, BitLength: 16, Resolution: 0.01, Type: FieldTemperature},
+			{Name: "Humidity", BitOffset: 32, BitLength: 16, Resolution: 0.004, Signed: true, Type: FieldNumber, Unit: "%"},
+			{Name: "Atmospheric Pressure", BitOffset: 48, BitLength: 16, Resolution: 100, Type: FieldPressure},
+		},
+	},
+	{
+		PGN:    PGNTemperature,
+		Name:   "Temperature",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			lookupField("Instance", 8, 8),
+			lookupField("Source", 16, 8),
+			{Name: "Actual Temperature", BitOffset: 24, BitLength: 16, Resolution: 0.01, Type: FieldTemperature},
+			{Name: "Set Temperature", BitOffset: 40, BitLength: 16, Resolution: 0.01, Type: FieldTemperature},
+			reservedField(56, 8),
+		},
+	},
+	{
+		PGN:    PGNHumidity,
+		Name:   "Humidity",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			lookupField("Instance", 8, 8),
+			lookupField("Source", 16, 8),
+			{Name: "Actual Humidity", BitOffset: 24, BitLength: 16, Resolution: 0.004, Signed: true, Type: FieldNumber, Unit: "%"},
+			{Name: "Set Humidity", BitOffset: 40, BitLength: 16, Resolution: 0.004, Signed: true, Type: FieldNumber, Unit: "%"},
+			reservedField(56, 8),
+		},
+	},
+	{
+		PGN:    PGNActualPressure,
+		Name:   "Actual Pressure",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			lookupField("Instance", 8, 8),
+			lookupField("Source", 16, 8),
+			{Name: "Pressure", BitOffset: 24, BitLength: 32, Resolution: 0.1, Signed: true, Type: FieldPressure},
+			reservedField(56, 8),
+		},
+	},
+	{
+		PGN:    PGNSetPressure,
+		Name:   "Set Pressure",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			lookupField("Instance", 8, 8),
+			lookupField("Source", 16, 8),
+			{Name: "Pressure", BitOffset: 24, BitLength: 32, Resolution: 0.1, Type: FieldPressure},
+			reservedField(56, 8),
+		},
+	},
+	{
+		PGN:    PGNTemperatureExtendedRange,
+		Name:   "Temperature, Extended Range",
+		Length: 8,
+		Fields: []Field{
+			sidField(),
+			lookupField("Instance", 8, 8),
+			lookupField("Source", 16, 8),
+			{Name: "Temperature", BitOffset: 24, BitLength: 24, Resolution: 0.001, Type: FieldTemperature},
+			{Name: "Set Temperature", BitOffset: 48, BitLength: 16, Resolution: 0.1, Type: FieldTemperature},
+		},
+	},
+}
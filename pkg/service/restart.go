@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RestartMode is when the Runner runs an activity again after its Run returns
+type RestartMode int
+
+const (
+	// RestartNever leaves an activity stopped once Run returns, and shuts everything down if it failed.
+	RestartNever RestartMode = iota
+	// RestartOnFailure runs an activity again if Run returns an error.
+	RestartOnFailure
+	// RestartAlways runs an activity again whenever Run returns, until the Runner is stopping.
+	RestartAlways
+)
+
+func (m RestartMode) String() string {
+	switch m {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	default:
+		return fmt.Sprintf("RestartMode(%d)", int(m))
+	}
+}
+
+// Restart policy defaults
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	defaultRestartWindow  = time.Minute
+)
+
+// RestartPolicy is how the Runner supervises an activity.  Run is called again on the same Activity, so it must
+// be able to run more than once; Shutdown and Kill are only called when the Runner stops.
+type RestartPolicy struct {
+	Mode RestartMode
+	// InitialBackoff is the wait before a restart, doubling for each further restart within Window up to
+	// MaxBackoff.  They default to 100ms and 30s.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter varies each wait by up to this fraction of it, either way, so activities failing together don't
+	// restart together.
+	Jitter float64
+	// MaxRestarts is how many restarts are allowed within Window before the failure is treated as fatal and
+	// the Runner shuts everything down.  Zero is no limit.  Window defaults to a minute.
+	MaxRestarts int
+	Window      time.Duration
+}
+
+// SetRestartPolicy sets how the activities with the given name are restarted.  Activities without one are never
+// restarted.
+func (r *Runner) SetRestartPolicy(name string, policy RestartPolicy) {
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaultInitialBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultMaxBackoff
+	}
+	if policy.Window <= 0 {
+		policy.Window = defaultRestartWindow
+	}
+	policy.Jitter = min(max(policy.Jitter, 0), 1)
+
+	r.restartPolicies[name] = policy
+}
+
+// backoff is the wait before a restart, given how many restarts there have been in the window including this one
+func (p RestartPolicy) backoff(restarts int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < restarts && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+
+	if p.Jitter > 0 {
+		// #nosec G404 -- backoff jitter doesn't need cryptographic randomness.
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// isFailure reports whether an error returned by Run means the activity failed, rather than stopped because its
+// context was done
+func isFailure(err error) bool {
+	return err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled)
+}
+
+// superviseRun calls an activity's Run, restarting it as its policy says, until it returns for good or ctx is
+// done.  It returns how many times it restarted Run, and the last error from Run or a wrapped one once the
+// restart limit is hit.
+func (r *Runner) superviseRun(ctx context.Context, activity Activity, alog *logrus.Entry) (int, error) {
+	policy := r.restartPolicies[activity.Name()]
+
+	var restarts []time.Time
+	for total := 0; ; total++ {
+		err := activity.Run(ctx)
+		if ctx.Err() != nil {
+			return total, err
+		}
+
+		switch policy.Mode {
+		case RestartOnFailure:
+			if !isFailure(err) {
+				return total, err
+			}
+		case RestartAlways:
+		case RestartNever:
+			fallthrough
+		default:
+			return total, err
+		}
+
+		// Only count the restarts within the window
+		now := time.Now()
+		for len(restarts) > 0 && now.Sub(restarts[0]) > policy.Window {
+			restarts = restarts[1:]
+		}
+		restarts = append(restarts, now)
+		if policy.MaxRestarts > 0 && len(restarts) > policy.MaxRestarts {
+			if err == nil {
+				err = errors.New("exited")
+			}
+			return total, fmt.Errorf("restarted %d times in %v, giving up: %w", policy.MaxRestarts, policy.Window, err)
+		}
+
+		backoff := policy.backoff(len(restarts))
+		alog.WithError(err).WithField("restarts", len(restarts)).WithField("backoff", backoff).Warn("restart activity")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return total, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/service"
+	"github.com/sirupsen/logrus"
+)
+
+// flakyActivity fails the first few times it runs, then runs until its context is done, or returns straight away if
+// it's short lived
+type flakyActivity struct {
+	name       string
+	failures   int32
+	shortLived bool
+
+	runs     atomic.Int32
+	shutdown atomic.Int32
+	killed   atomic.Int32
+}
+
+func (a *flakyActivity) Name() string {
+	return a.name
+}
+
+func (a *flakyActivity) Run(ctx context.Context) error {
+	if n := a.runs.Add(1); n <= a.failures {
+		return errors.New("device went away")
+	}
+	if a.shortLived {
+		return nil
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (a *flakyActivity) Shutdown(context.Context) error {
+	a.shutdown.Add(1)
+	return nil
+}
+
+func (a *flakyActivity) Kill() error {
+	a.killed.Add(1)
+	return nil
+}
+
+func TestServiceRunRestartsOnFailure(t *testing.T) {
+	dongle := &flakyActivity{name: "dongle", failures: 2}
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	runner.RegisterActivities(dongle)
+	runner.SetRestartPolicy("dongle", service.RestartPolicy{Mode: service.RestartOnFailure, InitialBackoff: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result := runner.RunWithResult(ctx)
+	if e, a := 0, result.ExitCode(); e != a {
+		t.Errorf("expected exit code returned from run to be %d, got %d", e, a)
+	}
+	if e, a := int32(3), dongle.runs.Load(); e != a {
+		t.Errorf("expected %d runs, got %d", e, a)
+	}
+	if e, a := 2, result.Activities[0].Restarts; e != a {
+		t.Errorf("expected %d restarts in the result, got %d", e, a)
+	}
+	if dongle.shutdown.Load() != 1 || dongle.killed.Load() != 1 {
+		t.Errorf("expected one shutdown and kill, got %d and %d", dongle.shutdown.Load(), dongle.killed.Load())
+	}
+}
+
+func TestServiceRunOnFailureLeavesCleanExitsAlone(t *testing.T) {
+	oneShot := &flakyActivity{name: "one-shot", shortLived: true}
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	runner.RegisterActivities(oneShot)
+	runner.SetRestartPolicy("one-shot", service.RestartPolicy{Mode: service.RestartOnFailure, InitialBackoff: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if e, a := 0, runner.Run(ctx); e != a {
+		t.Errorf("expected exit code returned from run to be %d, got %d", e, a)
+	}
+	if e, a := int32(1), oneShot.runs.Load(); e != a {
+		t.Errorf("expected %d runs, got %d", e, a)
+	}
+}
+
+func TestServiceRunRestartsAlways(t *testing.T) {
+	poller := &flakyActivity{name: "poller", shortLived: true}
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	runner.RegisterActivities(poller)
+	runner.SetRestartPolicy("poller", service.RestartPolicy{Mode: service.RestartAlways, InitialBackoff: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if e, a := 0, runner.Run(ctx); e != a {
+		t.Errorf("expected exit code returned from run to be %d, got %d", e, a)
+	}
+	if runs := poller.runs.Load(); runs < 3 {
+		t.Errorf("expected the poller to keep being run, got %d runs", runs)
+	}
+}
+
+func TestServiceRunEscalatesAfterRestartLimit(t *testing.T) {
+	dongle := &flakyActivity{name: "dongle", failures: 100}
+	other := &flakyActivity{name: "other"}
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	runner.RegisterActivities(dongle, other)
+	runner.SetRestartPolicy("dongle", service.RestartPolicy{
+		Mode:           service.RestartOnFailure,
+		InitialBackoff: 20 * time.Millisecond,
+		MaxRestarts:    2,
+		Window:         time.Minute,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if e, a := 1, runner.Run(ctx); e != a {
+		t.Errorf("expected exit code returned from run to be %d, got %d", e, a)
+	}
+
+	// Two restarts, waiting 20ms and then 40ms, and the third failure gives up and stops everything
+	if e, a := int32(3), dongle.runs.Load(); e != a {
+		t.Errorf("expected %d runs, got %d", e, a)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond || elapsed > 4*time.Second {
+		t.Errorf("expected backoff of 60ms in all and no wait for the context, took %v", elapsed)
+	}
+	if other.killed.Load() != 1 {
+		t.Error("expected the other activity to be stopped")
+	}
+}
+
+func TestRestartModeString(t *testing.T) {
+	for mode, s := range map[service.RestartMode]string{
+		service.RestartNever:     "never",
+		service.RestartOnFailure: "on-failure",
+		service.RestartAlways:    "always",
+		service.RestartMode(9):   "RestartMode(9)",
+	} {
+		if mode.String() != s {
+			t.Errorf("expected %q, got %q", s, mode.String())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrShutdownTimeout is reported for an activity whose Shutdown didn't return within the shutdown timeout.
+	ErrShutdownTimeout = errors.New("shutdown timed out")
+	// ErrKillTimeout is reported for an activity whose Kill and Run didn't both return within the kill timeout.
+	ErrKillTimeout = errors.New("kill timed out")
+)
+
+// ActivityResult is how one activity's run went
+type ActivityResult struct {
+	Name string
+	// Started is false if the activity never ran, because a dependency failed or the Runner stopped first.
+	Started bool
+	// StartErr is the error from Starter.Start, or its timeout.  Run isn't called if it's set.
+	StartErr error
+	// RunErr is the last error Run returned, after Restarts restarts.
+	RunErr   error
+	Restarts int
+	// ShutdownErr and KillErr are what Shutdown and Kill returned, if they returned in time.
+	ShutdownErr      error
+	ShutdownTimedOut bool
+	KillErr          error
+	KillTimedOut     bool
+
+	// RunDuration runs from the start of Start or Run until Run returned for good, and ShutdownDuration and
+	// KillDuration until those returned or timed out.
+	RunDuration      time.Duration
+	ShutdownDuration time.Duration
+	KillDuration     time.Duration
+}
+
+// Err joins the activity's errors, with timeouts reported as ErrShutdownTimeout and ErrKillTimeout.  Run errors
+// that just mean its context was done aren't included.
+func (a ActivityResult) Err() error {
+	var errs []error
+	if a.StartErr != nil {
+		errs = append(errs, a.StartErr)
+	}
+	if isFailure(a.RunErr) {
+		errs = append(errs, a.RunErr)
+	}
+	if a.ShutdownErr != nil {
+		errs = append(errs, fmt.Errorf("shutdown: %w", a.ShutdownErr))
+	}
+	if a.ShutdownTimedOut {
+		errs = append(errs, ErrShutdownTimeout)
+	}
+	if a.KillErr != nil {
+		errs = append(errs, fmt.Errorf("kill: %w", a.KillErr))
+	}
+	if a.KillTimedOut {
+		errs = append(errs, ErrKillTimeout)
+	}
+	return errors.Join(errs...)
+}
+
+// Result is how a Runner's run went
+type Result struct {
+	// SetupErr is why nothing was run at all, i.e. a missing dependency.
+	SetupErr error
+	// Activities are the results for each activity, in the order they were registered.
+	Activities []ActivityResult
+	// Failed is set if an activity failed and stopped the Runner, and TriggeredBy is that activity's name.
+	Failed      bool
+	TriggeredBy string
+	Duration    time.Duration
+}
+
+// Activity returns the result for the first activity with the given name.
+func (r *Result) Activity(name string) (ActivityResult, bool) {
+	for _, a := range r.Activities {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return ActivityResult{}, false
+}
+
+// Err joins the errors from every activity, each prefixed with the activity's name.
+func (r *Result) Err() error {
+	var errs []error
+	if r.SetupErr != nil {
+		errs = append(errs, r.SetupErr)
+	}
+	for _, a := range r.Activities {
+		if err := a.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ExitCode is 1 if an activity failed or nothing could be run, and 0 otherwise.
+func (r *Result) ExitCode() int {
+	if r.SetupErr != nil || r.Failed {
+		return 1
+	}
+	return 0
+}
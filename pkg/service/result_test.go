@@ -0,0 +1,113 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/service"
+	"github.com/sirupsen/logrus"
+)
+
+// hangingActivity runs until its context is done, then never returns from Shutdown and fails to Kill
+type hangingActivity struct {
+	orderedActivity
+}
+
+func (a *hangingActivity) Shutdown(context.Context) error {
+	a.log.add("shutdown " + a.name)
+	select {}
+}
+
+func (a *hangingActivity) Kill() error {
+	a.log.add("kill " + a.name)
+	return errors.New("no such process")
+}
+
+func TestServiceRunWithResult(t *testing.T) {
+	events := &eventLog{}
+	errGone := errors.New("device went away")
+	bus := &hangingActivity{orderedActivity{name: "canbus", log: events}}
+	api := &orderedActivity{name: "api", log: events}
+
+	runner := service.NewRunner(logrus.StandardLogger(), 50*time.Millisecond, time.Second)
+	runner.RegisterActivities(bus)
+	if err := runner.RegisterActivity(api, "canbus"); err != nil {
+		t.Fatal(err)
+	}
+	runner.RegisterActivities(&orderedActivity{name: "broken", log: events, runErr: errGone})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result := runner.RunWithResult(ctx)
+
+	if e, a := 1, result.ExitCode(); e != a {
+		t.Errorf("expected exit code %d, got %d", e, a)
+	}
+	if !result.Failed || result.TriggeredBy != "broken" {
+		t.Errorf("expected broken to have stopped the runner, got %t and %q", result.Failed, result.TriggeredBy)
+	}
+	if e, a := 3, len(result.Activities); e != a {
+		t.Fatalf("expected %d activity results, got %d", e, a)
+	}
+	for i, name := range []string{"canbus", "api", "broken"} {
+		if result.Activities[i].Name != name {
+			t.Errorf("expected activity %d to be %q, got %q", i, name, result.Activities[i].Name)
+		}
+	}
+
+	err := result.Err()
+	if !errors.Is(err, errGone) || !errors.Is(err, service.ErrShutdownTimeout) {
+		t.Errorf("expected the joined error to include the run error and shutdown timeout, got %v", err)
+	}
+
+	busResult, _ := result.Activity("canbus")
+	if !busResult.Started || !busResult.ShutdownTimedOut || busResult.KillErr == nil {
+		t.Errorf("expected canbus to time out shutting down and fail to kill, got %+v", busResult)
+	}
+	if busResult.ShutdownDuration < 50*time.Millisecond || busResult.RunDuration <= 0 {
+		t.Errorf("expected canbus durations to be recorded, got %+v", busResult)
+	}
+
+	if apiResult, _ := result.Activity("api"); apiResult.Err() != nil {
+		t.Errorf("expected api not to have failed, got %v", apiResult.Err())
+	}
+	if _, ok := result.Activity("nope"); ok {
+		t.Error("expected no result for an unknown activity")
+	}
+}
+
+func TestServiceRunWithResultSetupError(t *testing.T) {
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	if err := runner.RegisterActivity(&orderedActivity{name: "api", log: &eventLog{}}, "canbus"); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runner.RunWithResult(context.Background())
+	if result.SetupErr == nil || result.ExitCode() != 1 || len(result.Activities) != 0 {
+		t.Errorf("expected a setup error and nothing run, got %+v", result)
+	}
+	if !errors.Is(result.Err(), result.SetupErr) {
+		t.Errorf("expected the joined error to include the setup error, got %v", result.Err())
+	}
+}
+
+func TestServiceRunWithResultSkippedDependent(t *testing.T) {
+	events := &eventLog{}
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	bus := &starterActivity{orderedActivity: orderedActivity{name: "canbus", log: events}, startErr: errors.New("no such device")}
+	runner.RegisterActivities(bus)
+	if err := runner.RegisterActivity(&orderedActivity{name: "api", log: events}, "canbus"); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runner.RunWithResult(context.Background())
+	busResult, _ := result.Activity("canbus")
+	if busResult.StartErr == nil || busResult.RunErr != nil || result.TriggeredBy != "canbus" {
+		t.Errorf("expected canbus to fail to start and stop the runner, got %+v", busResult)
+	}
+	if apiResult, _ := result.Activity("api"); apiResult.Started || apiResult.Err() != nil {
+		t.Errorf("expected api never to have started, got %+v", apiResult)
+	}
+}
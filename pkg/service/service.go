@@ -4,6 +4,9 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,34 +21,58 @@ type Activity interface {
 	Kill() error
 }
 
+// Readier is implemented by activities that aren't ready for use as soon as Run is called, such as
+// canbus.ChannelActivity.  Activities that depend on one aren't run until its Ready channel is closed.  Activities
+// that are neither Readiers nor Starters are ready as soon as they're run.
+type Readier interface {
+	Ready() <-chan struct{}
+}
+
+// errDependencyFailed is the Run error recorded for an activity that never ran because a dependency failed
+var errDependencyFailed = errors.New("dependency failed before it was ready")
+
 // Runner runs Activitys.
 type Runner struct {
-	activities      []Activity
+	activities []Activity
+	// dependencies maps an activity name to the names of the activities it depends on.
+	dependencies map[string][]string
+	// restartPolicies maps an activity name to how it's restarted.
+	restartPolicies map[string]RestartPolicy
+
+	startupTimeout  time.Duration
 	shutdownTimeout time.Duration
 	killTimeout     time.Duration
 	wg              *sync.WaitGroup
 	log             *logrus.Logger
 
-	// erroredSignal is closed whenever any Activity.Run has returned a non-nil error.
+	// runs is the activities as run by Run, for ActivityReady, and allReady is closed once they're all ready.
+	runsMu   sync.Mutex
+	runs     []*activityRun
+	allReady chan struct{}
+
+	// erroredSignal is closed by fail when the first activity fails, cancelling the wrapped Run contexts and
+	// telling all of the other Activitys to stop running.
 	erroredSignal chan struct{}
 
-	// Since you cannot detect whether or not a channel is closed without reading
-	// from it, this is set to true when errored has been closed. The reason this
-	// is all necessary is because we need the errored channel in the runActivity
-	// function so when it is closed it automatically sends on the select it's used
-	// in, cancelling the wrapped Run context and telling all of the other Activitys
-	// to stop running.
-	errored bool
+	// failMu guards failed and triggeredBy, which record the activity that closed erroredSignal, since you cannot
+	// detect whether or not a channel is closed without reading from it.
+	failMu      sync.Mutex
+	failed      bool
+	triggeredBy string
 }
 
 // NewRunner returns a newly configured runner.
 func NewRunner(log *logrus.Logger, shutdownTimeout, killTimeout time.Duration) *Runner {
 	return &Runner{
+		startupTimeout:  defaultStartupTimeout,
 		shutdownTimeout: shutdownTimeout,
 		killTimeout:     killTimeout,
 		wg:              &sync.WaitGroup{},
 		log:             log,
 		erroredSignal:   make(chan struct{}),
+		allReady:        make(chan struct{}),
+		dependencies:    map[string][]string{},
+		restartPolicies: map[string]RestartPolicy{},
 	}
 }
 
@@ -57,93 +84,343 @@ func (r *Runner) RegisterActivities(activities ...Activity) {
 	}
 }
 
+// RegisterActivity registers an activity that depends on the activities with the given names.  Run doesn't run
+// it until they're ready, and shuts it down before them.  Dependencies may be registered later, but not in a
+// way that makes a cycle, which is an error.
+func (r *Runner) RegisterActivity(activity Activity, dependsOn ...string) error {
+	name := activity.Name()
+	for _, dep := range dependsOn {
+		if dep == name {
+			return fmt.Errorf("activity %q depends on itself", name)
+		}
+		if path := r.dependencyPath(dep, name); path != nil {
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append([]string{name}, path...), " -> "))
+		}
+	}
+
+	r.activities = append(r.activities, activity)
+	r.dependencies[name] = append(r.dependencies[name], dependsOn...)
+	return nil
+}
+
+// dependencyPath returns the chain of dependencies leading from one activity to another, or nil if there isn't
+// one
+func (r *Runner) dependencyPath(from, to string) []string {
+	if from == to {
+		return []string{to}
+	}
+	for _, dep := range r.dependencies[from] {
+		if path := r.dependencyPath(dep, to); path != nil {
+			return append([]string{from}, path...)
+		}
+	}
+	return nil
+}
+
+// activityRun tracks one activity through a call to Run
+type activityRun struct {
+	activity   Activity
+	deps       []*activityRun
+	dependents []*activityRun
+
+	// ready is closed once the activity's dependents can run: when its Ready channel is closed, or straight away
+	// if it isn't a Readier.  Run returning nil also counts, for activities that do their work and exit.
+	ready     chan struct{}
+	readyOnce sync.Once
+	// runDone is closed once Run has returned, or if it was never called; runErr is what it returned.
+	runDone chan struct{}
+	runErr  error
+	// stopped is closed once the activity has been shut down and killed, or if it never ran.
+	stopped chan struct{}
+
+	// mu guards result, which goroutines left behind by a timeout may still be filling in.
+	mu     sync.Mutex
+	result ActivityResult
+}
+
+func (a *activityRun) markReady() {
+	a.readyOnce.Do(func() {
+		close(a.ready)
+	})
+}
+
+// record updates the activity's result
+func (a *activityRun) record(update func(result *ActivityResult)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	update(&a.result)
+}
+
+// snapshot returns a copy of the activity's result so far
+func (a *activityRun) snapshot() ActivityResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.result
+}
+
+// plan links the registered activities to their dependencies
+func (r *Runner) plan() ([]*activityRun, error) {
+	runs := make([]*activityRun, len(r.activities))
+	byName := map[string][]*activityRun{}
+	for i, activity := range r.activities {
+		runs[i] = &activityRun{
+			activity: activity,
+			ready:    make(chan struct{}),
+			runDone:  make(chan struct{}),
+			stopped:  make(chan struct{}),
+			result:   ActivityResult{Name: activity.Name()},
+		}
+		byName[activity.Name()] = append(byName[activity.Name()], runs[i])
+	}
+
+	for _, run := range runs {
+		name := run.activity.Name()
+		for _, dep := range r.dependencies[name] {
+			deps, ok := byName[dep]
+			if !ok {
+				return nil, fmt.Errorf("activity %q depends on %q, which isn't registered", name, dep)
+			}
+			for _, d := range deps {
+				if !slices.Contains(run.deps, d) {
+					run.deps = append(run.deps, d)
+					d.dependents = append(d.dependents, run)
+				}
+			}
+		}
+	}
+	return runs, nil
+}
+
 // Run runs all of the provided activities. Run is a blocking function and returns an exit
-// code.
+// code.  RunWithResult says what went wrong.
+//
+// Activities are run as soon as the activities they depend on are ready, and are stopped before them.  When ctx is
+// canceled or an activity fails, an activity's Run context is canceled, and it's shut down and killed, only once
+// every activity depending on it has stopped.  Its Err is still ctx's, so a Run can tell a timeout from a cancel.
 func (r *Runner) Run(ctx context.Context) int {
-	for i := range r.activities {
-		activity := r.activities[i]
+	return r.RunWithResult(ctx).ExitCode()
+}
 
+// RunWithResult runs all of the provided activities like Run, returning what each one's Run, Shutdown and Kill
+// returned and which activity's failure stopped the others.
+func (r *Runner) RunWithResult(ctx context.Context) *Result {
+	start := time.Now()
+	runs, err := r.plan()
+	if err != nil {
+		r.log.WithError(err).Error("plan activities")
+		return &Result{SetupErr: err, Duration: time.Since(start)}
+	}
+
+	r.runsMu.Lock()
+	r.runs = runs
+	r.runsMu.Unlock()
+	go r.watchReady(runs)
+
+	for _, run := range runs {
 		r.wg.Add(1) // Done is deferred in *Runner.runActivity.
-		go r.runActivity(ctx, activity)
+		go r.runActivity(ctx, run)
 	}
 
 	// Block until all activities are done.
 	r.wg.Wait()
 
-	if r.errored {
-		return 1
+	result := &Result{Activities: make([]ActivityResult, len(runs))}
+	for i, run := range runs {
+		result.Activities[i] = run.snapshot()
+	}
+	r.failMu.Lock()
+	result.Failed, result.TriggeredBy = r.failed, r.triggeredBy
+	r.failMu.Unlock()
+	result.Duration = time.Since(start)
+	return result
+}
+
+// fail records the first activity to fail and closes erroredSignal to stop the others
+func (r *Runner) fail(name string) {
+	r.failMu.Lock()
+	defer r.failMu.Unlock()
+	if r.failed {
+		return
+	}
+	r.failed, r.triggeredBy = true, name
+	close(r.erroredSignal)
+}
+
+// waitForDependencies blocks until every dependency of an activity is ready, returning false if one failed first
+// or the runner is stopping.
+func (r *Runner) waitForDependencies(ctx context.Context, run *activityRun) bool {
+	for _, dep := range run.deps {
+		select {
+		case <-dep.ready:
+		case <-dep.runDone:
+			if dep.runErr != nil {
+				return false
+			}
+		case <-ctx.Done():
+			return false
+		case <-r.erroredSignal:
+			return false
+		}
+	}
+	return true
+}
+
+// heldContext is the run context of an activity others depend on.  It has ctx's values and deadline, but isn't
+// done until it's released, at which point its Err and cause are ctx's, or Canceled if ctx isn't done.
+type heldContext struct {
+	context.Context
+	parent context.Context
+	err    error
+}
+
+// newHeldContext returns a context held open past parent, and the function that releases it.
+func newHeldContext(parent context.Context) (context.Context, context.CancelFunc) {
+	inner, cancel := context.WithCancelCause(context.WithoutCancel(parent))
+	c := &heldContext{Context: inner, parent: parent}
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			c.err = parent.Err()
+			if c.err == nil {
+				c.err = context.Canceled
+			}
+			cancel(context.Cause(parent))
+		})
 	}
-	return 0
+}
+
+func (c *heldContext) Deadline() (time.Time, bool) {
+	return c.parent.Deadline()
+}
+
+func (c *heldContext) Err() error {
+	// err is set before the inner context is canceled, and never changes after.
+	if c.Context.Err() == nil {
+		return nil
+	}
+	return c.err
 }
 
 // runActivity runs a single activity. It is expected that *Runner.wg.Add(1) is called
 // before calling this function.
-func (r *Runner) runActivity(ctx context.Context, activity Activity) {
+func (r *Runner) runActivity(ctx context.Context, run *activityRun) {
 	defer r.wg.Done() // LIFO ensures this will be called last.
+	defer close(run.stopped)
 
+	activity := run.activity
 	alog := r.log.WithField("name", activity.Name())
 
-	runCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	if !r.waitForDependencies(ctx, run) {
+		alog.Debug("not running activity, its dependencies didn't start")
+		run.runErr = errDependencyFailed
+		close(run.runDone)
+		return
+	}
+
+	// Activities others depend on keep running until their dependents have stopped.
+	runCtx, cancelRun := context.WithCancel(ctx)
+	if len(run.dependents) > 0 {
+		runCtx, cancelRun = newHeldContext(ctx)
+	}
+	defer cancelRun()
 
-	runReturned := make(chan struct{})
+	run.record(func(result *ActivityResult) { result.Started = true })
 	go func() {
-		defer close(runReturned)
-		if err := activity.Run(runCtx); err != nil {
-			alog.WithError(err).Error("run activity")
-
-			// If the errored channel hasn't already been closed and the error returned
-			// wasn't a context.DeadlineExceeded and wasn't a context.Canceled then we
-			// need to close the signaling channel to shut down all activities.
-			if !r.errored &&
-				!errors.Is(err, context.DeadlineExceeded) &&
-				!errors.Is(err, context.Canceled) {
-				r.errored = true
-				close(r.erroredSignal)
+		defer close(run.runDone)
+		start := time.Now()
+		err := r.startActivity(runCtx, activity)
+		if err != nil {
+			run.record(func(result *ActivityResult) { result.StartErr = err })
+		} else {
+			if readier, ok := activity.(Readier); ok {
+				go func() {
+					select {
+					case <-readier.Ready():
+						run.markReady()
+					case <-run.runDone:
+					}
+				}()
+			} else {
+				run.markReady()
 			}
+			restarts, runErr := r.superviseRun(runCtx, activity, alog)
+			run.record(func(result *ActivityResult) { result.RunErr, result.Restarts = runErr, restarts })
+			err = runErr
+		}
+		run.record(func(result *ActivityResult) { result.RunDuration = time.Since(start) })
+		run.runErr = err
+		if err == nil {
+			run.markReady()
+			return
+		}
+
+		alog.WithError(err).Error("run activity")
+
+		// If the error returned wasn't a context.DeadlineExceeded and wasn't a
+		// context.Canceled then we need to shut down all activities.
+		if isFailure(err) {
+			r.fail(activity.Name())
 		}
 	}()
+	runReturned := (<-chan struct{})(run.runDone)
 
-	// Block until the main context has been canceled or run has returned.
+	// Block until the main context has been canceled, another activity has failed, or run has returned.
 	select {
 	case <-ctx.Done():
 	case <-runReturned:
 	case <-r.erroredSignal:
-		cancel()
 	}
 
+	// Activities that depend on this one are stopped first.
+	for _, dependent := range run.dependents {
+		<-dependent.stopped
+	}
+	cancelRun()
+
 	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.shutdownTimeout)
 	defer cancel()
 
+	shutdownStart := time.Now()
 	shutdownReturned := make(chan struct{})
 	go func() {
 		defer close(shutdownReturned)
 		if err := activity.Shutdown(shutdownCtx); err != nil {
 			alog.WithError(err).Error("shutdown activity")
+			run.record(func(result *ActivityResult) { result.ShutdownErr = err })
 		}
 	}()
 
 	// Block until we've either hit our shutdown timeout or shutdown has returned.
 	select {
 	case <-shutdownCtx.Done():
+		alog.Warn("shutdown activity timed out")
+		run.record(func(result *ActivityResult) { result.ShutdownTimedOut = true })
 	case <-shutdownReturned:
 	}
+	run.record(func(result *ActivityResult) { result.ShutdownDuration = time.Since(shutdownStart) })
 
 	killCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.killTimeout)
 	defer cancel()
 
+	killStart := time.Now()
 	killReturned := make(chan struct{})
 	go func() {
 		defer close(killReturned)
 		if err := activity.Kill(); err != nil {
 			alog.WithError(err).Error("kill activity")
+			run.record(func(result *ActivityResult) { result.KillErr = err })
 		}
 	}()
+	defer func() {
+		run.record(func(result *ActivityResult) { result.KillDuration = time.Since(killStart) })
+	}()
 
 	// Block until we've either hit our kill timeout or both Kill and Run have returned.
 	for killReturned != nil || runReturned != nil {
 		select {
 		case <-killCtx.Done():
+			alog.Warn("kill activity timed out")
+			run.record(func(result *ActivityResult) { result.KillTimedOut = true })
 			return
 		case <-killReturned:
 			killReturned = nil
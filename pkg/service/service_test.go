@@ -3,7 +3,9 @@ package service_test
 import (
 	"context"
 	"errors"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,11 +14,11 @@ import (
 )
 
 type Activity struct {
-	ran      bool
-	shutdown bool
-	killed   bool
+	ran      atomic.Bool
+	shutdown atomic.Bool
+	killed   atomic.Bool
 
-	runContextCancelledByCaller bool
+	runContextCancelledByCaller atomic.Bool
 
 	name    string
 	runFunc func(context.Context) error
@@ -34,7 +36,7 @@ func (a *Activity) Name() string {
 }
 
 func (a *Activity) Run(ctx context.Context) error {
-	a.ran = true
+	a.ran.Store(true)
 
 	if a.runFunc != nil {
 		if err := a.runFunc(ctx); err != nil {
@@ -43,14 +45,14 @@ func (a *Activity) Run(ctx context.Context) error {
 	}
 
 	if ctx.Err() == context.Canceled {
-		a.runContextCancelledByCaller = true
+		a.runContextCancelledByCaller.Store(true)
 	}
 
 	return nil
 }
 
 func (a *Activity) Shutdown(_ context.Context) error {
-	a.shutdown = true
+	a.shutdown.Store(true)
 
 	halt := make(chan struct{})
 	<-halt
@@ -59,24 +61,24 @@ func (a *Activity) Shutdown(_ context.Context) error {
 }
 
 func (a *Activity) Kill() error {
-	a.killed = true
+	a.killed.Store(true)
 	return nil
 }
 
 func (a *Activity) validate(t *testing.T, runContextCanceledByCaller bool) {
-	if !a.ran {
+	if !a.ran.Load() {
 		t.Errorf("expected %q run function to be invoked, was not", a.Name())
 	}
 
-	if !a.shutdown {
+	if !a.shutdown.Load() {
 		t.Errorf("expected %q shutdown function to be invoked, was not", a.Name())
 	}
 
-	if !a.killed {
+	if !a.killed.Load() {
 		t.Errorf("expected %q kill function to be invoked, was not", a.Name())
 	}
 
-	if e, a := a.runContextCancelledByCaller, runContextCanceledByCaller; e != a {
+	if e, a := a.runContextCancelledByCaller.Load(), runContextCanceledByCaller; e != a {
 		t.Errorf("expected value of %t for runContextCanceledByCaller, got %t", e, a)
 	}
 }
@@ -414,3 +416,219 @@ func assertRunnerExitCode(t *testing.T, done <-chan int, expected int) {
 		t.Fatalf("runner did not return")
 	}
 }
+
+// eventLog records what the runner did to a set of activities, in order
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.events...)
+}
+
+// orderedActivity logs its calls to an eventLog.  Run blocks until its context is done, or returns runErr.
+// onShutdown, if set, is called from Shutdown.
+type orderedActivity struct {
+	name       string
+	log        *eventLog
+	runErr     error
+	onShutdown func()
+	runCtx     atomic.Value
+}
+
+// running reports whether Run has been called and its context isn't done yet
+func (a *orderedActivity) running() bool {
+	ctx, ok := a.runCtx.Load().(context.Context)
+	return ok && ctx.Err() == nil
+}
+
+func (a *orderedActivity) Name() string {
+	return a.name
+}
+
+func (a *orderedActivity) Run(ctx context.Context) error {
+	a.runCtx.Store(ctx)
+	a.log.add("run " + a.name)
+	if a.runErr != nil {
+		return a.runErr
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (a *orderedActivity) Shutdown(context.Context) error {
+	a.log.add("shutdown " + a.name)
+	if a.onShutdown != nil {
+		a.onShutdown()
+	}
+	return nil
+}
+
+func (a *orderedActivity) Kill() error {
+	a.log.add("kill " + a.name)
+	return nil
+}
+
+// readyActivity is an orderedActivity that becomes ready a while after Run is called
+type readyActivity struct {
+	orderedActivity
+	delay time.Duration
+	ready chan struct{}
+}
+
+func (a *readyActivity) Ready() <-chan struct{} {
+	return a.ready
+}
+
+func (a *readyActivity) Run(ctx context.Context) error {
+	a.runCtx.Store(ctx)
+	a.log.add("run " + a.name)
+	if a.runErr != nil {
+		return a.runErr
+	}
+	time.AfterFunc(a.delay, func() {
+		a.log.add("ready " + a.name)
+		close(a.ready)
+	})
+	<-ctx.Done()
+	return nil
+}
+
+func TestRegisterActivityRejectsCycles(t *testing.T) {
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	if err := runner.RegisterActivity(&orderedActivity{name: "a"}, "b"); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if err := runner.RegisterActivity(&orderedActivity{name: "b"}, "c"); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+
+	err := runner.RegisterActivity(&orderedActivity{name: "c"}, "a")
+	if err == nil || err.Error() != "dependency cycle: c -> a -> b -> c" {
+		t.Fatalf("expected a dependency cycle error, got %v", err)
+	}
+	if err := runner.RegisterActivity(&orderedActivity{name: "d"}, "d"); err == nil {
+		t.Fatal("expected an activity depending on itself to be rejected")
+	}
+	if err := runner.RegisterActivity(&orderedActivity{name: "c"}, "d"); err != nil {
+		t.Fatalf("register c: %v", err)
+	}
+}
+
+func TestServiceRunDependencyOrder(t *testing.T) {
+	events := &eventLog{}
+	config := &readyActivity{
+		orderedActivity: orderedActivity{name: "config", log: events},
+		delay:           50 * time.Millisecond,
+		ready:           make(chan struct{}),
+	}
+	bus := &readyActivity{
+		orderedActivity: orderedActivity{name: "canbus", log: events},
+		delay:           10 * time.Millisecond,
+		ready:           make(chan struct{}),
+	}
+	api := &orderedActivity{name: "api", log: events}
+
+	// Canceling ctx mustn't stop an activity's Run until everything depending on it has shut down
+	var stillRunning atomic.Int32
+	api.onShutdown = func() {
+		if bus.running() && config.running() {
+			stillRunning.Add(1)
+		}
+	}
+	bus.onShutdown = func() {
+		if !api.running() && config.running() {
+			stillRunning.Add(1)
+		}
+	}
+
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	// Registered in the wrong order on purpose
+	if err := runner.RegisterActivity(api, "canbus"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.RegisterActivity(bus, "config"); err != nil {
+		t.Fatal(err)
+	}
+	runner.RegisterActivities(config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if e, a := 0, runner.Run(ctx); e != a {
+		t.Errorf("expected exit code returned from run to be %d, got %d", e, a)
+	}
+
+	expected := []string{
+		"run config", "ready config", "run canbus", "ready canbus", "run api",
+		"shutdown api", "kill api", "shutdown canbus", "kill canbus", "shutdown config", "kill config",
+	}
+	if e, a := expected, events.get(); !slices.Equal(e, a) {
+		t.Errorf("expected events %v, got %v", e, a)
+	}
+	if a := stillRunning.Load(); a != 2 {
+		t.Errorf("expected dependencies to still be running at both dependent shutdowns, got %d of 2", a)
+	}
+
+	// Held run contexts still carry ctx's deadline, and report the timeout once they're done
+	deadline, _ := ctx.Deadline()
+	for _, activity := range []*orderedActivity{&config.orderedActivity, &bus.orderedActivity, api} {
+		runCtx := activity.runCtx.Load().(context.Context)
+		if d, ok := runCtx.Deadline(); !ok || !d.Equal(deadline) {
+			t.Errorf("expected %q run context deadline %v, got %v", activity.name, deadline, d)
+		}
+		if err := runCtx.Err(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %q run context error to be the deadline, got %v", activity.name, err)
+		}
+		if cause := context.Cause(runCtx); !errors.Is(cause, context.DeadlineExceeded) {
+			t.Errorf("expected %q run context cause to be the deadline, got %v", activity.name, cause)
+		}
+	}
+}
+
+func TestServiceRunMissingDependency(t *testing.T) {
+	events := &eventLog{}
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	if err := runner.RegisterActivity(&orderedActivity{name: "api", log: events}, "canbus"); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, a := 1, runner.Run(context.Background()); e != a {
+		t.Errorf("expected exit code returned from run to be %d, got %d", e, a)
+	}
+	if a := events.get(); len(a) != 0 {
+		t.Errorf("expected nothing to run, got %v", a)
+	}
+}
+
+func TestServiceRunSkipsDependentsOfFailedActivity(t *testing.T) {
+	events := &eventLog{}
+	bus := &readyActivity{
+		orderedActivity: orderedActivity{name: "canbus", log: events, runErr: errors.New("no such device")},
+		ready:           make(chan struct{}),
+	}
+	api := &orderedActivity{name: "api", log: events}
+
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	runner.RegisterActivities(bus)
+	if err := runner.RegisterActivity(api, "canbus"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if e, a := 1, runner.Run(ctx); e != a {
+		t.Errorf("expected exit code returned from run to be %d, got %d", e, a)
+	}
+	if e, a := []string{"run canbus", "shutdown canbus", "kill canbus"}, events.get(); !slices.Equal(e, a) {
+		t.Errorf("expected events %v, got %v", e, a)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultStartupTimeout is how long Starter.Start gets unless SetStartupTimeout says otherwise
+const defaultStartupTimeout = 30 * time.Second
+
+// Starter is implemented by activities with a synchronous startup phase, like canbus.Interface's Start.  The
+// Runner calls Start once, within its startup timeout, before the first Run.  The activity is ready when Start
+// returns (and its Ready channel is closed, if it's also a Readier), and a Start error or timeout stops the
+// Runner the same way a Run error does.
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// SetStartupTimeout sets how long Starter.Start may take.  It defaults to 30s.
+func (r *Runner) SetStartupTimeout(timeout time.Duration) {
+	r.startupTimeout = timeout
+}
+
+// startActivity calls Start on activities that are Starters, giving up once the startup timeout is up even if
+// Start hasn't returned
+func (r *Runner) startActivity(ctx context.Context, activity Activity) error {
+	starter, ok := activity.(Starter)
+	if !ok {
+		return nil
+	}
+
+	startCtx, cancel := context.WithTimeout(ctx, r.startupTimeout)
+	defer cancel()
+
+	started := make(chan error, 1)
+	go func() {
+		started <- starter.Start(startCtx)
+	}()
+
+	select {
+	case err := <-started:
+		if err != nil && ctx.Err() == nil && startCtx.Err() != nil {
+			// Report the timeout as a failure, not as the context being done
+			return fmt.Errorf("start timed out after %v", r.startupTimeout)
+		}
+		if err != nil {
+			return fmt.Errorf("start: %w", err)
+		}
+		return nil
+	case <-startCtx.Done():
+		if ctx.Err() != nil {
+			return fmt.Errorf("start: %w", ctx.Err())
+		}
+		return fmt.Errorf("start timed out after %v", r.startupTimeout)
+	}
+}
+
+// Ready is closed once every activity is ready, which is never if one fails first.
+func (r *Runner) Ready() <-chan struct{} {
+	return r.allReady
+}
+
+// ActivityReady reports whether the activities with the given name are ready.  It's false before Run.
+func (r *Runner) ActivityReady(name string) bool {
+	r.runsMu.Lock()
+	runs := r.runs
+	r.runsMu.Unlock()
+
+	found := false
+	for _, run := range runs {
+		if run.activity.Name() != name {
+			continue
+		}
+		found = true
+		select {
+		case <-run.ready:
+		default:
+			return false
+		}
+	}
+	return found
+}
+
+// watchReady closes the Runner's Ready channel once every activity is ready, giving up if one stops first
+func (r *Runner) watchReady(runs []*activityRun) {
+	for _, run := range runs {
+		select {
+		case <-run.ready:
+		case <-run.stopped:
+			select {
+			case <-run.ready:
+			default:
+				return
+			}
+		}
+	}
+	close(r.allReady)
+}
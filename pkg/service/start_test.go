@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/service"
+	"github.com/sirupsen/logrus"
+)
+
+// starterActivity is an orderedActivity with a Start that takes a while and then returns startErr.  A negative
+// delay blocks Start forever, ignoring its context.
+type starterActivity struct {
+	orderedActivity
+	delay    time.Duration
+	startErr error
+}
+
+func (a *starterActivity) Start(context.Context) error {
+	a.log.add("start " + a.name)
+	if a.delay < 0 {
+		select {}
+	}
+	time.Sleep(a.delay)
+	a.log.add("started " + a.name)
+	return a.startErr
+}
+
+func TestServiceRunStartGatesReadiness(t *testing.T) {
+	events := &eventLog{}
+	bus := &starterActivity{orderedActivity: orderedActivity{name: "canbus", log: events}, delay: 50 * time.Millisecond}
+	api := &orderedActivity{name: "api", log: events}
+
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	runner.RegisterActivities(bus)
+	if err := runner.RegisterActivity(api, "canbus"); err != nil {
+		t.Fatal(err)
+	}
+	if runner.ActivityReady("canbus") {
+		t.Error("expected canbus not to be ready before Run")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := runRunnerAsync(ctx, runner)
+
+	select {
+	case <-runner.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("runner never became ready")
+	}
+	if !runner.ActivityReady("canbus") || !runner.ActivityReady("api") {
+		t.Error("expected every activity to be ready")
+	}
+	if runner.ActivityReady("nope") {
+		t.Error("expected an unknown activity not to be ready")
+	}
+
+	cancel()
+	assertRunnerExitCode(t, done, 0)
+
+	// Run canbus and run api race each other once canbus has started
+	got := events.get()
+	if slices.Index(got, "run api") < slices.Index(got, "started canbus") {
+		t.Errorf("expected api to run after canbus started, got %v", got)
+	}
+	if e, a := []string{"shutdown api", "kill api", "shutdown canbus", "kill canbus"}, got[len(got)-4:]; !slices.Equal(e, a) {
+		t.Errorf("expected events to end %v, got %v", e, got)
+	}
+}
+
+func TestServiceRunStartFailureStopsRunner(t *testing.T) {
+	events := &eventLog{}
+	bus := &starterActivity{orderedActivity: orderedActivity{name: "canbus", log: events}, startErr: errors.New("no such device")}
+	api := &orderedActivity{name: "api", log: events}
+
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	runner.RegisterActivities(bus)
+	if err := runner.RegisterActivity(api, "canbus"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if e, a := 1, runner.Run(ctx); e != a {
+		t.Errorf("expected exit code returned from run to be %d, got %d", e, a)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected a start failure to stop the runner straight away, took %v", elapsed)
+	}
+	if e, a := []string{"start canbus", "started canbus", "shutdown canbus", "kill canbus"}, events.get(); !slices.Equal(e, a) {
+		t.Errorf("expected events %v, got %v", e, a)
+	}
+
+	select {
+	case <-runner.Ready():
+		t.Error("expected the runner never to be ready")
+	default:
+	}
+}
+
+func TestServiceRunStartTimesOut(t *testing.T) {
+	events := &eventLog{}
+	bus := &starterActivity{orderedActivity: orderedActivity{name: "canbus", log: events}, delay: -1}
+
+	runner := service.NewRunner(logrus.StandardLogger(), time.Second, time.Second)
+	runner.SetStartupTimeout(50 * time.Millisecond)
+	runner.RegisterActivities(bus)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if e, a := 1, runner.Run(ctx); e != a {
+		t.Errorf("expected exit code returned from run to be %d, got %d", e, a)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the startup timeout to stop the runner, took %v", elapsed)
+	}
+	if runner.ActivityReady("canbus") {
+		t.Error("expected canbus never to be ready")
+	}
+}
@@ -0,0 +1,172 @@
+package usbcan
+
+import (
+	"errors"
+	"io"
+
+	"github.com/brutella/can"
+)
+
+// DecoderOptions is a type that contains options on a Decoder.
+type DecoderOptions struct {
+	// FrameHandler is called with each data frame.
+	FrameHandler can.HandlerFunc
+	// CommandHandler is called with each command frame that has a good checksum.
+	CommandHandler func(CommandFrame)
+}
+
+// Decoder turns a byte stream from the analyzer back into packets, handing each to the handlers as soon as it's
+// complete.  Bytes go straight into a fixed ring buffer and packets are decoded in place, so a steady stream of
+// frames never allocates.  Anything that doesn't decode only costs the 0xaa that looked like the start of a packet:
+// the Decoder resyncs on the very next 0xaa, so a corrupt packet never takes good ones after it down with it.
+type Decoder struct {
+	options DecoderOptions
+	rx      ring
+	skipped uint64
+
+	// command is scratch space for pulling a command frame out of the ring in one piece
+	command [CommandFrameLen]byte
+}
+
+// NewDecoder returns a Decoder calling the given handlers.
+func NewDecoder(options DecoderOptions) *Decoder {
+	return &Decoder{options: options}
+}
+
+// Write decodes bytes from a capture or any other source.  It never fails.
+func (d *Decoder) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := copy(d.rx.space(), p[written:])
+		d.rx.commit(n)
+		written += n
+		d.decode()
+	}
+	return written, nil
+}
+
+// ReadOnce does a single Read from r straight into the buffer, then decodes what's there.  Use it to read from a
+// port with a timeout, where a read can come back empty.
+func (d *Decoder) ReadOnce(r io.Reader) (int, error) {
+	n, err := r.Read(d.rx.space())
+	d.rx.commit(n)
+	d.decode()
+	return n, err
+}
+
+// ReadFrom decodes everything read from r until it fails, returning nil at io.EOF.
+func (d *Decoder) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		n, err := d.ReadOnce(r)
+		total += int64(n)
+		if errors.Is(err, io.EOF) {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Buffered returns the number of bytes held back as the start of a packet that hasn't finished arriving.
+func (d *Decoder) Buffered() int {
+	return d.rx.len()
+}
+
+// Skipped returns the number of bytes thrown away so far because they weren't part of a valid packet.
+func (d *Decoder) Skipped() uint64 {
+	return d.skipped
+}
+
+// Reset throws away any partial packet, i.e. after reopening the port.
+func (d *Decoder) Reset() {
+	d.rx.reset()
+}
+
+// decode is a helper to deliver every complete packet in the buffer, leaving a partial one at the end
+func (d *Decoder) decode() {
+	for d.rx.len() > 0 {
+		if d.rx.at(0) != 0xaa {
+			n := d.rx.index(0xaa)
+			if n == -1 {
+				n = d.rx.len()
+			}
+			d.skipped += uint64(n)
+			d.rx.discard(n)
+			continue
+		}
+
+		n, ok := d.decodePacket()
+		if !ok {
+			d.skipped++
+			d.rx.discard(1)
+			continue
+		}
+		if n == 0 {
+			return
+		}
+		d.rx.discard(n)
+	}
+}
+
+// decodePacket is a helper to decode the packet at the start of the buffer.  It returns how many bytes the packet
+// used, zero if it isn't all here yet, or false if the bytes aren't a valid packet.
+func (d *Decoder) decodePacket() (int, bool) {
+	r := &d.rx
+	if r.len() < 2 {
+		return 0, true
+	}
+
+	packetType := r.at(1)
+	switch {
+	case packetType == 0x55:
+		if r.len() < CommandFrameLen {
+			return 0, true
+		}
+		for i := range d.command {
+			d.command[i] = r.at(i)
+		}
+		if Checksum(d.command[:]) != d.command[CommandFrameLen-1] {
+			return 0, false
+		}
+		if d.options.CommandHandler != nil {
+			c := CommandFrame{Command: Command(d.command[2])}
+			copy(c.Payload[:], d.command[3:CommandFrameLen-1])
+			d.options.CommandHandler(c)
+		}
+		return CommandFrameLen, true
+
+	case packetType>>6 == 3:
+		dataLen := int(packetType & 0xf)
+		if dataLen > can.MaxFrameDataLength {
+			return 0, false
+		}
+		idLen := 2
+		if packetType&0x20 != 0 {
+			idLen = 4
+		}
+		frameLen := 2 + idLen + dataLen + 1
+		if r.len() < frameLen {
+			return 0, true
+		}
+		if r.at(frameLen-1) != 0x55 {
+			return 0, false
+		}
+
+		frame := can.Frame{Length: uint8(dataLen)}
+		for i := 0; i < idLen; i++ {
+			frame.ID |= uint32(r.at(2+i)) << (8 * i)
+		}
+		for i := 0; i < dataLen; i++ {
+			frame.Data[i] = r.at(2 + idLen + i)
+		}
+		if d.options.FrameHandler != nil {
+			d.options.FrameHandler(frame)
+		}
+		return frameLen, true
+
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,162 @@
+package usbcan
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+// testStream encodes frames the way the analyzer sends them
+func testStream(frames ...can.Frame) []byte {
+	var buf []byte
+	for _, f := range frames {
+		buf, _ = AppendFrame(buf, f)
+	}
+	return buf
+}
+
+// decodeInChunks feeds data to a decoder in writes of at most chunk bytes, returning the frames delivered
+func decodeInChunks(data []byte, chunk int) []can.Frame {
+	var got []can.Frame
+	d := NewDecoder(DecoderOptions{FrameHandler: func(f can.Frame) { got = append(got, f) }})
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		_, _ = d.Write(data[:n])
+		data = data[n:]
+	}
+	return got
+}
+
+var testFrames = []can.Frame{
+	{ID: 0x123, Length: 2, Data: [8]byte{0x01, 0xaa}},
+	{ID: 0x09F80101, Length: 8, Data: [8]byte{0xaa, 0x55, 3, 4, 5, 6, 7, 0xaa}},
+	{ID: 0x7ff, Length: 0},
+}
+
+func TestDecoderResyncsAfterCorruptPackets(t *testing.T) {
+	badEnd := testStream(can.Frame{ID: 0x321, Length: 3, Data: [8]byte{1, 2, 3}})
+	badEnd[len(badEnd)-1] = 0x56
+	// A data frame header claiming 15 bytes of data
+	badLength := []byte{0xaa, 0xcf, 0x01, 0x02}
+
+	var stream []byte
+	stream = append(stream, 0x00, 0x13)
+	stream = append(stream, testStream(testFrames[0])...)
+	stream = append(stream, badEnd...)
+	stream = append(stream, testStream(testFrames[1])...)
+	stream = append(stream, badLength...)
+	stream = append(stream, 0xaa, 0x12)
+	stream = append(stream, testStream(testFrames[2])...)
+
+	for _, chunk := range []int{1, 7, 32, len(stream)} {
+		require.Equal(t, testFrames, decodeInChunks(stream, chunk), "chunk %d", chunk)
+	}
+}
+
+func TestDecoderCommandFrames(t *testing.T) {
+	var got []CommandFrame
+	d := NewDecoder(DecoderOptions{CommandHandler: func(c CommandFrame) { got = append(got, c) }})
+
+	buf, err := AppendCommand(nil, CommandStatus, []byte{3, 7, 0x20})
+	require.NoError(t, err)
+	corrupt, err := AppendCommand(nil, CommandStatus, []byte{1})
+	require.NoError(t, err)
+	corrupt[19]++
+	buf = append(buf, corrupt...)
+
+	n, err := d.Write(buf)
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+	require.Zero(t, d.Buffered())
+	require.Equal(t, uint64(CommandFrameLen), d.Skipped())
+	require.Len(t, got, 1)
+	require.Equal(t, CommandStatus, got[0].Command)
+	require.Equal(t, Status{RxErrorCount: 3, TxErrorCount: 7, ErrorFlags: 0x20}, got[0].Status())
+}
+
+func TestDecoderReadFrom(t *testing.T) {
+	var got []can.Frame
+	d := NewDecoder(DecoderOptions{FrameHandler: func(f can.Frame) { got = append(got, f) }})
+
+	// A capture bigger than the buffer, ending part way through a frame
+	var capture []byte
+	for len(capture) < 3*ringSize {
+		capture = append(capture, testStream(testFrames...)...)
+	}
+	capture = append(capture, 0xaa, 0xc8, 0x01)
+
+	n, err := d.ReadFrom(bytes.NewReader(capture))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(capture)), n)
+	require.Len(t, got, (len(capture)-3)/len(testStream(testFrames...))*len(testFrames))
+	require.Equal(t, 3, d.Buffered())
+
+	d.Reset()
+	require.Zero(t, d.Buffered())
+}
+
+func TestDecoderDoesNotAllocate(t *testing.T) {
+	d := NewDecoder(DecoderOptions{FrameHandler: func(can.Frame) {}})
+	stream := testStream(testFrames...)
+	stream = append(stream, 0x00, 0xaa, 0xc8)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = d.Write(stream)
+		d.Reset()
+	})
+	require.Zero(t, allocs)
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	frames := make([]can.Frame, 200)
+	for i := range frames {
+		frames[i] = can.Frame{ID: 0x09F80100 + uint32(i), Length: 8, Data: [8]byte{byte(i), 1, 2, 3, 4, 5, 6, 7}}
+	}
+	stream := testStream(frames...)
+
+	for _, readSize := range []int{32, 512, ringSize} {
+		b.Run(fmt.Sprintf("read%d", readSize), func(b *testing.B) {
+			d := NewDecoder(DecoderOptions{FrameHandler: func(can.Frame) {}})
+			r := &limitedReader{r: bytes.NewReader(stream), n: readSize}
+			b.SetBytes(int64(len(stream)))
+			b.ReportAllocs()
+			for b.Loop() {
+				r.r.Reset(stream)
+				for {
+					if _, err := d.ReadOnce(r); err != nil {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+// limitedReader caps each Read, like a serial port handing over whatever has arrived
+type limitedReader struct {
+	r *bytes.Reader
+	n int
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	return l.r.Read(p[:min(len(p), l.n)])
+}
+
+func FuzzDecoder(f *testing.F) {
+	status, _ := AppendCommand(nil, CommandStatus, []byte{1, 2, 3})
+	f.Add(testStream(testFrames...), uint8(1))
+	f.Add(append(status, testStream(testFrames[0])...), uint8(5))
+	f.Add([]byte{0xaa, 0xcf, 0xaa, 0xc1, 0x01, 0x02, 0x03, 0x55}, uint8(3))
+
+	f.Fuzz(func(t *testing.T, data []byte, chunk uint8) {
+		whole := decodeInChunks(data, len(data))
+		for _, frame := range whole {
+			require.LessOrEqual(t, frame.Length, uint8(can.MaxFrameDataLength))
+		}
+		// How the bytes are split across reads must never change what's decoded
+		require.Equal(t, whole, decodeInChunks(data, int(chunk)+1))
+	})
+}
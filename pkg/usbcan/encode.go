@@ -0,0 +1,128 @@
+package usbcan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/brutella/can"
+)
+
+// AppendFrame encodes a data frame onto buf.  IDs that don't fit in 16 bits are sent as extended frames.
+func AppendFrame(buf []byte, frame can.Frame) ([]byte, error) {
+	if frame.Length > can.MaxFrameDataLength {
+		return buf, fmt.Errorf("invalid frame length %d", frame.Length)
+	}
+
+	frameType := 0xc0 | frame.Length
+	// Not sure if this is the right way to do it, but for now give it a shot
+	// (we're only sending standard frames over calex, so need to test this on n2k someday if we care...)
+	extended := frame.ID > 0xffff
+	if extended {
+		frameType |= 0x20
+	}
+
+	buf = append(buf, 0xaa, frameType, byte(frame.ID), byte(frame.ID>>8))
+	if extended {
+		buf = append(buf, byte(frame.ID>>16), byte(frame.ID>>24))
+	}
+	buf = append(buf, frame.Data[0:frame.Length]...)
+	return append(buf, 0x55), nil
+}
+
+// AppendCommand encodes a command frame with up to 16 bytes of payload onto buf.
+func AppendCommand(buf []byte, command Command, payload []byte) ([]byte, error) {
+	if len(payload) > 16 {
+		return buf, fmt.Errorf("command payload too long: %d bytes", len(payload))
+	}
+
+	start := len(buf)
+	buf = append(buf, 0xaa, 0x55, byte(command))
+	buf = append(buf, payload...)
+	for len(buf)-start < CommandFrameLen {
+		buf = append(buf, 0)
+	}
+	buf[len(buf)-1] = Checksum(buf[start:])
+	return buf, nil
+}
+
+// AppendSettings encodes a CommandSetFixed frame onto buf.
+func AppendSettings(buf []byte, settings Settings) ([]byte, error) {
+	br, err := BitRateSetting(settings.BitRate)
+	if err != nil {
+		return buf, err
+	}
+
+	payload := make([]byte, 0, 12)
+	payload = append(payload, br, byte(settings.FrameType))
+	payload = binary.LittleEndian.AppendUint32(payload, settings.Filter)
+	payload = binary.LittleEndian.AppendUint32(payload, settings.Mask)
+	payload = append(payload, byte(settings.Mode), 0x01)
+
+	return AppendCommand(buf, CommandSetFixed, payload)
+}
+
+// ParseSettings decodes the payload of a CommandSetFixed frame, as some firmwares echo it back.  The bitrate is
+// zero if the setting byte isn't one we know.
+func ParseSettings(c CommandFrame) Settings {
+	return Settings{
+		BitRate:   bitRateFromSetting(c.Payload[0]),
+		FrameType: FrameType(c.Payload[1]),
+		Filter:    binary.LittleEndian.Uint32(c.Payload[2:6]),
+		Mask:      binary.LittleEndian.Uint32(c.Payload[6:10]),
+		Mode:      Mode(c.Payload[10]),
+	}
+}
+
+// Encoder writes packets to an io.Writer, typically a serial port.  Each packet goes out in a single Write, so an
+// Encoder can be shared between goroutines if the writer can.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// WriteFrame sends a data frame.
+func (e *Encoder) WriteFrame(frame can.Frame) error {
+	var scratch [DataFrameMaxLen]byte
+	buf, err := AppendFrame(scratch[:0], frame)
+	if err != nil {
+		return err
+	}
+	return e.write(buf)
+}
+
+// WriteCommand sends a command frame.
+func (e *Encoder) WriteCommand(command Command, payload []byte) error {
+	var scratch [CommandFrameLen]byte
+	buf, err := AppendCommand(scratch[:0], command, payload)
+	if err != nil {
+		return err
+	}
+	return e.write(buf)
+}
+
+// WriteSettings sends a CommandSetFixed frame.
+func (e *Encoder) WriteSettings(settings Settings) error {
+	var scratch [CommandFrameLen]byte
+	buf, err := AppendSettings(scratch[:0], settings)
+	if err != nil {
+		return err
+	}
+	return e.write(buf)
+}
+
+// write is a helper to send a packet, treating a short write as an error
+func (e *Encoder) write(buf []byte) error {
+	o, err := e.w.Write(buf)
+	if err != nil {
+		return err
+	}
+	if o != len(buf) {
+		return fmt.Errorf("sent %d of %d bytes", o, len(buf))
+	}
+	return nil
+}
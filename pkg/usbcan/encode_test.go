@@ -0,0 +1,72 @@
+package usbcan
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendFrame(t *testing.T) {
+	buf, err := AppendFrame(nil, can.Frame{ID: 0x123, Length: 2, Data: [8]byte{0x01, 0x02}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xaa, 0xc2, 0x23, 0x01, 0x01, 0x02, 0x55}, buf)
+
+	buf, err = AppendFrame(buf[:0], can.Frame{ID: 0x09F80101, Length: 1, Data: [8]byte{0xff}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xaa, 0xe1, 0x01, 0x01, 0xf8, 0x09, 0xff, 0x55}, buf)
+
+	_, err = AppendFrame(nil, can.Frame{Length: 9})
+	require.Error(t, err)
+}
+
+func TestAppendCommand(t *testing.T) {
+	buf, err := AppendCommand([]byte{0x00}, CommandStatus, []byte{1, 2})
+	require.NoError(t, err)
+	require.Len(t, buf, 1+CommandFrameLen)
+	require.Equal(t, []byte{0xaa, 0x55, 0x04, 1, 2}, buf[1:6])
+	require.Equal(t, byte(0x07), buf[len(buf)-1])
+
+	_, err = AppendCommand(nil, CommandStatus, make([]byte, 17))
+	require.Error(t, err)
+}
+
+func TestSettingsRoundTrip(t *testing.T) {
+	settings := Settings{BitRate: 250000, FrameType: FrameExtended, Filter: 0x100, Mask: 0x7ff, Mode: ModeSilent}
+	buf, err := AppendSettings(nil, settings)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xaa, 0x55, 0x12, 0x05, 0x02}, buf[:5])
+	require.Equal(t, byte(0x01), buf[14])
+
+	var got []CommandFrame
+	d := NewDecoder(DecoderOptions{CommandHandler: func(c CommandFrame) { got = append(got, c) }})
+	_, _ = d.Write(buf)
+	require.Len(t, got, 1)
+	require.Equal(t, CommandSetFixed, got[0].Command)
+	require.Equal(t, settings, ParseSettings(got[0]))
+
+	_, err = AppendSettings(nil, Settings{BitRate: 12345})
+	require.Error(t, err)
+}
+
+// shortWriter accepts at most n bytes per write
+type shortWriter struct {
+	bytes.Buffer
+	n int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	return w.Buffer.Write(p[:min(len(p), w.n)])
+}
+
+func TestEncoder(t *testing.T) {
+	var out bytes.Buffer
+	e := NewEncoder(&out)
+	require.NoError(t, e.WriteFrame(can.Frame{ID: 0x10, Length: 1, Data: [8]byte{0x42}}))
+	require.NoError(t, e.WriteCommand(CommandStatus, nil))
+	require.NoError(t, e.WriteSettings(Settings{BitRate: 500000}))
+	require.Equal(t, 6+2*CommandFrameLen, out.Len())
+
+	require.ErrorContains(t, NewEncoder(&shortWriter{n: 4}).WriteFrame(can.Frame{Length: 8}), "sent 4 of 13 bytes")
+}
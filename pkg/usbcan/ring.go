@@ -0,0 +1,55 @@
+package usbcan
+
+// ringSize is the size of the receive buffer.  It's a power of two so wrapping an index is a mask.
+const ringSize = 4096
+
+// ring is a fixed receive buffer that reads go straight into, so the read path never allocates or copies to make
+// room.  head and tail only ever count up and are wrapped into buf when used.
+type ring struct {
+	buf  [ringSize]byte
+	head uint
+	tail uint
+}
+
+// len returns the number of undecoded bytes
+func (r *ring) len() int {
+	return int(r.tail - r.head)
+}
+
+// at returns the i'th undecoded byte
+func (r *ring) at(i int) byte {
+	return r.buf[(r.head+uint(i))%ringSize]
+}
+
+// index returns the offset of the first b after the first undecoded byte, or -1
+func (r *ring) index(b byte) int {
+	for i := 1; i < r.len(); i++ {
+		if r.at(i) == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// discard drops n decoded bytes
+func (r *ring) discard(n int) {
+	r.head += uint(n)
+}
+
+// reset drops everything
+func (r *ring) reset() {
+	r.head, r.tail = 0, 0
+}
+
+// space returns the free part of buf after tail, up to the wrap point, for Read to fill.  The decoder never leaves
+// more than one partial packet behind, so this is never empty.
+func (r *ring) space() []byte {
+	start := int(r.tail % ringSize)
+	end := min(ringSize, start+ringSize-r.len())
+	return r.buf[start:end]
+}
+
+// commit marks n bytes of the last space as filled
+func (r *ring) commit(n int) {
+	r.tail += uint(n)
+}
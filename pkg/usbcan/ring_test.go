@@ -0,0 +1,32 @@
+package usbcan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingWraps(t *testing.T) {
+	var r ring
+	require.Len(t, r.space(), ringSize)
+
+	// Fill up to just short of the end, then decode most of it away
+	r.commit(ringSize - 3)
+	r.discard(ringSize - 5)
+	require.Equal(t, 2, r.len())
+
+	// Reads fill to the end of the buffer first, then wrap around to the start
+	require.Len(t, r.space(), 3)
+	r.commit(copy(r.space(), []byte{1, 2, 3}))
+	r.commit(copy(r.space(), []byte{4, 5, 6}))
+	require.Equal(t, 8, r.len())
+	require.Equal(t, byte(1), r.at(2))
+	require.Equal(t, byte(6), r.at(7))
+	require.Equal(t, 5, r.index(4))
+	require.Equal(t, -1, r.index(9))
+	require.Len(t, r.space(), ringSize-8)
+
+	// A full ring has no space left
+	r.commit(len(r.space()))
+	require.Empty(t, r.space())
+}
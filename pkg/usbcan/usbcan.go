@@ -0,0 +1,171 @@
+// Package usbcan is the serial wire format spoken by the common "USB-CAN Analyzer" adapters (Seeed and the many
+// QinHeng CH340-based clones).  It has no dependency on a serial port: the Decoder reads from any io.Reader or byte
+// slice, and the Encoder and Append functions write to any io.Writer or byte slice.
+//
+// The format has two kinds of packet, both starting with 0xaa:
+//   - data frames: 0xaa, a type byte (0xc0 | extended 0x20 | remote 0x10 | length), a little-endian 2 or 4 byte
+//     ID, the data, and 0x55
+//   - command frames: 0xaa 0x55, a command byte, 16 bytes of payload and a checksum, 20 bytes in all
+//
+// Docs for this whole crappy thing:
+//   - https://github.com/SeeedDocument/USB-CAN-Analyzer/blob/master/res/Document/USB%20(Serial%20port)%20to%20CAN%20protocol%20defines.pdf
+//   - https://github.com/SeeedDocument/USB-CAN-Analyzer?tab=readme-ov-file
+//   - https://github.com/kobolt/usb-can/blob/master/canusb.c
+package usbcan
+
+import (
+	"fmt"
+)
+
+// Mode is an enum for the analyzer's CAN controller modes
+type Mode byte
+
+const (
+	ModeNormal         Mode = 0
+	ModeLoopback       Mode = 1
+	ModeSilent         Mode = 2
+	ModeLoopbackSilent Mode = 3
+)
+
+// FrameType is an enum for the frame type the analyzer is configured for
+type FrameType byte
+
+const (
+	FrameStandard FrameType = 1
+	FrameExtended FrameType = 2
+)
+
+// Command is an enum for the command byte of 0xaa 0x55 command frames
+type Command byte
+
+const (
+	// CommandSetVariable configures the analyzer for variable-length frames (not used by this package).
+	CommandSetVariable Command = 0x02
+	// CommandStatus queries the CAN controller status (error counters and flags).
+	CommandStatus Command = 0x04
+	// CommandSetFixed configures the analyzer with the fixed 20-byte settings frame.
+	CommandSetFixed Command = 0x12
+)
+
+const (
+	// CommandFrameLen is the fixed length of every 0xaa 0x55 command frame, in both directions.
+	CommandFrameLen = 20
+	// DataFrameMaxLen is the longest data frame: 0xaa, type, 4 byte ID, 8 data bytes, 0x55.
+	DataFrameMaxLen = 15
+)
+
+// CommandFrame is a decoded 0xaa 0x55 command frame.
+type CommandFrame struct {
+	Command Command
+	// Payload holds bytes 3 through 18 of the frame, between the command byte and the checksum.
+	Payload [16]byte
+}
+
+// Status is the CAN controller status reported by the analyzer in response to a CommandStatus query.  There's no
+// published document for the reply, and only bytes 0-2 have been seen from real analyzers; bytes 3 and 4 are what
+// usbcantest.Emulator sends and are unverified, so callers must treat a zero BitRate as "not reported".  The
+// response payload is laid out as:
+//
+//	byte 0: receive error counter
+//	byte 1: transmit error counter
+//	byte 2: error flags, raw from the firmware
+//	byte 3: the bitrate setting byte last configured (see BitRateSetting), or 0 before the first settings frame
+//	byte 4: the Mode last configured
+//	bytes 5-15: unused, sent as 0
+type Status struct {
+	RxErrorCount uint8
+	TxErrorCount uint8
+	// ErrorFlags is the raw error flags byte as reported by the firmware.
+	ErrorFlags uint8
+	// BitRate and Mode are the settings the controller is running with.  BitRate is zero if it hasn't been
+	// configured, or reports a setting byte we don't know.
+	BitRate int
+	Mode    Mode
+}
+
+// Status interprets the payload of a CommandStatus response.
+func (c CommandFrame) Status() Status {
+	return Status{
+		RxErrorCount: c.Payload[0],
+		TxErrorCount: c.Payload[1],
+		ErrorFlags:   c.Payload[2],
+		BitRate:      bitRateFromSetting(c.Payload[3]),
+		Mode:         Mode(c.Payload[4]),
+	}
+}
+
+// AppendStatus encodes a CommandStatus response onto buf, as the analyzer sends it.
+func AppendStatus(buf []byte, status Status) ([]byte, error) {
+	var br byte
+	if status.BitRate != 0 {
+		var err error
+		if br, err = BitRateSetting(status.BitRate); err != nil {
+			return buf, err
+		}
+	}
+	return AppendCommand(buf, CommandStatus, []byte{status.RxErrorCount, status.TxErrorCount, status.ErrorFlags, br, byte(status.Mode)})
+}
+
+// Settings is the configuration sent in a CommandSetFixed frame
+type Settings struct {
+	BitRate   int
+	FrameType FrameType
+	// Filter and Mask are the acceptance filter; a zero Mask accepts everything.
+	Filter uint32
+	Mask   uint32
+	Mode   Mode
+}
+
+// BitRateSetting maps a bitrate to the analyzer's setting byte for it.
+func BitRateSetting(bitRate int) (byte, error) {
+	switch bitRate {
+	case 1000000:
+		return 0x01, nil
+	case 800000:
+		return 0x02, nil
+	case 500000:
+		return 0x03, nil
+	case 400000:
+		return 0x04, nil
+	case 250000:
+		return 0x05, nil
+	case 200000:
+		return 0x06, nil
+	case 125000:
+		return 0x07, nil
+	case 100000:
+		return 0x08, nil
+	case 50000:
+		return 0x09, nil
+	case 20000:
+		return 0x0a, nil
+	case 10000:
+		return 0x0b, nil
+	case 5000:
+		return 0x0c, nil
+	default:
+		return 0, fmt.Errorf("no matching bitrate setting for %d", bitRate)
+	}
+}
+
+// bitRates is every bitrate the analyzer supports, fastest first
+var bitRates = []int{1000000, 800000, 500000, 400000, 250000, 200000, 125000, 100000, 50000, 20000, 10000, 5000}
+
+// bitRateFromSetting maps a setting byte back to its bitrate, or zero if it isn't one we know.
+func bitRateFromSetting(setting byte) int {
+	for _, bitRate := range bitRates {
+		if br, _ := BitRateSetting(bitRate); br == setting {
+			return bitRate
+		}
+	}
+	return 0
+}
+
+// Checksum calculates a command frame's checksum, which is the low byte of the sum of the command and payload.
+func Checksum(frame []byte) byte {
+	cs := byte(0)
+	for _, b := range frame[2 : CommandFrameLen-1] {
+		cs += b
+	}
+	return cs
+}
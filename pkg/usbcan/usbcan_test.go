@@ -0,0 +1,46 @@
+package usbcan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitRateSetting(t *testing.T) {
+	br, err := BitRateSetting(250000)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x05), br)
+
+	_, err = BitRateSetting(300000)
+	require.Error(t, err)
+}
+
+func TestChecksum(t *testing.T) {
+	frame := make([]byte, CommandFrameLen)
+	frame[0], frame[1], frame[2] = 0xaa, 0x55, 0x12
+	frame[3], frame[18] = 0xf0, 0x20
+	// The header and the checksum byte itself aren't summed
+	frame[19] = 0x99
+	require.Equal(t, byte(0x22), Checksum(frame))
+}
+
+func TestCommandFrameStatus(t *testing.T) {
+	c := CommandFrame{Command: CommandStatus, Payload: [16]byte{1, 128, 0x20}}
+	require.Equal(t, Status{RxErrorCount: 1, TxErrorCount: 128, ErrorFlags: 0x20}, c.Status())
+}
+
+func TestStatusRoundTrip(t *testing.T) {
+	status := Status{RxErrorCount: 1, TxErrorCount: 2, ErrorFlags: 0x20, BitRate: 250000, Mode: ModeSilent}
+	buf, err := AppendStatus(nil, status)
+	require.NoError(t, err)
+	require.Len(t, buf, CommandFrameLen)
+	require.Equal(t, []byte{1, 2, 0x20, 0x05, 0x02}, buf[3:8])
+
+	var c CommandFrame
+	c.Command = Command(buf[2])
+	copy(c.Payload[:], buf[3:19])
+	require.Equal(t, status, c.Status())
+
+	_, err = AppendStatus(nil, Status{BitRate: 12345})
+	require.Error(t, err)
+}
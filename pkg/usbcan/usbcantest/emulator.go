@@ -0,0 +1,320 @@
+// Package usbcantest emulates a USB-CAN analyzer, so code driving one can be tested end to end without hardware.
+// The emulator is reached either through an in-memory serial.Port or, on Linux, a pseudo-terminal.
+package usbcantest
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/boatkit-io/tugboat/pkg/usbcan"
+	"github.com/brutella/can"
+	"go.bug.st/serial"
+)
+
+// Corruption is an enum for the kinds of damage the emulator can do to a frame on its way to the host
+type Corruption int
+
+const (
+	// CorruptEndByte replaces the trailing 0x55, as happens when a byte is mangled on the serial line.
+	CorruptEndByte Corruption = iota
+	// CorruptTruncate cuts the frame short, as if the adapter's buffer overflowed.  The host can't tell until enough
+	// of the following frames arrive to fill out the length in the header.
+	CorruptTruncate
+	// CorruptNoise sends a burst of line noise ahead of the frame.
+	CorruptNoise
+	// CorruptBitFlip flips a random bit anywhere in the frame.
+	CorruptBitFlip
+
+	numCorruptions = 4
+)
+
+// ErrNotConnected is returned when sending to the host while nothing has the emulator open.
+var ErrNotConnected = errors.New("no host is connected to the emulator")
+
+// EmulatorOptions is a type that contains options on an Emulator.
+type EmulatorOptions struct {
+	// Status is what the emulator reports when asked with CommandStatus.  Its BitRate and Mode are filled in from
+	// the settings the host last configured.
+	Status usbcan.Status
+	// EchoSettings makes the emulator answer settings frames by echoing them back, as some firmwares do.
+	EchoSettings bool
+	// Unresponsive makes the emulator ignore every command, for testing timeouts.
+	Unresponsive bool
+	// FrameHandler receives each frame the host transmits onto the emulated bus.
+	FrameHandler can.HandlerFunc
+	// CorruptRate is the chance each frame sent with SendFrame is damaged with a random Corruption.
+	CorruptRate float64
+	// Seed seeds the corruption so a test run can be repeated.  Zero picks a random seed.
+	Seed uint64
+}
+
+// hostConn is how the emulator sends bytes back to whatever has it open
+type hostConn interface {
+	deliver(b []byte) error
+}
+
+// Emulator pretends to be a USB-CAN analyzer.  It takes the settings frame the host sends at startup and honors
+// its mode: normal mode puts the host's frames on the emulated bus (FrameHandler), the loopback modes echo them
+// straight back instead, and the silent modes never transmit.  Traffic from the rest of the bus is injected with
+// SendFrame, optionally corrupted the ways a real serial link corrupts it.
+type Emulator struct {
+	options EmulatorOptions
+
+	// decodeMu serializes the bytes the host writes, since Port writes can come from several goroutines
+	decodeMu sync.Mutex
+	decoder  *usbcan.Decoder
+
+	mu       sync.Mutex
+	host     hostConn
+	settings *usbcan.Settings
+	closed   bool
+	closers  []io.Closer
+	rng      *rand.Rand
+}
+
+// NewEmulator returns an Emulator with the given options.
+func NewEmulator(options EmulatorOptions) *Emulator {
+	seed := options.Seed
+	if seed == 0 {
+		seed = rand.Uint64() // #nosec G404 -- emulated corruption doesn't need cryptographic randomness.
+	}
+
+	e := &Emulator{
+		options: options,
+		rng:     rand.New(rand.NewPCG(seed, seed)), // #nosec G404 -- emulated corruption doesn't need cryptographic randomness.
+	}
+	e.decoder = usbcan.NewDecoder(usbcan.DecoderOptions{
+		FrameHandler:   e.handleFrame,
+		CommandHandler: e.handleCommand,
+	})
+	return e
+}
+
+// Settings returns the settings the host last configured, if it has.
+func (e *Emulator) Settings() (usbcan.Settings, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.settings == nil {
+		return usbcan.Settings{}, false
+	}
+	return *e.settings, true
+}
+
+// Open connects a new in-memory port to the emulator, replacing any earlier connection.  It has the signature of
+// serial.Open so it can be used as USBCANChannelOptions.OpenPort; the name and mode are ignored.
+func (e *Emulator) Open(string, *serial.Mode) (serial.Port, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil, errors.New("emulator is closed")
+	}
+	p := newPort(e)
+	e.host = p
+	e.closers = append(e.closers, p)
+	return p, nil
+}
+
+// Serve talks to a host over rw until reading fails, i.e. the controlling side of a pty.  io.EOF is a clean end.
+func (e *Emulator) Serve(rw io.ReadWriter) error {
+	conn := &writerConn{w: rw}
+	e.connect(conn)
+	defer e.disconnect(conn)
+
+	buf := make([]byte, 512)
+	for {
+		n, err := rw.Read(buf)
+		if n > 0 {
+			e.receive(buf[:n])
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close disconnects the host and closes everything the emulator opened.
+func (e *Emulator) Close() error {
+	e.mu.Lock()
+	e.closed = true
+	e.host = nil
+	closers := e.closers
+	e.closers = nil
+	e.mu.Unlock()
+
+	var errs []error
+	for _, c := range closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// SendFrame sends a frame from the emulated bus to the host, corrupting it at CorruptRate.  Frames are dropped, as
+// the controller would, while the host has it in a loopback mode.
+func (e *Emulator) SendFrame(frame can.Frame) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.options.CorruptRate > 0 && e.rng.Float64() < e.options.CorruptRate {
+		return e.sendFrameLocked(frame, Corruption(e.rng.IntN(numCorruptions)), true)
+	}
+	return e.sendFrameLocked(frame, 0, false)
+}
+
+// SendCorruptFrame sends a frame to the host damaged in the given way.
+func (e *Emulator) SendCorruptFrame(frame can.Frame, corruption Corruption) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.sendFrameLocked(frame, corruption, true)
+}
+
+// SendRaw sends bytes to the host exactly as given.
+func (e *Emulator) SendRaw(b []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.sendLocked(b)
+}
+
+// sendFrameLocked is a helper to encode, optionally corrupt and send a frame from the bus
+func (e *Emulator) sendFrameLocked(frame can.Frame, corruption Corruption, corrupt bool) error {
+	if mode := e.modeLocked(); mode == usbcan.ModeLoopback || mode == usbcan.ModeLoopbackSilent {
+		return nil
+	}
+
+	buf, err := usbcan.AppendFrame(nil, frame)
+	if err != nil {
+		return err
+	}
+	if corrupt {
+		buf = e.corruptLocked(buf, corruption)
+	}
+	return e.sendLocked(buf)
+}
+
+// corruptLocked is a helper to damage an encoded frame
+func (e *Emulator) corruptLocked(buf []byte, corruption Corruption) []byte {
+	switch corruption {
+	case CorruptEndByte:
+		buf[len(buf)-1] = byte(e.rng.IntN(0x55))
+	case CorruptTruncate:
+		buf = buf[:1+e.rng.IntN(len(buf)-1)]
+	case CorruptNoise:
+		noise := make([]byte, 1+e.rng.IntN(8))
+		for i := range noise {
+			noise[i] = byte(e.rng.IntN(256))
+		}
+		buf = append(noise, buf...)
+	case CorruptBitFlip:
+		buf[e.rng.IntN(len(buf))] ^= 1 << e.rng.IntN(8)
+	default:
+	}
+	return buf
+}
+
+// sendLocked is a helper to hand bytes to the connected host
+func (e *Emulator) sendLocked(b []byte) error {
+	if e.host == nil {
+		return ErrNotConnected
+	}
+	return e.host.deliver(b)
+}
+
+// modeLocked returns the configured controller mode, which is normal until the host sends settings
+func (e *Emulator) modeLocked() usbcan.Mode {
+	if e.settings == nil {
+		return usbcan.ModeNormal
+	}
+	return e.settings.Mode
+}
+
+// receive decodes bytes the host wrote
+func (e *Emulator) receive(b []byte) {
+	e.decodeMu.Lock()
+	defer e.decodeMu.Unlock()
+
+	_, _ = e.decoder.Write(b)
+}
+
+// handleFrame is called with each data frame the host writes
+func (e *Emulator) handleFrame(frame can.Frame) {
+	e.mu.Lock()
+	mode := e.modeLocked()
+	if mode == usbcan.ModeLoopback || mode == usbcan.ModeLoopbackSilent {
+		buf, _ := usbcan.AppendFrame(nil, frame)
+		_ = e.sendLocked(buf)
+	}
+	e.mu.Unlock()
+
+	if mode == usbcan.ModeNormal && e.options.FrameHandler != nil {
+		e.options.FrameHandler(frame)
+	}
+}
+
+// handleCommand is called with each command frame the host writes
+func (e *Emulator) handleCommand(c usbcan.CommandFrame) {
+	if e.options.Unresponsive {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch c.Command {
+	case usbcan.CommandSetFixed:
+		settings := usbcan.ParseSettings(c)
+		e.settings = &settings
+		if e.options.EchoSettings {
+			buf, _ := usbcan.AppendCommand(nil, c.Command, c.Payload[:])
+			_ = e.sendLocked(buf)
+		}
+	case usbcan.CommandStatus:
+		status := e.options.Status
+		if e.settings != nil {
+			status.BitRate, status.Mode = e.settings.BitRate, e.settings.Mode
+		}
+		buf, _ := usbcan.AppendStatus(nil, status)
+		_ = e.sendLocked(buf)
+	default:
+	}
+}
+
+func (e *Emulator) isClosed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.closed
+}
+
+func (e *Emulator) connect(conn hostConn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.host = conn
+}
+
+func (e *Emulator) disconnect(conn hostConn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.host == conn {
+		e.host = nil
+	}
+}
+
+// writerConn sends to a host reached through a plain writer
+type writerConn struct {
+	w io.Writer
+}
+
+func (c *writerConn) deliver(b []byte) error {
+	_, err := c.w.Write(b)
+	return err
+}
@@ -0,0 +1,164 @@
+package usbcantest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boatkit-io/tugboat/pkg/usbcan"
+	"github.com/brutella/can"
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+// testHost is the host side of an emulator connection, decoding whatever the emulator sends
+type testHost struct {
+	port     serial.Port
+	encoder  *usbcan.Encoder
+	frames   chan can.Frame
+	commands chan usbcan.CommandFrame
+}
+
+func openTestHost(t *testing.T, e *Emulator) *testHost {
+	port, err := e.Open("", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = e.Close() })
+
+	h := &testHost{
+		port:     port,
+		encoder:  usbcan.NewEncoder(port),
+		frames:   make(chan can.Frame, 16),
+		commands: make(chan usbcan.CommandFrame, 16),
+	}
+	decoder := usbcan.NewDecoder(usbcan.DecoderOptions{
+		FrameHandler:   func(f can.Frame) { h.frames <- f },
+		CommandHandler: func(c usbcan.CommandFrame) { h.commands <- c },
+	})
+	go func() {
+		_, _ = decoder.ReadFrom(port)
+	}()
+	return h
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("nothing was received")
+		var zero T
+		return zero
+	}
+}
+
+var testFrame = can.Frame{ID: 0x123, Length: 3, Data: [8]byte{1, 2, 3}}
+
+func TestEmulatorCommands(t *testing.T) {
+	e := NewEmulator(EmulatorOptions{Status: usbcan.Status{TxErrorCount: 9}, EchoSettings: true})
+	h := openTestHost(t, e)
+
+	_, ok := e.Settings()
+	require.False(t, ok)
+
+	settings := usbcan.Settings{BitRate: 250000, FrameType: usbcan.FrameStandard, Mode: usbcan.ModeSilent}
+	require.NoError(t, h.encoder.WriteSettings(settings))
+	echo := receive(t, h.commands)
+	require.Equal(t, usbcan.CommandSetFixed, echo.Command)
+	require.Equal(t, settings, usbcan.ParseSettings(echo))
+	got, ok := e.Settings()
+	require.True(t, ok)
+	require.Equal(t, settings, got)
+
+	require.NoError(t, h.encoder.WriteCommand(usbcan.CommandStatus, nil))
+	require.Equal(t, usbcan.Status{TxErrorCount: 9, BitRate: 250000, Mode: usbcan.ModeSilent}, receive(t, h.commands).Status())
+}
+
+func TestEmulatorModes(t *testing.T) {
+	transmitted := make(chan can.Frame, 4)
+	e := NewEmulator(EmulatorOptions{FrameHandler: func(f can.Frame) { transmitted <- f }})
+	h := openTestHost(t, e)
+
+	// Normal mode puts the host's frames on the bus and delivers the bus's frames
+	require.NoError(t, h.encoder.WriteFrame(testFrame))
+	require.Equal(t, testFrame, receive(t, transmitted))
+	require.NoError(t, e.SendFrame(testFrame))
+	require.Equal(t, testFrame, receive(t, h.frames))
+
+	// Loopback mode echoes the host's frames back and ignores the bus
+	require.NoError(t, h.encoder.WriteSettings(usbcan.Settings{BitRate: 250000, Mode: usbcan.ModeLoopback}))
+	require.NoError(t, e.SendFrame(can.Frame{ID: 0x1}))
+	require.NoError(t, h.encoder.WriteFrame(testFrame))
+	require.Equal(t, testFrame, receive(t, h.frames))
+
+	// Silent mode never transmits
+	require.NoError(t, h.encoder.WriteSettings(usbcan.Settings{BitRate: 250000, Mode: usbcan.ModeSilent}))
+	require.NoError(t, h.encoder.WriteFrame(testFrame))
+	require.NoError(t, e.SendFrame(can.Frame{ID: 0x2}))
+	require.Equal(t, uint32(0x2), receive(t, h.frames).ID)
+	require.Empty(t, transmitted)
+	require.Empty(t, h.frames)
+}
+
+func TestEmulatorCorruption(t *testing.T) {
+	e := NewEmulator(EmulatorOptions{Seed: 7})
+	h := openTestHost(t, e)
+
+	damaged := can.Frame{ID: 0x10, Length: 2, Data: [8]byte{0x11, 0x22}}
+	for _, corruption := range []Corruption{CorruptEndByte, CorruptTruncate} {
+		require.NoError(t, e.SendCorruptFrame(damaged, corruption))
+		require.NoError(t, e.SendFrame(testFrame))
+		require.Equal(t, testFrame, receive(t, h.frames), "after corruption %d", corruption)
+	}
+
+	// Noise ahead of a frame is skipped, leaving the frame itself intact
+	require.NoError(t, e.SendCorruptFrame(damaged, CorruptNoise))
+	require.Equal(t, damaged, receive(t, h.frames))
+
+	// Every frame gets damaged at a rate of 1, and the host resyncs on the good frames that follow
+	corrupting := NewEmulator(EmulatorOptions{CorruptRate: 1, Seed: 7})
+	ch := openTestHost(t, corrupting)
+	for range 20 {
+		require.NoError(t, corrupting.SendFrame(damaged))
+	}
+	require.NoError(t, corrupting.SendRaw(append([]byte{0x00, 0x00}, mustAppendFrame(t, testFrame)...)))
+	for {
+		if f := receive(t, ch.frames); f == testFrame {
+			break
+		}
+	}
+}
+
+func mustAppendFrame(t *testing.T, frame can.Frame) []byte {
+	buf, err := usbcan.AppendFrame(nil, frame)
+	require.NoError(t, err)
+	return buf
+}
+
+func TestEmulatorUnresponsive(t *testing.T) {
+	e := NewEmulator(EmulatorOptions{Unresponsive: true})
+	h := openTestHost(t, e)
+
+	require.NoError(t, h.encoder.WriteCommand(usbcan.CommandStatus, nil))
+	select {
+	case <-h.commands:
+		t.Fatal("unresponsive emulator answered")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestEmulatorDisconnected(t *testing.T) {
+	e := NewEmulator(EmulatorOptions{})
+	require.ErrorIs(t, e.SendFrame(testFrame), ErrNotConnected)
+
+	port, err := e.Open("", nil)
+	require.NoError(t, err)
+	require.NoError(t, port.Close())
+	require.ErrorIs(t, e.SendFrame(testFrame), ErrNotConnected)
+	_, err = port.Write([]byte{0xaa})
+	require.Error(t, err)
+
+	require.NoError(t, e.Close())
+	_, err = e.Open("", nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,137 @@
+package usbcantest
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// port is an in-memory serial.Port whose other end is an Emulator
+type port struct {
+	emulator *Emulator
+
+	mu          sync.Mutex
+	incoming    []byte
+	readTimeout time.Duration
+
+	ready     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ serial.Port = (*port)(nil)
+
+func newPort(e *Emulator) *port {
+	return &port{
+		emulator:    e,
+		readTimeout: serial.NoTimeout,
+		ready:       make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+// deliver queues bytes from the emulator for Read
+func (p *port) deliver(b []byte) error {
+	if p.isClosed() {
+		return ErrNotConnected
+	}
+
+	p.mu.Lock()
+	p.incoming = append(p.incoming, b...)
+	p.mu.Unlock()
+
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Read returns whatever the emulator has sent, waiting up to the read timeout for something to arrive.  Like a
+// real serial port, a timeout is a zero-length read rather than an error.
+func (p *port) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	timeout := p.readTimeout
+	p.mu.Unlock()
+
+	var timeoutCh <-chan time.Time
+	if timeout != serial.NoTimeout {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	for {
+		p.mu.Lock()
+		if len(p.incoming) > 0 {
+			n := copy(b, p.incoming)
+			p.incoming = p.incoming[n:]
+			p.mu.Unlock()
+			return n, nil
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-p.ready:
+		case <-timeoutCh:
+			return 0, nil
+		case <-p.done:
+			return 0, os.ErrClosed
+		}
+	}
+}
+
+// Write hands bytes to the emulator.
+func (p *port) Write(b []byte) (int, error) {
+	if p.isClosed() {
+		return 0, os.ErrClosed
+	}
+	p.emulator.receive(b)
+	return len(b), nil
+}
+
+func (p *port) SetReadTimeout(t time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.readTimeout = t
+	return nil
+}
+
+func (p *port) ResetInputBuffer() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.incoming = nil
+	return nil
+}
+
+func (p *port) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.emulator.disconnect(p)
+	})
+	return nil
+}
+
+func (p *port) isClosed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (*port) SetMode(*serial.Mode) error { return nil }
+func (*port) Drain() error               { return nil }
+func (*port) ResetOutputBuffer() error   { return nil }
+func (*port) SetDTR(bool) error          { return nil }
+func (*port) SetRTS(bool) error          { return nil }
+func (*port) Break(time.Duration) error  { return nil }
+
+func (*port) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
@@ -0,0 +1,84 @@
+package usbcantest
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenPTY serves the emulator on a new pseudo-terminal and returns the path of its terminal side, which the host
+// opens like any serial device.  The host can close and reopen it; Close tears it down.
+func (e *Emulator) ListenPTY() (string, error) {
+	master, path, err := OpenPTY()
+	if err != nil {
+		return "", err
+	}
+
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		_ = master.Close()
+		return "", errors.New("emulator is closed")
+	}
+	e.closers = append(e.closers, master)
+	e.mu.Unlock()
+
+	go func() {
+		for {
+			// Reading the controlling side fails with EIO whenever the terminal side isn't open
+			err := e.Serve(master)
+			if !errors.Is(err, syscall.EIO) || e.isClosed() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	return path, nil
+}
+
+// OpenPTY opens a pseudo-terminal pair with its controlling side in raw mode, for simulating serial devices.  It
+// returns the controlling side and the path of the terminal side, which the host opens like any serial device.
+func OpenPTY() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	path, err := setupPTY(master)
+	if err != nil {
+		_ = master.Close()
+		return nil, "", err
+	}
+	return master, path, nil
+}
+
+// setupPTY is a helper to unlock a pty and put its controlling side in raw mode, returning the terminal side's path
+func setupPTY(master *os.File) (string, error) {
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		return "", err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		return "", err
+	}
+
+	// Raw mode so the host sees bytes exactly as written
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return "", err
+	}
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return "", err
+	}
+
+	return "/dev/pts/" + strconv.Itoa(n), nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package usbcantest
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoPTY is returned off Linux, where we don't know how to set up a pty
+var errNoPTY = errors.New("emulating on a pty is only supported on linux")
+
+// ListenPTY is only supported on Linux.
+func (e *Emulator) ListenPTY() (string, error) {
+	return "", errNoPTY
+}
+
+// OpenPTY is only supported on Linux.
+func OpenPTY() (*os.File, string, error) {
+	return nil, "", errNoPTY
+}